@@ -0,0 +1,88 @@
+package tiff
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+)
+
+// OpenAppend returns an Encoder that appends pages to the TIFF file f
+// without rewriting it, like libtiff does: the pages are written at the end
+// of the file and the pointer to the next IFD of its last IFD is patched to
+// chain the first of them. The pages are complete only after Close has been
+// called, which does not close f.
+//
+// The pages are written in the byte order of f, ignoring
+// Options.ByteOrder, and BigTIFF files are appended to as BigTIFF. Before
+// Close, the IFD chain of the file already points to the first appended
+// page, whose IFD is only written once the next page is written or the
+// Encoder is closed.
+func OpenAppend(f *os.File) (*Encoder, error) {
+	h, offset, err := readHeader(f)
+	if err != nil {
+		return nil, err
+	}
+	e := &Encoder{w: f, big: h.bigTIFF, order: h.byteOrder}
+
+	// The pointer to the first IFD follows the first 4 bytes of the header,
+	// or the first 8 in a BigTIFF file.
+	e.appendPtr = 4
+	if h.bigTIFF {
+		e.appendPtr = 8
+	}
+	d := &decoder{r: f, byteOrder: h.byteOrder, bigTIFF: h.bigTIFF}
+	countLen := int64(2)
+	if h.bigTIFF {
+		countLen = 8
+	}
+	seen := make(map[int64]bool)
+	for offset != 0 {
+		if seen[offset] {
+			return nil, FormatError("IFD chain contains a loop")
+		}
+		seen[offset] = true
+		p, next, err := d.readEntries(offset)
+		if err != nil {
+			return nil, err
+		}
+		e.appendPtr = offset + countLen + int64(len(p))
+		offset = next
+	}
+
+	end, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	// The pixel data of the first page starts on a word boundary, as
+	// the IFD that follows it must.
+	pad := padLen(int(end%int64(e.align())), e.align())
+	if _, err := f.Write(make([]byte, pad)); err != nil {
+		return nil, err
+	}
+	e.appendEnd = end + int64(pad)
+	if !e.big && e.appendEnd > maxClassicSize {
+		return nil, errors.New("tiff: file already fills a classic TIFF file")
+	}
+	return e, nil
+}
+
+// linkAppend patches the pointer of the last IFD of the file appended to by
+// e to point to the IFD at ifdOffset, and returns to the end of the file.
+func (e *Encoder) linkAppend(ifdOffset int64) error {
+	ws := e.w.(io.WriteSeeker)
+	if _, err := ws.Seek(e.appendPtr, io.SeekStart); err != nil {
+		return err
+	}
+	var err error
+	if e.big {
+		err = binary.Write(ws, e.order, uint64(ifdOffset))
+	} else {
+		err = binary.Write(ws, e.order, uint32(ifdOffset))
+	}
+	if err != nil {
+		return err
+	}
+	_, err = ws.Seek(e.appendEnd, io.SeekStart)
+	return err
+}
@@ -0,0 +1,132 @@
+package tiff
+
+import (
+	"bytes"
+	"image"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// tempTIFF returns a temporary file holding data. It is to be removed with
+// removeTemp.
+func tempTIFF(t *testing.T, data []byte) *os.File {
+	t.Helper()
+	f, err := ioutil.TempFile("", "tiff-append")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write(data); err != nil {
+		removeTemp(f)
+		t.Fatal(err)
+	}
+	return f
+}
+
+func removeTemp(f *os.File) {
+	f.Close()
+	os.Remove(f.Name())
+}
+
+func TestOpenAppend(t *testing.T) {
+	src, err := openImage("video-001.tiff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gray := image.NewGray(image.Rect(0, 0, 7, 5))
+	for i := range gray.Pix {
+		gray.Pix[i] = uint8(i * 9)
+	}
+	for _, big := range []bool{false, true} {
+		var buf bytes.Buffer
+		// The odd-sized file needs padding before the appended data.
+		if err := Encode(&buf, gray, &Options{ForceBigTIFF: big, HostComputer: "abc"}); err != nil {
+			t.Fatal(err)
+		}
+		f := tempTIFF(t, buf.Bytes())
+		defer removeTemp(f)
+		want := []image.Image{gray}
+		for _, pages := range [][]image.Image{{src, gray}, {src}} {
+			e, err := OpenAppend(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, m := range pages {
+				if err := e.WriteImage(m, &Options{Compression: LZW}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := e.Close(); err != nil {
+				t.Fatal(err)
+			}
+			want = append(want, pages...)
+		}
+
+		data, err := ioutil.ReadFile(f.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Only the pointer to the next IFD of the last IFD changed.
+		i := 0
+		for i < buf.Len() && data[i] == buf.Bytes()[i] {
+			i++
+		}
+		n := 4
+		if big {
+			n = 8
+		}
+		if i+n > buf.Len() || !bytes.Equal(data[i+n:buf.Len()], buf.Bytes()[i+n:]) {
+			t.Errorf("BigTIFF %t: the existing file was rewritten", big)
+		}
+		got, err := DecodeAll(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("BigTIFF %t: %v", big, err)
+		}
+		if len(got) != len(want) {
+			t.Fatalf("BigTIFF %t: got %d pages, want %d", big, len(got), len(want))
+		}
+		for i := range got {
+			compare(t, want[i], got[i])
+		}
+		if c, err := DecodeConfigFull(bytes.NewReader(data)); err != nil || c.Pages != 4 {
+			t.Errorf("BigTIFF %t: got %d pages, %v", big, c.Pages, err)
+		}
+	}
+
+	// Pages are appended to a big-endian file in its byte order.
+	be, err := ioutil.ReadFile("testdata/video-001-uncompressed.tiff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gray16 := image.NewGray16(image.Rect(0, 0, 20, 10))
+	for i := range gray16.Pix {
+		gray16.Pix[i] = uint8(i * 7)
+	}
+	f := tempTIFF(t, be)
+	defer removeTemp(f)
+	e, err := OpenAppend(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.WriteImage(gray16, &Options{Compression: LZW, Predictor: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := DecodeAll(bytes.NewReader(data)); err != nil || len(got) != 2 {
+		t.Fatalf("big-endian file: got %d pages, %v", len(got), err)
+	} else {
+		compare(t, gray16, got[1])
+	}
+
+	g := tempTIFF(t, []byte("not a TIFF file"))
+	defer removeTemp(g)
+	if _, err := OpenAppend(g); err == nil {
+		t.Error("not a TIFF file: got no error")
+	}
+}
@@ -4,32 +4,40 @@
 
 package tiff
 
-import "io"
+import (
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"sync"
+)
 
-// buffer buffers an io.Reader to satisfy io.ReaderAt.
+// buffer buffers an io.Reader to satisfy io.ReaderAt. It is safe for
+// concurrent use, as io.ReaderAt requires.
 type buffer struct {
+	mu  sync.Mutex
 	r   io.Reader
 	buf []byte
 }
 
 // fill reads data from b.r until the buffer contains at least end bytes.
+// The buffer grows as data arrives rather than to end at once, so that an
+// offset beyond the end of a short input does not allocate memory for it.
 func (b *buffer) fill(end int) error {
-	m := len(b.buf)
-	if end > m {
-		if end > cap(b.buf) {
-			newcap := 1024
-			for newcap < end {
-				newcap *= 2
+	for m := len(b.buf); m < end; m = len(b.buf) {
+		if m == cap(b.buf) {
+			newcap := 2 * cap(b.buf)
+			if newcap < 1024 {
+				newcap = 1024
 			}
-			newbuf := make([]byte, end, newcap)
+			newbuf := make([]byte, m, newcap)
 			copy(newbuf, b.buf)
 			b.buf = newbuf
-		} else {
-			b.buf = b.buf[:end]
 		}
-		if n, err := io.ReadFull(b.r, b.buf[m:end]); err != nil {
-			end = m + n
-			b.buf = b.buf[:end]
+		next := minInt(end, cap(b.buf))
+		b.buf = b.buf[:next]
+		if n, err := io.ReadFull(b.r, b.buf[m:next]); err != nil {
+			b.buf = b.buf[:m+n]
 			return err
 		}
 	}
@@ -43,6 +51,8 @@ func (b *buffer) ReadAt(p []byte, off int64) (int, error) {
 		return 0, io.ErrUnexpectedEOF
 	}
 
+	b.mu.Lock()
+	defer b.mu.Unlock()
 	err := b.fill(end)
 	return copy(p, b.buf[o:end]), err
 }
@@ -51,12 +61,69 @@ func (b *buffer) ReadAt(p []byte, off int64) (int, error) {
 // n bytes starting at offset off.
 func (b *buffer) Slice(off, n int) ([]byte, error) {
 	end := off + n
+	b.mu.Lock()
+	defer b.mu.Unlock()
 	if err := b.fill(end); err != nil {
 		return nil, err
 	}
 	return b.buf[off:end], nil
 }
 
+// size reads all remaining data from b.r and returns the total length of the
+// data.
+func (b *buffer) size() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	rest, _ := ioutil.ReadAll(b.r)
+	b.buf = append(b.buf, rest...)
+	return int64(len(b.buf))
+}
+
+// readerSize returns the length of the data in r, if it can be determined
+// from its Size method or, for regular files, its Stat method, or
+// math.MaxInt64 otherwise.
+func readerSize(r io.ReaderAt) int64 {
+	switch r := r.(type) {
+	case *buffer:
+		return r.size()
+	case interface{ Size() int64 }:
+		return r.Size()
+	case interface{ Stat() (os.FileInfo, error) }:
+		if fi, err := r.Stat(); err == nil && fi.Mode().IsRegular() {
+			return fi.Size()
+		}
+	}
+	return math.MaxInt64
+}
+
+// readAt returns the n bytes at offset off in r. If the size of r is known,
+// reads past its end return the bytes up to the end and io.ErrUnexpectedEOF.
+// Otherwise, large reads are done in chunks, so that a bogus length in a
+// short file fails without allocating memory for all of it.
+func readAt(r io.ReaderAt, off, n int64) ([]byte, error) {
+	if s, ok := r.(interface{ Size() int64 }); ok && off >= 0 && n > s.Size()-off {
+		m := s.Size() - off
+		if m < 0 {
+			m = 0
+		}
+		buf := make([]byte, m)
+		if _, err := r.ReadAt(buf, off); err != nil && err != io.EOF {
+			return buf, err
+		}
+		return buf, io.ErrUnexpectedEOF
+	}
+	if n <= 1<<20 {
+		buf := make([]byte, n)
+		_, err := r.ReadAt(buf, off)
+		return buf, err
+	}
+	buf, err := ioutil.ReadAll(io.NewSectionReader(r, off, n))
+	if err == nil && int64(len(buf)) < n {
+		err = io.ErrUnexpectedEOF
+	}
+	return buf, err
+}
+
 // newReaderAt converts an io.Reader into an io.ReaderAt.
 func newReaderAt(r io.Reader) io.ReaderAt {
 	if ra, ok := r.(io.ReaderAt); ok {
@@ -0,0 +1,180 @@
+package tiff
+
+import (
+	"io"
+)
+
+// This file implements CCITT Group 4 (T.6) encoding. Decoding is done by
+// golang.org/x/image/ccitt.
+
+// A bitString is a code of n bits, stored in the low bits of bits.
+type bitString struct {
+	bits uint32
+	n    uint
+}
+
+// The codes of the two-dimensional coding modes (table 4 of T.4) and the
+// end-of-line code.
+var (
+	passCode       = bitString{0x1, 4}
+	horizontalCode = bitString{0x1, 3}
+	eolCode        = bitString{0x1, 12}
+	// verticalCodes are the codes for b1-a1 = -3 to 3, i.e. VR3 to VL3.
+	verticalCodes = [7]bitString{{0x3, 7}, {0x3, 6}, {0x3, 3}, {0x1, 1}, {0x2, 3}, {0x2, 6}, {0x2, 7}}
+)
+
+// whiteTerms and blackTerms hold the terminating codes for runs of 0 to 63
+// pixels (table 2 of T.4), whiteMakeups and blackMakeups the makeup codes for
+// runs of 64 to 2560 pixels in steps of 64 (tables 3a and 3b).
+var (
+	whiteTerms = [...]bitString{
+		{0x35, 8}, {0x07, 6}, {0x07, 4}, {0x08, 4}, {0x0b, 4}, {0x0c, 4}, {0x0e, 4}, {0x0f, 4}, // 0-7
+		{0x13, 5}, {0x14, 5}, {0x07, 5}, {0x08, 5}, {0x08, 6}, {0x03, 6}, {0x34, 6}, {0x35, 6}, // 8-15
+		{0x2a, 6}, {0x2b, 6}, {0x27, 7}, {0x0c, 7}, {0x08, 7}, {0x17, 7}, {0x03, 7}, {0x04, 7}, // 16-23
+		{0x28, 7}, {0x2b, 7}, {0x13, 7}, {0x24, 7}, {0x18, 7}, {0x02, 8}, {0x03, 8}, {0x1a, 8}, // 24-31
+		{0x1b, 8}, {0x12, 8}, {0x13, 8}, {0x14, 8}, {0x15, 8}, {0x16, 8}, {0x17, 8}, {0x28, 8}, // 32-39
+		{0x29, 8}, {0x2a, 8}, {0x2b, 8}, {0x2c, 8}, {0x2d, 8}, {0x04, 8}, {0x05, 8}, {0x0a, 8}, // 40-47
+		{0x0b, 8}, {0x52, 8}, {0x53, 8}, {0x54, 8}, {0x55, 8}, {0x24, 8}, {0x25, 8}, {0x58, 8}, // 48-55
+		{0x59, 8}, {0x5a, 8}, {0x5b, 8}, {0x4a, 8}, {0x4b, 8}, {0x32, 8}, {0x33, 8}, {0x34, 8}, // 56-63
+	}
+	whiteMakeups = [...]bitString{
+		{0x1b, 5}, {0x12, 5}, {0x17, 6}, {0x37, 7}, {0x36, 8}, {0x37, 8}, {0x64, 8}, {0x65, 8}, // 64-512
+		{0x68, 8}, {0x67, 8}, {0xcc, 9}, {0xcd, 9}, {0xd2, 9}, {0xd3, 9}, {0xd4, 9}, {0xd5, 9}, // 576-1024
+		{0xd6, 9}, {0xd7, 9}, {0xd8, 9}, {0xd9, 9}, {0xda, 9}, {0xdb, 9}, {0x98, 9}, {0x99, 9}, // 1088-1536
+		{0x9a, 9}, {0x18, 6}, {0x9b, 9}, {0x08, 11}, {0x0c, 11}, {0x0d, 11}, {0x12, 12}, {0x13, 12}, // 1600-2048
+		{0x14, 12}, {0x15, 12}, {0x16, 12}, {0x17, 12}, {0x1c, 12}, {0x1d, 12}, {0x1e, 12}, {0x1f, 12}, // 2112-2560
+	}
+	blackTerms = [...]bitString{
+		{0x37, 10}, {0x02, 3}, {0x03, 2}, {0x02, 2}, {0x03, 3}, {0x03, 4}, {0x02, 4}, {0x03, 5}, // 0-7
+		{0x05, 6}, {0x04, 6}, {0x04, 7}, {0x05, 7}, {0x07, 7}, {0x04, 8}, {0x07, 8}, {0x18, 9}, // 8-15
+		{0x17, 10}, {0x18, 10}, {0x08, 10}, {0x67, 11}, {0x68, 11}, {0x6c, 11}, {0x37, 11}, {0x28, 11}, // 16-23
+		{0x17, 11}, {0x18, 11}, {0xca, 12}, {0xcb, 12}, {0xcc, 12}, {0xcd, 12}, {0x68, 12}, {0x69, 12}, // 24-31
+		{0x6a, 12}, {0x6b, 12}, {0xd2, 12}, {0xd3, 12}, {0xd4, 12}, {0xd5, 12}, {0xd6, 12}, {0xd7, 12}, // 32-39
+		{0x6c, 12}, {0x6d, 12}, {0xda, 12}, {0xdb, 12}, {0x54, 12}, {0x55, 12}, {0x56, 12}, {0x57, 12}, // 40-47
+		{0x64, 12}, {0x65, 12}, {0x52, 12}, {0x53, 12}, {0x24, 12}, {0x37, 12}, {0x38, 12}, {0x27, 12}, // 48-55
+		{0x28, 12}, {0x58, 12}, {0x59, 12}, {0x2b, 12}, {0x2c, 12}, {0x5a, 12}, {0x66, 12}, {0x67, 12}, // 56-63
+	}
+	blackMakeups = [...]bitString{
+		{0x0f, 10}, {0xc8, 12}, {0xc9, 12}, {0x5b, 12}, {0x33, 12}, {0x34, 12}, {0x35, 12}, {0x6c, 13}, // 64-512
+		{0x6d, 13}, {0x4a, 13}, {0x4b, 13}, {0x4c, 13}, {0x4d, 13}, {0x72, 13}, {0x73, 13}, {0x74, 13}, // 576-1024
+		{0x75, 13}, {0x76, 13}, {0x77, 13}, {0x52, 13}, {0x53, 13}, {0x54, 13}, {0x55, 13}, {0x5a, 13}, // 1088-1536
+		{0x5b, 13}, {0x64, 13}, {0x65, 13}, {0x08, 11}, {0x0c, 11}, {0x0d, 11}, {0x12, 12}, {0x13, 12}, // 1600-2048
+		{0x14, 12}, {0x15, 12}, {0x16, 12}, {0x17, 12}, {0x1c, 12}, {0x1d, 12}, {0x1e, 12}, {0x1f, 12}, // 2112-2560
+	}
+)
+
+// bitWriter collects codes of arbitrary bit length, most significant bit
+// first.
+type bitWriter struct {
+	buf []byte
+	v   uint32 // Pending bits, not yet written to buf.
+	n   uint   // Number of pending bits in v, less than 8.
+}
+
+func (b *bitWriter) write(c bitString) {
+	b.v = b.v<<c.n | c.bits
+	b.n += c.n
+	for b.n >= 8 {
+		b.n -= 8
+		b.buf = append(b.buf, uint8(b.v>>b.n))
+	}
+	b.v &= 1<<b.n - 1
+}
+
+// flush pads the pending bits with zeros to a whole byte.
+func (b *bitWriter) flush() {
+	if b.n > 0 {
+		b.buf = append(b.buf, uint8(b.v<<(8-b.n)))
+		b.v, b.n = 0, 0
+	}
+}
+
+// writeRun writes the codes for a run of n white or black pixels.
+func (b *bitWriter) writeRun(n int, black bool) {
+	terms, makeups := whiteTerms[:], whiteMakeups[:]
+	if black {
+		terms, makeups = blackTerms[:], blackMakeups[:]
+	}
+	for n >= 2560+64 {
+		b.write(makeups[len(makeups)-1])
+		n -= 2560
+	}
+	if n >= 64 {
+		b.write(makeups[n/64-1])
+		n %= 64
+	}
+	b.write(terms[n])
+}
+
+// nextChange returns the position of the first pixel at or after i in row
+// whose color differs from c, or len(row) if there is none.
+func nextChange(row []uint8, i int, c uint8) int {
+	for ; i < len(row); i++ {
+		if row[i] != c {
+			return i
+		}
+	}
+	return len(row)
+}
+
+// encodeG4 writes the dx by dy pixels stored in pix, one byte per pixel with
+// 0 for white and 1 for black, to w using CCITT Group 4 compression. It has
+// the signature of a pixelEncoder; there is no predictor for bilevel data.
+func encodeG4(w io.Writer, pix []uint8, dx, dy, stride int, _ bool) error {
+	var b bitWriter
+	// The reference line of the first row is all white.
+	ref := make([]uint8, dx)
+	for y := 0; y < dy; y++ {
+		cur := pix[y*stride : y*stride+dx]
+		b.encodeG4Row(cur, ref)
+		ref = cur
+	}
+	// End the data with an EOFB, which consists of two EOL codes.
+	b.write(eolCode)
+	b.write(eolCode)
+	b.flush()
+	_, err := w.Write(b.buf)
+	return err
+}
+
+// encodeG4Row writes the codes of the coding line cur, using ref as the
+// reference line, following the coding procedure of section 2.2 of T.6.
+func (b *bitWriter) encodeG4Row(cur, ref []uint8) {
+	dx := len(cur)
+	// after returns the next changing element after the one at i.
+	after := func(row []uint8, i int) int {
+		if i >= dx {
+			return dx
+		}
+		return nextChange(row, i, row[i])
+	}
+	// The coding line starts with an imaginary white pixel at a0, which
+	// is the first changing element if the first pixel is black.
+	a0 := 0
+	a1 := nextChange(cur, 0, 0)
+	b1 := nextChange(ref, 0, 0)
+	for {
+		b2 := after(ref, b1)
+		switch d := b1 - a1; {
+		case b2 < a1:
+			b.write(passCode)
+			a0 = b2
+		case -3 <= d && d <= 3:
+			b.write(verticalCodes[d+3])
+			a0 = a1
+		default:
+			a2 := after(cur, a1)
+			b.write(horizontalCode)
+			black := a0 != a1 && cur[a0] != 0
+			b.writeRun(a1-a0, black)
+			b.writeRun(a2-a1, !black)
+			a0 = a2
+		}
+		if a0 >= dx {
+			return
+		}
+		c := cur[a0]
+		a1 = nextChange(cur, a0, c)
+		b1 = nextChange(ref, nextChange(ref, a0, 1-c), c)
+	}
+}
@@ -0,0 +1,100 @@
+package tiff
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+)
+
+// TestEncodeG4 tests that bilevel images encoded with CCITT Group 4
+// compression decode to the same pixels.
+func TestEncodeG4(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for _, w := range []int{1, 7, 64, 100, 1729, 6000} {
+		m := image.NewGray(image.Rect(0, 0, w, 40))
+		for y := 0; y < 40; y++ {
+			// Rows consist of runs of random lengths, some of which
+			// need makeup codes; every fourth row repeats the one
+			// above with small shifts to exercise the vertical mode.
+			row := m.Pix[y*m.Stride : y*m.Stride+w]
+			if y%4 == 3 {
+				copy(row[minInt(rnd.Intn(3), w):], m.Pix[(y-1)*m.Stride:(y-1)*m.Stride+w])
+				continue
+			}
+			c := uint8(0xff * rnd.Intn(2))
+			for x := 0; x < w; {
+				n := 1 + rnd.Intn(8)
+				if rnd.Intn(4) == 0 {
+					n = rnd.Intn(3000)
+				}
+				for ; n > 0 && x < w; n-- {
+					row[x] = c
+					x++
+				}
+				c = ^c
+			}
+		}
+		var buf bytes.Buffer
+		if err := Encode(&buf, m, &Options{Compression: CCITTGroup4}); err != nil {
+			t.Fatal(err)
+		}
+		img, err := Decode(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("width %d: %v", w, err)
+		}
+		compare(t, m, img)
+	}
+
+	// Images other than gray ones are thresholded at half luminance.
+	src := image.NewRGBA(image.Rect(2, 3, 6, 4))
+	src.Set(2, 3, color.RGBA{0x90, 0x90, 0x90, 0xff})
+	src.Set(3, 3, color.RGBA{0x70, 0x70, 0x70, 0xff})
+	src.Set(4, 3, color.White)
+	var buf bytes.Buffer
+	if err := Encode(&buf, src, &Options{Compression: CCITTGroup4}); err != nil {
+		t.Fatal(err)
+	}
+	img, err := Decode(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []uint8{0xff, 0, 0xff, 0}
+	if got := img.(*image.Gray).Pix; !bytes.Equal(got, want) {
+		t.Errorf("got pixels %v, want %v", got, want)
+	}
+
+	if err := Encode(&buf, src, &Options{Compression: CCITTGroup4, TileWidth: 16, TileLength: 16}); err == nil {
+		t.Error("tiled: got nil error")
+	}
+}
+
+// TestEncodeG4Reference tests that the CCITT Group 4 data of an image is the
+// same as that written by another encoder, since the coding is deterministic.
+func TestEncodeG4Reference(t *testing.T) {
+	b, err := ioutil.ReadFile(testdataDir + "bw-gopher_ccittGroup4.tiff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := Decode(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := Encode(&buf, img, &Options{Compression: CCITTGroup4}); err != nil {
+		t.Fatal(err)
+	}
+	strip := func(b []byte) []byte {
+		d, err := newDecoder(bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		off, n := d.features[tStripOffsets][0], d.features[tStripByteCounts][0]
+		return b[off : off+n]
+	}
+	if got, want := strip(buf.Bytes()), strip(b); !bytes.Equal(got, want) {
+		t.Errorf("got %d bytes of data, want the %d bytes of the reference", len(got), len(want))
+	}
+}
@@ -1,12 +1,15 @@
 package tiff
 
 import (
+	"errors"
+	"fmt"
 	"image"
 	"image/color"
 )
 
 // CMYKA represents CMYKAImg color, having 8 bits for each of cyan,
-// magenta, yellow and black, with alpha channel
+// magenta, yellow and black, with alpha channel. The C, M, Y and K values
+// are not premultiplied by alpha; A is 0xff for an opaque color.
 //
 // It is not associated with any particular color profile.
 type CMYKA struct {
@@ -14,34 +17,112 @@ type CMYKA struct {
 }
 
 func (c CMYKA) RGBA() (uint32, uint32, uint32, uint32) {
+	// The color is converted like a color.CMYK and then premultiplied
+	// by its alpha.
 	r, g, b, _ := color.CMYK{
 		C: c.C,
 		M: c.M,
 		Y: c.Y,
 		K: c.K,
 	}.RGBA()
-
-	w := 0xffff - uint32(c.K)*0x101
-	a := uint32((0xffff - uint32(c.A)*0x101) * w / 0xffff)
-	return r, g, b, 65535 - a
+	a := uint32(c.A) * 0x101
+	return r * a / 0xffff, g * a / 0xffff, b * a / 0xffff, a
 }
 
-// CMYKAModel is the Model for CMYKAImg colors.
+// CMYKAModel is the Model for CMYKAImg colors. Colors are converted by way
+// of color.NRGBA, preserving their alpha.
 var CMYKAModel color.Model = color.ModelFunc(cmykModel)
 
 func cmykModel(c color.Color) color.Color {
-	if _, ok := c.(CMYKA); ok {
+	switch c := c.(type) {
+	case CMYKA:
 		return c
+	case color.CMYK:
+		return CMYKA{c.C, c.M, c.Y, c.K, 0xff}
+	}
+	return rgbaToCMYKA(c.RGBA())
+}
+
+// rgbaToCMYKA converts a color with premultiplied 16-bit components, as
+// returned by color.Color's RGBA method, to CMYKA, like conversion to
+// color.NRGBA followed by color.RGBToCMYK.
+func rgbaToCMYKA(r, g, b, a uint32) CMYKA {
+	if a == 0 {
+		return CMYKA{0, 0, 0, 0xff, 0}
+	}
+	if a != 0xffff {
+		r = r * 0xffff / a
+		g = g * 0xffff / a
+		b = b * 0xffff / a
 	}
-	r, g, b, _ := c.RGBA()
 	cc, mm, yy, kk := color.RGBToCMYK(uint8(r>>8), uint8(g>>8), uint8(b>>8))
-	return CMYKA{cc, mm, yy, kk, 0xff}
+	return CMYKA{cc, mm, yy, kk, uint8(a >> 8)}
+}
+
+// CMYKAToNRGBA converts the pixels in src, which holds C, M, Y, K, A samples
+// like the Pix of a CMYKAImg, to R, G, B, A samples like the Pix of an
+// image.NRGBA and stores them in dst. It converts as many pixels as both
+// buffers have room for and returns their number.
+func CMYKAToNRGBA(dst, src []uint8) int {
+	n := minInt(len(dst)/4, len(src)/5)
+	for i := 0; i < n; i++ {
+		s := src[5*i : 5*i+5 : 5*i+5]
+		d := dst[4*i : 4*i+4 : 4*i+4]
+		d[0], d[1], d[2] = color.CMYKToRGB(s[0], s[1], s[2], s[3])
+		d[3] = s[4]
+	}
+	return n
+}
+
+// NRGBAToCMYKA converts the pixels in src, which holds R, G, B, A samples
+// like the Pix of an image.NRGBA, to C, M, Y, K, A samples like the Pix of
+// a CMYKAImg and stores them in dst. It converts as many pixels as both
+// buffers have room for and returns their number.
+func NRGBAToCMYKA(dst, src []uint8) int {
+	n := minInt(len(dst)/5, len(src)/4)
+	for i := 0; i < n; i++ {
+		s := src[4*i : 4*i+4 : 4*i+4]
+		d := dst[5*i : 5*i+5 : 5*i+5]
+		d[0], d[1], d[2], d[3] = color.RGBToCMYK(s[0], s[1], s[2])
+		d[4] = s[3]
+	}
+	return n
+}
+
+// ConvertCMYKAToNRGBA converts the pixels of src within rect to the same
+// pixels of dst, row by row with CMYKAToNRGBA, without going through
+// color.Color values. rect is clipped to the bounds of both images.
+func ConvertCMYKAToNRGBA(dst *image.NRGBA, src *CMYKAImg, rect image.Rectangle) {
+	r := rect.Intersect(dst.Rect).Intersect(src.Rect)
+	if r.Empty() {
+		return
+	}
+	n := r.Dx()
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		d := dst.Pix[dst.PixOffset(r.Min.X, y):][:4*n]
+		CMYKAToNRGBA(d, src.Pix[src.PixOffset(r.Min.X, y):][:5*n])
+	}
+}
+
+// ConvertNRGBAToCMYKA converts the pixels of src within rect to the same
+// pixels of dst, row by row with NRGBAToCMYKA, without going through
+// color.Color values. rect is clipped to the bounds of both images.
+func ConvertNRGBAToCMYKA(dst *CMYKAImg, src *image.NRGBA, rect image.Rectangle) {
+	r := rect.Intersect(dst.Rect).Intersect(src.Rect)
+	if r.Empty() {
+		return
+	}
+	n := r.Dx()
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		d := dst.Pix[dst.PixOffset(r.Min.X, y):][:5*n]
+		NRGBAToCMYKA(d, src.Pix[src.PixOffset(r.Min.X, y):][:4*n])
+	}
 }
 
-// CMYKAImg is an in-memory image whose At method returns color.CMYK values.
+// CMYKAImg is an in-memory image whose At method returns CMYKA values.
 type CMYKAImg struct {
-	// Pix holds the image's pixels, in C, M, Y, K order. The pixel at
-	// (x, y) starts at Pix[(y-Rect.Min.Y)*Stride + (x-Rect.Min.X)*4].
+	// Pix holds the image's pixels, in C, M, Y, K, A order. The pixel at
+	// (x, y) starts at Pix[(y-Rect.Min.Y)*Stride + (x-Rect.Min.X)*5].
 	Pix []uint8
 	// Stride is the Pix stride (in bytes) between vertically adjacent pixels.
 	Stride int
@@ -49,7 +130,7 @@ type CMYKAImg struct {
 	Rect image.Rectangle
 }
 
-func (p *CMYKAImg) ColorModel() color.Model { return color.CMYKModel }
+func (p *CMYKAImg) ColorModel() color.Model { return CMYKAModel }
 
 func (p *CMYKAImg) Bounds() image.Rectangle { return p.Rect }
 
@@ -74,21 +155,18 @@ func (p *CMYKAImg) CMYKAt(x, y int) CMYKA {
 // PixOffset returns the index of the first element of Pix that corresponds to
 // the pixel at (x, y).
 func (p *CMYKAImg) PixOffset(x, y int) int {
-	return (y-p.Rect.Min.Y)*p.Stride + (x-p.Rect.Min.X)*5
+	return pixOffset(p.Rect, p.Stride, 5, x, y)
 }
 
 func (p *CMYKAImg) Set(x, y int, c color.Color) {
-	if !(image.Point{x, y}.In(p.Rect)) {
-		return
-	}
-	i := p.PixOffset(x, y)
-	c1 := color.CMYKModel.Convert(c).(CMYKA)
-	s := p.Pix[i : i+5 : i+5] // Small cap improves performance, see https://golang.org/issue/27857
-	s[0] = c1.C
-	s[1] = c1.M
-	s[2] = c1.Y
-	s[3] = c1.K
-	s[4] = c1.A
+	p.SetCMYKA(x, y, CMYKAModel.Convert(c).(CMYKA))
+}
+
+// SetRGBA64 sets the pixel at (x, y) to c, converted as by CMYKAModel,
+// without going through a color.Color. Together with RGBA64At, it makes
+// CMYKAImg a draw.RGBA64Image.
+func (p *CMYKAImg) SetRGBA64(x, y int, c color.RGBA64) {
+	p.SetCMYKA(x, y, rgbaToCMYKA(uint32(c.R), uint32(c.G), uint32(c.B), uint32(c.A)))
 }
 
 func (p *CMYKAImg) SetCMYKA(x, y int, c CMYKA) {
@@ -104,27 +182,42 @@ func (p *CMYKAImg) SetCMYKA(x, y int, c CMYKA) {
 	s[4] = c.A
 }
 
+// SetRow copies row, which holds the samples of a full row of pixels in C,
+// M, Y, K, A order, into the row y of the image.
+func (p *CMYKAImg) SetRow(y int, row []uint8) error {
+	if y < p.Rect.Min.Y || y >= p.Rect.Max.Y {
+		return fmt.Errorf("tiff: SetRow: row %d is outside %v", y, p.Rect)
+	}
+	if n := 5 * p.Rect.Dx(); len(row) != n {
+		return fmt.Errorf("tiff: SetRow: got %d bytes, want %d", len(row), n)
+	}
+	i := p.PixOffset(p.Rect.Min.X, y)
+	copy(p.Pix[i:i+len(row)], row)
+	return nil
+}
+
 // SubImage returns an image representing the portion of the image p visible
 // through r. The returned value shares pixels with the original image.
 func (p *CMYKAImg) SubImage(r image.Rectangle) image.Image {
 	r = r.Intersect(p.Rect)
 	// If r1 and r2 are Rectangles, r1.Intersect(r2) is not guaranteed to be inside
 	// either r1 or r2 if the intersection is empty. Without explicitly checking for
-	// this, the Pix[i:] expression below can panic.
+	// this, the Pix[i:j:j] expression below can panic.
 	if r.Empty() {
 		return &CMYKAImg{}
 	}
-	i := p.PixOffset(r.Min.X, r.Min.Y)
+	i, j := subPix(p.Rect, p.Stride, 5, r)
 	return &CMYKAImg{
-		Pix:    p.Pix[i:],
+		Pix:    p.Pix[i:j:j],
 		Stride: p.Stride,
 		Rect:   r,
 	}
 }
 
-// Opaque scans the entire image and reports whether it is fully opaque.
+// Opaque scans the entire image and reports whether it is fully opaque,
+// that is whether all its alpha samples are 0xff.
 func (p *CMYKAImg) Opaque() bool {
-	return false
+	return opaqueAlpha(p.Pix, p.Rect, p.Stride, 5, 4, 1)
 }
 
 // NewCMYKA returns a new CMYKAImg image with the given bounds.
@@ -135,3 +228,83 @@ func NewCMYKA(r image.Rectangle) *CMYKAImg {
 		Rect:   r,
 	}
 }
+
+// BlendCMYKA returns the linear blend of a and b, which must have the same
+// bounds. Each sample, including alpha, is interpolated as (1-t)*a + t*b, so
+// that t == 0 yields a copy of a and t == 1 a copy of b.
+func BlendCMYKA(a, b *CMYKAImg, t float64) (*CMYKAImg, error) {
+	if a.Rect != b.Rect {
+		return nil, errors.New("tiff: BlendCMYKA: images have different bounds")
+	}
+	if !(t >= 0 && t <= 1) {
+		return nil, errors.New("tiff: BlendCMYKA: t is not within [0, 1]")
+	}
+	dst := NewCMYKA(a.Rect)
+	n := 5 * a.Rect.Dx()
+	for y := a.Rect.Min.Y; y < a.Rect.Max.Y; y++ {
+		pa := a.Pix[a.PixOffset(a.Rect.Min.X, y):][:n]
+		pb := b.Pix[b.PixOffset(b.Rect.Min.X, y):][:n]
+		pd := dst.Pix[dst.PixOffset(dst.Rect.Min.X, y):][:n]
+		for i := range pd {
+			pd[i] = uint8(float64(pa[i])*(1-t) + float64(pb[i])*t + 0.5)
+		}
+	}
+	return dst, nil
+}
+
+// AlphaChannel returns the alpha samples of p as a new image.Alpha with the
+// same bounds, such as for a soft mask that accompanies the CMYK samples.
+func (p *CMYKAImg) AlphaChannel() *image.Alpha {
+	dst := image.NewAlpha(p.Rect)
+	n := p.Rect.Dx()
+	for y := p.Rect.Min.Y; y < p.Rect.Max.Y; y++ {
+		s := p.Pix[p.PixOffset(p.Rect.Min.X, y):][:5*n]
+		d := dst.Pix[dst.PixOffset(p.Rect.Min.X, y):][:n]
+		for i := range d {
+			d[i] = s[5*i+4]
+		}
+	}
+	return dst
+}
+
+// DropAlpha returns the C, M, Y and K samples of p as a new image.CMYK with
+// the same bounds. The samples are copied as they are, so that the colors
+// of pixels that are not opaque are those they have where they are visible.
+func (p *CMYKAImg) DropAlpha() *image.CMYK {
+	dst := image.NewCMYK(p.Rect)
+	n := p.Rect.Dx()
+	for y := p.Rect.Min.Y; y < p.Rect.Max.Y; y++ {
+		s := p.Pix[p.PixOffset(p.Rect.Min.X, y):][:5*n]
+		d := dst.Pix[dst.PixOffset(p.Rect.Min.X, y):][:4*n]
+		for i, j := 0, 0; i < len(d); i, j = i+4, j+5 {
+			copy(d[i:i+4], s[j:j+4])
+		}
+	}
+	return dst
+}
+
+// MergeCMYKAlpha returns a new CMYKAImg with the bounds of cmyk that holds
+// its samples together with the alpha samples of a at the same
+// coordinates, which is the inverse of DropAlpha and AlphaChannel. Pixels
+// outside the bounds of a are transparent, as a's At method reports.
+func MergeCMYKAlpha(cmyk *image.CMYK, a *image.Alpha) *CMYKAImg {
+	dst := NewCMYKA(cmyk.Rect)
+	n := cmyk.Rect.Dx()
+	r := cmyk.Rect.Intersect(a.Rect)
+	for y := cmyk.Rect.Min.Y; y < cmyk.Rect.Max.Y; y++ {
+		s := cmyk.Pix[cmyk.PixOffset(cmyk.Rect.Min.X, y):][:4*n]
+		d := dst.Pix[dst.PixOffset(cmyk.Rect.Min.X, y):][:5*n]
+		for i, j := 0, 0; i < len(s); i, j = i+4, j+5 {
+			copy(d[j:j+4], s[i:i+4])
+		}
+		if y < r.Min.Y || y >= r.Max.Y {
+			continue
+		}
+		as := a.Pix[a.PixOffset(r.Min.X, y):][:r.Dx()]
+		d = d[5*(r.Min.X-cmyk.Rect.Min.X):]
+		for i, v := range as {
+			d[5*i+4] = v
+		}
+	}
+	return dst
+}
@@ -0,0 +1,185 @@
+package tiff
+
+import (
+	"image"
+	"image/color"
+)
+
+// CMYKA64 represents CMYK color with alpha channel, having 16 bits for each
+// of cyan, magenta, yellow, black and alpha. It is the 16-bit equivalent of
+// CMYKA; its C, M, Y and K values are not premultiplied by alpha either.
+//
+// It is not associated with any particular color profile.
+type CMYKA64 struct {
+	C, M, Y, K, A uint16
+}
+
+func (c CMYKA64) RGBA() (uint32, uint32, uint32, uint32) {
+	w := 0xffff - uint32(c.K)
+	a := uint32(c.A)
+	r := (0xffff - uint32(c.C)) * w / 0xffff * a / 0xffff
+	g := (0xffff - uint32(c.M)) * w / 0xffff * a / 0xffff
+	b := (0xffff - uint32(c.Y)) * w / 0xffff * a / 0xffff
+	return r, g, b, a
+}
+
+// CMYKA64Model is the Model for CMYKA64 colors.
+var CMYKA64Model color.Model = color.ModelFunc(cmyka64Model)
+
+func cmyka64Model(c color.Color) color.Color {
+	switch c := c.(type) {
+	case CMYKA64:
+		return c
+	case CMYKA:
+		return CMYKA64{
+			uint16(c.C) * 0x101,
+			uint16(c.M) * 0x101,
+			uint16(c.Y) * 0x101,
+			uint16(c.K) * 0x101,
+			uint16(c.A) * 0x101,
+		}
+	case color.CMYK:
+		return CMYKA64{
+			uint16(c.C) * 0x101,
+			uint16(c.M) * 0x101,
+			uint16(c.Y) * 0x101,
+			uint16(c.K) * 0x101,
+			0xffff,
+		}
+	}
+	return rgbaToCMYKA64(c.RGBA())
+}
+
+// rgbaToCMYKA64 converts a color with premultiplied 16-bit components to
+// CMYKA64, preserving its alpha.
+func rgbaToCMYKA64(r, g, b, a uint32) CMYKA64 {
+	if a == 0 {
+		return CMYKA64{0, 0, 0, 0xffff, 0}
+	}
+	if a != 0xffff {
+		r = r * 0xffff / a
+		g = g * 0xffff / a
+		b = b * 0xffff / a
+	}
+	w := r
+	if g > w {
+		w = g
+	}
+	if b > w {
+		w = b
+	}
+	if w == 0 {
+		return CMYKA64{0, 0, 0, 0xffff, uint16(a)}
+	}
+	cc := (w - r) * 0xffff / w
+	mm := (w - g) * 0xffff / w
+	yy := (w - b) * 0xffff / w
+	return CMYKA64{uint16(cc), uint16(mm), uint16(yy), uint16(0xffff - w), uint16(a)}
+}
+
+// CMYKA64Img is an in-memory image whose At method returns CMYKA64 values.
+type CMYKA64Img struct {
+	// Pix holds the image's pixels, in C, M, Y, K, A order and big-endian
+	// format. The pixel at (x, y) starts at
+	// Pix[(y-Rect.Min.Y)*Stride + (x-Rect.Min.X)*10].
+	Pix []uint8
+	// Stride is the Pix stride (in bytes) between vertically adjacent pixels.
+	Stride int
+	// Rect is the image's bounds.
+	Rect image.Rectangle
+}
+
+func (p *CMYKA64Img) ColorModel() color.Model { return CMYKA64Model }
+
+func (p *CMYKA64Img) Bounds() image.Rectangle { return p.Rect }
+
+func (p *CMYKA64Img) At(x, y int) color.Color {
+	return p.CMYKA64At(x, y)
+}
+
+func (p *CMYKA64Img) RGBA64At(x, y int) color.RGBA64 {
+	r, g, b, a := p.CMYKA64At(x, y).RGBA()
+	return color.RGBA64{uint16(r), uint16(g), uint16(b), uint16(a)}
+}
+
+func (p *CMYKA64Img) CMYKA64At(x, y int) CMYKA64 {
+	if !(image.Point{x, y}.In(p.Rect)) {
+		return CMYKA64{}
+	}
+	i := p.PixOffset(x, y)
+	s := p.Pix[i : i+10 : i+10] // Small cap improves performance, see https://golang.org/issue/27857
+	return CMYKA64{
+		uint16(s[0])<<8 | uint16(s[1]),
+		uint16(s[2])<<8 | uint16(s[3]),
+		uint16(s[4])<<8 | uint16(s[5]),
+		uint16(s[6])<<8 | uint16(s[7]),
+		uint16(s[8])<<8 | uint16(s[9]),
+	}
+}
+
+// PixOffset returns the index of the first element of Pix that corresponds to
+// the pixel at (x, y).
+func (p *CMYKA64Img) PixOffset(x, y int) int {
+	return pixOffset(p.Rect, p.Stride, 10, x, y)
+}
+
+func (p *CMYKA64Img) Set(x, y int, c color.Color) {
+	p.SetCMYKA64(x, y, CMYKA64Model.Convert(c).(CMYKA64))
+}
+
+// SetRGBA64 sets the pixel at (x, y) to c, converted as by CMYKA64Model,
+// without going through a color.Color. Together with RGBA64At, it makes
+// CMYKA64Img a draw.RGBA64Image.
+func (p *CMYKA64Img) SetRGBA64(x, y int, c color.RGBA64) {
+	p.SetCMYKA64(x, y, rgbaToCMYKA64(uint32(c.R), uint32(c.G), uint32(c.B), uint32(c.A)))
+}
+
+func (p *CMYKA64Img) SetCMYKA64(x, y int, c CMYKA64) {
+	if !(image.Point{x, y}.In(p.Rect)) {
+		return
+	}
+	i := p.PixOffset(x, y)
+	s := p.Pix[i : i+10 : i+10] // Small cap improves performance, see https://golang.org/issue/27857
+	s[0] = uint8(c.C >> 8)
+	s[1] = uint8(c.C)
+	s[2] = uint8(c.M >> 8)
+	s[3] = uint8(c.M)
+	s[4] = uint8(c.Y >> 8)
+	s[5] = uint8(c.Y)
+	s[6] = uint8(c.K >> 8)
+	s[7] = uint8(c.K)
+	s[8] = uint8(c.A >> 8)
+	s[9] = uint8(c.A)
+}
+
+// SubImage returns an image representing the portion of the image p visible
+// through r. The returned value shares pixels with the original image.
+func (p *CMYKA64Img) SubImage(r image.Rectangle) image.Image {
+	r = r.Intersect(p.Rect)
+	// If r1 and r2 are Rectangles, r1.Intersect(r2) is not guaranteed to be inside
+	// either r1 or r2 if the intersection is empty. Without explicitly checking for
+	// this, the Pix[i:j:j] expression below can panic.
+	if r.Empty() {
+		return &CMYKA64Img{}
+	}
+	i, j := subPix(p.Rect, p.Stride, 10, r)
+	return &CMYKA64Img{
+		Pix:    p.Pix[i:j:j],
+		Stride: p.Stride,
+		Rect:   r,
+	}
+}
+
+// Opaque scans the entire image and reports whether it is fully opaque.
+func (p *CMYKA64Img) Opaque() bool {
+	return opaqueAlpha(p.Pix, p.Rect, p.Stride, 10, 8, 2)
+}
+
+// NewCMYKA64 returns a new CMYKA64Img image with the given bounds.
+func NewCMYKA64(r image.Rectangle) *CMYKA64Img {
+	return &CMYKA64Img{
+		Pix:    make([]uint8, 10*r.Dx()*r.Dy()),
+		Stride: 10 * r.Dx(),
+		Rect:   r,
+	}
+}
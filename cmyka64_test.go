@@ -0,0 +1,156 @@
+package tiff
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"testing"
+)
+
+// TestDecodeCMYKA64 tests that 16-bit CMYK images with an unassociated alpha
+// channel are decoded into a CMYKA64Img with their exact samples.
+func TestDecodeCMYKA64(t *testing.T) {
+	const w, h = 3, 2
+	want := make([]CMYKA64, w*h)
+	data := make([]byte, 10*w*h)
+	for i := range want {
+		v := uint16(i) * 0x1235
+		want[i] = CMYKA64{v, v + 1, v + 0x100, 0xffff - v, 0x8000 + v}
+		for j, s := range []uint16{want[i].C, want[i].M, want[i].Y, want[i].K, want[i].A} {
+			binary.LittleEndian.PutUint16(data[10*i+2*j:], s)
+		}
+	}
+	b := buildTIFF(t, testPage{data, []ifdEntry{
+		{tImageWidth, dtShort, []uint32{w}},
+		{tImageLength, dtShort, []uint32{h}},
+		{tBitsPerSample, dtShort, []uint32{16, 16, 16, 16, 16}},
+		{tPhotometricInterpretation, dtShort, []uint32{pCMYK}},
+		{tStripOffsets, dtLong, []uint32{0}},
+		{tSamplesPerPixel, dtShort, []uint32{5}},
+		{tRowsPerStrip, dtShort, []uint32{h}},
+		{tStripByteCounts, dtLong, []uint32{uint32(len(data))}},
+		{tExtraSamples, dtShort, []uint32{2}}, // Unassociated alpha.
+	}})
+
+	c, err := DecodeConfig(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.ColorModel != CMYKA64Model {
+		t.Errorf("got color model %v, want CMYKA64Model", c.ColorModel)
+	}
+	img, err := Decode(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, ok := img.(*CMYKA64Img)
+	if !ok {
+		t.Fatalf("got image of type %T, want *CMYKA64Img", img)
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if got := m.CMYKA64At(x, y); got != want[y*w+x] {
+				t.Errorf("at (%d, %d): got %v, want %v", x, y, got, want[y*w+x])
+			}
+		}
+	}
+}
+
+// TestDecodeCMYK64 tests that 16-bit CMYK images without an alpha channel
+// are decoded into an opaque CMYKA64Img.
+func TestDecodeCMYK64(t *testing.T) {
+	const w, h = 2, 2
+	for _, n := range []int{4, 5} {
+		data := make([]byte, 2*n*w*h)
+		for i := range data {
+			data[i] = uint8(i*29 + 3)
+		}
+		bps := []uint32{16, 16, 16, 16, 16}[:n]
+		b := buildTIFF(t, testPage{data, []ifdEntry{
+			{tImageWidth, dtShort, []uint32{w}},
+			{tImageLength, dtShort, []uint32{h}},
+			{tBitsPerSample, dtShort, bps},
+			{tPhotometricInterpretation, dtShort, []uint32{pCMYK}},
+			{tStripOffsets, dtLong, []uint32{0}},
+			{tSamplesPerPixel, dtShort, []uint32{uint32(n)}},
+			{tRowsPerStrip, dtShort, []uint32{h}},
+			{tStripByteCounts, dtLong, []uint32{uint32(len(data))}},
+		}})
+		img, err := Decode(bytes.NewReader(b))
+		if err != nil {
+			t.Fatalf("%d samples: %v", n, err)
+		}
+		m, ok := img.(*CMYKA64Img)
+		if !ok {
+			t.Fatalf("%d samples: got image of type %T, want *CMYKA64Img", n, img)
+		}
+		for i := 0; i < w*h; i++ {
+			s := func(j int) uint16 { return binary.LittleEndian.Uint16(data[2*(n*i+j):]) }
+			want := CMYKA64{s(0), s(1), s(2), s(3), 0xffff}
+			if got := m.CMYKA64At(i%w, i/w); got != want {
+				t.Errorf("%d samples, pixel %d: got %v, want %v", n, i, got, want)
+			}
+		}
+	}
+}
+
+// TestEncodeCMYKA64 tests that a CMYKA64Img is written with 16-bit samples
+// and read back unchanged.
+func TestEncodeCMYKA64(t *testing.T) {
+	m := NewCMYKA64(image.Rect(0, 0, 5, 3))
+	for i := range m.Pix {
+		m.Pix[i] = uint8(i*i + 7)
+	}
+	for _, opts := range []*Options{nil, {Compression: LZW, Predictor: true}, {TileWidth: 16, TileLength: 16}} {
+		var buf bytes.Buffer
+		if err := Encode(&buf, m, opts); err != nil {
+			t.Fatal(err)
+		}
+		img, err := Decode(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("%+v: %v", opts, err)
+		}
+		got, ok := img.(*CMYKA64Img)
+		if !ok {
+			t.Fatalf("%+v: got image of type %T, want *CMYKA64Img", opts, img)
+		}
+		if !bytes.Equal(got.Pix, m.Pix) {
+			t.Errorf("%+v: decoded pixels differ", opts)
+		}
+	}
+}
+
+func TestCMYKA64Img(t *testing.T) {
+	m := NewCMYKA64(image.Rect(0, 0, 4, 3))
+	if m.Opaque() {
+		t.Error("new image is opaque")
+	}
+	c := CMYKA64{0x1234, 0x5678, 0x9abc, 0xdef0, 0xffff}
+	for y := 0; y < 3; y++ {
+		for x := 0; x < 4; x++ {
+			m.SetCMYKA64(x, y, c)
+		}
+	}
+	if !m.Opaque() {
+		t.Error("image with full alpha is not opaque")
+	}
+	sub := m.SubImage(image.Rect(1, 1, 3, 3)).(*CMYKA64Img)
+	if got := sub.CMYKA64At(2, 2); got != c {
+		t.Errorf("SubImage: got %v, want %v", got, c)
+	}
+	sub.Set(2, 2, CMYKA{0xff, 0, 0, 0, 0x80})
+	if got, want := m.CMYKA64At(2, 2), (CMYKA64{0xffff, 0, 0, 0, 0x8080}); got != want {
+		t.Errorf("Set through SubImage: got %v, want %v", got, want)
+	}
+	if m.Opaque() {
+		t.Error("image with a translucent pixel is opaque")
+	}
+
+	// Opaque colors have the same RGBA value as their 8-bit equivalent.
+	c8 := CMYKA{0x12, 0x56, 0x9a, 0xde, 0xff}
+	r0, g0, b0, a0 := c8.RGBA()
+	r1, g1, b1, a1 := CMYKA64Model.Convert(c8).RGBA()
+	if r0>>8 != r1>>8 || g0>>8 != g1>>8 || b0>>8 != b1>>8 || a0 != a1 {
+		t.Errorf("got RGBA %v, want %v", []uint32{r1, g1, b1, a1}, []uint32{r0, g0, b0, a0})
+	}
+}
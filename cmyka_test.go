@@ -0,0 +1,365 @@
+package tiff
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"reflect"
+	"testing"
+)
+
+// TestBlendCMYKA tests the linear blend of two CMYKAImg images.
+func TestBlendCMYKA(t *testing.T) {
+	r := image.Rect(0, 0, 3, 2)
+	cyan, magenta := NewCMYKA(r), NewCMYKA(r)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			cyan.SetCMYKA(x, y, CMYKA{0xff, 0, 0, 0, 0xff})
+			magenta.SetCMYKA(x, y, CMYKA{0, 0xff, 0, 0, 0xff})
+		}
+	}
+
+	for _, tc := range []struct {
+		t    float64
+		want CMYKA
+	}{
+		{0, CMYKA{0xff, 0, 0, 0, 0xff}},
+		{0.5, CMYKA{0x80, 0x80, 0, 0, 0xff}},
+		{1, CMYKA{0, 0xff, 0, 0, 0xff}},
+	} {
+		m, err := BlendCMYKA(cyan, magenta, tc.t)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				if got := m.CMYKAt(x, y); got != tc.want {
+					t.Fatalf("t=%v: got %v at (%d, %d), want %v", tc.t, got, x, y, tc.want)
+				}
+			}
+		}
+	}
+
+	if _, err := BlendCMYKA(cyan, magenta, 1.5); err == nil {
+		t.Error("t=1.5: got nil error, want non-nil")
+	}
+	if _, err := BlendCMYKA(cyan, NewCMYKA(image.Rect(0, 0, 2, 2)), 0.5); err == nil {
+		t.Error("different bounds: got nil error, want non-nil")
+	}
+}
+
+func TestCMYKAOpaque(t *testing.T) {
+	m := NewCMYKA(image.Rect(0, 0, 4, 3))
+	for y := 0; y < 3; y++ {
+		for x := 0; x < 4; x++ {
+			m.SetCMYKA(x, y, CMYKA{0x10, 0x20, 0x30, 0x40, 0xff})
+		}
+	}
+	if !m.Opaque() {
+		t.Error("fully opaque image: got false, want true")
+	}
+	m.SetCMYKA(3, 2, CMYKA{0x10, 0x20, 0x30, 0x40, 0xfe})
+	if m.Opaque() {
+		t.Error("partially transparent image: got true, want false")
+	}
+	// The transparent pixel is outside of the sub-image.
+	if sub := m.SubImage(image.Rect(0, 0, 3, 3)); !sub.(*CMYKAImg).Opaque() {
+		t.Error("opaque sub-image: got false, want true")
+	}
+	if NewCMYKA(image.Rect(0, 0, 2, 2)).Opaque() {
+		t.Error("new image: got true, want false")
+	}
+	if !NewCMYKA(image.Rect(0, 0, 0, 0)).Opaque() {
+		t.Error("empty image: got false, want true")
+	}
+}
+
+func TestCMYKASetRow(t *testing.T) {
+	m := NewCMYKA(image.Rect(2, 1, 6, 4))
+	for y := 1; y < 4; y++ {
+		row := make([]uint8, 5*4)
+		for i := range row {
+			row[i] = uint8(10*y + i)
+		}
+		if err := m.SetRow(y, row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for y := 1; y < 4; y++ {
+		for x := 2; x < 6; x++ {
+			i := uint8(10*y + 5*(x-2))
+			want := CMYKA{i, i + 1, i + 2, i + 3, i + 4}
+			if got := m.CMYKAt(x, y); got != want {
+				t.Errorf("at (%d, %d): got %v, want %v", x, y, got, want)
+			}
+		}
+	}
+
+	if err := m.SetRow(4, make([]uint8, 20)); err == nil {
+		t.Error("row outside the bounds: got nil error")
+	}
+	if err := m.SetRow(1, make([]uint8, 19)); err == nil {
+		t.Error("short row: got nil error")
+	}
+}
+
+func BenchmarkCMYKASetRow(b *testing.B) {
+	m := NewCMYKA(image.Rect(0, 0, 1024, 64))
+	row := make([]uint8, 5*1024)
+	b.SetBytes(int64(len(m.Pix)))
+	for i := 0; i < b.N; i++ {
+		for y := 0; y < 64; y++ {
+			m.SetRow(y, row)
+		}
+	}
+}
+
+func BenchmarkCMYKASet(b *testing.B) {
+	m := NewCMYKA(image.Rect(0, 0, 1024, 64))
+	var c color.Color = CMYKA{}
+	b.SetBytes(int64(len(m.Pix)))
+	for i := 0; i < b.N; i++ {
+		for y := 0; y < 64; y++ {
+			for x := 0; x < 1024; x++ {
+				m.Set(x, y, c)
+			}
+		}
+	}
+}
+
+// TestEncodeCMYKA tests that a CMYKAImg is written as a CMYK image with an
+// alpha sample and decoded back into a CMYKAImg.
+func TestEncodeCMYKA(t *testing.T) {
+	m := NewCMYKA(image.Rect(0, 0, 37, 21))
+	for i := range m.Pix {
+		m.Pix[i] = uint8(i * 7)
+	}
+	for _, opts := range []*Options{
+		nil,
+		{Compression: LZW, Predictor: true},
+		{Compression: Deflate, TileWidth: 16, TileLength: 16},
+	} {
+		var buf bytes.Buffer
+		if err := Encode(&buf, m, opts); err != nil {
+			t.Fatal(err)
+		}
+		err := WalkIFDs(bytes.NewReader(buf.Bytes()), func(_ int64, tags map[uint16]Tag) error {
+			if got := tags[tPhotometricInterpretation].Value; !reflect.DeepEqual(got, []uint64{pCMYK}) {
+				t.Errorf("%+v: got PhotometricInterpretation %v, want %d", opts, got, pCMYK)
+			}
+			if got := tags[tExtraSamples].Value; !reflect.DeepEqual(got, []uint64{2}) {
+				t.Errorf("%+v: got ExtraSamples %v, want 2", opts, got)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		img, err := Decode(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, ok := img.(*CMYKAImg)
+		if !ok {
+			t.Fatalf("%+v: got image of type %T, want *CMYKAImg", opts, img)
+		}
+		if got.Rect != m.Rect || !bytes.Equal(got.Pix, m.Pix) {
+			t.Errorf("%+v: decoded image differs", opts)
+		}
+	}
+}
+
+// rgba64Image is the interface of draw.RGBA64Image, which is not available
+// in all supported Go versions.
+type rgba64Image interface {
+	image.Image
+	Set(x, y int, c color.Color)
+	RGBA64At(x, y int) color.RGBA64
+	SetRGBA64(x, y int, c color.RGBA64)
+}
+
+// TestCMYKASetRGBA64 tests that SetRGBA64 of CMYKAImg and CMYKA64Img sets
+// the same pixels as Set.
+func TestCMYKASetRGBA64(t *testing.T) {
+	r := image.Rect(1, 1, 4, 3)
+	for _, pair := range [][2]rgba64Image{
+		{NewCMYKA(r), NewCMYKA(r)},
+		{NewCMYKA64(r), NewCMYKA64(r)},
+	} {
+		a, b := pair[0], pair[1]
+		for y := r.Min.Y - 1; y <= r.Max.Y; y++ {
+			for x := r.Min.X - 1; x <= r.Max.X; x++ {
+				c := color.RGBA64{uint16(x * 0x3000), uint16(y * 0x5000), 0x8000, 0xffff}
+				a.Set(x, y, c)
+				b.SetRGBA64(x, y, c)
+			}
+		}
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				if ca, cb := a.RGBA64At(x, y), b.RGBA64At(x, y); ca != cb {
+					t.Errorf("%T: pixel (%d, %d): Set gave %v, SetRGBA64 %v", a, x, y, ca, cb)
+				}
+			}
+		}
+	}
+}
+
+// TestCMYKAModel tests that CMYKAModel and CMYKA64Model preserve alpha and
+// that the bulk conversions agree with the model.
+func TestCMYKAModel(t *testing.T) {
+	if m := NewCMYKA(image.Rect(0, 0, 1, 1)).ColorModel(); m != CMYKAModel {
+		t.Errorf("got color model %v, want CMYKAModel", m)
+	}
+	near := func(a, b uint32) bool { return a-b+0x200 <= 0x400 } // |a-b| <= 0x200
+	for _, c := range []color.NRGBA{{200, 100, 50, 128}, {10, 20, 30, 255}, {255, 255, 255, 1}, {1, 2, 3, 0}} {
+		want := [4]uint32{}
+		want[0], want[1], want[2], want[3] = c.RGBA()
+		for _, model := range []color.Model{CMYKAModel, CMYKA64Model} {
+			got := [4]uint32{}
+			got[0], got[1], got[2], got[3] = model.Convert(c).RGBA()
+			for i := range got {
+				if !near(got[i], want[i]) {
+					t.Errorf("%v converted by %T: got RGBA %v, want %v", c, model.Convert(c), got, want)
+					break
+				}
+			}
+		}
+		cmyka := CMYKAModel.Convert(c).(CMYKA)
+		if cmyka.A != c.A {
+			t.Errorf("%v: got alpha %d", c, cmyka.A)
+		}
+		if c.A == 0 {
+			continue
+		}
+		dst := make([]uint8, 5)
+		if n := NRGBAToCMYKA(dst, []uint8{c.R, c.G, c.B, c.A}); n != 1 || dst[4] != c.A {
+			t.Errorf("NRGBAToCMYKA(%v): got %v, %d pixels", c, dst, n)
+		}
+		if c.A == 0xff && !reflect.DeepEqual(dst, []uint8{cmyka.C, cmyka.M, cmyka.Y, cmyka.K, cmyka.A}) {
+			t.Errorf("NRGBAToCMYKA(%v): got %v, want %v", c, dst, cmyka)
+		}
+		back := make([]uint8, 8)
+		if n := CMYKAToNRGBA(back, dst); n != 1 {
+			t.Errorf("CMYKAToNRGBA: converted %d pixels, want 1", n)
+		}
+		if back[3] != c.A {
+			t.Errorf("CMYKAToNRGBA(%v): got %v", dst, back[:4])
+		}
+	}
+	for _, c := range []color.Color{CMYKA{1, 2, 3, 4, 5}, color.CMYK{1, 2, 3, 4}} {
+		if got := CMYKAModel.Convert(c).(CMYKA); got.C != 1 || got.M != 2 || got.Y != 3 || got.K != 4 {
+			t.Errorf("%v: got %v", c, got)
+		}
+	}
+}
+
+// TestConvertCMYKAToNRGBA tests that the bulk conversions of images give
+// the same pixels as conversions of single colors, within the rectangle.
+func TestConvertCMYKAToNRGBA(t *testing.T) {
+	src := NewCMYKA(image.Rect(-2, -1, 6, 4))
+	for i := range src.Pix {
+		src.Pix[i] = uint8(i * 37)
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, 10, 10))
+	rect := image.Rect(-1, 0, 4, 3)
+	ConvertCMYKAToNRGBA(dst, src, rect)
+	back := NewCMYKA(src.Rect)
+	ConvertNRGBAToCMYKA(back, dst, rect)
+	for y := dst.Rect.Min.Y; y < dst.Rect.Max.Y; y++ {
+		for x := dst.Rect.Min.X; x < dst.Rect.Max.X; x++ {
+			in := (image.Point{x, y}).In(rect)
+			c := src.CMYKAt(x, y)
+			want := color.NRGBA{}
+			if in {
+				want.R, want.G, want.B = color.CMYKToRGB(c.C, c.M, c.Y, c.K)
+				want.A = c.A
+			}
+			if got := dst.NRGBAAt(x, y); got != want {
+				t.Errorf("NRGBA at (%d, %d): got %v, want %v", x, y, got, want)
+			}
+			if !(image.Point{x, y}).In(back.Rect) {
+				continue
+			}
+			want2 := CMYKA{}
+			if in {
+				want2.C, want2.M, want2.Y, want2.K = color.RGBToCMYK(want.R, want.G, want.B)
+				want2.A = want.A
+			}
+			if got := back.CMYKAt(x, y); got != want2 {
+				t.Errorf("CMYKA at (%d, %d): got %v, want %v", x, y, got, want2)
+			}
+		}
+	}
+
+	// Rectangles outside either image convert nothing.
+	ConvertCMYKAToNRGBA(dst, src, image.Rect(20, 20, 30, 30))
+	ConvertNRGBAToCMYKA(back, dst, image.Rect(-5, -5, 0, 0))
+}
+
+func BenchmarkConvertCMYKAToNRGBA(b *testing.B) {
+	src := NewCMYKA(image.Rect(0, 0, 1024, 64))
+	dst := image.NewNRGBA(src.Rect)
+	b.SetBytes(int64(len(src.Pix)))
+	for i := 0; i < b.N; i++ {
+		ConvertCMYKAToNRGBA(dst, src, src.Rect)
+	}
+}
+
+func BenchmarkConvertCMYKAToNRGBAAt(b *testing.B) {
+	src := NewCMYKA(image.Rect(0, 0, 1024, 64))
+	dst := image.NewNRGBA(src.Rect)
+	b.SetBytes(int64(len(src.Pix)))
+	for i := 0; i < b.N; i++ {
+		for y := 0; y < 64; y++ {
+			for x := 0; x < 1024; x++ {
+				dst.Set(x, y, src.At(x, y))
+			}
+		}
+	}
+}
+
+func TestCMYKAlphaSplit(t *testing.T) {
+	r := image.Rect(2, 1, 7, 4)
+	m := NewCMYKA(r)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			m.SetCMYKA(x, y, CMYKA{uint8(x), uint8(y), uint8(x * y), 0x40, uint8(x*0x20 + y)})
+		}
+	}
+	// A SubImage has a stride larger than its rows.
+	sub := m.SubImage(image.Rect(3, 1, 6, 4)).(*CMYKAImg)
+	a, cmyk := sub.AlphaChannel(), sub.DropAlpha()
+	if a.Rect != sub.Rect || cmyk.Rect != sub.Rect {
+		t.Fatalf("got bounds %v and %v, want %v", a.Rect, cmyk.Rect, sub.Rect)
+	}
+	for y := sub.Rect.Min.Y; y < sub.Rect.Max.Y; y++ {
+		for x := sub.Rect.Min.X; x < sub.Rect.Max.X; x++ {
+			c := sub.CMYKAt(x, y)
+			if got := a.AlphaAt(x, y).A; got != c.A {
+				t.Errorf("AlphaChannel: got %d at (%d, %d), want %d", got, x, y, c.A)
+			}
+			if got, want := cmyk.CMYKAt(x, y), (color.CMYK{c.C, c.M, c.Y, c.K}); got != want {
+				t.Errorf("DropAlpha: got %v at (%d, %d), want %v", got, x, y, want)
+			}
+		}
+	}
+	// MergeCMYKAlpha restores the image, with pixels outside the mask
+	// transparent.
+	for _, mask := range []image.Rectangle{sub.Rect, image.Rect(3, 2, 5, 4)} {
+		merged := MergeCMYKAlpha(cmyk, a.SubImage(mask).(*image.Alpha))
+		if merged.Rect != sub.Rect {
+			t.Fatalf("MergeCMYKAlpha: got bounds %v, want %v", merged.Rect, sub.Rect)
+		}
+		for y := sub.Rect.Min.Y; y < sub.Rect.Max.Y; y++ {
+			for x := sub.Rect.Min.X; x < sub.Rect.Max.X; x++ {
+				want := sub.CMYKAt(x, y)
+				if !(image.Point{x, y}.In(mask)) {
+					want.A = 0
+				}
+				if got := merged.CMYKAt(x, y); got != want {
+					t.Errorf("mask %v: got %v at (%d, %d), want %v", mask, got, x, y, want)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package tiff
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// A codec compresses and decompresses blocks for a Compression value that
+// this package does not implement itself.
+type codec struct {
+	enc func(io.Writer) io.WriteCloser
+	dec func(io.Reader) io.Reader
+}
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = make(map[uint16]codec)
+)
+
+// builtinCompression reports whether this package handles the Compression
+// value id itself, for decoding or encoding.
+func builtinCompression(id uint16) bool {
+	switch id {
+	case cNone, cCCITT, cG3, cG4, cLZW, cJPEGOld, cJPEG, cDeflate, cPackBits, cDeflateOld:
+		return true
+	}
+	return false
+}
+
+// RegisterCompression registers a codec for the Compression value id, such
+// as 50000 for Zstandard or 34925 for LZMA, which this package does not
+// implement itself. dec returns a reader of the decompressed data of a strip
+// or tile read from its argument; if the reader is an io.Closer, it is closed
+// once the block is read. enc returns a writer that writes the compressed
+// form of the data written to it to its argument; it may be nil if the
+// codec can only decode. Images are encoded with the codec if
+// Options.Compression is RegisteredCompression(id).
+//
+// Registering a codec for an id that already has one replaces it.
+// RegisterCompression panics if id is a value that this package handles
+// itself or if dec is nil.
+func RegisterCompression(id uint16, enc func(io.Writer) io.WriteCloser, dec func(io.Reader) io.Reader) {
+	if builtinCompression(id) {
+		panic(fmt.Sprintf("tiff: RegisterCompression of built-in compression %d", id))
+	}
+	if dec == nil {
+		panic("tiff: RegisterCompression with nil decoder")
+	}
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	codecs[id] = codec{enc, dec}
+}
+
+// registeredCodec returns the codec registered for the Compression value id.
+func registeredCodec(id uint32) (codec, bool) {
+	if id > 0xffff {
+		return codec{}, false
+	}
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	c, ok := codecs[uint16(id)]
+	return c, ok
+}
+
+// registeredBase is the CompressionType of the Compression value 0; the
+// CompressionTypes of registered codecs follow it.
+const registeredBase = 1 << 16
+
+// RegisteredCompression returns the CompressionType that selects the codec
+// registered with RegisterCompression for the Compression value id.
+func RegisteredCompression(id uint16) CompressionType {
+	return CompressionType(registeredBase + int(id))
+}
@@ -0,0 +1,90 @@
+package tiff
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+// TestRegisterCompression tests that images are encoded and decoded with
+// registered codecs.
+func TestRegisterCompression(t *testing.T) {
+	const id = 65000
+	decoded := 0
+	inflate := func(r io.Reader) io.Reader { return flate.NewReader(r) }
+	RegisterCompression(id, func(w io.Writer) io.WriteCloser {
+		fw, err := flate.NewWriter(w, flate.BestSpeed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fw
+	}, func(r io.Reader) io.Reader {
+		decoded++
+		return flate.NewReader(r)
+	})
+	defer func() {
+		codecsMu.Lock()
+		delete(codecs, id)
+		codecsMu.Unlock()
+	}()
+
+	img, err := openImage("video-001.tiff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, opt := range []*Options{
+		{Compression: RegisteredCompression(id)},
+		{Compression: RegisteredCompression(id), Predictor: true, TileWidth: 64, TileLength: 64},
+	} {
+		var buf bytes.Buffer
+		if err := Encode(&buf, img, opt); err != nil {
+			t.Fatal(err)
+		}
+		err := WalkIFDs(bytes.NewReader(buf.Bytes()), func(_ int64, tags map[uint16]Tag) error {
+			if got := tags[tCompression].Value; !reflect.DeepEqual(got, []uint64{id}) {
+				t.Errorf("%+v: got Compression %v, want %d", opt, got, id)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		m, err := Decode(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		compare(t, img, m)
+		if got := sourceInfoOf(t, buf.Bytes()).Options().Compression; got != opt.Compression {
+			t.Errorf("%+v: SourceInfo.Options has compression %d", opt, got)
+		}
+	}
+	if decoded == 0 {
+		t.Error("decoder was not used")
+	}
+
+	// A codec that can only decode cannot be used for encoding.
+	var buf bytes.Buffer
+	if err := Encode(&buf, img, &Options{Compression: RegisteredCompression(id)}); err != nil {
+		t.Fatal(err)
+	}
+	RegisterCompression(id, nil, inflate)
+	if got := sourceInfoOf(t, buf.Bytes()).Options().Compression; got != LZW {
+		t.Errorf("decode-only codec: SourceInfo.Options has compression %d, want LZW", got)
+	}
+	if err := Encode(ioutil.Discard, img, &Options{Compression: RegisteredCompression(id)}); err == nil {
+		t.Error("decode-only codec: got nil error")
+	}
+	if err := Encode(ioutil.Discard, img, &Options{Compression: RegisteredCompression(id + 1)}); err == nil {
+		t.Error("unregistered codec: got nil error")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("registering LZW did not panic")
+		}
+	}()
+	RegisterCompression(cLZW, nil, inflate)
+}
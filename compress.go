@@ -14,20 +14,19 @@ type byteReader interface {
 	io.ByteReader
 }
 
-// unpackBits decodes the PackBits-compressed data in src and returns the
-// uncompressed data.
+// unpackBits decodes the PackBits-compressed data in src, appends it to dst
+// and returns the extended slice. It stops once size bytes have been
+// decoded, so that runs beyond the end of a block do not use memory.
 //
 // The PackBits compression format is described in section 9 (p. 42)
 // of the TIFF spec.
-func unpackBits(r io.Reader) ([]byte, error) {
-	buf := make([]byte, 128)
-	dst := make([]byte, 0, 1024)
+func unpackBits(r io.Reader, dst []byte, size int) ([]byte, error) {
 	br, ok := r.(byteReader)
 	if !ok {
 		br = bufio.NewReader(r)
 	}
 
-	for {
+	for len(dst) < size {
 		b, err := br.ReadByte()
 		if err != nil {
 			if err == io.EOF {
@@ -38,11 +37,11 @@ func unpackBits(r io.Reader) ([]byte, error) {
 		code := int(int8(b))
 		switch {
 		case code >= 0:
-			n, err := io.ReadFull(br, buf[:code+1])
-			if err != nil {
+			m := len(dst)
+			dst = append(dst, make([]byte, code+1)...)
+			if _, err := io.ReadFull(br, dst[m:]); err != nil {
 				return nil, err
 			}
-			dst = append(dst, buf[:n]...)
 		case code == -128:
 			// No-op.
 		default:
@@ -50,9 +49,64 @@ func unpackBits(r io.Reader) ([]byte, error) {
 				return nil, err
 			}
 			for j := 0; j < 1-code; j++ {
-				buf[j] = b
+				dst = append(dst, b)
 			}
-			dst = append(dst, buf[:1-code]...)
 		}
 	}
+	return dst, nil
+}
+
+// packBitsWriter is an io.WriteCloser that buffers the data written to it
+// and writes it PackBits-compressed to w when it is closed. The data is
+// split into rows rows of equal length, each of which is packed on its
+// own, as section 9 of the spec requires.
+type packBitsWriter struct {
+	w    io.Writer
+	rows int
+	buf  []byte
+}
+
+func (p *packBitsWriter) Write(b []byte) (int, error) {
+	p.buf = append(p.buf, b...)
+	return len(b), nil
+}
+
+func (p *packBitsWriter) Close() error {
+	n := len(p.buf)
+	if p.rows > 1 && n%p.rows == 0 {
+		n /= p.rows
+	}
+	var dst []byte
+	for src := p.buf; len(src) > 0; src = src[minInt(n, len(src)):] {
+		dst = packBits(dst, src[:minInt(n, len(src))])
+	}
+	_, err := p.w.Write(dst)
+	return err
+}
+
+// packBits appends the PackBits-compressed src to dst and returns the
+// extended slice. Runs of two or more equal bytes that start a packet are
+// replicated; literal packets end before runs of three or more bytes.
+func packBits(dst, src []byte) []byte {
+	for i := 0; i < len(src); {
+		j := i + 1
+		for j < len(src) && j-i < 128 && src[j] == src[i] {
+			j++
+		}
+		if j-i >= 2 {
+			dst = append(dst, byte(1-(j-i)), src[i])
+			i = j
+			continue
+		}
+		for j < len(src) && j-i < 128 {
+			if j+2 < len(src) && src[j] == src[j+1] && src[j] == src[j+2] {
+				break
+			}
+			j++
+		}
+		dst = append(dst, byte(j-i-1))
+		dst = append(dst, src[i:j]...)
+		i = j
+	}
+	return dst
 }
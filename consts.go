@@ -16,55 +16,179 @@ package tiff
 // The presence of a length means that each IFD is effectively an array.
 
 const (
-	leHeader = "II\x2A\x00" // Header for little-endian files.
-	beHeader = "MM\x00\x2A" // Header for big-endian files.
+	leHeader    = "II\x2A\x00" // Header for little-endian files.
+	beHeader    = "MM\x00\x2A" // Header for big-endian files.
+	bigLEHeader = "II\x2B\x00" // Header for little-endian BigTIFF files.
+	bigBEHeader = "MM\x00\x2B" // Header for big-endian BigTIFF files.
 
-	ifdLen = 12 // Length of an IFD entry in bytes.
+	ifdLen    = 12 // Length of an IFD entry in bytes.
+	bigIFDLen = 20 // Length of an IFD entry in BigTIFF files.
+
+	// maxEntries limits the number of entries of an IFD, which is at most
+	// 65535 in classic TIFF files but not limited in BigTIFF files, so
+	// that corrupt files do not cause huge allocations.
+	maxEntries = 1 << 16
 )
 
-// Data types (p. 14-16 of the spec).
+// Data types (p. 14-16 of the spec). The 64-bit types were introduced by
+// BigTIFF but occasionally show up in classic TIFF files, too.
 const (
-	dtByte     = 1
-	dtASCII    = 2
-	dtShort    = 3
-	dtLong     = 4
-	dtRational = 5
+	dtByte      = 1
+	dtASCII     = 2
+	dtShort     = 3
+	dtLong      = 4
+	dtRational  = 5
+	dtSByte     = 6
+	dtUndefined = 7
+	dtSShort    = 8
+	dtSLong     = 9
+	dtSRational = 10
+	dtFloat     = 11
+	dtDouble    = 12
+	dtIFD       = 13
+	dtLong8     = 16
+	dtSLong8    = 17
+	dtIFD8      = 18
 )
 
-// The length of one instance of each data type in bytes.
-var lengths = [...]uint32{0, 1, 1, 2, 4, 8}
+// The length of one instance of each data type in bytes. Unassigned data
+// types have a length of 0.
+var lengths = [...]uint32{0, 1, 1, 2, 4, 8, 1, 1, 2, 4, 8, 4, 8, 4, 0, 0, 8, 8, 8}
 
 // Tags (see p. 28-41 of the spec).
 const (
+	tNewSubfileType            = 254
+	tSubfileType               = 255
 	tImageWidth                = 256
 	tImageLength               = 257
 	tBitsPerSample             = 258
 	tCompression               = 259
 	tPhotometricInterpretation = 262
+	tThreshholding             = 263
+	tCellWidth                 = 264
+	tCellLength                = 265
 
 	tFillOrder = 266
 
+	tImageDescription = 270
+	tMake             = 271
+	tModel            = 272
+
 	tStripOffsets    = 273
+	tOrientation     = 274
 	tSamplesPerPixel = 277
 	tRowsPerStrip    = 278
 	tStripByteCounts = 279
+	tMinSampleValue  = 280
+	tMaxSampleValue  = 281
 
 	tT4Options = 292 // CCITT Group 3 options, a set of 32 flag bits.
 	tT6Options = 293 // CCITT Group 4 options, a set of 32 flag bits.
 
+	tPageNumber = 297
+
 	tTileWidth      = 322
 	tTileLength     = 323
 	tTileOffsets    = 324
 	tTileByteCounts = 325
 
+	tJPEGTables = 347
+
+	// Tags of old-style JPEG compression (TIFF Technical Note 2).
+	tJPEGInterchangeFormat       = 513
+	tJPEGInterchangeFormatLength = 514
+
 	tXResolution    = 282
 	tYResolution    = 283
 	tResolutionUnit = 296
 
+	tPlanarConfiguration = 284
+	tFreeOffsets         = 288
+	tFreeByteCounts      = 289
+	tGrayResponseUnit    = 290
+	tGrayResponseCurve   = 291
+
+	tSoftware     = 305
+	tDateTime     = 306
+	tArtist       = 315
+	tHostComputer = 316
+	tCopyright    = 33432
+
 	tPredictor    = 317
 	tColorMap     = 320
 	tExtraSamples = 338
 	tSampleFormat = 339
+
+	// Parameters of YCbCr images (p. 89-94 of the spec).
+	tYCbCrCoefficients   = 529
+	tYCbCrSubSampling    = 530
+	tYCbCrPositioning    = 531
+	tReferenceBlackWhite = 532
+
+	// Inks of separated images.
+	tInkSet       = 332
+	tInkNames     = 333
+	tNumberOfInks = 334
+
+	inkSetCMYK    = 1
+	inkSetNotCMYK = 2
+
+	// Clipping path tags (TIFF Technical Note 2).
+	tClipPath       = 343
+	tXClipPathUnits = 344
+	tYClipPathUnits = 345
+
+	// Image resource blocks (Adobe Photoshop TIFF Technical Notes).
+	tPhotoshop = 34377
+
+	// GeoTIFF tags (GeoTIFF specification, OGC 19-008r4, section 7).
+	tModelPixelScale     = 33550
+	tModelTiepoint       = 33922
+	tModelTransformation = 34264
+	tGeoKeyDirectory     = 34735
+	tGeoDoubleParams     = 34736
+	tGeoASCIIParams      = 34737
+
+	// Embedded ICC color profile (ICC specification, annex B.4).
+	tICCProfile = 34675
+	// XMP packet (XMP specification part 3, section 1.1.4).
+	tXMP = 700
+	// IPTC-NAA record (IPTC Information Interchange Model).
+	tIPTC = 33723
+
+	// Child IFDs of a page, such as reduced-resolution versions of it
+	// (Adobe PageMaker 6.0 TIFF Technical Notes, section 1).
+	tSubIFDs = 330
+
+	// Pointers to the Exif IFD, the GPS IFD and the Interoperability IFD
+	// within the Exif IFD (Exif 2.3 specification, section 4.6.3).
+	tExifIFD    = 34665
+	tGPSIFD     = 34853
+	tInteropIFD = 40965
+)
+
+// Tags of the Exif IFD (Exif 2.3 specification, section 4.6.5).
+const (
+	tExposureTime       = 33434
+	tFNumber            = 33437
+	tISOSpeedRatings    = 34855
+	tExifVersion        = 36864
+	tDateTimeOriginal   = 36867
+	tDateTimeDigitized  = 36868
+	tSubSecTimeOriginal = 37521
+)
+
+// Tags of the GPS IFD (Exif 2.3 specification, section 4.6.6).
+const (
+	tGPSVersionID    = 0
+	tGPSLatitudeRef  = 1
+	tGPSLatitude     = 2
+	tGPSLongitudeRef = 3
+	tGPSLongitude    = 4
+	tGPSAltitudeRef  = 5
+	tGPSAltitude     = 6
+	tGPSTimeStamp    = 7
+	tGPSDateStamp    = 29
 )
 
 // Compression types (defined in various places in the spec and supplements).
@@ -91,12 +215,27 @@ const (
 	pCMYK        = 5
 	pYCbCr       = 6
 	pCIELab      = 8
+	pICCLab      = 9
 )
 
 // Values for the tPredictor tag (page 64-65 of the spec).
 const (
-	prNone       = 1
-	prHorizontal = 2
+	prNone          = 1
+	prHorizontal    = 2
+	prFloatingPoint = 3 // From Adobe's TIFF Technical Note 3.
+)
+
+// Values for the tPlanarConfiguration tag (page 38).
+const (
+	pcChunky = 1 // The samples of each pixel are stored contiguously.
+	pcPlanar = 2 // Each sample is stored in a separate plane.
+)
+
+// Values for the tSampleFormat tag (page 80).
+const (
+	sfUint  = 1 // Unsigned integer data.
+	sfInt   = 2 // Two's complement signed integer data.
+	sfFloat = 3 // IEEE floating point data.
 )
 
 // Values for the tResolutionUnit tag (page 18).
@@ -112,13 +251,22 @@ type imageMode int
 const (
 	mBilevel imageMode = iota
 	mPaletted
+	mPalettedAlpha
 	mGray
 	mGrayInvert
+	mGrayAlpha
 	mRGB
 	mRGBA
 	mNRGBA
 	mCMYK
 	mCMYKA
+	mNChannel
+	mLab
+	mYCbCr
+	mRaw
+	mFloat32
+	mInt16
+	mInt32
 )
 
 // CompressionType describes the type of compression used in Options.
@@ -131,6 +279,7 @@ const (
 	LZW
 	CCITTGroup3
 	CCITTGroup4
+	PackBits
 )
 
 // specValue returns the compression type constant from the TIFF spec that
@@ -145,6 +294,11 @@ func (c CompressionType) specValue() uint32 {
 		return cG3
 	case CCITTGroup4:
 		return cG4
+	case PackBits:
+		return cPackBits
+	}
+	if c >= registeredBase && c <= registeredBase+0xffff {
+		return uint32(c - registeredBase)
 	}
 	return cNone
 }
@@ -0,0 +1,278 @@
+package tiff
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"sort"
+)
+
+// copiedPointers holds the tags whose values are offsets in the source
+// file, which CopyPage does not copy as they are.
+var copiedPointers = map[uint16]bool{
+	tStripOffsets:    true,
+	tStripByteCounts: true,
+	tTileOffsets:     true,
+	tTileByteCounts:  true,
+	tFreeOffsets:     true,
+	tFreeByteCounts:  true,
+	tSubIFDs:         true,
+	tExifIFD:         true,
+	tGPSIFD:          true,
+	tInteropIFD:      true,
+}
+
+// CopyPage writes the page src as the next page of dst without decoding
+// it: the compressed data of its strips or tiles and the entries of its
+// IFD are copied verbatim, with the offsets of the strips or tiles updated
+// for their new position, so that pages can be extracted from and merged
+// into multi-page files losslessly and quickly. The Exif and GPS IFDs of
+// the page are copied too, but not its SubIFDs, the Interoperability IFD
+// or other entries of the IFD type. The edits made to src by DeleteTag and
+// SetTag are applied to the copied entries.
+//
+// As the samples are not byte-swapped, pages with samples of more than 8
+// bits cannot be copied to a file of the other byte order unless they are
+// JPEG compressed; the byte order of dst is set by Options.ByteOrder for
+// its first page, and is little-endian if that page is copied. Neither can
+// old-style JPEG compressed pages be copied, whose data is referenced by
+// other entries. If src is the first page written by dst and
+// comes from a BigTIFF file, dst writes a BigTIFF file.
+func CopyPage(dst *Encoder, src *Page) error {
+	return copyPage(dst, src, nil)
+}
+
+// CropLossless writes the part rect of the page src as the next page of
+// dst, like CopyPage but keeping only the strips or tiles that cover rect,
+// so that JPEG compressed pages can be cropped without generation loss.
+// The ImageWidth and ImageLength entries are set to the size of rect, and
+// the GeoTIFF tie points and transformation are moved with its origin.
+//
+// rect must lie within the page and its top left corner must be aligned
+// with the tiles of a tiled page. For a page stored in strips, rect must
+// span the full width of the page, its top must be aligned with the strips
+// and its bottom too, unless it is the bottom of the page. Tiles that
+// extend past rect at the right and bottom are kept whole; their pixels
+// outside rect are ignored by readers like the padding of edge tiles.
+func CropLossless(dst *Encoder, src *Page, rect image.Rectangle) error {
+	return copyPage(dst, src, &rect)
+}
+
+// copyPage implements CopyPage and, if crop is not nil, CropLossless.
+func copyPage(dst *Encoder, src *Page, crop *image.Rectangle) error {
+	if dst.closed {
+		return errors.New("tiff: Encoder is already closed")
+	}
+	r, h := src.f.r, src.f.h
+	tags, _, err := readTags(r, h, src.offset)
+	if err != nil {
+		return err
+	}
+	for id, t := range src.edits {
+		if t == nil {
+			delete(tags, id)
+		} else {
+			tags[id] = *t
+		}
+	}
+
+	uints := func(id uint16) []uint64 {
+		v, _ := tags[id].Value.([]uint64)
+		return v
+	}
+	compression := uint64(cNone)
+	if c := uints(tCompression); len(c) > 0 {
+		compression = c[0]
+	}
+	if compression == cJPEGOld {
+		return UnsupportedError("copying old-style JPEG compressed pages")
+	}
+	if h.byteOrder != dst.order && compression != cJPEG {
+		for _, b := range uints(tBitsPerSample) {
+			if b > 8 {
+				return UnsupportedError("copying samples of more than 8 bits between byte orders")
+			}
+		}
+	}
+	offsetTag, countTag := uint16(tStripOffsets), uint16(tStripByteCounts)
+	if _, ok := tags[tTileOffsets]; ok {
+		offsetTag, countTag = tTileOffsets, tTileByteCounts
+	}
+	offsets, counts := uints(offsetTag), uints(countTag)
+	if len(offsets) == 0 || len(offsets) != len(counts) {
+		return FormatError("inconsistent strip or tile offsets and byte counts")
+	}
+	if crop != nil {
+		keep, err := cropBlocks(tags, *crop, len(offsets))
+		if err != nil {
+			return err
+		}
+		var o, c []uint64
+		for _, i := range keep {
+			o, c = append(o, offsets[i]), append(c, counts[i])
+		}
+		offsets, counts = o, c
+		cropTags(tags, *crop)
+	}
+	var imageLen int64
+	for _, n := range counts {
+		imageLen += int64(n)
+	}
+
+	var copied []ifdEntry
+	for _, t := range sortedTags(tags) {
+		if copiedPointers[t.ID] || t.Type == TypeIFD || t.Type == TypeIFD8 {
+			continue
+		}
+		e, err := t.entry()
+		if err != nil {
+			return err
+		}
+		copied = append(copied, e)
+	}
+	var subs []subIFD
+	for _, tag := range []int{tExifIFD, tGPSIFD} {
+		off := uints(uint16(tag))
+		if len(off) == 0 {
+			continue
+		}
+		subTags, _, err := readTags(r, h, int64(off[0]))
+		if err != nil {
+			return err
+		}
+		var d []ifdEntry
+		for _, t := range sortedTags(subTags) {
+			if copiedPointers[t.ID] || t.Type == TypeIFD || t.Type == TypeIFD8 {
+				continue
+			}
+			e, err := t.entry()
+			if err != nil {
+				return err
+			}
+			d = append(d, e)
+		}
+		subs = append(subs, subIFD{tag: tag, entries: d})
+	}
+
+	entries := func(dataOffset int64) []ifdEntry {
+		newOffsets := make([]int64, len(counts))
+		newCounts := make([]int64, len(counts))
+		off := dataOffset
+		for i, n := range counts {
+			// Empty tiles keep an offset of 0.
+			if n == 0 && offsetTag == tTileOffsets {
+				continue
+			}
+			newOffsets[i], newCounts[i] = off, int64(n)
+			off += int64(n)
+		}
+		return append(append([]ifdEntry(nil), copied...),
+			dst.offsetEntry(int(offsetTag), newOffsets),
+			dst.offsetEntry(int(countTag), newCounts),
+		)
+	}
+	data := func(w io.Writer) error {
+		for i, n := range counts {
+			if n == 0 {
+				continue
+			}
+			if _, err := io.CopyN(w, io.NewSectionReader(r, int64(offsets[i]), int64(n)), int64(n)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return dst.placePage(h.bigTIFF, imageLen, entries, copied, subs, data)
+}
+
+// sortedTags returns the tags in the order of their IDs, so that the
+// entries copied from them do not depend on the iteration order of maps.
+func sortedTags(tags map[uint16]Tag) []Tag {
+	s := make([]Tag, 0, len(tags))
+	for _, t := range tags {
+		s = append(s, t)
+	}
+	sort.Slice(s, func(i, j int) bool { return s[i].ID < s[j].ID })
+	return s
+}
+
+// cropBlocks returns the indices of the n strips or tiles of the page
+// described by tags that cover rect, in the order of the file.
+func cropBlocks(tags map[uint16]Tag, rect image.Rectangle, n int) ([]int, error) {
+	value := func(id uint16, def int) int {
+		if v, ok := tags[id].Value.([]uint64); ok && len(v) > 0 {
+			return int(v[0])
+		}
+		return def
+	}
+	width, length := value(tImageWidth, 0), value(tImageLength, 0)
+	if rect.Empty() || !rect.In(image.Rect(0, 0, width, length)) {
+		return nil, fmt.Errorf("tiff: crop rectangle %v is not within the %dx%d page", rect, width, length)
+	}
+	planes := 1
+	if value(tPlanarConfiguration, pcChunky) == pcPlanar {
+		planes = value(tSamplesPerPixel, 1)
+	}
+	blkW, blkH := width, value(tRowsPerStrip, length)
+	tiled := false
+	if _, ok := tags[tTileOffsets]; ok {
+		blkW, blkH, tiled = value(tTileWidth, 0), value(tTileLength, 0), true
+	}
+	if blkW <= 0 || blkH <= 0 {
+		return nil, FormatError("invalid strip or tile size")
+	}
+	blkH = minInt(blkH, length)
+	across, down := (width+blkW-1)/blkW, (length+blkH-1)/blkH
+	if n != across*down*planes {
+		return nil, FormatError("inconsistent number of strips or tiles")
+	}
+	switch {
+	case rect.Min.X%blkW != 0 || rect.Min.Y%blkH != 0:
+		return nil, fmt.Errorf("tiff: crop rectangle %v is not aligned with the %dx%d blocks of the page", rect, blkW, blkH)
+	case !tiled && (rect.Dx() != width || rect.Max.Y%blkH != 0 && rect.Max.Y != length):
+		return nil, fmt.Errorf("tiff: crop rectangle %v does not cover whole strips of the page", rect)
+	}
+	var keep []int
+	for p := 0; p < planes; p++ {
+		for j := rect.Min.Y / blkH; j < (rect.Max.Y+blkH-1)/blkH; j++ {
+			for i := rect.Min.X / blkW; i < (rect.Max.X+blkW-1)/blkW; i++ {
+				keep = append(keep, (p*down+j)*across+i)
+			}
+		}
+	}
+	return keep, nil
+}
+
+// cropTags sets the size of the page described by tags to that of rect and
+// moves the GeoTIFF raster space with its origin.
+func cropTags(tags map[uint16]Tag, rect image.Rectangle) {
+	tags[tImageWidth] = Tag{ID: tImageWidth, Type: TypeLong, Count: 1, Value: []uint64{uint64(rect.Dx())}}
+	tags[tImageLength] = Tag{ID: tImageLength, Type: TypeLong, Count: 1, Value: []uint64{uint64(rect.Dy())}}
+	x, y := float64(rect.Min.X), float64(rect.Min.Y)
+	if t, ok := tags[tModelTiepoint]; ok {
+		if v, ok := t.Value.([]float64); ok {
+			v = append([]float64(nil), v...)
+			// Each tie point maps the raster point (I, J, K) to the
+			// model point (X, Y, Z).
+			for i := 0; i+1 < len(v); i += 6 {
+				v[i] -= x
+				v[i+1] -= y
+			}
+			t.Value = v
+			tags[tModelTiepoint] = t
+		}
+	}
+	if t, ok := tags[tModelTransformation]; ok {
+		if v, ok := t.Value.([]float64); ok && len(v) == 16 {
+			v = append([]float64(nil), v...)
+			// The matrix maps the raster point (I+x, J+y) of the page to
+			// the model space, so its translation column absorbs x and y.
+			for row := 0; row < 3; row++ {
+				v[4*row+3] += v[4*row]*x + v[4*row+1]*y
+			}
+			t.Value = v
+			tags[tModelTransformation] = t
+		}
+	}
+}
@@ -0,0 +1,263 @@
+package tiff
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+// blocks returns the compressed data of the strips or tiles of the first
+// page of the file in p.
+func blocks(t *testing.T, p []byte) [][]byte {
+	t.Helper()
+	d, err := newDecoder(bytes.NewReader(p))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := d.layout()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b [][]byte
+	for i := range l.offsets {
+		b = append(b, p[l.offsets[i]:l.offsets[i]+l.counts[i]])
+	}
+	return b
+}
+
+func TestCopyPage(t *testing.T) {
+	src, err := openImage("video-001.tiff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gray := image.NewGray(image.Rect(0, 0, 40, 40))
+	gray.Pix[0] = 0xff
+	black := color.Color(color.Black)
+	var in bytes.Buffer
+	e := NewEncoder(&in)
+	if err := e.WriteImage(src, &Options{Compression: LZW, Predictor: true, RowsPerStrip: 16, Exif: &Exif{ISO: 400}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.WriteImage(gray, &Options{Compression: Deflate, TileWidth: 16, TileLength: 16, SparseFill: &black}); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+	be, err := ioutil.ReadFile("testdata/video-001-uncompressed.tiff")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := Open(bytes.NewReader(in.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fbe, err := Open(bytes.NewReader(be))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, stream := range []bool{false, true} {
+		// The pages are merged with a page of a big-endian file.
+		var out bytes.Buffer
+		e := NewEncoder(&out)
+		if stream {
+			e = NewStreamEncoder(&out)
+		}
+		for _, p := range []*Page{f.Pages[0], fbe.Pages[0], f.Pages[1]} {
+			if err := CopyPage(e, p); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := e.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if stream {
+			copy(out.Bytes(), e.Header())
+		}
+
+		got, err := DecodeAll(bytes.NewReader(out.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := DecodeAll(bytes.NewReader(in.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		wantBE, err := Decode(bytes.NewReader(be))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 3 {
+			t.Fatalf("got %d pages, want 3", len(got))
+		}
+		compare(t, want[0], got[0])
+		compare(t, wantBE, got[1])
+		compare(t, want[1], got[2])
+
+		// The compressed data and the metadata are kept.
+		g, err := Open(bytes.NewReader(out.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		c, err := g.Pages[0].Config()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.Compression != cLZW || c.Predictor != prHorizontal || c.RowsPerStrip != 16 {
+			t.Errorf("got source %+v", c.SourceInfo)
+		}
+		if _, m, err := DecodeWithMetadata(bytes.NewReader(out.Bytes())); err != nil {
+			t.Fatal(err)
+		} else if m.Exif == nil || m.Exif.ISO != 400 {
+			t.Errorf("got metadata %+v", m)
+		}
+		c, err = g.Pages[2].Config()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.Blocks != 9 || c.TileWidth != 16 {
+			t.Errorf("got %d tiles of width %d, want 9 of width 16", c.Blocks, c.TileWidth)
+		}
+	}
+
+	var out bytes.Buffer
+	e = NewEncoder(&out)
+	if err := CopyPage(e, f.Pages[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+	a, b := blocks(t, in.Bytes()), blocks(t, out.Bytes())
+	if len(a) != len(b) {
+		t.Fatalf("got %d strips, want %d", len(b), len(a))
+	}
+	for i := range a {
+		if !bytes.Equal(a[i], b[i]) {
+			t.Errorf("strip %d differs", i)
+		}
+	}
+	if err := CopyPage(e, f.Pages[0]); err == nil {
+		t.Error("closed Encoder: got no error")
+	}
+}
+
+// TestCopyPageBigEndian16 tests that pages of big-endian files with 16-bit
+// samples, which would be misread in the little-endian copy, are rejected.
+func TestCopyPageBigEndian16(t *testing.T) {
+	var b bytes.Buffer
+	b.WriteString("MM\x00\x2a")
+	binary.Write(&b, binary.BigEndian, uint32(8))
+	entries := [][3]uint32{
+		{tImageWidth, dtLong, 1},
+		{tImageLength, dtLong, 1},
+		{tBitsPerSample, dtShort, 16},
+		{tPhotometricInterpretation, dtShort, pBlackIsZero},
+		{tStripOffsets, dtLong, 8 + 2 + 6*12 + 4},
+		{tStripByteCounts, dtLong, 2},
+	}
+	binary.Write(&b, binary.BigEndian, uint16(len(entries)))
+	for _, e := range entries {
+		binary.Write(&b, binary.BigEndian, uint16(e[0]))
+		binary.Write(&b, binary.BigEndian, uint16(e[1]))
+		binary.Write(&b, binary.BigEndian, uint32(1))
+		if e[1] == dtShort {
+			binary.Write(&b, binary.BigEndian, [2]uint16{uint16(e[2]), 0})
+		} else {
+			binary.Write(&b, binary.BigEndian, e[2])
+		}
+	}
+	b.Write([]byte{0, 0, 0, 0, 0x12, 0x34})
+	if m, err := Decode(bytes.NewReader(b.Bytes())); err != nil {
+		t.Fatal(err)
+	} else if got := m.(*image.Gray16).Pix; !bytes.Equal(got, []byte{0x12, 0x34}) {
+		t.Fatalf("got pixels %x", got)
+	}
+	f, err := Open(bytes.NewReader(b.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := CopyPage(NewEncoder(ioutil.Discard), f.Pages[0]); err == nil {
+		t.Error("got no error")
+	}
+}
+
+func TestCropLossless(t *testing.T) {
+	src, err := openImage("video-001.tiff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := src.Bounds()
+	geo := &GeoInfo{PixelScale: []float64{1, 1, 0}, Tiepoints: []float64{0, 0, 0, 100, 200, 0}}
+	for _, tc := range []struct {
+		opt  Options
+		rect image.Rectangle
+	}{
+		{Options{Compression: LZW, TileWidth: 32, TileLength: 32, Geo: geo}, image.Rect(32, 64, 70, b.Dy())},
+		{Options{Compression: Deflate, TileWidth: 16, TileLength: 48}, image.Rect(0, 48, 16, 60)},
+		{Options{Compression: LZW, RowsPerStrip: 16, Geo: geo}, image.Rect(0, 16, b.Dx(), 48)},
+		{Options{RowsPerStrip: 16}, image.Rect(0, 32, b.Dx(), b.Dy())},
+		{Options{}, image.Rect(0, 0, b.Dx(), b.Dy())},
+	} {
+		var in bytes.Buffer
+		if err := Encode(&in, src, &tc.opt); err != nil {
+			t.Fatal(err)
+		}
+		f, err := Open(bytes.NewReader(in.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out bytes.Buffer
+		e := NewEncoder(&out)
+		if err := CropLossless(e, f.Pages[0], tc.rect); err != nil {
+			t.Fatalf("%v: %v", tc.rect, err)
+		}
+		if err := e.Close(); err != nil {
+			t.Fatal(err)
+		}
+		got, m, err := DecodeWithMetadata(bytes.NewReader(out.Bytes()))
+		if err != nil {
+			t.Fatalf("%v: %v", tc.rect, err)
+		}
+		compare(t, src.(*image.RGBA).SubImage(tc.rect), got)
+		if tc.opt.Geo != nil {
+			want := []float64{-float64(tc.rect.Min.X), -float64(tc.rect.Min.Y), 0, 100, 200, 0}
+			if m.Geo == nil || !reflect.DeepEqual(m.Geo.Tiepoints, want) {
+				t.Errorf("%v: got GeoTIFF tags %+v, want tie points %v", tc.rect, m.Geo, want)
+			}
+		}
+	}
+
+	var in bytes.Buffer
+	if err := Encode(&in, src, &Options{TileWidth: 32, TileLength: 32}); err != nil {
+		t.Fatal(err)
+	}
+	var strips bytes.Buffer
+	if err := Encode(&strips, src, &Options{RowsPerStrip: 16}); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		file []byte
+		rect image.Rectangle
+	}{
+		{in.Bytes(), image.Rect(16, 0, 64, 32)},
+		{in.Bytes(), image.Rect(0, 0, b.Dx()+1, 32)},
+		{in.Bytes(), image.Rect(0, 0, 0, 0)},
+		{strips.Bytes(), image.Rect(0, 0, 32, 32)},
+		{strips.Bytes(), image.Rect(0, 16, b.Dx(), 40)},
+		{strips.Bytes(), image.Rect(0, 8, b.Dx(), 32)},
+	} {
+		f, err := Open(bytes.NewReader(tc.file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := CropLossless(NewEncoder(ioutil.Discard), f.Pages[0], tc.rect); err == nil {
+			t.Errorf("%v: got no error", tc.rect)
+		}
+	}
+}
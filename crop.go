@@ -0,0 +1,126 @@
+package tiff
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// Crop returns a copy of the portion of img inside r. Unlike SubImage, the
+// returned image does not share its pixels with img. Its bounds are the
+// intersection of r with the bounds of img and it has the same concrete type
+// as img for the image types of the standard library and this package;
+// other images are copied into an *image.RGBA64. Crop returns nil if r does
+// not intersect the bounds of img.
+func Crop(img image.Image, r image.Rectangle) image.Image {
+	r = r.Intersect(img.Bounds())
+	if r.Empty() {
+		return nil
+	}
+	switch m := img.(type) {
+	case *image.Gray:
+		dst := image.NewGray(r)
+		copyRows(dst.Pix, dst.Stride, m.Pix[m.PixOffset(r.Min.X, r.Min.Y):], m.Stride, r.Dx(), r.Dy())
+		return dst
+	case *image.Gray16:
+		dst := image.NewGray16(r)
+		copyRows(dst.Pix, dst.Stride, m.Pix[m.PixOffset(r.Min.X, r.Min.Y):], m.Stride, r.Dx()*2, r.Dy())
+		return dst
+	case *image.Alpha:
+		dst := image.NewAlpha(r)
+		copyRows(dst.Pix, dst.Stride, m.Pix[m.PixOffset(r.Min.X, r.Min.Y):], m.Stride, r.Dx(), r.Dy())
+		return dst
+	case *image.Alpha16:
+		dst := image.NewAlpha16(r)
+		copyRows(dst.Pix, dst.Stride, m.Pix[m.PixOffset(r.Min.X, r.Min.Y):], m.Stride, r.Dx()*2, r.Dy())
+		return dst
+	case *image.RGBA:
+		dst := image.NewRGBA(r)
+		copyRows(dst.Pix, dst.Stride, m.Pix[m.PixOffset(r.Min.X, r.Min.Y):], m.Stride, r.Dx()*4, r.Dy())
+		return dst
+	case *image.RGBA64:
+		dst := image.NewRGBA64(r)
+		copyRows(dst.Pix, dst.Stride, m.Pix[m.PixOffset(r.Min.X, r.Min.Y):], m.Stride, r.Dx()*8, r.Dy())
+		return dst
+	case *image.NRGBA:
+		dst := image.NewNRGBA(r)
+		copyRows(dst.Pix, dst.Stride, m.Pix[m.PixOffset(r.Min.X, r.Min.Y):], m.Stride, r.Dx()*4, r.Dy())
+		return dst
+	case *image.NRGBA64:
+		dst := image.NewNRGBA64(r)
+		copyRows(dst.Pix, dst.Stride, m.Pix[m.PixOffset(r.Min.X, r.Min.Y):], m.Stride, r.Dx()*8, r.Dy())
+		return dst
+	case *image.CMYK:
+		dst := image.NewCMYK(r)
+		copyRows(dst.Pix, dst.Stride, m.Pix[m.PixOffset(r.Min.X, r.Min.Y):], m.Stride, r.Dx()*4, r.Dy())
+		return dst
+	case *image.Paletted:
+		dst := image.NewPaletted(r, append(color.Palette(nil), m.Palette...))
+		copyRows(dst.Pix, dst.Stride, m.Pix[m.PixOffset(r.Min.X, r.Min.Y):], m.Stride, r.Dx(), r.Dy())
+		return dst
+	case *CMYKAImg:
+		dst := NewCMYKA(r)
+		copyRows(dst.Pix, dst.Stride, m.Pix[m.PixOffset(r.Min.X, r.Min.Y):], m.Stride, r.Dx()*5, r.Dy())
+		return dst
+	case *PremulCMYKAImg:
+		dst := NewPremulCMYKA(r)
+		copyRows(dst.Pix, dst.Stride, m.Pix[m.PixOffset(r.Min.X, r.Min.Y):], m.Stride, r.Dx()*5, r.Dy())
+		return dst
+	case *CMYKA64Img:
+		dst := NewCMYKA64(r)
+		copyRows(dst.Pix, dst.Stride, m.Pix[m.PixOffset(r.Min.X, r.Min.Y):], m.Stride, r.Dx()*10, r.Dy())
+		return dst
+	case *GrayAImg:
+		dst := NewGrayA(r)
+		copyRows(dst.Pix, dst.Stride, m.Pix[m.PixOffset(r.Min.X, r.Min.Y):], m.Stride, r.Dx()*2, r.Dy())
+		return dst
+	case *GrayA16Img:
+		dst := NewGrayA16(r)
+		copyRows(dst.Pix, dst.Stride, m.Pix[m.PixOffset(r.Min.X, r.Min.Y):], m.Stride, r.Dx()*4, r.Dy())
+		return dst
+	case *NChannelImg:
+		dst := NewNChannel(r, m.Samples, m.BytesPerSample, m.InkNames)
+		copyRows(dst.Pix, dst.Stride, m.Pix[m.PixOffset(r.Min.X, r.Min.Y):], m.Stride, r.Dx()*m.Samples*m.BytesPerSample, r.Dy())
+		return dst
+	case *LabImg:
+		dst := NewLab(r, m.BytesPerSample)
+		copyRows(dst.Pix, dst.Stride, m.Pix[m.PixOffset(r.Min.X, r.Min.Y):], m.Stride, r.Dx()*3*m.BytesPerSample, r.Dy())
+		return dst
+	case *MultiSampleImg:
+		dst := NewMultiSample(r, m.Samples, m.BytesPerSample)
+		copyRows(dst.Pix, dst.Stride, m.Pix[m.PixOffset(r.Min.X, r.Min.Y):], m.Stride, r.Dx()*m.Samples*m.BytesPerSample, r.Dy())
+		return dst
+	case *Float32Img:
+		dst := NewFloat32(r)
+		for y := 0; y < r.Dy(); y++ {
+			i := m.PixOffset(r.Min.X, r.Min.Y+y)
+			copy(dst.Pix[y*dst.Stride:(y+1)*dst.Stride], m.Pix[i:i+r.Dx()])
+		}
+		return dst
+	case *Int16Img:
+		dst := NewInt16(r)
+		for y := 0; y < r.Dy(); y++ {
+			i := m.PixOffset(r.Min.X, r.Min.Y+y)
+			copy(dst.Pix[y*dst.Stride:(y+1)*dst.Stride], m.Pix[i:i+r.Dx()])
+		}
+		return dst
+	case *Int32Img:
+		dst := NewInt32(r)
+		for y := 0; y < r.Dy(); y++ {
+			i := m.PixOffset(r.Min.X, r.Min.Y+y)
+			copy(dst.Pix[y*dst.Stride:(y+1)*dst.Stride], m.Pix[i:i+r.Dx()])
+		}
+		return dst
+	}
+	dst := image.NewRGBA64(r)
+	draw.Draw(dst, r, img, r.Min, draw.Src)
+	return dst
+}
+
+// copyRows copies rows of n bytes each from src to dst, which have the
+// given strides.
+func copyRows(dst []uint8, dstStride int, src []uint8, srcStride int, n, rows int) {
+	for y := 0; y < rows; y++ {
+		copy(dst[y*dstStride:y*dstStride+n], src[y*srcStride:y*srcStride+n])
+	}
+}
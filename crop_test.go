@@ -0,0 +1,52 @@
+package tiff
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// TestCrop tests that Crop returns an independent copy of the same type.
+func TestCrop(t *testing.T) {
+	src := NewCMYKA(image.Rect(0, 0, 4, 4))
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			src.SetCMYKA(x, y, CMYKA{uint8(x), uint8(y), 0, 0, 0xff})
+		}
+	}
+	r := image.Rect(1, 2, 5, 4)
+	m, ok := Crop(src, r).(*CMYKAImg)
+	if !ok {
+		t.Fatalf("got image of type %T, want *CMYKAImg", Crop(src, r))
+	}
+	if got, want := m.Bounds(), image.Rect(1, 2, 4, 4); got != want {
+		t.Fatalf("got bounds %v, want %v", got, want)
+	}
+	for y := 2; y < 4; y++ {
+		for x := 1; x < 4; x++ {
+			if got, want := m.CMYKAt(x, y), src.CMYKAt(x, y); got != want {
+				t.Errorf("at (%d, %d): got %v, want %v", x, y, got, want)
+			}
+		}
+	}
+
+	// Changing one image must not change the other.
+	src.SetCMYKA(1, 2, CMYKA{0xff, 0xff, 0xff, 0xff, 0xff})
+	if got, want := m.CMYKAt(1, 2), (CMYKA{1, 2, 0, 0, 0xff}); got != want {
+		t.Errorf("after changing the source: got %v, want %v", got, want)
+	}
+	m.SetCMYKA(3, 3, CMYKA{})
+	if got, want := src.CMYKAt(3, 3), (CMYKA{3, 3, 0, 0, 0xff}); got != want {
+		t.Errorf("after changing the copy: got %v, want %v", got, want)
+	}
+
+	if got := Crop(src, image.Rect(4, 4, 6, 6)); got != nil {
+		t.Errorf("got %v for a rectangle outside the bounds, want nil", got.Bounds())
+	}
+
+	// Images of other types are copied into an RGBA64 image.
+	u := &image.Uniform{color.RGBA{1, 2, 3, 4}}
+	if _, ok := Crop(u, image.Rect(0, 0, 2, 2)).(*image.RGBA64); !ok {
+		t.Errorf("got image of type %T, want *image.RGBA64", Crop(u, image.Rect(0, 0, 2, 2)))
+	}
+}
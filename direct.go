@@ -0,0 +1,88 @@
+package tiff
+
+import "image"
+
+// directPix returns the part of the Pix of d.direct that holds the strip of
+// blkW by blkH pixels starting at row ymin, if the uncompressed samples of
+// the strip can be stored there as they are, so that they need not be
+// copied from d.buf by decode. This is the case for 8-bit gray, paletted,
+// RGB, RGBA, CMYK and CMYKA images stored in strips whose samples decode to
+// the Pix of their image without conversions; RGB samples are expanded in
+// place by finishDirect. Otherwise, directPix returns nil.
+func (d *decoder) directPix(l *blockLayout, ymin, blkW, blkH int) []byte {
+	if d.direct == nil || d.transformer != nil || d.bpp != 8 || d.lsb || l.padding || l.planes != 1 || blkW != d.config.Width {
+		return nil
+	}
+	switch d.firstVal(tCompression) {
+	case cG3, cG4, cJPEG, cJPEGOld:
+		return nil
+	}
+	if p := d.firstVal(tPredictor); p != 0 && p != prNone && p != prHorizontal {
+		return nil
+	}
+	var pix []byte
+	var stride, n int // n is the number of bytes per pixel of pix.
+	switch img := d.direct.(type) {
+	case *image.Gray:
+		if d.mode != mGray {
+			return nil
+		}
+		pix, stride, n = img.Pix, img.Stride, 1
+	case *image.Paletted:
+		if d.mode != mPaletted {
+			return nil
+		}
+		pix, stride, n = img.Pix, img.Stride, 1
+	case *image.RGBA:
+		if d.mode != mRGB && !(d.mode == mRGBA && d.premul) {
+			return nil
+		}
+		pix, stride, n = img.Pix, img.Stride, 4
+	case *image.NRGBA:
+		if d.mode != mNRGBA || d.premul {
+			return nil
+		}
+		pix, stride, n = img.Pix, img.Stride, 4
+	case *image.CMYK:
+		if d.mode != mCMYK {
+			return nil
+		}
+		pix, stride, n = img.Pix, img.Stride, 4
+	case *CMYKAImg:
+		if d.mode != mCMYKA || d.firstVal(tExtraSamples) == 1 {
+			return nil
+		}
+		pix, stride, n = img.Pix, img.Stride, 5
+	default:
+		return nil
+	}
+	samples := n
+	if d.mode == mRGB {
+		samples = 3
+	}
+	if l.samples != samples || stride != blkW*n || d.direct.Bounds() != image.Rect(0, 0, d.config.Width, d.config.Height) {
+		return nil
+	}
+	return pix[ymin*stride : minInt(ymin+blkH, d.config.Height)*stride]
+}
+
+// finishDirect completes a block whose data was read into d.pix, as returned
+// by directPix. Data that a codec did not decompress in place is copied, and
+// RGB samples are expanded to RGBA pixels.
+func (d *decoder) finishDirect(samples int) {
+	if len(d.buf) > 0 && &d.buf[0] != &d.pix[0] {
+		copy(d.pix, d.buf)
+	}
+	if samples != 3 {
+		return
+	}
+	// The pixels are expanded from the end, so that no samples are
+	// overwritten before they are moved.
+	pix := d.pix
+	for i := len(pix)/4 - 1; i >= 0; i-- {
+		pix[4*i+3] = 0xff
+		pix[4*i+2] = pix[3*i+2]
+		pix[4*i+1] = pix[3*i+1]
+		pix[4*i+0] = pix[3*i+0]
+	}
+}
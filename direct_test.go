@@ -0,0 +1,146 @@
+package tiff
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"reflect"
+	"testing"
+)
+
+// directImages returns an image of each type whose strips are decoded into
+// the Pix of the image directly.
+func directImages() []image.Image {
+	r := image.Rect(0, 0, 23, 11)
+	gray := image.NewGray(r)
+	paletted := image.NewPaletted(r, palette.Plan9)
+	rgb, rgba, nrgba := image.NewRGBA(r), image.NewRGBA(r), image.NewNRGBA(r)
+	cmyk, cmyka := image.NewCMYK(r), NewCMYKA(r)
+	for y := 0; y < r.Dy(); y++ {
+		for x := 0; x < r.Dx(); x++ {
+			v, a := uint8(x*11+y), uint8(y*23)
+			gray.SetGray(x, y, color.Gray{v})
+			paletted.SetColorIndex(x, y, v)
+			rgb.SetRGBA(x, y, color.RGBA{v, 0xff - v, v / 2, 0xff})
+			rgba.Set(x, y, color.NRGBA{v, 0xff - v, v / 2, a})
+			nrgba.SetNRGBA(x, y, color.NRGBA{v, 0xff - v, v / 2, a})
+			cmyk.SetCMYK(x, y, color.CMYK{v, 0, 0xff - v, 0x10})
+			cmyka.SetCMYKA(x, y, CMYKA{v, 0, 0xff - v, 0x10, a})
+		}
+	}
+	return []image.Image{gray, paletted, rgb, rgba, nrgba, cmyk, cmyka}
+}
+
+// sameImage reports whether m and src are images of the same type with the
+// same pixels. The palettes of paletted images are not compared, as their
+// colors are decoded as color.RGBA64 values.
+func sameImage(m, src image.Image) bool {
+	if p, ok := src.(*image.Paletted); ok {
+		q, ok := m.(*image.Paletted)
+		return ok && q.Rect == p.Rect && bytes.Equal(q.Pix, p.Pix)
+	}
+	return reflect.DeepEqual(m, src)
+}
+
+func TestDecodeDirect(t *testing.T) {
+	for _, src := range directImages() {
+		for i, opt := range []*Options{
+			{RowsPerStrip: 3},
+			{Compression: LZW, Predictor: true, RowsPerStrip: 4},
+			{Compression: Deflate},
+			{Compression: PackBits, RowsPerStrip: 5},
+		} {
+			var buf bytes.Buffer
+			if err := Encode(&buf, src, opt); err != nil {
+				t.Fatal(err)
+			}
+			r := bytes.NewReader(buf.Bytes())
+			h, offset, err := readHeader(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			d, err := newIFDDecoder(r, h, offset, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			l, err := d.layout()
+			if err != nil {
+				t.Fatal(err)
+			}
+			d.direct = d.newImage(src.Bounds())
+			if d.directPix(l, 0, l.width, l.height) == nil {
+				t.Errorf("%T, options %d: strips are not decoded directly", src, i)
+			}
+
+			m, err := Decode(bytes.NewReader(buf.Bytes()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !sameImage(m, src) {
+				t.Errorf("%T, options %d: Decode returns another %T", src, i, m)
+			}
+			into := d.newImage(src.Bounds())
+			if err := DecodeInto(bytes.NewReader(buf.Bytes()), into.(draw.Image)); err != nil {
+				t.Fatal(err)
+			}
+			if !sameImage(into, src) {
+				t.Errorf("%T, options %d: DecodeInto decodes another %T", src, i, into)
+			}
+		}
+	}
+}
+
+// TestDecodeDirectDamaged tests that a damaged strip that is decoded
+// directly into the image is zeroed with AllowPartial.
+func TestDecodeDirectDamaged(t *testing.T) {
+	src := directImages()[2].(*image.RGBA)
+	var buf bytes.Buffer
+	if err := Encode(&buf, src, &Options{Compression: LZW, RowsPerStrip: 4}); err != nil {
+		t.Fatal(err)
+	}
+	b := buf.Bytes()
+	c, err := DecodeConfigFull(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.SourceInfo.Blocks != 3 {
+		t.Fatalf("got %d strips, want 3", c.SourceInfo.Blocks)
+	}
+	// The LZW data of the first strip follows the header directly.
+	for i := 8; i < 20; i++ {
+		b[i] = 0xff
+	}
+	m, err := DecodeWithOptions(bytes.NewReader(b), &DecodeOptions{AllowPartial: true})
+	if _, ok := err.(*PartialError); !ok {
+		t.Fatalf("got error %v, want a *PartialError", err)
+	}
+	got := m.(*image.RGBA)
+	want := image.NewRGBA(src.Rect)
+	copy(want.Pix[4*want.Stride:], src.Pix[4*src.Stride:])
+	if !bytes.Equal(got.Pix, want.Pix) {
+		t.Error("the damaged strip is not zeroed or the others differ")
+	}
+}
+
+func BenchmarkDecodeDirect(b *testing.B) {
+	m := image.NewRGBA(image.Rect(0, 0, 2048, 1024))
+	for i := range m.Pix {
+		m.Pix[i] = uint8(i * 37 >> 5)
+		if i%4 == 3 {
+			m.Pix[i] = 0xff
+		}
+	}
+	var buf bytes.Buffer
+	if err := Encode(&buf, m, &Options{Compression: LZW}); err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(len(m.Pix)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := Decode(bytes.NewReader(buf.Bytes())); err != nil {
+			b.Fatal("Decode:", err)
+		}
+	}
+}
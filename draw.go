@@ -0,0 +1,104 @@
+package tiff
+
+import (
+	"image"
+	"image/color"
+)
+
+// DrawOver composites src over the part r of dst, like draw.Draw with the
+// draw.Over operator: the pixel of src at sp is drawn at r.Min. r is
+// clipped to the bounds of dst and of src.
+//
+// The colors of src are converted to CMYKA as by CMYKAModel and blended
+// with those of dst in CMYKA space: the result has an alpha of
+// sa + da*(1-sa), and each of its C, M, Y and K samples is the average of
+// those of src and dst weighted by sa and da*(1-sa), where sa and da are
+// the alphas of src and dst. Opaque source pixels thus replace those of
+// dst, and fully transparent ones leave them unchanged. *image.NRGBA,
+// *image.RGBA and *image.Uniform sources are read without going through
+// color.Color values.
+func DrawOver(dst *CMYKAImg, r image.Rectangle, src image.Image, sp image.Point) {
+	orig := r.Min
+	r = r.Intersect(dst.Rect).Intersect(src.Bounds().Add(orig.Sub(sp)))
+	if r.Empty() {
+		return
+	}
+	sp = sp.Add(r.Min.Sub(orig))
+	n := r.Dx()
+	switch src := src.(type) {
+	case *image.Uniform:
+		c := CMYKAModel.Convert(src.C).(CMYKA)
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			d := dst.Pix[dst.PixOffset(r.Min.X, y):][:5*n]
+			for i := 0; i < len(d); i += 5 {
+				blendCMYKA(d[i:i+5:i+5], c)
+			}
+		}
+	case *image.NRGBA:
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			d := dst.Pix[dst.PixOffset(r.Min.X, y):][:5*n]
+			s := src.Pix[src.PixOffset(sp.X, sp.Y+y-r.Min.Y):][:4*n]
+			for i, j := 0, 0; i < len(d); i, j = i+5, j+4 {
+				switch s[j+3] {
+				case 0:
+					continue
+				case 0xff:
+					d[i], d[i+1], d[i+2], d[i+3] = color.RGBToCMYK(s[j], s[j+1], s[j+2])
+					d[i+4] = 0xff
+					continue
+				}
+				// The color is premultiplied as by color.NRGBA's RGBA
+				// method, so that it converts as by CMYKAModel.
+				a := uint32(s[j+3]) * 0x101
+				r := uint32(s[j]) * 0x101 * a / 0xffff
+				g := uint32(s[j+1]) * 0x101 * a / 0xffff
+				b := uint32(s[j+2]) * 0x101 * a / 0xffff
+				blendCMYKA(d[i:i+5:i+5], rgbaToCMYKA(r, g, b, a))
+			}
+		}
+	case *image.RGBA:
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			d := dst.Pix[dst.PixOffset(r.Min.X, y):][:5*n]
+			s := src.Pix[src.PixOffset(sp.X, sp.Y+y-r.Min.Y):][:4*n]
+			for i, j := 0, 0; i < len(d); i, j = i+5, j+4 {
+				switch s[j+3] {
+				case 0:
+					continue
+				case 0xff:
+					d[i], d[i+1], d[i+2], d[i+3] = color.RGBToCMYK(s[j], s[j+1], s[j+2])
+					d[i+4] = 0xff
+					continue
+				}
+				blendCMYKA(d[i:i+5:i+5], rgbaToCMYKA(uint32(s[j])*0x101, uint32(s[j+1])*0x101, uint32(s[j+2])*0x101, uint32(s[j+3])*0x101))
+			}
+		}
+	default:
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			d := dst.Pix[dst.PixOffset(r.Min.X, y):][:5*n]
+			sy := sp.Y + y - r.Min.Y
+			for i, x := 0, sp.X; i < len(d); i, x = i+5, x+1 {
+				blendCMYKA(d[i:i+5:i+5], rgbaToCMYKA(src.At(x, sy).RGBA()))
+			}
+		}
+	}
+}
+
+// blendCMYKA composites the color s over the pixel d, which holds C, M, Y,
+// K, A samples, as described for DrawOver.
+func blendCMYKA(d []uint8, s CMYKA) {
+	switch s.A {
+	case 0:
+		return
+	case 0xff:
+		d[0], d[1], d[2], d[3], d[4] = s.C, s.M, s.Y, s.K, 0xff
+		return
+	}
+	// The weights are scaled by 0xff*0xff.
+	ws := uint32(s.A) * 0xff
+	wd := uint32(d[4]) * (0xff - uint32(s.A))
+	w := ws + wd
+	for k, v := range [4]uint8{s.C, s.M, s.Y, s.K} {
+		d[k] = uint8((uint32(v)*ws + uint32(d[k])*wd + w/2) / w)
+	}
+	d[4] = uint8((w + 0x7f) / 0xff)
+}
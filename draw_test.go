@@ -0,0 +1,100 @@
+package tiff
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+// TestDrawOver tests that the specialized loops of DrawOver give the same
+// pixels as its generic one, and the blending of single pixels.
+func TestDrawOver(t *testing.T) {
+	base := NewCMYKA(image.Rect(0, 0, 8, 6))
+	for i := range base.Pix {
+		base.Pix[i] = uint8(i * 29)
+	}
+	nrgba := image.NewNRGBA(image.Rect(10, 10, 15, 13))
+	for i := range nrgba.Pix {
+		nrgba.Pix[i] = uint8(i * 53)
+	}
+	// Some pixels are opaque or transparent.
+	nrgba.Pix[3], nrgba.Pix[7], nrgba.Pix[11] = 0xff, 0, 0xff
+	rgba := image.NewRGBA(image.Rect(10, 10, 15, 13))
+	draw.Draw(rgba, rgba.Rect, nrgba, nrgba.Rect.Min, draw.Src)
+	uniform := image.NewUniform(color.NRGBA{0x20, 0x80, 0xc0, 0x60})
+
+	r := image.Rect(-1, 2, 5, 9)
+	sp := image.Pt(9, 10)
+	for _, src := range []image.Image{nrgba, rgba, uniform} {
+		got := NewCMYKA(base.Rect)
+		copy(got.Pix, base.Pix)
+		DrawOver(got, r, src, sp)
+		want := NewCMYKA(base.Rect)
+		copy(want.Pix, base.Pix)
+		DrawOver(want, r, imageView{src}, sp)
+		for y := base.Rect.Min.Y; y < base.Rect.Max.Y; y++ {
+			for x := base.Rect.Min.X; x < base.Rect.Max.X; x++ {
+				if g, w := got.CMYKAt(x, y), want.CMYKAt(x, y); g != w {
+					t.Errorf("%T: got %v at (%d, %d), want %v", src, g, x, y, w)
+				}
+			}
+		}
+		// Only the pixels within the clipped rectangle are changed: the
+		// source covers x in [0, 4] and y in [2, 4], or [2, 5] for the
+		// unbounded uniform image.
+		if got.CMYKAt(5, 3) != base.CMYKAt(5, 3) || src != uniform && got.CMYKAt(2, 5) != base.CMYKAt(2, 5) {
+			t.Errorf("%T: pixels outside the rectangle were changed", src)
+		}
+	}
+
+	for _, tc := range []struct {
+		dst, src, want CMYKA
+	}{
+		{CMYKA{10, 20, 30, 40, 0xff}, CMYKA{200, 0, 0, 0, 0xff}, CMYKA{200, 0, 0, 0, 0xff}},
+		{CMYKA{10, 20, 30, 40, 0xff}, CMYKA{200, 0, 0, 0, 0}, CMYKA{10, 20, 30, 40, 0xff}},
+		{CMYKA{0, 0, 0, 0xff, 0xff}, CMYKA{0xff, 0, 0, 0, 0x80}, CMYKA{0x80, 0, 0, 0x7f, 0xff}},
+		{CMYKA{0, 0, 0, 0xff, 0}, CMYKA{0xff, 0, 0, 0, 0x80}, CMYKA{0xff, 0, 0, 0, 0x80}},
+	} {
+		d := []uint8{tc.dst.C, tc.dst.M, tc.dst.Y, tc.dst.K, tc.dst.A}
+		blendCMYKA(d, tc.src)
+		if got := (CMYKA{d[0], d[1], d[2], d[3], d[4]}); got != tc.want {
+			t.Errorf("%v over %v: got %v, want %v", tc.src, tc.dst, got, tc.want)
+		}
+	}
+}
+
+// overlay returns an NRGBA image of text-like overlay pixels, mostly
+// transparent or opaque with antialiased edges.
+func overlay(r image.Rectangle) *image.NRGBA {
+	m := image.NewNRGBA(r)
+	for i := 0; i < len(m.Pix); i += 4 {
+		m.Pix[i], m.Pix[i+1], m.Pix[i+2] = uint8(i), uint8(i>>3), 0xff
+		switch i / 4 % 16 {
+		case 0, 1, 2, 3, 4, 5, 6:
+		case 7, 15:
+			m.Pix[i+3] = 0x80
+		default:
+			m.Pix[i+3] = 0xff
+		}
+	}
+	return m
+}
+
+func BenchmarkDrawOver(b *testing.B) {
+	dst := NewCMYKA(image.Rect(0, 0, 1024, 64))
+	src := overlay(dst.Rect)
+	b.SetBytes(int64(len(src.Pix)))
+	for i := 0; i < b.N; i++ {
+		DrawOver(dst, dst.Rect, src, image.Point{})
+	}
+}
+
+func BenchmarkDrawOverDraw(b *testing.B) {
+	dst := NewCMYKA(image.Rect(0, 0, 1024, 64))
+	src := overlay(dst.Rect)
+	b.SetBytes(int64(len(src.Pix)))
+	for i := 0; i < b.N; i++ {
+		draw.Draw(dst, dst.Rect, src, image.Point{}, draw.Over)
+	}
+}
@@ -0,0 +1,69 @@
+package tiff
+
+import (
+	"fmt"
+	"io"
+)
+
+// DeletePage removes the page i from f.Pages, so that WriteTo does not
+// write it, for example to drop the thumbnail of a file. A transparency
+// mask that follows the page is not removed with it.
+func (f *File) DeletePage(i int) error {
+	if i < 0 || i >= len(f.Pages) {
+		return fmt.Errorf("tiff: page %d out of range [0, %d)", i, len(f.Pages))
+	}
+	f.Pages = append(f.Pages[:i], f.Pages[i+1:]...)
+	return nil
+}
+
+// DeleteTag removes the entry id from the IFD of the page when it is copied
+// by WriteTo or CopyPage. Deleting the ExifIFD or GPSInfo entry removes the
+// Exif or GPS IFD of the page. The entries that locate the strips or tiles
+// cannot be deleted.
+func (p *Page) DeleteTag(id uint16) error {
+	switch id {
+	case tStripOffsets, tStripByteCounts, tTileOffsets, tTileByteCounts:
+		return fmt.Errorf("tiff: tag %d locates the pixel data and cannot be deleted", id)
+	}
+	if p.edits == nil {
+		p.edits = make(map[uint16]*Tag)
+	}
+	p.edits[id] = nil
+	return nil
+}
+
+// SetTag adds the entry t to the IFD of the page, or replaces the entry
+// with its ID, when the page is copied by WriteTo or CopyPage. Entries
+// that hold offsets in the file, such as StripOffsets and ExifIFD, cannot
+// be set, nor can entries of the IFD types.
+func (p *Page) SetTag(t Tag) error {
+	if copiedPointers[t.ID] || t.Type == TypeIFD || t.Type == TypeIFD8 {
+		return fmt.Errorf("tiff: tag %d holds offsets and cannot be set", t.ID)
+	}
+	if _, err := t.entry(); err != nil {
+		return err
+	}
+	if p.edits == nil {
+		p.edits = make(map[uint16]*Tag)
+	}
+	p.edits[t.ID] = &t
+	return nil
+}
+
+// WriteTo writes the pages of f.Pages to w as a new file, with the edits of
+// DeleteTag and SetTag applied to their IFDs. The compressed data of the
+// strips and tiles is copied verbatim, like with CopyPage, and the file
+// keeps the byte order and format of f. It returns the number of bytes
+// written.
+func (f *File) WriteTo(w io.Writer) (int64, error) {
+	cw := &countWriter{w: w}
+	e := NewEncoder(cw)
+	e.order = f.h.byteOrder
+	for _, p := range f.Pages {
+		if err := CopyPage(e, p); err != nil {
+			return cw.n, err
+		}
+	}
+	err := e.Close()
+	return cw.n, err
+}
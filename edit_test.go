@@ -0,0 +1,96 @@
+package tiff
+
+import (
+	"bytes"
+	"image"
+	"reflect"
+	"testing"
+)
+
+func TestEditFile(t *testing.T) {
+	src, err := openImage("video-001.tiff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var in bytes.Buffer
+	e := NewEncoder(&in)
+	opt := &Options{
+		Compression: LZW,
+		Exif:        &Exif{ISO: 400},
+		GPS:         &GPS{Latitude: 47.5, Longitude: 8.25},
+		Software:    "scanner",
+		Artist:      "someone",
+	}
+	if err := e.WriteImage(src, opt); err != nil {
+		t.Fatal(err)
+	}
+	thumb := image.NewGray(image.Rect(0, 0, 8, 6))
+	if err := e.WriteImage(thumb, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := Open(bytes.NewReader(in.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.DeletePage(2); err == nil {
+		t.Error("DeletePage(2) of a file of 2 pages succeeds")
+	}
+	if err := f.DeletePage(1); err != nil {
+		t.Fatal(err)
+	}
+	p := f.Pages[0]
+	if err := p.SetTag(Tag{ID: tStripOffsets, Type: TypeLong, Count: 1, Value: []uint64{8}}); err == nil {
+		t.Error("SetTag of StripOffsets succeeds")
+	}
+	if err := p.DeleteTag(tStripByteCounts); err == nil {
+		t.Error("DeleteTag of StripByteCounts succeeds")
+	}
+	for _, id := range []uint16{tExifIFD, tGPSIFD, tArtist} {
+		if err := p.DeleteTag(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := p.SetTag(Tag{ID: tSoftware, Type: TypeASCII, Value: "editor"}); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	n, err := f.WriteTo(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(out.Len()) {
+		t.Errorf("WriteTo returns %d bytes, wrote %d", n, out.Len())
+	}
+
+	var pages []map[uint16]Tag
+	if err := WalkIFDs(bytes.NewReader(out.Bytes()), func(_ int64, tags map[uint16]Tag) error {
+		pages = append(pages, tags)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(pages) != 1 {
+		t.Fatalf("got %d pages, want 1", len(pages))
+	}
+	for _, id := range []uint16{tExifIFD, tGPSIFD, tArtist} {
+		if _, ok := pages[0][id]; ok {
+			t.Errorf("tag %d is not deleted", id)
+		}
+	}
+	if got := pages[0][tSoftware].Value; got != "editor" {
+		t.Errorf("Software is %q, want %q", got, "editor")
+	}
+	// The pixel data is copied verbatim.
+	if !reflect.DeepEqual(blocks(t, out.Bytes()), blocks(t, in.Bytes())) {
+		t.Error("the strips of the page differ")
+	}
+	m, err := Decode(bytes.NewReader(out.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	compare(t, src, m)
+}
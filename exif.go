@@ -0,0 +1,141 @@
+package tiff
+
+import (
+	"fmt"
+	"io"
+	"math"
+)
+
+// Exif holds the entries of an Exif IFD, which cameras use to record how
+// and when a photograph was taken (Exif 2.3 specification). The IFD is
+// referenced by the ExifIFD tag of the image's IFD.
+type Exif struct {
+	// ExposureTime is the exposure time in seconds.
+	ExposureTime float64
+	// FNumber is the F number of the lens.
+	FNumber float64
+	// ISO is the ISO speed rating of the camera.
+	ISO int
+	// DateTimeOriginal is the date and time at which the image was
+	// captured and DateTimeDigitized the one at which it was stored, in
+	// the format "YYYY:MM:DD HH:MM:SS". SubSecTimeOriginal holds the
+	// fractions of a second of DateTimeOriginal as decimal digits, such as
+	// "123" for 0.123 seconds.
+	DateTimeOriginal   string
+	DateTimeDigitized  string
+	SubSecTimeOriginal string
+
+	// Tags holds all entries of the Exif IFD keyed by tag. When an Exif
+	// IFD is written, the entries in Tags are written as well, except for
+	// those set by a non-zero field above, which takes precedence, and
+	// the pointer to the Interoperability IFD, which cannot be copied.
+	Tags map[uint16]Tag
+}
+
+// newExif returns the Exif of an Exif IFD with the given entries.
+func newExif(tags map[uint16]Tag) *Exif {
+	x := &Exif{Tags: tags}
+	str := func(id uint16) string {
+		s, _ := tags[id].Value.(string)
+		return s
+	}
+	x.DateTimeOriginal = str(tDateTimeOriginal)
+	x.DateTimeDigitized = str(tDateTimeDigitized)
+	x.SubSecTimeOriginal = str(tSubSecTimeOriginal)
+
+	rational := func(id uint16) float64 {
+		if v, ok := tags[id].Value.([][2]uint32); ok && len(v) > 0 && v[0][1] != 0 {
+			return float64(v[0][0]) / float64(v[0][1])
+		}
+		return 0
+	}
+	x.ExposureTime = rational(tExposureTime)
+	x.FNumber = rational(tFNumber)
+
+	if v, ok := tags[tISOSpeedRatings].Value.([]uint64); ok && len(v) > 0 {
+		x.ISO = int(v[0])
+	}
+	return x
+}
+
+// readSubIFD reads the entries of the sub-IFD referenced by the entry tag
+// among the entries of an IFD of the file in r. It returns nil if there is
+// no such entry.
+func readSubIFD(r io.ReaderAt, h header, tags map[uint16]Tag, tag uint16) (map[uint16]Tag, error) {
+	v, ok := tags[tag].Value.([]uint64)
+	if !ok || len(v) == 0 {
+		return nil, nil
+	}
+	if v[0] > math.MaxInt64 {
+		return nil, FormatError(fmt.Sprintf("invalid offset of sub-IFD %d", tag))
+	}
+	sub, _, err := readTags(r, h, int64(v[0]))
+	return sub, err
+}
+
+// rational returns a fraction approximating f, which must not be negative.
+// Exposure times such as 1/250 second are given as 1 over an integer.
+func rational(f float64) [2]uint32 {
+	if f > 0 && f < 1 {
+		if d := math.Round(1 / f); d <= math.MaxUint32 && math.Abs(1/d-f) <= 1e-9*f {
+			return [2]uint32{1, uint32(d)}
+		}
+	}
+	den := 1.0
+	for den < 1e6 && f*den < math.MaxUint32/10 && f*den != math.Trunc(f*den) {
+		den *= 10
+	}
+	return [2]uint32{uint32(math.Round(f * den)), uint32(den)}
+}
+
+// entries returns the IFD entries of x for writing.
+func (x *Exif) entries() ([]ifdEntry, error) {
+	if x.ExposureTime < 0 || x.FNumber < 0 || x.ISO < 0 || x.ISO > math.MaxUint16 {
+		return nil, fmt.Errorf("tiff: invalid Exif values %+v", *x)
+	}
+	tags := make(map[uint16]Tag)
+	for id, t := range x.Tags {
+		if id != tInteropIFD {
+			t.ID = id
+			tags[id] = t
+		}
+	}
+	if _, ok := tags[tExifVersion]; !ok {
+		tags[tExifVersion] = Tag{ID: tExifVersion, Type: TypeUndefined, Value: []byte("0230")}
+	}
+	for id, s := range map[uint16]string{
+		tDateTimeOriginal:   x.DateTimeOriginal,
+		tDateTimeDigitized:  x.DateTimeDigitized,
+		tSubSecTimeOriginal: x.SubSecTimeOriginal,
+	} {
+		if s != "" {
+			tags[id] = Tag{ID: id, Type: TypeASCII, Value: s}
+		}
+	}
+	for id, f := range map[uint16]float64{
+		tExposureTime: x.ExposureTime,
+		tFNumber:      x.FNumber,
+	} {
+		if f != 0 {
+			tags[id] = Tag{ID: id, Type: TypeRational, Value: [][2]uint32{rational(f)}}
+		}
+	}
+	if x.ISO != 0 {
+		tags[tISOSpeedRatings] = Tag{ID: tISOSpeedRatings, Type: TypeShort, Value: []uint64{uint64(x.ISO)}}
+	}
+
+	return tagEntries(tags)
+}
+
+// tagEntries converts tags into IFD entries for writing.
+func tagEntries(tags map[uint16]Tag) ([]ifdEntry, error) {
+	d := make([]ifdEntry, 0, len(tags))
+	for _, t := range tags {
+		e, err := t.entry()
+		if err != nil {
+			return nil, err
+		}
+		d = append(d, e)
+	}
+	return d, nil
+}
@@ -0,0 +1,87 @@
+package tiff
+
+import (
+	"bytes"
+	"image"
+	"reflect"
+	"testing"
+)
+
+// TestEncodeExif tests that an Exif IFD written by the encoder is read back
+// by DecodeWithMetadata, in classic and BigTIFF files.
+func TestEncodeExif(t *testing.T) {
+	exif := &Exif{
+		ExposureTime:       1.0 / 250,
+		FNumber:            2.8,
+		ISO:                800,
+		DateTimeOriginal:   "2020:06:07 08:09:10",
+		DateTimeDigitized:  "2020:06:07 08:09:11",
+		SubSecTimeOriginal: "042",
+		Tags: map[uint16]Tag{
+			65000:       {ID: 65000, Type: TypeLong, Value: []uint64{12345}},
+			tInteropIFD: {ID: tInteropIFD, Type: TypeLong, Value: []uint64{1234}},
+		},
+	}
+	img := image.NewGray(image.Rect(0, 0, 5, 3))
+	for _, opts := range []*Options{
+		{Exif: exif},
+		{Exif: exif, Compression: LZW, ForceBigTIFF: true},
+	} {
+		var buf bytes.Buffer
+		if err := Encode(&buf, img, opts); err != nil {
+			t.Fatal(err)
+		}
+		_, m, err := DecodeWithMetadata(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("%+v: %v", opts, err)
+		}
+		got := m.Exif
+		if got == nil {
+			t.Fatalf("%+v: got no Exif IFD", opts)
+		}
+		if got.ExposureTime != exif.ExposureTime || got.FNumber != exif.FNumber || got.ISO != exif.ISO ||
+			got.DateTimeOriginal != exif.DateTimeOriginal || got.DateTimeDigitized != exif.DateTimeDigitized ||
+			got.SubSecTimeOriginal != exif.SubSecTimeOriginal {
+			t.Errorf("%+v: got %+v", opts, got)
+		}
+		if v := got.Tags[tExposureTime].Value; !reflect.DeepEqual(v, [][2]uint32{{1, 250}}) {
+			t.Errorf("%+v: got ExposureTime %v, want 1/250", opts, v)
+		}
+		if v := got.Tags[65000].Value; !reflect.DeepEqual(v, []uint64{12345}) {
+			t.Errorf("%+v: got private tag %v, want [12345]", opts, v)
+		}
+		if _, ok := got.Tags[tInteropIFD]; ok {
+			t.Errorf("%+v: the Interoperability IFD pointer was copied", opts)
+		}
+		if v := got.Tags[tExifVersion].Value; !reflect.DeepEqual(v, []byte("0230")) {
+			t.Errorf("%+v: got ExifVersion %q", opts, v)
+		}
+	}
+
+	// The Exif IFD is omitted from baseline files.
+	var buf bytes.Buffer
+	if err := Encode(&buf, img, &Options{Exif: exif, BaselineOnly: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, m, err := DecodeWithMetadata(bytes.NewReader(buf.Bytes())); err != nil || m.Exif != nil {
+		t.Errorf("BaselineOnly: got Exif %+v, error %v", m.Exif, err)
+	}
+}
+
+func TestRational(t *testing.T) {
+	for _, tc := range []struct {
+		f    float64
+		want [2]uint32
+	}{
+		{0, [2]uint32{0, 1}},
+		{1.0 / 250, [2]uint32{1, 250}},
+		{1.0 / 3, [2]uint32{1, 3}},
+		{2.8, [2]uint32{28, 10}},
+		{0.3, [2]uint32{3, 10}},
+		{30, [2]uint32{30, 1}},
+	} {
+		if got := rational(tc.f); got != tc.want {
+			t.Errorf("rational(%v): got %v, want %v", tc.f, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package tiff
+
+import (
+	"image"
+	"io"
+)
+
+// A File gives access to the pages of a TIFF file without decoding them.
+// Open reads only the header and the IFD chain of the file; the IFD of a
+// page is parsed and its pixel data read only when a method of the Page is
+// called, so that many files can be indexed cheaply. The methods of a File
+// and its Pages can be called concurrently, except for those that edit
+// them: DeletePage, DeleteTag and SetTag.
+type File struct {
+	r io.ReaderAt
+	h header
+	// Pages holds the pages of the file in the order of the IFD chain,
+	// including reduced-resolution images and transparency masks.
+	Pages []*Page
+}
+
+// A Page is an image in a File: a page of its IFD chain or a SubIFD.
+type Page struct {
+	f      *File
+	offset int64 // Offset of the IFD of the page.
+	// edits holds the tags set by SetTag and, as nil, those deleted by
+	// DeleteTag, which are applied when the page is copied.
+	edits map[uint16]*Tag
+}
+
+// Open returns a File for the TIFF file in r, after reading its header and
+// its chain of IFDs. Errors in the IFD of a page are reported by the
+// methods of the Page.
+func Open(r io.ReaderAt) (*File, error) {
+	h, offset, err := readHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	f := &File{r: r, h: h}
+	d := &decoder{r: r, byteOrder: h.byteOrder, bigTIFF: h.bigTIFF}
+	seen := make(map[int64]bool)
+	for offset != 0 {
+		if seen[offset] {
+			return nil, FormatError("IFD chain contains a loop")
+		}
+		seen[offset] = true
+		f.Pages = append(f.Pages, &Page{f: f, offset: offset})
+		if _, offset, err = d.readEntries(offset); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// decoder returns a new decoder for the page, so that concurrent calls do
+// not share decoding state.
+func (p *Page) decoder() (*decoder, error) {
+	return newIFDDecoder(p.f.r, p.f.h, p.offset, nil)
+}
+
+// Offset returns the offset of the IFD of the page in the file.
+func (p *Page) Offset() int64 { return p.offset }
+
+// Config returns the color model, dimensions and orientation of the page
+// and the information about how it is stored. Pages is the number of IFDs
+// in the chain starting with the page.
+func (p *Page) Config() (ConfigFull, error) {
+	d, err := p.decoder()
+	if err != nil {
+		return ConfigFull{}, err
+	}
+	return d.configFull()
+}
+
+// Image decodes the page.
+func (p *Page) Image() (image.Image, error) {
+	d, err := p.decoder()
+	if err != nil {
+		return nil, err
+	}
+	return d.decodeImage()
+}
+
+// Region decodes the part of the page that lies within rect, reading only
+// the strips or tiles that intersect it, like DecodeRegion.
+func (p *Page) Region(rect image.Rectangle) (image.Image, error) {
+	d, err := p.decoder()
+	if err != nil {
+		return nil, err
+	}
+	return d.decodeRegion(rect)
+}
+
+// SubImages returns the SubIFDs of the page, such as its reduced-resolution
+// versions in pyramidal and DNG files. It returns no Pages if the page has
+// no SubIFDs tag.
+func (p *Page) SubImages() ([]*Page, error) {
+	// Only the tags are needed, whether or not the image can be decoded.
+	d, err := readIFD(p.f.r, p.f.h, p.offset)
+	if err != nil {
+		return nil, err
+	}
+	var subs []*Page
+	for _, off := range d.features[tSubIFDs] {
+		if off == 0 {
+			return nil, FormatError("zero SubIFDs offset")
+		}
+		subs = append(subs, &Page{f: p.f, offset: int64(off)})
+	}
+	return subs, nil
+}
+
+// Mask returns the transparency mask of the page: the page that follows it
+// in the IFD chain if that page is marked as a transparency mask by bit 2
+// of its NewSubfileType and has a PhotometricInterpretation of
+// TransparencyMask. The pixels of the mask that are part of the image have
+// an alpha of 0xff, the others 0. The mask may have a higher resolution
+// than the page. Mask returns nil if the page has no mask.
+func (p *Page) Mask() (*image.Alpha, error) {
+	var next *Page
+	for i, q := range p.f.Pages {
+		if q.offset == p.offset && i+1 < len(p.f.Pages) {
+			next = p.f.Pages[i+1]
+			break
+		}
+	}
+	if next == nil {
+		return nil, nil
+	}
+	d, err := readIFD(p.f.r, p.f.h, next.offset)
+	if err != nil {
+		return nil, err
+	}
+	if d.subfileType()&4 == 0 || d.firstVal(tPhotometricInterpretation) != pTransMask {
+		return nil, nil
+	}
+	m, err := next.Image()
+	if err != nil {
+		return nil, err
+	}
+	g, ok := m.(*image.Gray)
+	if !ok {
+		return nil, FormatError("transparency mask of another type than bilevel")
+	}
+	return &image.Alpha{Pix: g.Pix, Stride: g.Stride, Rect: g.Rect}, nil
+}
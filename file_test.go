@@ -0,0 +1,153 @@
+package tiff
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"sync"
+	"testing"
+)
+
+func TestOpen(t *testing.T) {
+	src, err := openImage("video-001.tiff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := Encode(&buf, src, &Options{Compression: LZW, TileWidth: 32, TileLength: 32, Pyramid: &Pyramid{Levels: 2}}); err != nil {
+		t.Fatal(err)
+	}
+	want, err := DecodeAll(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := newDecoder(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := d.layout()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// pixelsRead reports whether r was read within the tiles of the first
+	// page.
+	pixelsRead := func(r *recordingReaderAt) bool {
+		for _, off := range r.offsets {
+			for k := range l.offsets {
+				if off >= int64(l.offsets[k]) && off < int64(l.offsets[k]+l.counts[k]) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	r := &recordingReaderAt{r: bytes.NewReader(buf.Bytes())}
+	f, err := Open(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f.Pages) != len(want) {
+		t.Fatalf("got %d pages, want %d", len(f.Pages), len(want))
+	}
+	for i, p := range f.Pages {
+		c, err := p.Config()
+		if err != nil {
+			t.Fatalf("page %d: %v", i, err)
+		}
+		if b := want[i].Bounds(); c.Width != b.Dx() || c.Height != b.Dy() || c.Pages != len(want)-i {
+			t.Errorf("page %d: got %dx%d, %d pages", i, c.Width, c.Height, c.Pages)
+		}
+	}
+	if pixelsRead(r) {
+		t.Error("Open and Config read pixel data")
+	}
+
+	// The pages can be decoded concurrently and in any order.
+	if f, err = Open(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	got := make([]image.Image, len(f.Pages))
+	errs := make([]error, len(f.Pages))
+	for i := len(f.Pages) - 1; i >= 0; i-- {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			got[i], errs[i] = f.Pages[i].Image()
+		}(i)
+	}
+	wg.Wait()
+	for i := range got {
+		if errs[i] != nil {
+			t.Fatalf("page %d: %v", i, errs[i])
+		}
+		compare(t, want[i], got[i])
+	}
+
+	rect := image.Rect(40, 30, 70, 50)
+	region, err := f.Pages[0].Region(rect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compare(t, want[0].(*image.RGBA).SubImage(rect), region)
+
+	if subs, err := f.Pages[0].SubImages(); len(subs) != 0 || err != nil {
+		t.Errorf("got %d SubIFDs, %v", len(subs), err)
+	}
+}
+
+func TestPageMask(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 30, 20))
+	mask := image.NewAlpha(image.Rect(0, 0, 60, 40))
+	for y := 0; y < 40; y++ {
+		for x := 0; x < 60; x++ {
+			mask.SetAlpha(x, y, color.Alpha{uint8(x * 4)})
+		}
+	}
+	var buf bytes.Buffer
+	if err := Encode(&buf, src, &Options{Compression: LZW, Mask: mask, Pyramid: &Pyramid{Levels: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	f, err := Open(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f.Pages) != 3 {
+		t.Fatalf("got %d pages, want the image, its mask and an overview", len(f.Pages))
+	}
+	got, err := f.Pages[0].Mask()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got == nil || got.Rect != mask.Rect {
+		t.Fatalf("got mask %v", got)
+	}
+	for y := 0; y < 40; y++ {
+		for x := 0; x < 60; x++ {
+			want := uint8(0)
+			if x*4 >= 0x80 {
+				want = 0xff
+			}
+			if a := got.AlphaAt(x, y).A; a != want {
+				t.Fatalf("got alpha %d at (%d, %d), want %d", a, x, y, want)
+			}
+		}
+	}
+	for _, p := range f.Pages[1:] {
+		if m, err := p.Mask(); m != nil || err != nil {
+			t.Errorf("page at %d: got mask %v, %v", p.Offset(), m, err)
+		}
+	}
+
+	// PageFilter skips the mask.
+	pages, err := DecodeAllWithOptions(bytes.NewReader(buf.Bytes()), &DecodeOptions{
+		PageFilter: func(subfileType uint32) bool { return subfileType&4 == 0 },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pages) != 2 {
+		t.Errorf("PageFilter: got %d pages, want 2", len(pages))
+	}
+}
@@ -0,0 +1,87 @@
+package tiff
+
+import (
+	"image"
+	"image/color"
+)
+
+// Float32Img is an in-memory image holding one 32-bit floating-point sample
+// per pixel. It is returned for grayscale images with a SampleFormat of
+// IEEE floating point, as used for elevation models and other scientific
+// data. Its At method maps samples from [0, 1] to gray values, clamping
+// those outside of that range; use Float32At to get the samples as stored.
+type Float32Img struct {
+	// Pix holds the image's samples. The pixel at (x, y) is at
+	// Pix[(y-Rect.Min.Y)*Stride + (x-Rect.Min.X)].
+	Pix []float32
+	// Stride is the Pix stride (in elements) between vertically adjacent
+	// pixels.
+	Stride int
+	// Rect is the image's bounds.
+	Rect image.Rectangle
+}
+
+func (p *Float32Img) ColorModel() color.Model { return color.Gray16Model }
+
+func (p *Float32Img) Bounds() image.Rectangle { return p.Rect }
+
+func (p *Float32Img) At(x, y int) color.Color {
+	v := p.Float32At(x, y)
+	switch {
+	case v >= 1:
+		return color.Gray16{0xffff}
+	case v > 0:
+		return color.Gray16{uint16(v*0xffff + 0.5)}
+	}
+	// Negative samples and NaN are black.
+	return color.Gray16{0}
+}
+
+// Float32At returns the sample of the pixel at (x, y), or 0 if the pixel is
+// outside the image.
+func (p *Float32Img) Float32At(x, y int) float32 {
+	if !(image.Point{x, y}.In(p.Rect)) {
+		return 0
+	}
+	return p.Pix[p.PixOffset(x, y)]
+}
+
+// PixOffset returns the index of the element of Pix that corresponds to the
+// pixel at (x, y).
+func (p *Float32Img) PixOffset(x, y int) int {
+	return pixOffset(p.Rect, p.Stride, 1, x, y)
+}
+
+func (p *Float32Img) SetFloat32(x, y int, v float32) {
+	if !(image.Point{x, y}.In(p.Rect)) {
+		return
+	}
+	p.Pix[p.PixOffset(x, y)] = v
+}
+
+// SubImage returns an image representing the portion of the image p visible
+// through r. The returned value shares pixels with the original image.
+func (p *Float32Img) SubImage(r image.Rectangle) image.Image {
+	r = r.Intersect(p.Rect)
+	// If r1 and r2 are Rectangles, r1.Intersect(r2) is not guaranteed to be inside
+	// either r1 or r2 if the intersection is empty. Without explicitly checking for
+	// this, the Pix[i:j:j] expression below can panic.
+	if r.Empty() {
+		return &Float32Img{}
+	}
+	i, j := subPix(p.Rect, p.Stride, 1, r)
+	return &Float32Img{
+		Pix:    p.Pix[i:j:j],
+		Stride: p.Stride,
+		Rect:   r,
+	}
+}
+
+// NewFloat32 returns a new Float32Img image with the given bounds.
+func NewFloat32(r image.Rectangle) *Float32Img {
+	return &Float32Img{
+		Pix:    make([]float32, r.Dx()*r.Dy()),
+		Stride: r.Dx(),
+		Rect:   r,
+	}
+}
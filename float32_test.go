@@ -0,0 +1,136 @@
+package tiff
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"math"
+	"reflect"
+	"testing"
+)
+
+// predictFloat applies the floating point predictor to rows of w pixels
+// with the given number of 32-bit samples per pixel.
+func predictFloat(samples []float32, w, spp int) []byte {
+	wc := w * spp
+	n := 4 * wc
+	out := make([]byte, 4*len(samples))
+	for y := 0; y*wc < len(samples); y++ {
+		row := out[y*n : (y+1)*n]
+		for i, v := range samples[y*wc : (y+1)*wc] {
+			u := math.Float32bits(v)
+			for b := 0; b < 4; b++ {
+				row[b*wc+i] = uint8(u >> (24 - 8*uint(b)))
+			}
+		}
+		for i := n - 1; i >= spp; i-- {
+			row[i] -= row[i-spp]
+		}
+	}
+	return out
+}
+
+// float32Page returns a page of w×h pixels with spp floating point samples
+// each.
+func float32Page(data []byte, w, h, spp int, photometric, predictor uint32) testPage {
+	bps := make([]uint32, spp)
+	sf := make([]uint32, spp)
+	for i := range bps {
+		bps[i], sf[i] = 32, sfFloat
+	}
+	return testPage{data, []ifdEntry{
+		{tImageWidth, dtShort, []uint32{uint32(w)}},
+		{tImageLength, dtShort, []uint32{uint32(h)}},
+		{tBitsPerSample, dtShort, bps},
+		{tPhotometricInterpretation, dtShort, []uint32{photometric}},
+		{tStripOffsets, dtLong, []uint32{0}},
+		{tSamplesPerPixel, dtShort, []uint32{uint32(spp)}},
+		{tRowsPerStrip, dtShort, []uint32{uint32(h)}},
+		{tStripByteCounts, dtLong, []uint32{uint32(len(data))}},
+		{tPredictor, dtShort, []uint32{predictor}},
+		{tSampleFormat, dtShort, sf},
+	}}
+}
+
+// TestDecodeFloat32 tests that 32-bit floating point images are decoded
+// into a Float32Img, with and without the floating point predictor.
+func TestDecodeFloat32(t *testing.T) {
+	const w, h = 5, 3
+	want := make([]float32, w*h)
+	for i := range want {
+		want[i] = float32(i)*0.125 - 0.5
+	}
+	want[7] = float32(math.Inf(1))
+
+	plain := make([]byte, 4*len(want))
+	for i, v := range want {
+		binary.LittleEndian.PutUint32(plain[4*i:], math.Float32bits(v))
+	}
+	for _, tc := range []struct {
+		name      string
+		data      []byte
+		predictor uint32
+	}{
+		{"none", plain, prNone},
+		{"floating point", predictFloat(want, w, 1), prFloatingPoint},
+	} {
+		b := buildTIFF(t, float32Page(tc.data, w, h, 1, pBlackIsZero, tc.predictor))
+
+		img, err := Decode(bytes.NewReader(b))
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		m, ok := img.(*Float32Img)
+		if !ok {
+			t.Errorf("%s: got image of type %T, want *Float32Img", tc.name, img)
+			continue
+		}
+		if !reflect.DeepEqual(m.Pix, want) {
+			t.Errorf("%s: got samples %v, want %v", tc.name, m.Pix, want)
+		}
+	}
+}
+
+// TestDecodeArrayFloatPredictor tests the floating point predictor with
+// several samples per pixel.
+func TestDecodeArrayFloatPredictor(t *testing.T) {
+	const w, h, spp = 4, 2, 3
+	want := make([]float32, w*h*spp)
+	for i := range want {
+		want[i] = float32(i*i) / 7
+	}
+	b := buildTIFF(t, float32Page(predictFloat(want, w, spp), w, h, spp, pRGB, prFloatingPoint))
+
+	data, _, dtype, err := DecodeArray(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dtype != "float32" || !reflect.DeepEqual(data, want) {
+		t.Errorf("got %s data %v, want %v", dtype, data, want)
+	}
+}
+
+func TestFloat32Img(t *testing.T) {
+	m := NewFloat32(image.Rect(0, 0, 4, 3))
+	m.SetFloat32(2, 1, 0.5)
+	m.SetFloat32(3, 2, 2)
+	m.SetFloat32(0, 0, -1)
+	sub := m.SubImage(image.Rect(2, 1, 4, 3)).(*Float32Img)
+	if got := sub.Float32At(2, 1); got != 0.5 {
+		t.Errorf("SubImage: got %v, want 0.5", got)
+	}
+	for _, tc := range []struct {
+		x, y int
+		want color.Gray16
+	}{
+		{2, 1, color.Gray16{0x8000}},
+		{3, 2, color.Gray16{0xffff}},
+		{0, 0, color.Gray16{0}},
+	} {
+		if got := m.At(tc.x, tc.y); got != tc.want {
+			t.Errorf("At(%d, %d): got %v, want %v", tc.x, tc.y, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package tiff
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// FuzzDecode decodes arbitrary input with limits on the image size, which
+// must fail cleanly rather than panic or exhaust memory. The seed corpus
+// holds the test images; testdata/fuzz/FuzzDecode holds inputs that once
+// caused excessive allocations.
+func FuzzDecode(f *testing.F) {
+	names, err := filepath.Glob("testdata/*.tiff")
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, name := range names {
+		b, err := ioutil.ReadFile(name)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(b)
+	}
+	f.Fuzz(func(t *testing.T, b []byte) {
+		opts := &DecodeOptions{MaxWidth: 1 << 12, MaxHeight: 1 << 12, MaxMemory: 1 << 26}
+		cfg, err := DecodeConfig(bytes.NewReader(b))
+		if err != nil {
+			return
+		}
+		img, err := DecodeWithOptions(bytes.NewReader(b), opts)
+		if err != nil {
+			return
+		}
+		if img.Bounds().Dx() != cfg.Width || img.Bounds().Dy() != cfg.Height {
+			t.Errorf("image of %v, configuration of %dx%d", img.Bounds(), cfg.Width, cfg.Height)
+		}
+		DecodeAllWithOptions(bytes.NewReader(b), opts)
+		Validate(bytes.NewReader(b))
+	})
+}
+
+// TestBoundedAllocations tests that the memory allocated for the strips of
+// an image is bounded by the input and the size of the image rather than by
+// byte counts and decompressed lengths, even without DecodeOptions.
+func TestBoundedAllocations(t *testing.T) {
+	page := func(data []byte, compression, count uint32) []byte {
+		return buildTIFF(t, testPage{data, []ifdEntry{
+			{tImageWidth, dtShort, []uint32{64}},
+			{tImageLength, dtShort, []uint32{64}},
+			{tBitsPerSample, dtShort, []uint32{8}},
+			{tCompression, dtShort, []uint32{compression}},
+			{tPhotometricInterpretation, dtShort, []uint32{pBlackIsZero}},
+			{tStripOffsets, dtLong, []uint32{0}},
+			{tStripByteCounts, dtLong, []uint32{count}},
+		}})
+	}
+	var z bytes.Buffer
+	w := zlib.NewWriter(&z)
+	w.Write(make([]byte, 1<<26))
+	w.Close()
+	packbits := bytes.Repeat([]byte{0x81, 0}, 1<<18)
+	for name, b := range map[string][]byte{
+		"JPEG byte count":     page([]byte{0xff, 0xd8}, cJPEG, 0xfffffff0),
+		"Deflate bomb":        page(z.Bytes(), cDeflate, uint32(z.Len())),
+		"PackBits expansion":  page(packbits, cPackBits, uint32(len(packbits))),
+		"uncompressed length": page(make([]byte, 16), cNone, 0x7ffffff0),
+	} {
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		Decode(bytes.NewReader(b))
+		runtime.ReadMemStats(&after)
+		if n := after.TotalAlloc - before.TotalAlloc; n > 8<<20 {
+			t.Errorf("%s: allocated %d bytes", name, n)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package tiff
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// A GeoKeyID identifies a key of the GeoKeyDirectory of a GeoTIFF file
+// (GeoTIFF specification, OGC 19-008r4, section 7.1).
+type GeoKeyID uint16
+
+// Frequently used GeoKeys.
+const (
+	GTModelType       GeoKeyID = 1024
+	GTRasterType      GeoKeyID = 1025
+	GTCitation        GeoKeyID = 1026
+	GeodeticCRS       GeoKeyID = 2048 // GeographicTypeGeoKey in GeoTIFF 1.0.
+	GeodeticCitation  GeoKeyID = 2049
+	GeogAngularUnits  GeoKeyID = 2054
+	ProjectedCRS      GeoKeyID = 3072 // ProjectedCSTypeGeoKey in GeoTIFF 1.0.
+	ProjectedCitation GeoKeyID = 3073
+	ProjLinearUnits   GeoKeyID = 3076
+	VerticalCRS       GeoKeyID = 4096
+	VerticalCitation  GeoKeyID = 4097
+	VerticalUnits     GeoKeyID = 4099
+)
+
+// Values of the GTModelType and GTRasterType keys, and the value of keys
+// whose parameters are given by other keys.
+const (
+	ModelTypeProjected  = 1
+	ModelTypeGeographic = 2
+	ModelTypeGeocentric = 3
+
+	RasterPixelIsArea  = 1
+	RasterPixelIsPoint = 2
+
+	GeoUserDefined = 32767
+)
+
+// GeoInfo holds the GeoTIFF tags of an image, which relate its raster space
+// to a model space such as a map projection.
+type GeoInfo struct {
+	// PixelScale is the size of a pixel in model space, as X, Y and Z
+	// scale, or nil if the ModelPixelScale tag is absent.
+	PixelScale []float64
+	// Tiepoints holds sets of 6 values I, J, K, X, Y, Z, each mapping the
+	// raster point (I, J, K) to the model point (X, Y, Z).
+	Tiepoints []float64
+	// Transformation is the 4×4 matrix, in row-major order, that maps
+	// raster to model space, or nil if the ModelTransformation tag is
+	// absent.
+	Transformation []float64
+	// Keys holds the GeoKeys. Their values are a []uint16 for keys stored
+	// as Short values, a []float64 for Double values and a string for
+	// ASCII values.
+	Keys map[GeoKeyID]interface{}
+}
+
+// Short returns the first value of the key id if it holds Short values.
+func (g *GeoInfo) Short(id GeoKeyID) (uint16, bool) {
+	v, ok := g.Keys[id].([]uint16)
+	if !ok || len(v) == 0 {
+		return 0, false
+	}
+	return v[0], true
+}
+
+// newGeoInfo returns the GeoInfo of an IFD with the given entries, or nil if
+// they hold no GeoTIFF tags.
+func newGeoInfo(tags map[uint16]Tag) (*GeoInfo, error) {
+	doubles := func(id uint16) []float64 {
+		v, _ := tags[id].Value.([]float64)
+		return v
+	}
+	g := &GeoInfo{
+		PixelScale:     doubles(tModelPixelScale),
+		Tiepoints:      doubles(tModelTiepoint),
+		Transformation: doubles(tModelTransformation),
+	}
+	dir, _ := tags[tGeoKeyDirectory].Value.([]uint64)
+	if g.PixelScale == nil && g.Tiepoints == nil && g.Transformation == nil && dir == nil {
+		return nil, nil
+	}
+	if dir == nil {
+		return g, nil
+	}
+	if len(dir) < 4 || uint64(len(dir)) < 4+4*dir[3] {
+		return nil, FormatError("GeoKeyDirectory too short")
+	}
+	params, _ := tags[tGeoDoubleParams].Value.([]float64)
+	ascii, _ := tags[tGeoASCIIParams].Value.(string)
+	g.Keys = make(map[GeoKeyID]interface{})
+	for i := uint64(0); i < dir[3]; i++ {
+		k := dir[4+4*i : 8+4*i]
+		id, loc, count, off := GeoKeyID(k[0]), k[1], k[2], k[3]
+		switch loc {
+		case 0:
+			g.Keys[id] = []uint16{uint16(off)}
+		case tGeoKeyDirectory:
+			if off+count > uint64(len(dir)) {
+				return nil, FormatError(fmt.Sprintf("GeoKey %d out of range", id))
+			}
+			v := make([]uint16, count)
+			for j := range v {
+				v[j] = uint16(dir[off+uint64(j)])
+			}
+			g.Keys[id] = v
+		case tGeoDoubleParams:
+			if off+count > uint64(len(params)) {
+				return nil, FormatError(fmt.Sprintf("GeoKey %d out of range", id))
+			}
+			g.Keys[id] = append([]float64(nil), params[off:off+count]...)
+		case tGeoASCIIParams:
+			if off+count > uint64(len(ascii)) {
+				return nil, FormatError(fmt.Sprintf("GeoKey %d out of range", id))
+			}
+			// Each string is terminated by a '|', which is included in
+			// its count.
+			g.Keys[id] = strings.TrimSuffix(ascii[off:off+count], "|")
+		default:
+			return nil, UnsupportedError(fmt.Sprintf("GeoKey %d stored in tag %d", id, loc))
+		}
+	}
+	return g, nil
+}
+
+// tags returns the GeoTIFF tags of g for writing.
+func (g *GeoInfo) tags() ([]Tag, error) {
+	var tags []Tag
+	for _, t := range []struct {
+		id   uint16
+		v    []float64
+		size int // The number of values must be a multiple of size.
+	}{
+		{tModelPixelScale, g.PixelScale, 3},
+		{tModelTiepoint, g.Tiepoints, 6},
+		{tModelTransformation, g.Transformation, 16},
+	} {
+		if len(t.v)%t.size != 0 {
+			return nil, fmt.Errorf("tiff: GeoTIFF tag %d has %d values, want a multiple of %d", t.id, len(t.v), t.size)
+		}
+		if len(t.v) > 0 {
+			tags = append(tags, Tag{ID: t.id, Type: TypeDouble, Value: t.v})
+		}
+	}
+	if len(g.Keys) == 0 {
+		return tags, nil
+	}
+
+	// The keys must be sorted by ID.
+	ids := make([]int, 0, len(g.Keys))
+	for id := range g.Keys {
+		ids = append(ids, int(id))
+	}
+	sort.Ints(ids)
+	dir := []uint64{1, 1, 1, uint64(len(ids))} // GeoTIFF 1.1.
+	// Keys with several Short values have them stored after the keys.
+	var shorts []uint64
+	var params []float64
+	var ascii string
+	for _, id := range ids {
+		switch v := g.Keys[GeoKeyID(id)].(type) {
+		case []uint16:
+			switch len(v) {
+			case 0:
+				return nil, fmt.Errorf("tiff: GeoKey %d has no value", id)
+			case 1:
+				dir = append(dir, uint64(id), 0, 1, uint64(v[0]))
+				continue
+			}
+			dir = append(dir, uint64(id), tGeoKeyDirectory, uint64(len(v)), uint64(4+4*len(ids)+len(shorts)))
+			for _, x := range v {
+				shorts = append(shorts, uint64(x))
+			}
+		case []float64:
+			dir = append(dir, uint64(id), tGeoDoubleParams, uint64(len(v)), uint64(len(params)))
+			params = append(params, v...)
+		case string:
+			if strings.ContainsRune(v, '|') {
+				return nil, fmt.Errorf("tiff: invalid value %q of GeoKey %d", v, id)
+			}
+			dir = append(dir, uint64(id), tGeoASCIIParams, uint64(len(v)+1), uint64(len(ascii)))
+			ascii += v + "|"
+		default:
+			return nil, fmt.Errorf("tiff: value of type %T for GeoKey %d", v, id)
+		}
+	}
+	dir = append(dir, shorts...)
+	if len(dir) > math.MaxUint16 || len(params) > math.MaxUint16 || len(ascii) > math.MaxUint16 {
+		return nil, fmt.Errorf("tiff: too many GeoKey values")
+	}
+	tags = append(tags, Tag{ID: tGeoKeyDirectory, Type: TypeShort, Value: dir})
+	if len(params) > 0 {
+		tags = append(tags, Tag{ID: tGeoDoubleParams, Type: TypeDouble, Value: params})
+	}
+	if ascii != "" {
+		tags = append(tags, Tag{ID: tGeoASCIIParams, Type: TypeASCII, Value: ascii})
+	}
+	return tags, nil
+}
@@ -0,0 +1,74 @@
+package tiff
+
+import (
+	"bytes"
+	"image"
+	"reflect"
+	"testing"
+)
+
+// TestEncodeGeoInfo tests that GeoTIFF tags written by the encoder are read
+// back unchanged, with GeoKeys of all value types.
+func TestEncodeGeoInfo(t *testing.T) {
+	geo := &GeoInfo{
+		PixelScale: []float64{0.5, 0.5, 0},
+		Tiepoints:  []float64{0, 0, 0, 8.5, 47.25, 0},
+		Keys: map[GeoKeyID]interface{}{
+			GTModelType:      []uint16{ModelTypeGeographic},
+			GTRasterType:     []uint16{RasterPixelIsArea},
+			GeodeticCRS:      []uint16{4326},
+			GTCitation:       "WGS 84",
+			GeodeticCitation: "course map",
+			2057:             []float64{6378137},
+			2059:             []float64{298.257223563},
+			60000:            []uint16{1, 2, 3},
+		},
+	}
+	img := image.NewGray(image.Rect(0, 0, 4, 4))
+	var buf bytes.Buffer
+	if err := Encode(&buf, img, &Options{Geo: geo}); err != nil {
+		t.Fatal(err)
+	}
+	_, m, err := DecodeWithMetadata(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(m.Geo, geo) {
+		t.Errorf("got %+v, want %+v", m.Geo, geo)
+	}
+	if v, ok := m.Geo.Short(GeodeticCRS); !ok || v != 4326 {
+		t.Errorf("got GeodeticCRS %d, %v, want 4326", v, ok)
+	}
+
+	for _, bad := range []*GeoInfo{
+		{Tiepoints: []float64{1, 2, 3}},
+		{Keys: map[GeoKeyID]interface{}{GTCitation: "a|b"}},
+		{Keys: map[GeoKeyID]interface{}{GTModelType: 1}},
+	} {
+		if err := Encode(&buf, img, &Options{Geo: bad}); err == nil {
+			t.Errorf("%+v: got nil error", bad)
+		}
+	}
+}
+
+// TestDecodeGeoKeyRange tests that GeoKeys pointing outside of their
+// parameters are reported.
+func TestDecodeGeoKeyRange(t *testing.T) {
+	dir, err := Tag{ID: tGeoKeyDirectory, Type: TypeShort, Value: []uint64{1, 1, 0, 1, 2057, tGeoDoubleParams, 1, 5}}.entry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := buildTIFF(t, testPage{make([]byte, 1), []ifdEntry{
+		{tImageWidth, dtShort, []uint32{1}},
+		{tImageLength, dtShort, []uint32{1}},
+		{tBitsPerSample, dtShort, []uint32{8}},
+		{tPhotometricInterpretation, dtShort, []uint32{pBlackIsZero}},
+		{tStripOffsets, dtLong, []uint32{0}},
+		{tRowsPerStrip, dtShort, []uint32{1}},
+		{tStripByteCounts, dtLong, []uint32{1}},
+		dir,
+	}})
+	if _, _, err := DecodeWithMetadata(bytes.NewReader(b)); err == nil {
+		t.Error("got nil error for a GeoKey out of range")
+	}
+}
@@ -4,5 +4,6 @@ go 1.12
 
 require (
 	github.com/hhrutter/lzw v0.0.0-20190827003112-58b82c5a41cc
+	github.com/klauspost/compress v1.11.13
 	golang.org/x/image v0.0.0-20190823064033-3a9bac650e44
 )
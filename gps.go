@@ -0,0 +1,122 @@
+package tiff
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// GPS holds the entries of a GPS IFD, which records where a photograph was
+// taken (Exif 2.3 specification). The IFD is referenced by the GPSInfo tag
+// of the image's IFD.
+type GPS struct {
+	// Latitude and Longitude are the position in degrees, positive to the
+	// north and east and negative to the south and west.
+	Latitude, Longitude float64
+	// Altitude is the altitude in meters, negative below sea level. It is
+	// not written if it is zero.
+	Altitude float64
+	// Time is the time of the position fix, which is stored in UTC. It is
+	// zero if the IFD does not hold both the date and the time.
+	Time time.Time
+
+	// Tags holds all entries of the GPS IFD keyed by tag. When a GPS IFD
+	// is written, the entries in Tags are written as well, except for
+	// those set by the fields above, which take precedence.
+	Tags map[uint16]Tag
+}
+
+// newGPS returns the GPS of a GPS IFD with the given entries.
+func newGPS(tags map[uint16]Tag) *GPS {
+	g := &GPS{Tags: tags}
+	rationals := func(id uint16) []float64 {
+		v, _ := tags[id].Value.([][2]uint32)
+		f := make([]float64, len(v))
+		for i, r := range v {
+			if r[1] != 0 {
+				f[i] = float64(r[0]) / float64(r[1])
+			}
+		}
+		return f
+	}
+	// Latitude and longitude are given as degrees, minutes and seconds.
+	degrees := func(id, ref uint16, negative string) float64 {
+		var f float64
+		for i, v := range rationals(id) {
+			if i < 3 {
+				f += v / math.Pow(60, float64(i))
+			}
+		}
+		if s, _ := tags[ref].Value.(string); s == negative {
+			f = -f
+		}
+		return f
+	}
+	g.Latitude = degrees(tGPSLatitude, tGPSLatitudeRef, "S")
+	g.Longitude = degrees(tGPSLongitude, tGPSLongitudeRef, "W")
+
+	if v := rationals(tGPSAltitude); len(v) > 0 {
+		g.Altitude = v[0]
+		if ref, _ := tags[tGPSAltitudeRef].Value.([]byte); len(ref) > 0 && ref[0] == 1 {
+			g.Altitude = -g.Altitude
+		}
+	}
+
+	date, _ := tags[tGPSDateStamp].Value.(string)
+	day, err := time.Parse("2006:01:02", date)
+	if hms := rationals(tGPSTimeStamp); err == nil && len(hms) == 3 {
+		d := time.Duration(hms[0]*float64(time.Hour) + hms[1]*float64(time.Minute) + hms[2]*float64(time.Second))
+		g.Time = day.Add(d.Round(time.Microsecond))
+	}
+	return g
+}
+
+// entries returns the IFD entries of g for writing.
+func (g *GPS) entries() ([]ifdEntry, error) {
+	if !(g.Latitude >= -90 && g.Latitude <= 90) || !(g.Longitude >= -180 && g.Longitude <= 180) ||
+		math.IsNaN(g.Altitude) || math.Abs(g.Altitude) > math.MaxUint32 {
+		return nil, fmt.Errorf("tiff: invalid GPS position %v, %v, %v", g.Latitude, g.Longitude, g.Altitude)
+	}
+	tags := make(map[uint16]Tag)
+	for id, t := range g.Tags {
+		t.ID = id
+		tags[id] = t
+	}
+	if _, ok := tags[tGPSVersionID]; !ok {
+		tags[tGPSVersionID] = Tag{ID: tGPSVersionID, Type: TypeByte, Value: []byte{2, 3, 0, 0}}
+	}
+	// degrees returns f as degrees, minutes and seconds.
+	degrees := func(f float64) [][2]uint32 {
+		d := math.Floor(f)
+		m := (f - d) * 60
+		return [][2]uint32{{uint32(d), 1}, {uint32(math.Floor(m)), 1}, rational((m - math.Floor(m)) * 60)}
+	}
+	set := func(id uint16, typ DataType, v interface{}) {
+		tags[id] = Tag{ID: id, Type: typ, Value: v}
+	}
+	ref := func(f float64, positive, negative string) string {
+		if f < 0 {
+			return negative
+		}
+		return positive
+	}
+	set(tGPSLatitudeRef, TypeASCII, ref(g.Latitude, "N", "S"))
+	set(tGPSLatitude, TypeRational, degrees(math.Abs(g.Latitude)))
+	set(tGPSLongitudeRef, TypeASCII, ref(g.Longitude, "E", "W"))
+	set(tGPSLongitude, TypeRational, degrees(math.Abs(g.Longitude)))
+	if g.Altitude != 0 {
+		below := byte(0)
+		if g.Altitude < 0 {
+			below = 1
+		}
+		set(tGPSAltitudeRef, TypeByte, []byte{below})
+		set(tGPSAltitude, TypeRational, [][2]uint32{rational(math.Abs(g.Altitude))})
+	}
+	if !g.Time.IsZero() {
+		t := g.Time.UTC()
+		sec := float64(t.Second()) + float64(t.Nanosecond())/1e9
+		set(tGPSDateStamp, TypeASCII, t.Format("2006:01:02"))
+		set(tGPSTimeStamp, TypeRational, [][2]uint32{{uint32(t.Hour()), 1}, {uint32(t.Minute()), 1}, rational(sec)})
+	}
+	return tagEntries(tags)
+}
@@ -0,0 +1,56 @@
+package tiff
+
+import (
+	"bytes"
+	"image"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestEncodeGPS tests that a GPS IFD written by the encoder, alone or
+// together with an Exif IFD, is read back by DecodeWithMetadata.
+func TestEncodeGPS(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 2, 2))
+	for _, gps := range []*GPS{
+		{Latitude: 47.123456, Longitude: -8.654321, Altitude: 432.5,
+			Time: time.Date(2020, 6, 7, 8, 9, 10, 250e6, time.UTC)},
+		{Latitude: -33.9, Longitude: 151.2, Altitude: -12,
+			Tags: map[uint16]Tag{18: {ID: 18, Type: TypeASCII, Value: "WGS-84"}}},
+	} {
+		for _, opts := range []*Options{
+			{GPS: gps},
+			{GPS: gps, Exif: &Exif{ISO: 100}, ForceBigTIFF: true},
+		} {
+			var buf bytes.Buffer
+			if err := Encode(&buf, img, opts); err != nil {
+				t.Fatal(err)
+			}
+			_, m, err := DecodeWithMetadata(bytes.NewReader(buf.Bytes()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := m.GPS
+			if got == nil {
+				t.Fatalf("%+v: got no GPS IFD", gps)
+			}
+			if math.Abs(got.Latitude-gps.Latitude) > 1e-9 || math.Abs(got.Longitude-gps.Longitude) > 1e-9 ||
+				got.Altitude != gps.Altitude || !got.Time.Equal(gps.Time) {
+				t.Errorf("got %+v, want %+v", got, gps)
+			}
+			for id, want := range gps.Tags {
+				if v := got.Tags[id].Value; !reflect.DeepEqual(v, want.Value) {
+					t.Errorf("tag %d: got %v, want %v", id, v, want.Value)
+				}
+			}
+			if opts.Exif != nil && (m.Exif == nil || m.Exif.ISO != 100) {
+				t.Errorf("got Exif %+v with GPS", m.Exif)
+			}
+		}
+	}
+
+	if err := Encode(&bytes.Buffer{}, img, &Options{GPS: &GPS{Latitude: 91}}); err == nil {
+		t.Error("got nil error for a latitude of 91 degrees")
+	}
+}
@@ -0,0 +1,268 @@
+package tiff
+
+import (
+	"image"
+	"image/color"
+)
+
+// GrayA represents a GrayAImg color, having 8 bits for each of gray and
+// alpha. The gray value is not premultiplied by alpha; A is 0xff for an
+// opaque color.
+type GrayA struct {
+	Y, A uint8
+}
+
+func (c GrayA) RGBA() (uint32, uint32, uint32, uint32) {
+	a := uint32(c.A) * 0x101
+	y := uint32(c.Y) * 0x101 * a / 0xffff
+	return y, y, y, a
+}
+
+// GrayA16 represents a GrayA16Img color, having 16 bits for each of gray
+// and alpha. The gray value is not premultiplied by alpha; A is 0xffff for
+// an opaque color.
+type GrayA16 struct {
+	Y, A uint16
+}
+
+func (c GrayA16) RGBA() (uint32, uint32, uint32, uint32) {
+	a := uint32(c.A)
+	y := uint32(c.Y) * a / 0xffff
+	return y, y, y, a
+}
+
+// GrayAModel and GrayA16Model are the Models for GrayAImg and GrayA16Img
+// colors. Colors are converted to the luminance of their non-premultiplied
+// color, like color.GrayModel does, preserving their alpha.
+var (
+	GrayAModel   color.Model = color.ModelFunc(grayAModel)
+	GrayA16Model color.Model = color.ModelFunc(grayA16Model)
+)
+
+func grayAModel(c color.Color) color.Color {
+	switch c := c.(type) {
+	case GrayA:
+		return c
+	case GrayA16:
+		return GrayA{uint8(c.Y >> 8), uint8(c.A >> 8)}
+	}
+	c16 := rgbaToGrayA16(c.RGBA())
+	return GrayA{uint8(c16.Y >> 8), uint8(c16.A >> 8)}
+}
+
+func grayA16Model(c color.Color) color.Color {
+	switch c := c.(type) {
+	case GrayA16:
+		return c
+	case GrayA:
+		return GrayA16{uint16(c.Y) * 0x101, uint16(c.A) * 0x101}
+	}
+	return rgbaToGrayA16(c.RGBA())
+}
+
+// rgbaToGrayA16 converts a color with premultiplied 16-bit components, as
+// returned by color.Color's RGBA method, to GrayA16.
+func rgbaToGrayA16(r, g, b, a uint32) GrayA16 {
+	if a == 0 {
+		return GrayA16{}
+	}
+	if a != 0xffff {
+		r = r * 0xffff / a
+		g = g * 0xffff / a
+		b = b * 0xffff / a
+	}
+	// The coefficients are those of color.GrayModel; they add up to 1<<16.
+	y := (19595*r + 38470*g + 7471*b + 1<<15) >> 16
+	return GrayA16{uint16(y), uint16(a)}
+}
+
+// GrayAImg is an in-memory image whose At method returns GrayA values.
+type GrayAImg struct {
+	// Pix holds the image's pixels, in Y, A order. The pixel at (x, y)
+	// starts at Pix[(y-Rect.Min.Y)*Stride + (x-Rect.Min.X)*2].
+	Pix []uint8
+	// Stride is the Pix stride (in bytes) between vertically adjacent pixels.
+	Stride int
+	// Rect is the image's bounds.
+	Rect image.Rectangle
+}
+
+func (p *GrayAImg) ColorModel() color.Model { return GrayAModel }
+
+func (p *GrayAImg) Bounds() image.Rectangle { return p.Rect }
+
+func (p *GrayAImg) At(x, y int) color.Color {
+	return p.GrayAAt(x, y)
+}
+
+func (p *GrayAImg) RGBA64At(x, y int) color.RGBA64 {
+	r, g, b, a := p.GrayAAt(x, y).RGBA()
+	return color.RGBA64{uint16(r), uint16(g), uint16(b), uint16(a)}
+}
+
+func (p *GrayAImg) GrayAAt(x, y int) GrayA {
+	if !(image.Point{x, y}.In(p.Rect)) {
+		return GrayA{}
+	}
+	i := p.PixOffset(x, y)
+	s := p.Pix[i : i+2 : i+2] // Small cap improves performance, see https://golang.org/issue/27857
+	return GrayA{s[0], s[1]}
+}
+
+// PixOffset returns the index of the first element of Pix that corresponds to
+// the pixel at (x, y).
+func (p *GrayAImg) PixOffset(x, y int) int {
+	return pixOffset(p.Rect, p.Stride, 2, x, y)
+}
+
+func (p *GrayAImg) Set(x, y int, c color.Color) {
+	p.SetGrayA(x, y, GrayAModel.Convert(c).(GrayA))
+}
+
+// SetRGBA64 sets the pixel at (x, y) to c, converted as by GrayAModel,
+// without going through a color.Color.
+func (p *GrayAImg) SetRGBA64(x, y int, c color.RGBA64) {
+	c16 := rgbaToGrayA16(uint32(c.R), uint32(c.G), uint32(c.B), uint32(c.A))
+	p.SetGrayA(x, y, GrayA{uint8(c16.Y >> 8), uint8(c16.A >> 8)})
+}
+
+func (p *GrayAImg) SetGrayA(x, y int, c GrayA) {
+	if !(image.Point{x, y}.In(p.Rect)) {
+		return
+	}
+	i := p.PixOffset(x, y)
+	s := p.Pix[i : i+2 : i+2] // Small cap improves performance, see https://golang.org/issue/27857
+	s[0] = c.Y
+	s[1] = c.A
+}
+
+// SubImage returns an image representing the portion of the image p visible
+// through r. The returned value shares pixels with the original image.
+func (p *GrayAImg) SubImage(r image.Rectangle) image.Image {
+	r = r.Intersect(p.Rect)
+	// If r1 and r2 are Rectangles, r1.Intersect(r2) is not guaranteed to be inside
+	// either r1 or r2 if the intersection is empty. Without explicitly checking for
+	// this, the Pix[i:j:j] expression below can panic.
+	if r.Empty() {
+		return &GrayAImg{}
+	}
+	i, j := subPix(p.Rect, p.Stride, 2, r)
+	return &GrayAImg{
+		Pix:    p.Pix[i:j:j],
+		Stride: p.Stride,
+		Rect:   r,
+	}
+}
+
+// Opaque scans the entire image and reports whether it is fully opaque,
+// that is whether all its alpha samples are 0xff.
+func (p *GrayAImg) Opaque() bool {
+	return opaqueAlpha(p.Pix, p.Rect, p.Stride, 2, 1, 1)
+}
+
+// NewGrayA returns a new GrayAImg image with the given bounds.
+func NewGrayA(r image.Rectangle) *GrayAImg {
+	return &GrayAImg{
+		Pix:    make([]uint8, 2*r.Dx()*r.Dy()),
+		Stride: 2 * r.Dx(),
+		Rect:   r,
+	}
+}
+
+// GrayA16Img is an in-memory image whose At method returns GrayA16 values.
+type GrayA16Img struct {
+	// Pix holds the image's pixels, in Y, A order and big-endian format.
+	// The pixel at (x, y) starts at
+	// Pix[(y-Rect.Min.Y)*Stride + (x-Rect.Min.X)*4].
+	Pix []uint8
+	// Stride is the Pix stride (in bytes) between vertically adjacent pixels.
+	Stride int
+	// Rect is the image's bounds.
+	Rect image.Rectangle
+}
+
+func (p *GrayA16Img) ColorModel() color.Model { return GrayA16Model }
+
+func (p *GrayA16Img) Bounds() image.Rectangle { return p.Rect }
+
+func (p *GrayA16Img) At(x, y int) color.Color {
+	return p.GrayA16At(x, y)
+}
+
+func (p *GrayA16Img) RGBA64At(x, y int) color.RGBA64 {
+	r, g, b, a := p.GrayA16At(x, y).RGBA()
+	return color.RGBA64{uint16(r), uint16(g), uint16(b), uint16(a)}
+}
+
+func (p *GrayA16Img) GrayA16At(x, y int) GrayA16 {
+	if !(image.Point{x, y}.In(p.Rect)) {
+		return GrayA16{}
+	}
+	i := p.PixOffset(x, y)
+	s := p.Pix[i : i+4 : i+4] // Small cap improves performance, see https://golang.org/issue/27857
+	return GrayA16{
+		uint16(s[0])<<8 | uint16(s[1]),
+		uint16(s[2])<<8 | uint16(s[3]),
+	}
+}
+
+// PixOffset returns the index of the first element of Pix that corresponds to
+// the pixel at (x, y).
+func (p *GrayA16Img) PixOffset(x, y int) int {
+	return pixOffset(p.Rect, p.Stride, 4, x, y)
+}
+
+func (p *GrayA16Img) Set(x, y int, c color.Color) {
+	p.SetGrayA16(x, y, GrayA16Model.Convert(c).(GrayA16))
+}
+
+// SetRGBA64 sets the pixel at (x, y) to c, converted as by GrayA16Model,
+// without going through a color.Color.
+func (p *GrayA16Img) SetRGBA64(x, y int, c color.RGBA64) {
+	p.SetGrayA16(x, y, rgbaToGrayA16(uint32(c.R), uint32(c.G), uint32(c.B), uint32(c.A)))
+}
+
+func (p *GrayA16Img) SetGrayA16(x, y int, c GrayA16) {
+	if !(image.Point{x, y}.In(p.Rect)) {
+		return
+	}
+	i := p.PixOffset(x, y)
+	s := p.Pix[i : i+4 : i+4] // Small cap improves performance, see https://golang.org/issue/27857
+	s[0] = uint8(c.Y >> 8)
+	s[1] = uint8(c.Y)
+	s[2] = uint8(c.A >> 8)
+	s[3] = uint8(c.A)
+}
+
+// SubImage returns an image representing the portion of the image p visible
+// through r. The returned value shares pixels with the original image.
+func (p *GrayA16Img) SubImage(r image.Rectangle) image.Image {
+	r = r.Intersect(p.Rect)
+	// If r1 and r2 are Rectangles, r1.Intersect(r2) is not guaranteed to be inside
+	// either r1 or r2 if the intersection is empty. Without explicitly checking for
+	// this, the Pix[i:j:j] expression below can panic.
+	if r.Empty() {
+		return &GrayA16Img{}
+	}
+	i, j := subPix(p.Rect, p.Stride, 4, r)
+	return &GrayA16Img{
+		Pix:    p.Pix[i:j:j],
+		Stride: p.Stride,
+		Rect:   r,
+	}
+}
+
+// Opaque scans the entire image and reports whether it is fully opaque,
+// that is whether all its alpha samples are 0xffff.
+func (p *GrayA16Img) Opaque() bool {
+	return opaqueAlpha(p.Pix, p.Rect, p.Stride, 4, 2, 2)
+}
+
+// NewGrayA16 returns a new GrayA16Img image with the given bounds.
+func NewGrayA16(r image.Rectangle) *GrayA16Img {
+	return &GrayA16Img{
+		Pix:    make([]uint8, 4*r.Dx()*r.Dy()),
+		Stride: 4 * r.Dx(),
+		Rect:   r,
+	}
+}
@@ -0,0 +1,85 @@
+package tiff
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestGrayAModel(t *testing.T) {
+	for _, tc := range []struct {
+		c    color.Color
+		want GrayA
+	}{
+		{color.NRGBA{0x80, 0x80, 0x80, 0x40}, GrayA{0x80, 0x40}},
+		{color.RGBA{0x20, 0x20, 0x20, 0x40}, GrayA{0x7f, 0x40}},
+		{color.Gray{0x33}, GrayA{0x33, 0xff}},
+		{color.Transparent, GrayA{}},
+		{GrayA16{0x1234, 0x8000}, GrayA{0x12, 0x80}},
+	} {
+		if got := GrayAModel.Convert(tc.c); got != tc.want {
+			t.Errorf("%#v: got %#v, want %#v", tc.c, got, tc.want)
+		}
+	}
+	if got := GrayA16Model.Convert(GrayA{0x12, 0x80}); got != (GrayA16{0x1212, 0x8080}) {
+		t.Errorf("GrayA16Model: got %#v", got)
+	}
+
+	// RGBA premultiplies the gray value.
+	if r, g, b, a := (GrayA{0xff, 0x80}).RGBA(); r != 0x8080 || g != r || b != r || a != 0x8080 {
+		t.Errorf("GrayA.RGBA: got %#x %#x %#x %#x", r, g, b, a)
+	}
+}
+
+// TestGrayARoundTrip tests that GrayAImg and GrayA16Img images are written
+// as gray with unassociated alpha and decode to images of the same type.
+func TestGrayARoundTrip(t *testing.T) {
+	r := image.Rect(0, 0, 13, 9)
+	m8, m16 := NewGrayA(r), NewGrayA16(r)
+	for y := 0; y < r.Dy(); y++ {
+		for x := 0; x < r.Dx(); x++ {
+			m8.SetGrayA(x, y, GrayA{uint8(x * 19), uint8(y*29 + x)})
+			m16.SetGrayA16(x, y, GrayA16{uint16(x * 4999), uint16(y*7001 + x)})
+		}
+	}
+	for _, src := range []image.Image{m8, m16, m8.SubImage(image.Rect(2, 3, 10, 8)), imageView{m16}} {
+		for _, opt := range []*Options{
+			nil,
+			{Compression: LZW, Predictor: true},
+			{WhiteIsZero: true, TileWidth: 16, TileLength: 16},
+		} {
+			var buf bytes.Buffer
+			if err := Encode(&buf, src, opt); err != nil {
+				t.Fatalf("%T, %+v: %v", src, opt, err)
+			}
+			got, err := Decode(&buf)
+			if err != nil {
+				t.Fatalf("%T, %+v: %v", src, opt, err)
+			}
+			if got.ColorModel() != src.ColorModel() {
+				t.Errorf("%T, %+v: got color model of %T", src, opt, got)
+			}
+			compare(t, src, got)
+		}
+	}
+}
+
+func TestGrayAOpaque(t *testing.T) {
+	m := NewGrayA16(image.Rect(0, 0, 3, 2))
+	if m.Opaque() {
+		t.Error("new image: got opaque")
+	}
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 3; x++ {
+			m.Set(x, y, color.White)
+		}
+	}
+	if !m.Opaque() {
+		t.Error("white image: got not opaque")
+	}
+	m.SetGrayA16(2, 1, GrayA16{0, 0xfffe})
+	if m.Opaque() {
+		t.Error("translucent pixel: got opaque")
+	}
+}
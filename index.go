@@ -0,0 +1,97 @@
+package tiff
+
+import (
+	"fmt"
+	"image"
+	"io"
+)
+
+// A Block is a strip or tile of a page, as listed by Page.Blocks.
+type Block struct {
+	// Offset and Length locate the compressed data of the block in the
+	// file. Length is zero for the empty tiles of sparse files, whose
+	// pixels are zero.
+	Offset, Length int64
+	// Rect holds the pixels of the page covered by the block, which are
+	// clipped to the bounds of the page for strips.
+	Rect image.Rectangle
+	// Plane is the sample that the block holds in an image whose samples
+	// are stored in separate planes, and 0 otherwise.
+	Plane int
+}
+
+// Blocks returns the strips or tiles of the page in the order of their
+// offsets tag, so that callers that fetch parts of remote files, such as
+// with HTTP range requests, can read only the blocks they need and decode
+// them with DecodeTile. Tiles extend past the bounds of the page at the
+// right and bottom edges as they are stored, but Rect is clipped to the
+// page.
+func (p *Page) Blocks() ([]Block, error) {
+	d, err := p.decoder()
+	if err != nil {
+		return nil, err
+	}
+	l, err := d.layout()
+	if err != nil {
+		return nil, err
+	}
+	full := image.Rect(0, 0, d.config.Width, d.config.Height)
+	n := l.across * l.down
+	blocks := make([]Block, n*l.planes)
+	for k := range blocks {
+		i, j := k%n%l.across, k%n/l.across
+		blocks[k] = Block{
+			Offset: int64(l.offsets[k]),
+			Length: int64(l.counts[k]),
+			Rect:   image.Rect(i*l.width, j*l.height, (i+1)*l.width, (j+1)*l.height).Intersect(full),
+			Plane:  k / n,
+		}
+	}
+	return blocks, nil
+}
+
+// DecodeTile decodes the strip or tile idx of the page, as listed by
+// Blocks, from its compressed data, which the caller has read from the
+// file. The returned image has the bounds of the Rect of the block. Only
+// the IFD of the page is read from the file. The blocks of images whose
+// samples are stored in separate planes cannot be decoded on their own.
+func (p *Page) DecodeTile(data []byte, idx int) (image.Image, error) {
+	d, err := p.decoder()
+	if err != nil {
+		return nil, err
+	}
+	l, err := d.layout()
+	if err != nil {
+		return nil, err
+	}
+	if l.planes > 1 {
+		return nil, UnsupportedError("decoding a single block of a planar image")
+	}
+	if idx < 0 || idx >= l.across*l.down {
+		return nil, fmt.Errorf("tiff: block %d out of range [0, %d)", idx, l.across*l.down)
+	}
+	if n := uint(len(data)); n < l.counts[idx] {
+		return nil, fmt.Errorf("tiff: %d bytes of data for block %d of %d bytes", n, idx, l.counts[idx])
+	}
+	d.r = &blockReaderAt{data: data[:l.counts[idx]], offset: int64(l.offsets[idx])}
+	i, j := idx%l.across, idx/l.across
+	return d.decodeRegion(image.Rect(i*l.width, j*l.height, (i+1)*l.width, (j+1)*l.height))
+}
+
+// blockReaderAt is the io.ReaderAt of the decoder of DecodeTile, which holds
+// only the data of the block at offset in the file.
+type blockReaderAt struct {
+	data   []byte
+	offset int64
+}
+
+func (b *blockReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < b.offset || off > b.offset+int64(len(b.data)) {
+		return 0, FormatError("read outside the data of the block")
+	}
+	n := copy(p, b.data[off-b.offset:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
@@ -0,0 +1,63 @@
+package tiff
+
+import (
+	"bytes"
+	"image"
+	"testing"
+)
+
+func TestDecodeTile(t *testing.T) {
+	src, err := openImage("video-001.tiff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, opt := range []*Options{
+		{Compression: LZW, TileWidth: 32, TileLength: 48},
+		{Compression: Deflate, Predictor: true, RowsPerStrip: 10},
+		{RowsPerStrip: 7},
+	} {
+		var buf bytes.Buffer
+		if err := Encode(&buf, src, opt); err != nil {
+			t.Fatal(err)
+		}
+		b := buf.Bytes()
+		f, err := Open(bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := f.Pages[0].Image()
+		if err != nil {
+			t.Fatal(err)
+		}
+		blocks, err := f.Pages[0].Blocks()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The pixels of the page are covered by the blocks exactly once.
+		var area int
+		for _, blk := range blocks {
+			area += blk.Rect.Dx() * blk.Rect.Dy()
+		}
+		if size := want.Bounds().Size(); area != size.X*size.Y {
+			t.Errorf("%+v: the blocks cover %d pixels, want %d", opt, area, size.X*size.Y)
+		}
+		for i, blk := range blocks {
+			m, err := f.Pages[0].DecodeTile(b[blk.Offset:blk.Offset+blk.Length], i)
+			if err != nil {
+				t.Fatalf("%+v: block %d: %v", opt, i, err)
+			}
+			if m.Bounds() != blk.Rect {
+				t.Errorf("%+v: block %d: got bounds %v, want %v", opt, i, m.Bounds(), blk.Rect)
+			}
+			compare(t, want.(interface {
+				SubImage(image.Rectangle) image.Image
+			}).SubImage(blk.Rect), m)
+		}
+		if _, err := f.Pages[0].DecodeTile(nil, len(blocks)); err == nil {
+			t.Errorf("%+v: DecodeTile of block %d of %d succeeds", opt, len(blocks), len(blocks))
+		}
+		if _, err := f.Pages[0].DecodeTile(b[blocks[0].Offset:blocks[0].Offset+blocks[0].Length-1], 0); err == nil {
+			t.Errorf("%+v: DecodeTile with truncated data succeeds", opt)
+		}
+	}
+}
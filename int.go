@@ -0,0 +1,152 @@
+package tiff
+
+import (
+	"image"
+	"image/color"
+)
+
+// Int16Img is an in-memory image holding one signed 16-bit integer sample
+// per pixel. It is returned for grayscale images with a SampleFormat of
+// signed integer data and 16 bits per sample, as used for depth and
+// disparity maps. Its At method maps the samples linearly to gray values,
+// the most negative one to black; use Int16At to get the samples as stored.
+type Int16Img struct {
+	// Pix holds the image's samples. The pixel at (x, y) is at
+	// Pix[(y-Rect.Min.Y)*Stride + (x-Rect.Min.X)].
+	Pix []int16
+	// Stride is the Pix stride (in elements) between vertically adjacent
+	// pixels.
+	Stride int
+	// Rect is the image's bounds.
+	Rect image.Rectangle
+}
+
+func (p *Int16Img) ColorModel() color.Model { return color.Gray16Model }
+
+func (p *Int16Img) Bounds() image.Rectangle { return p.Rect }
+
+func (p *Int16Img) At(x, y int) color.Color {
+	return color.Gray16{uint16(p.Int16At(x, y)) ^ 0x8000}
+}
+
+// Int16At returns the sample of the pixel at (x, y), or 0 if the pixel is
+// outside the image.
+func (p *Int16Img) Int16At(x, y int) int16 {
+	if !(image.Point{x, y}.In(p.Rect)) {
+		return 0
+	}
+	return p.Pix[p.PixOffset(x, y)]
+}
+
+// PixOffset returns the index of the element of Pix that corresponds to the
+// pixel at (x, y).
+func (p *Int16Img) PixOffset(x, y int) int {
+	return pixOffset(p.Rect, p.Stride, 1, x, y)
+}
+
+func (p *Int16Img) SetInt16(x, y int, v int16) {
+	if !(image.Point{x, y}.In(p.Rect)) {
+		return
+	}
+	p.Pix[p.PixOffset(x, y)] = v
+}
+
+// SubImage returns an image representing the portion of the image p visible
+// through r. The returned value shares pixels with the original image.
+func (p *Int16Img) SubImage(r image.Rectangle) image.Image {
+	r = r.Intersect(p.Rect)
+	// If r1 and r2 are Rectangles, r1.Intersect(r2) is not guaranteed to be inside
+	// either r1 or r2 if the intersection is empty. Without explicitly checking for
+	// this, the Pix[i:j:j] expression below can panic.
+	if r.Empty() {
+		return &Int16Img{}
+	}
+	i, j := subPix(p.Rect, p.Stride, 1, r)
+	return &Int16Img{
+		Pix:    p.Pix[i:j:j],
+		Stride: p.Stride,
+		Rect:   r,
+	}
+}
+
+// NewInt16 returns a new Int16Img image with the given bounds.
+func NewInt16(r image.Rectangle) *Int16Img {
+	return &Int16Img{
+		Pix:    make([]int16, r.Dx()*r.Dy()),
+		Stride: r.Dx(),
+		Rect:   r,
+	}
+}
+
+// Int32Img is an in-memory image holding one signed 32-bit integer sample
+// per pixel. It is returned for grayscale images with a SampleFormat of
+// signed integer data and 32 bits per sample. Its At method maps the
+// samples linearly to gray values, keeping their 16 most significant bits;
+// use Int32At to get the samples as stored.
+type Int32Img struct {
+	// Pix holds the image's samples. The pixel at (x, y) is at
+	// Pix[(y-Rect.Min.Y)*Stride + (x-Rect.Min.X)].
+	Pix []int32
+	// Stride is the Pix stride (in elements) between vertically adjacent
+	// pixels.
+	Stride int
+	// Rect is the image's bounds.
+	Rect image.Rectangle
+}
+
+func (p *Int32Img) ColorModel() color.Model { return color.Gray16Model }
+
+func (p *Int32Img) Bounds() image.Rectangle { return p.Rect }
+
+func (p *Int32Img) At(x, y int) color.Color {
+	return color.Gray16{uint16(uint32(p.Int32At(x, y))>>16) ^ 0x8000}
+}
+
+// Int32At returns the sample of the pixel at (x, y), or 0 if the pixel is
+// outside the image.
+func (p *Int32Img) Int32At(x, y int) int32 {
+	if !(image.Point{x, y}.In(p.Rect)) {
+		return 0
+	}
+	return p.Pix[p.PixOffset(x, y)]
+}
+
+// PixOffset returns the index of the element of Pix that corresponds to the
+// pixel at (x, y).
+func (p *Int32Img) PixOffset(x, y int) int {
+	return pixOffset(p.Rect, p.Stride, 1, x, y)
+}
+
+func (p *Int32Img) SetInt32(x, y int, v int32) {
+	if !(image.Point{x, y}.In(p.Rect)) {
+		return
+	}
+	p.Pix[p.PixOffset(x, y)] = v
+}
+
+// SubImage returns an image representing the portion of the image p visible
+// through r. The returned value shares pixels with the original image.
+func (p *Int32Img) SubImage(r image.Rectangle) image.Image {
+	r = r.Intersect(p.Rect)
+	// If r1 and r2 are Rectangles, r1.Intersect(r2) is not guaranteed to be inside
+	// either r1 or r2 if the intersection is empty. Without explicitly checking for
+	// this, the Pix[i:j:j] expression below can panic.
+	if r.Empty() {
+		return &Int32Img{}
+	}
+	i, j := subPix(p.Rect, p.Stride, 1, r)
+	return &Int32Img{
+		Pix:    p.Pix[i:j:j],
+		Stride: p.Stride,
+		Rect:   r,
+	}
+}
+
+// NewInt32 returns a new Int32Img image with the given bounds.
+func NewInt32(r image.Rectangle) *Int32Img {
+	return &Int32Img{
+		Pix:    make([]int32, r.Dx()*r.Dy()),
+		Stride: r.Dx(),
+		Rect:   r,
+	}
+}
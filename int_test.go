@@ -0,0 +1,116 @@
+package tiff
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"reflect"
+	"testing"
+)
+
+// TestDecodeInt tests that signed integer images are decoded into an
+// Int16Img or Int32Img and that their arrays round-trip through EncodeArray
+// and DecodeArray.
+func TestDecodeInt(t *testing.T) {
+	const h, w = 4, 37
+	i16 := make([]int16, h*w)
+	i32 := make([]int32, h*w)
+	for i := range i16 {
+		i16[i] = int16(i*977 - 20000)
+		i32[i] = int32(i*123457 - 9000000)
+	}
+	i32[5] = -1 << 31
+	for _, opts := range []*Options{
+		nil,
+		{Compression: LZW, Predictor: true},
+		{Compression: Deflate, Predictor: true, TileWidth: 16, TileLength: 16},
+	} {
+		for _, data := range []interface{}{i16, i32} {
+			out := new(bytes.Buffer)
+			if err := EncodeArray(out, data, [3]int{h, w, 1}, opts); err != nil {
+				t.Fatal(err)
+			}
+			got, _, dtype, err := DecodeArray(bytes.NewReader(out.Bytes()))
+			if err != nil {
+				t.Fatalf("%T %+v: %v", data, opts, err)
+			}
+			if !reflect.DeepEqual(got, data) {
+				t.Errorf("%T %+v: DecodeArray returned different %s data", data, opts, dtype)
+			}
+
+			img, err := Decode(bytes.NewReader(out.Bytes()))
+			if err != nil {
+				t.Fatalf("%T %+v: %v", data, opts, err)
+			}
+			switch m := img.(type) {
+			case *Int16Img:
+				if !reflect.DeepEqual(m.Pix, data) {
+					t.Errorf("%+v: Int16Img holds different samples", opts)
+				}
+			case *Int32Img:
+				if !reflect.DeepEqual(m.Pix, data) {
+					t.Errorf("%+v: Int32Img holds different samples", opts)
+				}
+			default:
+				t.Errorf("%T %+v: got image of type %T", data, opts, img)
+			}
+		}
+	}
+
+	u32 := make([]uint32, h*w*2)
+	for i := range u32 {
+		u32[i] = uint32(i) * 0x1234567
+	}
+	out := new(bytes.Buffer)
+	if err := EncodeArray(out, u32, [3]int{h, w, 2}, &Options{Compression: LZW}); err != nil {
+		t.Fatal(err)
+	}
+	if got, _, dtype, err := DecodeArray(bytes.NewReader(out.Bytes())); err != nil || !reflect.DeepEqual(got, u32) {
+		t.Errorf("uint32: got %s data, %v", dtype, err)
+	}
+
+	// Several signed samples per pixel are only supported by DecodeArray.
+	out.Reset()
+	if err := EncodeArray(out, i16[:2*w*2], [3]int{2, w, 2}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Decode(bytes.NewReader(out.Bytes())); err == nil {
+		t.Error("Decode: got nil error for two signed samples per pixel")
+	}
+}
+
+func TestIntImg(t *testing.T) {
+	m16 := NewInt16(image.Rect(0, 0, 4, 3))
+	m16.SetInt16(2, 1, -32768)
+	m16.SetInt16(3, 2, 32767)
+	m32 := NewInt32(image.Rect(0, 0, 4, 3))
+	m32.SetInt32(2, 1, -1<<31)
+	m32.SetInt32(3, 2, 1<<31-1)
+	if got := m16.SubImage(image.Rect(2, 1, 4, 3)).(*Int16Img).Int16At(2, 1); got != -32768 {
+		t.Errorf("Int16Img.SubImage: got %v, want -32768", got)
+	}
+	if got := m32.SubImage(image.Rect(2, 1, 4, 3)).(*Int32Img).Int32At(3, 2); got != 1<<31-1 {
+		t.Errorf("Int32Img.SubImage: got %v, want %v", got, 1<<31-1)
+	}
+	for _, tc := range []struct {
+		x, y int
+		want color.Gray16
+	}{
+		{2, 1, color.Gray16{0}},
+		{3, 2, color.Gray16{0xffff}},
+		{0, 0, color.Gray16{0x8000}},
+	} {
+		if got := m16.At(tc.x, tc.y); got != tc.want {
+			t.Errorf("Int16Img.At(%d, %d): got %v, want %v", tc.x, tc.y, got, tc.want)
+		}
+		if got := m32.At(tc.x, tc.y); got != tc.want {
+			t.Errorf("Int32Img.At(%d, %d): got %v, want %v", tc.x, tc.y, got, tc.want)
+		}
+	}
+	if got := Orient(m16, 3).(*Int16Img).Int16At(1, 1); got != -32768 {
+		t.Errorf("Orient: got %v, want -32768", got)
+	}
+	if got := Crop(m32, image.Rect(3, 2, 4, 3)).(*Int32Img).Pix; !reflect.DeepEqual(got, []int32{1<<31 - 1}) {
+		t.Errorf("Crop: got %v", got)
+	}
+}
@@ -0,0 +1,190 @@
+package tiff
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// Lab represents a CIE L*a*b* color relative to the D50 white point, which
+// is the white point of the ICC profile connection space. L ranges from 0
+// to 100; A and B are typically within [-128, 127]. Its RGBA method converts
+// it to opaque sRGB.
+type Lab struct {
+	L, A, B float64
+}
+
+// The D50 white point in CIE XYZ.
+const (
+	d50X = 0.96422
+	d50Y = 1.0
+	d50Z = 0.82521
+)
+
+func (c Lab) RGBA() (uint32, uint32, uint32, uint32) {
+	fy := (c.L + 16) / 116
+	fx := fy + c.A/500
+	fz := fy - c.B/200
+	x, y, z := d50X*labInv(fx), d50Y*labInv(fy), d50Z*labInv(fz)
+	// The matrix converts D50 XYZ to linear sRGB, with Bradford chromatic
+	// adaptation to the D65 white point of sRGB.
+	r := 3.1338561*x - 1.6168667*y - 0.4906146*z
+	g := -0.9787684*x + 1.9161415*y + 0.0334540*z
+	b := 0.0719453*x - 0.2289914*y + 1.4052427*z
+	return srgbEncode(r), srgbEncode(g), srgbEncode(b), 0xffff
+}
+
+// labInv is the inverse of the function f of the definition of CIELAB.
+func labInv(t float64) float64 {
+	if t > 6.0/29 {
+		return t * t * t
+	}
+	return 3 * (6.0 / 29) * (6.0 / 29) * (t - 4.0/29)
+}
+
+// labF is the function f of the definition of CIELAB.
+func labF(t float64) float64 {
+	if t > (6.0/29)*(6.0/29)*(6.0/29) {
+		return math.Cbrt(t)
+	}
+	return t/(3*(6.0/29)*(6.0/29)) + 4.0/29
+}
+
+// srgbEncode applies the sRGB transfer function to the linear value v,
+// clamped to [0, 1], and returns it as a 16-bit value.
+func srgbEncode(v float64) uint32 {
+	switch {
+	case v <= 0:
+		return 0
+	case v >= 1:
+		return 0xffff
+	case v <= 0.0031308:
+		v *= 12.92
+	default:
+		v = 1.055*math.Pow(v, 1/2.4) - 0.055
+	}
+	return uint32(v*0xffff + 0.5)
+}
+
+// srgbDecode converts the 16-bit sRGB value v to a linear value.
+func srgbDecode(v uint32) float64 {
+	f := float64(v) / 0xffff
+	if f <= 0.04045 {
+		return f / 12.92
+	}
+	return math.Pow((f+0.055)/1.055, 2.4)
+}
+
+// LabModel is the Model for Lab colors. Colors are converted to opaque Lab
+// colors by way of their non-premultiplied sRGB values.
+var LabModel color.Model = color.ModelFunc(labModel)
+
+func labModel(c color.Color) color.Color {
+	if c, ok := c.(Lab); ok {
+		return c
+	}
+	r, g, b, a := c.RGBA()
+	if a == 0 {
+		return Lab{}
+	}
+	if a != 0xffff {
+		r = r * 0xffff / a
+		g = g * 0xffff / a
+		b = b * 0xffff / a
+	}
+	lr, lg, lb := srgbDecode(r), srgbDecode(g), srgbDecode(b)
+	// The matrix is the inverse of the one used by Lab.RGBA.
+	x := 0.4360747*lr + 0.3850649*lg + 0.1430804*lb
+	y := 0.2225045*lr + 0.7168786*lg + 0.0606169*lb
+	z := 0.0139322*lr + 0.0971045*lg + 0.7141733*lb
+	fx, fy, fz := labF(x/d50X), labF(y/d50Y), labF(z/d50Z)
+	return Lab{116*fy - 16, 500 * (fx - fy), 200 * (fy - fz)}
+}
+
+// labSamples returns the color given by CIELab samples l, a and b of
+// bytesPerSample bytes as stored in a TIFF file: L* is scaled to the full
+// range of the samples, a* and b* are two's complement signed values, with
+// 8 fractional bits if they have 16 bits.
+func labSamples(l, a, b uint32, bytesPerSample int) Lab {
+	if bytesPerSample == 2 {
+		return Lab{float64(l) * 100 / 0xffff, float64(int16(a)) / 256, float64(int16(b)) / 256}
+	}
+	return Lab{float64(l) * 100 / 0xff, float64(int8(a)), float64(int8(b))}
+}
+
+// LabImg is an in-memory image holding the samples of an image with a
+// PhotometricInterpretation of CIELab or ICCLab. It is returned instead of
+// an image converted to sRGB if DecodeOptions.RawLab is set. Its At method
+// returns Lab values.
+type LabImg struct {
+	// Pix holds the image's samples, in L*, a*, b* order and in the
+	// encoding of CIELab images (p. 110-111 of the spec), to which the
+	// samples of ICCLab images are converted: L* is unsigned, a* and b*
+	// are signed. Samples of 16 bits are in big-endian format. The pixel
+	// at (x, y) starts at
+	// Pix[(y-Rect.Min.Y)*Stride + (x-Rect.Min.X)*3*BytesPerSample].
+	Pix []uint8
+	// Stride is the Pix stride (in bytes) between vertically adjacent pixels.
+	Stride int
+	// Rect is the image's bounds.
+	Rect image.Rectangle
+	// BytesPerSample is the size of a sample in Pix, 1 or 2.
+	BytesPerSample int
+}
+
+func (p *LabImg) ColorModel() color.Model { return LabModel }
+
+func (p *LabImg) Bounds() image.Rectangle { return p.Rect }
+
+func (p *LabImg) At(x, y int) color.Color {
+	return p.LabAt(x, y)
+}
+
+func (p *LabImg) LabAt(x, y int) Lab {
+	if !(image.Point{x, y}.In(p.Rect)) {
+		return Lab{}
+	}
+	i := p.PixOffset(x, y)
+	if p.BytesPerSample == 2 {
+		s := p.Pix[i : i+6 : i+6]
+		return labSamples(uint32(s[0])<<8|uint32(s[1]), uint32(s[2])<<8|uint32(s[3]), uint32(s[4])<<8|uint32(s[5]), 2)
+	}
+	s := p.Pix[i : i+3 : i+3]
+	return labSamples(uint32(s[0]), uint32(s[1]), uint32(s[2]), 1)
+}
+
+// PixOffset returns the index of the first element of Pix that corresponds to
+// the pixel at (x, y).
+func (p *LabImg) PixOffset(x, y int) int {
+	return pixOffset(p.Rect, p.Stride, 3*p.BytesPerSample, x, y)
+}
+
+// SubImage returns an image representing the portion of the image p visible
+// through r. The returned value shares pixels with the original image.
+func (p *LabImg) SubImage(r image.Rectangle) image.Image {
+	r = r.Intersect(p.Rect)
+	// If r1 and r2 are Rectangles, r1.Intersect(r2) is not guaranteed to be inside
+	// either r1 or r2 if the intersection is empty. Without explicitly checking for
+	// this, the Pix[i:j:j] expression below can panic.
+	if r.Empty() {
+		return &LabImg{BytesPerSample: p.BytesPerSample}
+	}
+	i, j := subPix(p.Rect, p.Stride, 3*p.BytesPerSample, r)
+	return &LabImg{
+		Pix:            p.Pix[i:j:j],
+		Stride:         p.Stride,
+		Rect:           r,
+		BytesPerSample: p.BytesPerSample,
+	}
+}
+
+// NewLab returns a new LabImg with the given bounds and bytes per sample.
+func NewLab(r image.Rectangle, bytesPerSample int) *LabImg {
+	stride := 3 * bytesPerSample * r.Dx()
+	return &LabImg{
+		Pix:            make([]uint8, stride*r.Dy()),
+		Stride:         stride,
+		Rect:           r,
+		BytesPerSample: bytesPerSample,
+	}
+}
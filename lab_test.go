@@ -0,0 +1,84 @@
+package tiff
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image/color"
+	"math"
+	"testing"
+)
+
+func TestLabModel(t *testing.T) {
+	for _, c := range []color.RGBA{
+		{0, 0, 0, 0xff},
+		{0xff, 0xff, 0xff, 0xff},
+		{0xff, 0, 0, 0xff},
+		{200, 100, 50, 0xff},
+		{10, 20, 240, 0xff},
+	} {
+		lab := LabModel.Convert(c).(Lab)
+		got := color.RGBAModel.Convert(lab).(color.RGBA)
+		if d := math.Abs(float64(got.R)-float64(c.R)) + math.Abs(float64(got.G)-float64(c.G)) + math.Abs(float64(got.B)-float64(c.B)); d > 2 {
+			t.Errorf("%v: got %v by way of %+v", c, got, lab)
+		}
+	}
+	if lab := LabModel.Convert(color.White).(Lab); math.Abs(lab.L-100) > 0.01 || math.Abs(lab.A) > 0.01 || math.Abs(lab.B) > 0.01 {
+		t.Errorf("white: got %+v", lab)
+	}
+}
+
+// TestDecodeLab tests the decoding of CIELab and ICCLab images, converted to
+// sRGB and with DecodeOptions.RawLab.
+func TestDecodeLab(t *testing.T) {
+	for _, tc := range []struct {
+		photometric, bps uint32
+		samples          []uint16 // L*, a*, b* of white, black and red.
+	}{
+		{pCIELab, 8, []uint16{0xff, 0, 0, 0, 0, 0, 0x8a, 0x51, 0x46}},
+		{pICCLab, 8, []uint16{0xff, 0x80, 0x80, 0, 0x80, 0x80, 0x8a, 0xd1, 0xc6}},
+		{pCIELab, 16, []uint16{0xffff, 0, 0, 0, 0, 0, 0x8a8a, 0x5100, 0x4600}},
+		{pICCLab, 16, []uint16{0xffff, 0x8000, 0x8000, 0, 0x8000, 0x8000, 0x8a8a, 0xd100, 0xc600}},
+	} {
+		var data []byte
+		for _, s := range tc.samples {
+			if tc.bps == 16 {
+				data = append(data, 0, 0)
+				binary.LittleEndian.PutUint16(data[len(data)-2:], s)
+			} else {
+				data = append(data, uint8(s))
+			}
+		}
+		b := buildTIFF(t, testPage{data, []ifdEntry{
+			{tImageWidth, dtShort, []uint32{3}},
+			{tImageLength, dtShort, []uint32{1}},
+			{tBitsPerSample, dtShort, []uint32{tc.bps, tc.bps, tc.bps}},
+			{tPhotometricInterpretation, dtShort, []uint32{tc.photometric}},
+			{tStripOffsets, dtLong, []uint32{0}},
+			{tSamplesPerPixel, dtShort, []uint32{3}},
+			{tRowsPerStrip, dtShort, []uint32{1}},
+			{tStripByteCounts, dtLong, []uint32{uint32(len(data))}},
+		}})
+		img, err := Decode(bytes.NewReader(b))
+		if err != nil {
+			t.Fatalf("photometric %d, %d bits: %v", tc.photometric, tc.bps, err)
+		}
+		for x, want := range []color.RGBA{{0xff, 0xff, 0xff, 0xff}, {0, 0, 0, 0xff}, {0xff, 0, 0, 0xff}} {
+			got := color.RGBAModel.Convert(img.At(x, 0)).(color.RGBA)
+			if math.Abs(float64(got.R)-float64(want.R))+math.Abs(float64(got.G)-float64(want.G))+math.Abs(float64(got.B)-float64(want.B)) > 8 {
+				t.Errorf("photometric %d, %d bits: got %v at %d, want %v", tc.photometric, tc.bps, got, x, want)
+			}
+		}
+
+		img, err = DecodeWithOptions(bytes.NewReader(b), &DecodeOptions{RawLab: true})
+		if err != nil {
+			t.Fatalf("photometric %d, %d bits: %v", tc.photometric, tc.bps, err)
+		}
+		m, ok := img.(*LabImg)
+		if !ok {
+			t.Fatalf("photometric %d, %d bits: got image of type %T, want *LabImg", tc.photometric, tc.bps, img)
+		}
+		if got := m.LabAt(2, 0); math.Abs(got.L-54.12) > 0.01 || got.A != 81 || got.B != 70 {
+			t.Errorf("photometric %d, %d bits: got %+v, want L* 54.12, a* 81, b* 70", tc.photometric, tc.bps, got)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tiff
+
+// This file holds the LZW decompressor of github.com/hhrutter/lzw, reduced
+// to the MSB-first codes with early change that TIFF uses and extended by a
+// reset method, so that its dictionary can be reused for many blocks.
+
+import (
+	"errors"
+	"io"
+)
+
+const (
+	lzwMaxWidth     = 12
+	lzwInvalidCode  = 0xffff
+	lzwFlushBuffer  = 1 << lzwMaxWidth
+	lzwLiteralWidth = 8
+	lzwClear        = 1 << lzwLiteralWidth
+	lzwEOF          = lzwClear + 1
+	lzwInitialWidth = 1 + lzwLiteralWidth
+)
+
+var errLZWInvalidCode = errors.New("lzw: invalid code")
+
+// lzwReader is the state from which readMSB converts a byte stream into a
+// code stream.
+type lzwReader struct {
+	r     io.ByteReader
+	bits  uint32
+	nBits uint
+	width uint
+	err   error
+
+	// The first 1<<lzwLiteralWidth codes are literal codes.
+	// The next two codes mean clear and EOF.
+	// Other valid codes are in the range [lo, hi] where lo := clear + 2,
+	// with the upper bound incrementing on each code seen.
+	//
+	// overflow is the code at which hi overflows the code width. It always
+	// equals 1 << width.
+	//
+	// last is the most recently seen code, or lzwInvalidCode.
+	//
+	// An invariant is that
+	// (hi < overflow) || (hi == overflow && last == lzwInvalidCode)
+	hi, overflow, last uint16
+
+	// Each code c in [lo, hi] expands to two or more bytes. For c != hi:
+	//   suffix[c] is the last of these bytes.
+	//   prefix[c] is the code for all but the last byte.
+	//   This code can either be a literal code or another code in [lo, c).
+	// The c == hi case is a special case.
+	suffix [1 << lzwMaxWidth]uint8
+	prefix [1 << lzwMaxWidth]uint16
+
+	// output is the temporary output buffer.
+	// Literal codes are accumulated from the start of the buffer.
+	// Non-literal codes decode to a sequence of suffixes that are first
+	// written right-to-left from the end of the buffer before being copied
+	// to the start of the buffer.
+	// It is flushed when it contains >= 1<<lzwMaxWidth bytes,
+	// so that there is always room to decode an entire code.
+	output [2 * 1 << lzwMaxWidth]byte
+	o      int    // write index into output
+	toRead []byte // bytes to return from Read
+}
+
+// reset makes z decompress the data of r, discarding its previous state.
+// The dictionary is cleared by the clear code that starts each stream or,
+// if missing, overwritten as codes are seen.
+func (z *lzwReader) reset(r io.ByteReader) {
+	z.r = r
+	z.bits, z.nBits = 0, 0
+	z.width = lzwInitialWidth
+	z.err = nil
+	z.hi = lzwEOF
+	z.overflow = 1 << lzwInitialWidth
+	z.last = lzwInvalidCode
+	z.o = 0
+	z.toRead = nil
+}
+
+// readMSB returns the next code for "Most Significant Bits first" data.
+func (z *lzwReader) readMSB() (uint16, error) {
+	for z.nBits < z.width {
+		x, err := z.r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		z.bits |= uint32(x) << (24 - z.nBits)
+		z.nBits += 8
+	}
+	code := uint16(z.bits >> (32 - z.width))
+	z.bits <<= z.width
+	z.nBits -= z.width
+	return code, nil
+}
+
+func (z *lzwReader) Read(b []byte) (int, error) {
+	for {
+		if len(z.toRead) > 0 {
+			n := copy(b, z.toRead)
+			z.toRead = z.toRead[n:]
+			return n, nil
+		}
+		if z.err != nil {
+			return 0, z.err
+		}
+		z.decode()
+	}
+}
+
+// handleOverflow widens the codes one code early, as TIFF requires.
+func (z *lzwReader) handleOverflow() {
+	if z.hi+1 >= z.overflow {
+		if z.width == lzwMaxWidth {
+			z.last = lzwInvalidCode
+		} else {
+			z.width++
+			z.overflow <<= 1
+		}
+	}
+}
+
+// decode decompresses bytes from r and leaves them in z.toRead.
+func (z *lzwReader) decode() {
+	// Loop over the code stream, converting codes into decompressed bytes.
+loop:
+	for {
+		code, err := z.readMSB()
+		if err != nil {
+			// Some writers omit the EOF code, so the end of the data
+			// is not an error.
+			z.err = err
+			break
+		}
+		switch {
+		case code < lzwClear:
+			// We have a literal code.
+			z.output[z.o] = uint8(code)
+			z.o++
+			if z.last != lzwInvalidCode {
+				// Save what the hi code expands to.
+				z.suffix[z.hi] = uint8(code)
+				z.prefix[z.hi] = z.last
+			}
+		case code == lzwClear:
+			z.width = lzwInitialWidth
+			z.hi = lzwEOF
+			z.overflow = 1 << z.width
+			z.last = lzwInvalidCode
+			continue
+		case code == lzwEOF:
+			z.err = io.EOF
+			break loop
+		case code <= z.hi:
+			c, i := code, len(z.output)-1
+			if code == z.hi && z.last != lzwInvalidCode {
+				// code == hi is a special case which expands to the last expansion
+				// followed by the head of the last expansion. To find the head, we walk
+				// the prefix chain until we find a literal code.
+				c = z.last
+				for c >= lzwClear {
+					c = z.prefix[c]
+				}
+				z.output[i] = uint8(c)
+				i--
+				c = z.last
+			}
+			// Copy the suffix chain into output and then write that to w.
+			for c >= lzwClear {
+				z.output[i] = z.suffix[c]
+				i--
+				c = z.prefix[c]
+			}
+			z.output[i] = uint8(c)
+			z.o += copy(z.output[z.o:], z.output[i:])
+			if z.last != lzwInvalidCode {
+				// Save what the hi code expands to.
+				z.suffix[z.hi] = uint8(c)
+				z.prefix[z.hi] = z.last
+			}
+		default:
+			z.err = errLZWInvalidCode
+			break loop
+		}
+		z.last, z.hi = code, z.hi+1
+		z.handleOverflow()
+		if z.o >= lzwFlushBuffer {
+			break
+		}
+	}
+	// Flush pending output.
+	z.toRead = z.output[:z.o]
+	z.o = 0
+}
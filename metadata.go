@@ -0,0 +1,122 @@
+package tiff
+
+import (
+	"image"
+	"io"
+)
+
+// Metadata holds the descriptive tags of an image, as returned by
+// DecodeWithMetadata.
+type Metadata struct {
+	// XResolution and YResolution are the number of pixels per
+	// ResolutionUnit in each direction. They are zero if the tags are
+	// absent.
+	XResolution, YResolution float64
+	// ResolutionUnit is 1 (no absolute unit), 2 (inch) or 3
+	// (centimeter). It is 2 if the tag is absent.
+	ResolutionUnit int
+	// Orientation is the value of the Orientation tag, as in ConfigFull.
+	// It is 1 if the tag is absent.
+	Orientation int
+
+	// DateTime is the date and time of image creation, in the format
+	// "YYYY:MM:DD HH:MM:SS".
+	DateTime         string
+	Software         string
+	Artist           string
+	ImageDescription string
+
+	// ICCProfile is the embedded ICC profile, and XMP and IPTC are the
+	// XMP packet and the IPTC-NAA record, each nil if there is none. They
+	// are the raw bytes of their entries, whatever the entries' data type.
+	ICCProfile []byte
+	XMP, IPTC  []byte
+
+	// Tags holds all entries of the IFD keyed by tag, including the ones
+	// above and those unknown to this package.
+	Tags map[uint16]Tag
+	// Exif holds the entries of the Exif IFD, or is nil if the image has
+	// none.
+	Exif *Exif
+	// GPS holds the entries of the GPS IFD, or is nil if the image has
+	// none.
+	GPS *GPS
+	// Geo holds the GeoTIFF tags, or is nil if the image has none.
+	Geo *GeoInfo
+}
+
+// newMetadata returns the Metadata of an IFD with the given entries.
+func newMetadata(tags map[uint16]Tag) *Metadata {
+	m := &Metadata{ResolutionUnit: resPerInch, Orientation: 1, Tags: tags}
+	str := func(id uint16) string {
+		s, _ := tags[id].Value.(string)
+		return s
+	}
+	m.DateTime = str(tDateTime)
+	m.Software = str(tSoftware)
+	m.Artist = str(tArtist)
+	m.ImageDescription = str(tImageDescription)
+
+	rational := func(id uint16) float64 {
+		if v, ok := tags[id].Value.([][2]uint32); ok && len(v) > 0 && v[0][1] != 0 {
+			return float64(v[0][0]) / float64(v[0][1])
+		}
+		return 0
+	}
+	m.XResolution = rational(tXResolution)
+	m.YResolution = rational(tYResolution)
+
+	if v, ok := tags[tResolutionUnit].Value.([]uint64); ok && len(v) > 0 {
+		m.ResolutionUnit = int(v[0])
+	}
+	if v, ok := tags[tOrientation].Value.([]uint64); ok && len(v) > 0 && v[0] != 0 {
+		m.Orientation = int(v[0])
+	}
+	return m
+}
+
+// DecodeWithMetadata is like Decode but also returns the metadata of the
+// image.
+func DecodeWithMetadata(r io.Reader) (image.Image, *Metadata, error) {
+	ra := newReaderAt(r)
+	h, offset, err := readHeader(ra)
+	if err != nil {
+		return nil, nil, err
+	}
+	d, err := newIFDDecoder(ra, h, offset, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	tags, _, err := readTags(ra, h, offset)
+	if err != nil {
+		return nil, nil, err
+	}
+	exif, err := readSubIFD(ra, h, tags, tExifIFD)
+	if err != nil {
+		return nil, nil, err
+	}
+	gps, err := readSubIFD(ra, h, tags, tGPSIFD)
+	if err != nil {
+		return nil, nil, err
+	}
+	geo, err := newGeoInfo(tags)
+	if err != nil {
+		return nil, nil, err
+	}
+	img, err := d.decodeImage()
+	if err != nil {
+		return nil, nil, err
+	}
+	m := newMetadata(tags)
+	m.ICCProfile = d.blobs[tICCProfile]
+	m.XMP = d.blobs[tXMP]
+	m.IPTC = d.blobs[tIPTC]
+	m.Geo = geo
+	if exif != nil {
+		m.Exif = newExif(exif)
+	}
+	if gps != nil {
+		m.GPS = newGPS(gps)
+	}
+	return img, m, nil
+}
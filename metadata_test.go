@@ -0,0 +1,170 @@
+package tiff
+
+import (
+	"bytes"
+	"image"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+// TestDecodeWithMetadata tests that the descriptive tags of an image are
+// returned along with its pixels.
+func TestDecodeWithMetadata(t *testing.T) {
+	ascii := func(s string) []uint32 {
+		v := make([]uint32, len(s)+1)
+		for i := range s {
+			v[i] = uint32(s[i])
+		}
+		return v
+	}
+	b := buildTIFF(t, testPage{make([]byte, 6), []ifdEntry{
+		{tImageWidth, dtShort, []uint32{3}},
+		{tImageLength, dtShort, []uint32{2}},
+		{tBitsPerSample, dtShort, []uint32{8}},
+		{tPhotometricInterpretation, dtShort, []uint32{pBlackIsZero}},
+		{tImageDescription, dtASCII, ascii("finish line")},
+		{tStripOffsets, dtLong, []uint32{0}},
+		{tOrientation, dtShort, []uint32{6}},
+		{tRowsPerStrip, dtShort, []uint32{2}},
+		{tStripByteCounts, dtLong, []uint32{6}},
+		{tXResolution, dtRational, []uint32{300, 1}},
+		{tYResolution, dtRational, []uint32{301, 2}},
+		{tResolutionUnit, dtShort, []uint32{resPerCM}},
+		{tSoftware, dtASCII, ascii("camera 1.0")},
+		{tDateTime, dtASCII, ascii("2020:06:07 08:09:10")},
+		{tArtist, dtASCII, ascii("someone")},
+		{40000, dtLong, []uint32{123456}},
+	}})
+
+	img, m, err := DecodeWithMetadata(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := img.Bounds().Size(); got.X != 3 || got.Y != 2 {
+		t.Errorf("got image size %v, want (3,2)", got)
+	}
+	want := Metadata{
+		XResolution:      300,
+		YResolution:      150.5,
+		ResolutionUnit:   resPerCM,
+		Orientation:      6,
+		DateTime:         "2020:06:07 08:09:10",
+		Software:         "camera 1.0",
+		Artist:           "someone",
+		ImageDescription: "finish line",
+	}
+	got := *m
+	got.Tags = nil
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if len(m.Tags) != 16 {
+		t.Errorf("got %d tags, want 16", len(m.Tags))
+	}
+	if v := m.Tags[40000].Value; !reflect.DeepEqual(v, []uint64{123456}) {
+		t.Errorf("got value %v for private tag, want [123456]", v)
+	}
+}
+
+// TestEncodeBlobs tests that an embedded ICC profile, XMP packet and IPTC
+// record are read back and kept when an image is encoded with the options
+// of its SourceInfo.
+func TestEncodeBlobs(t *testing.T) {
+	profile := []byte("\x00\x00\x02\x0cappl\x02\x10\x00\x00mntrRGB XYZ profile data")
+	xmp := []byte(`<x:xmpmeta xmlns:x="adobe:ns:meta/"></x:xmpmeta>`)
+	iptc := []byte("\x1c\x02\x78\x00\x07caption")
+	img := image.NewCMYK(image.Rect(0, 0, 3, 2))
+	var buf bytes.Buffer
+	if err := Encode(&buf, img, &Options{Compression: LZW, ICCProfile: profile, XMP: xmp, IPTC: iptc}); err != nil {
+		t.Fatal(err)
+	}
+	check := func(name string, m *Metadata) {
+		for _, b := range []struct {
+			name      string
+			got, want []byte
+		}{
+			{"ICC profile", m.ICCProfile, profile},
+			{"XMP", m.XMP, xmp},
+			{"IPTC", m.IPTC, iptc},
+		} {
+			if !bytes.Equal(b.got, b.want) {
+				t.Errorf("%s: got %s %q, want %q", name, b.name, b.got, b.want)
+			}
+		}
+	}
+	_, m, err := DecodeWithMetadata(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("encoded", m)
+
+	c, err := DecodeConfigFull(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := Encode(&out, img, c.SourceInfo.Options()); err != nil {
+		t.Fatal(err)
+	}
+	if _, m, err = DecodeWithMetadata(bytes.NewReader(out.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	check("re-encoded", m)
+
+	// IPTC records are often stored as Long values.
+	b := buildTIFF(t, testPage{make([]byte, 1), []ifdEntry{
+		{tImageWidth, dtShort, []uint32{1}},
+		{tImageLength, dtShort, []uint32{1}},
+		{tBitsPerSample, dtShort, []uint32{8}},
+		{tPhotometricInterpretation, dtShort, []uint32{pBlackIsZero}},
+		{tStripOffsets, dtLong, []uint32{0}},
+		{tRowsPerStrip, dtShort, []uint32{1}},
+		{tStripByteCounts, dtLong, []uint32{1}},
+		{tIPTC, dtLong, []uint32{0x0078021c, 0x61630700}},
+	}})
+	if _, m, err = DecodeWithMetadata(bytes.NewReader(b)); err != nil {
+		t.Fatal(err)
+	}
+	if want := []byte("\x1c\x02\x78\x00\x00\x07ca"); !bytes.Equal(m.IPTC, want) {
+		t.Errorf("Long IPTC: got %q, want %q", m.IPTC, want)
+	}
+}
+
+// TestEncodeResolution tests that the resolution given in Options is written
+// and decodes into Metadata.
+func TestEncodeResolution(t *testing.T) {
+	m := image.NewGray(image.Rect(0, 0, 3, 2))
+	for _, tc := range []struct {
+		opts       *Options
+		x, y       float64
+		unit       int
+		xRationals [2]uint32
+	}{
+		{nil, 72, 72, resPerInch, [2]uint32{72, 1}},
+		{&Options{XResolution: 300, YResolution: 150.5, ResolutionUnit: resPerCM}, 300, 150.5, resPerCM, [2]uint32{300, 1}},
+		{&Options{XResolution: 0.25}, 0.25, 0.25, resPerInch, [2]uint32{1, 4}},
+		{&Options{YResolution: 600, ResolutionUnit: resNone}, 600, 600, resNone, [2]uint32{600, 1}},
+		{&Options{ResolutionUnit: resPerCM}, 72, 72, resPerInch, [2]uint32{72, 1}},
+	} {
+		var buf bytes.Buffer
+		if err := Encode(&buf, m, tc.opts); err != nil {
+			t.Fatal(err)
+		}
+		_, md, err := DecodeWithMetadata(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if md.XResolution != tc.x || md.YResolution != tc.y || md.ResolutionUnit != tc.unit {
+			t.Errorf("%+v: got resolution %v x %v, unit %d", tc.opts, md.XResolution, md.YResolution, md.ResolutionUnit)
+		}
+		if got := md.Tags[tXResolution].Value; !reflect.DeepEqual(got, [][2]uint32{tc.xRationals}) {
+			t.Errorf("%+v: got XResolution %v, want %v", tc.opts, got, tc.xRationals)
+		}
+	}
+	for _, opts := range []*Options{{XResolution: -1}, {YResolution: 1e20}, {XResolution: 300, ResolutionUnit: 4}} {
+		if err := Encode(ioutil.Discard, m, opts); err == nil {
+			t.Errorf("%+v: got no error", opts)
+		}
+	}
+}
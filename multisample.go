@@ -0,0 +1,97 @@
+package tiff
+
+import (
+	"image"
+	"image/color"
+)
+
+// MultiSampleImg is an in-memory image holding samples whose meaning is not
+// known to this package. It is returned for images with an unknown
+// PhotometricInterpretation when DecodeOptions.RawOnUnknownPhotometric is
+// set. Its At method returns the first sample of each pixel as a gray
+// value.
+type MultiSampleImg struct {
+	// Pix holds the image's samples, in the order in which they are stored
+	// in the file. Samples of 16 bits are in big-endian format; samples of
+	// fewer than 8 bits are stored one per byte and are not scaled. The
+	// pixel at (x, y) starts at
+	// Pix[(y-Rect.Min.Y)*Stride + (x-Rect.Min.X)*Samples*BytesPerSample].
+	Pix []uint8
+	// Stride is the Pix stride (in bytes) between vertically adjacent pixels.
+	Stride int
+	// Rect is the image's bounds.
+	Rect image.Rectangle
+	// Samples is the number of samples per pixel.
+	Samples int
+	// BytesPerSample is the size of a sample in Pix, 1 or 2.
+	BytesPerSample int
+}
+
+func (p *MultiSampleImg) ColorModel() color.Model {
+	if p.BytesPerSample == 2 {
+		return color.Gray16Model
+	}
+	return color.GrayModel
+}
+
+func (p *MultiSampleImg) Bounds() image.Rectangle { return p.Rect }
+
+func (p *MultiSampleImg) At(x, y int) color.Color {
+	v := p.Sample(x, y, 0)
+	if p.BytesPerSample == 2 {
+		return color.Gray16{uint16(v)}
+	}
+	return color.Gray{uint8(v)}
+}
+
+// Sample returns sample i of the pixel at (x, y), or 0 if the pixel is
+// outside the image or i is out of range.
+func (p *MultiSampleImg) Sample(x, y, i int) uint32 {
+	if !(image.Point{x, y}.In(p.Rect)) || i < 0 || i >= p.Samples {
+		return 0
+	}
+	j := p.PixOffset(x, y) + i*p.BytesPerSample
+	if p.BytesPerSample == 2 {
+		return uint32(p.Pix[j])<<8 | uint32(p.Pix[j+1])
+	}
+	return uint32(p.Pix[j])
+}
+
+// PixOffset returns the index of the first element of Pix that corresponds to
+// the pixel at (x, y).
+func (p *MultiSampleImg) PixOffset(x, y int) int {
+	return pixOffset(p.Rect, p.Stride, p.Samples*p.BytesPerSample, x, y)
+}
+
+// SubImage returns an image representing the portion of the image p visible
+// through r. The returned value shares pixels with the original image.
+func (p *MultiSampleImg) SubImage(r image.Rectangle) image.Image {
+	r = r.Intersect(p.Rect)
+	// If r1 and r2 are Rectangles, r1.Intersect(r2) is not guaranteed to be inside
+	// either r1 or r2 if the intersection is empty. Without explicitly checking for
+	// this, the Pix[i:j:j] expression below can panic.
+	if r.Empty() {
+		return &MultiSampleImg{Samples: p.Samples, BytesPerSample: p.BytesPerSample}
+	}
+	i, j := subPix(p.Rect, p.Stride, p.Samples*p.BytesPerSample, r)
+	return &MultiSampleImg{
+		Pix:            p.Pix[i:j:j],
+		Stride:         p.Stride,
+		Rect:           r,
+		Samples:        p.Samples,
+		BytesPerSample: p.BytesPerSample,
+	}
+}
+
+// NewMultiSample returns a new MultiSampleImg with the given bounds, number
+// of samples per pixel and bytes per sample.
+func NewMultiSample(r image.Rectangle, samples, bytesPerSample int) *MultiSampleImg {
+	stride := samples * bytesPerSample * r.Dx()
+	return &MultiSampleImg{
+		Pix:            make([]uint8, stride*r.Dy()),
+		Stride:         stride,
+		Rect:           r,
+		Samples:        samples,
+		BytesPerSample: bytesPerSample,
+	}
+}
@@ -0,0 +1,82 @@
+package tiff
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"testing"
+)
+
+// TestDecodeRawOnUnknownPhotometric tests that images with an unknown
+// photometric interpretation decode into a MultiSampleImg with their
+// samples as stored if DecodeOptions.RawOnUnknownPhotometric is set.
+func TestDecodeRawOnUnknownPhotometric(t *testing.T) {
+	const w, h = 3, 2
+	const pLogLuv = 32845
+	for _, bps := range []uint32{8, 16} {
+		n := int(bps / 8)
+		data := make([]byte, 3*n*w*h)
+		for i := 0; i < 3*w*h; i++ {
+			if n == 2 {
+				binary.LittleEndian.PutUint16(data[2*i:], uint16(i)*0x0101+1)
+			} else {
+				data[i] = uint8(i)
+			}
+		}
+		b := buildTIFF(t, testPage{data, []ifdEntry{
+			{tImageWidth, dtShort, []uint32{w}},
+			{tImageLength, dtShort, []uint32{h}},
+			{tBitsPerSample, dtShort, []uint32{bps, bps, bps}},
+			{tPhotometricInterpretation, dtShort, []uint32{pLogLuv}},
+			{tStripOffsets, dtLong, []uint32{0}},
+			{tSamplesPerPixel, dtShort, []uint32{3}},
+			{tRowsPerStrip, dtShort, []uint32{h}},
+			{tStripByteCounts, dtLong, []uint32{uint32(len(data))}},
+		}})
+
+		if _, err := Decode(bytes.NewReader(b)); err == nil {
+			t.Errorf("%d bits: got nil error without RawOnUnknownPhotometric", bps)
+		}
+		img, err := DecodeWithOptions(bytes.NewReader(b), &DecodeOptions{RawOnUnknownPhotometric: true})
+		if err != nil {
+			t.Fatalf("%d bits: %v", bps, err)
+		}
+		m, ok := img.(*MultiSampleImg)
+		if !ok {
+			t.Fatalf("%d bits: got image of type %T, want *MultiSampleImg", bps, img)
+		}
+		if m.Samples != 3 || m.BytesPerSample != n {
+			t.Errorf("%d bits: got %d samples of %d bytes, want 3 of %d", bps, m.Samples, m.BytesPerSample, n)
+		}
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				for s := 0; s < 3; s++ {
+					i := (y*w+x)*3 + s
+					want := uint32(i)
+					if n == 2 {
+						want = uint32(i)*0x0101 + 1
+					}
+					if got := m.Sample(x, y, s); got != want {
+						t.Errorf("%d bits: sample %d at (%d, %d): got %d, want %d", bps, s, x, y, got, want)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestMultiSampleImg(t *testing.T) {
+	m := NewMultiSample(image.Rect(0, 0, 4, 3), 2, 2)
+	m.Pix[m.PixOffset(2, 1)+2] = 0x12
+	m.Pix[m.PixOffset(2, 1)+3] = 0x34
+	sub := m.SubImage(image.Rect(1, 1, 3, 3)).(*MultiSampleImg)
+	if got := sub.Sample(2, 1, 1); got != 0x1234 {
+		t.Errorf("SubImage: got sample %#x, want 0x1234", got)
+	}
+	if got := sub.Sample(0, 0, 1); got != 0 {
+		t.Errorf("outside the bounds: got sample %#x, want 0", got)
+	}
+	if got := m.Sample(2, 1, 2); got != 0 {
+		t.Errorf("out of range sample: got %#x, want 0", got)
+	}
+}
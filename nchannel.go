@@ -0,0 +1,137 @@
+package tiff
+
+import (
+	"image"
+	"image/color"
+)
+
+// NChannelImg is an in-memory image holding the samples of a separated
+// image with any number of inks, such as CMYK plus spot colors. It is
+// returned for images with a PhotometricInterpretation of Separated whose
+// NumberOfInks is not 4 or which have more than 5 samples per pixel. Its At
+// method returns the first four inks as CMYK and ignores any others.
+type NChannelImg struct {
+	// Pix holds the image's samples, in the order in which they are stored
+	// in the file. Samples of 16 bits are in big-endian format. The pixel
+	// at (x, y) starts at
+	// Pix[(y-Rect.Min.Y)*Stride + (x-Rect.Min.X)*Samples*BytesPerSample].
+	Pix []uint8
+	// Stride is the Pix stride (in bytes) between vertically adjacent pixels.
+	Stride int
+	// Rect is the image's bounds.
+	Rect image.Rectangle
+	// Samples is the number of samples per pixel.
+	Samples int
+	// BytesPerSample is the size of a sample in Pix, 1 or 2.
+	BytesPerSample int
+	// InkNames holds the names of the inks of the samples, as given by the
+	// InkNames tag, or nil if they are unknown. There may be fewer names
+	// than samples.
+	InkNames []string
+}
+
+func (p *NChannelImg) ColorModel() color.Model {
+	if p.BytesPerSample == 2 {
+		return CMYKA64Model
+	}
+	return color.CMYKModel
+}
+
+func (p *NChannelImg) Bounds() image.Rectangle { return p.Rect }
+
+func (p *NChannelImg) At(x, y int) color.Color {
+	if p.BytesPerSample == 2 {
+		return CMYKA64{
+			uint16(p.Sample(x, y, 0)),
+			uint16(p.Sample(x, y, 1)),
+			uint16(p.Sample(x, y, 2)),
+			uint16(p.Sample(x, y, 3)),
+			0xffff,
+		}
+	}
+	return color.CMYK{
+		uint8(p.Sample(x, y, 0)),
+		uint8(p.Sample(x, y, 1)),
+		uint8(p.Sample(x, y, 2)),
+		uint8(p.Sample(x, y, 3)),
+	}
+}
+
+// Sample returns sample i of the pixel at (x, y), or 0 if the pixel is
+// outside the image or i is out of range.
+func (p *NChannelImg) Sample(x, y, i int) uint32 {
+	if !(image.Point{x, y}.In(p.Rect)) || i < 0 || i >= p.Samples {
+		return 0
+	}
+	j := p.PixOffset(x, y) + i*p.BytesPerSample
+	if p.BytesPerSample == 2 {
+		return uint32(p.Pix[j])<<8 | uint32(p.Pix[j+1])
+	}
+	return uint32(p.Pix[j])
+}
+
+// SetSample sets sample i of the pixel at (x, y) to v. It does nothing if
+// the pixel is outside the image or i is out of range.
+func (p *NChannelImg) SetSample(x, y, i int, v uint32) {
+	if !(image.Point{x, y}.In(p.Rect)) || i < 0 || i >= p.Samples {
+		return
+	}
+	j := p.PixOffset(x, y) + i*p.BytesPerSample
+	if p.BytesPerSample == 2 {
+		p.Pix[j], p.Pix[j+1] = uint8(v>>8), uint8(v)
+		return
+	}
+	p.Pix[j] = uint8(v)
+}
+
+// PixOffset returns the index of the first element of Pix that corresponds to
+// the pixel at (x, y).
+func (p *NChannelImg) PixOffset(x, y int) int {
+	return pixOffset(p.Rect, p.Stride, p.Samples*p.BytesPerSample, x, y)
+}
+
+// SubImage returns an image representing the portion of the image p visible
+// through r. The returned value shares pixels with the original image.
+func (p *NChannelImg) SubImage(r image.Rectangle) image.Image {
+	r = r.Intersect(p.Rect)
+	// If r1 and r2 are Rectangles, r1.Intersect(r2) is not guaranteed to be inside
+	// either r1 or r2 if the intersection is empty. Without explicitly checking for
+	// this, the Pix[i:j:j] expression below can panic.
+	if r.Empty() {
+		return &NChannelImg{Samples: p.Samples, BytesPerSample: p.BytesPerSample, InkNames: p.InkNames}
+	}
+	i, j := subPix(p.Rect, p.Stride, p.Samples*p.BytesPerSample, r)
+	return &NChannelImg{
+		Pix:            p.Pix[i:j:j],
+		Stride:         p.Stride,
+		Rect:           r,
+		Samples:        p.Samples,
+		BytesPerSample: p.BytesPerSample,
+		InkNames:       p.InkNames,
+	}
+}
+
+// multiSample returns a MultiSampleImg sharing the pixels of p.
+func (p *NChannelImg) multiSample() *MultiSampleImg {
+	return &MultiSampleImg{
+		Pix:            p.Pix,
+		Stride:         p.Stride,
+		Rect:           p.Rect,
+		Samples:        p.Samples,
+		BytesPerSample: p.BytesPerSample,
+	}
+}
+
+// NewNChannel returns a new NChannelImg with the given bounds, number of
+// samples per pixel, bytes per sample and ink names.
+func NewNChannel(r image.Rectangle, samples, bytesPerSample int, inkNames []string) *NChannelImg {
+	stride := samples * bytesPerSample * r.Dx()
+	return &NChannelImg{
+		Pix:            make([]uint8, stride*r.Dy()),
+		Stride:         stride,
+		Rect:           r,
+		Samples:        samples,
+		BytesPerSample: bytesPerSample,
+		InkNames:       inkNames,
+	}
+}
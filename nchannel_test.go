@@ -0,0 +1,87 @@
+package tiff
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"reflect"
+	"testing"
+)
+
+// TestNChannelRoundTrip tests that NChannelImg images are written as
+// separated images with their inks and decode to the same samples.
+func TestNChannelRoundTrip(t *testing.T) {
+	names := []string{"Cyan", "Magenta", "Yellow", "Black", "PANTONE 185 C", "Varnish"}
+	for _, bytesPerSample := range []int{1, 2} {
+		src := NewNChannel(image.Rect(0, 0, 11, 7), 6, bytesPerSample, names)
+		for i := range src.Pix {
+			src.Pix[i] = uint8(i*13 + 5)
+		}
+		for _, opt := range []*Options{
+			nil,
+			{Compression: LZW, Predictor: true},
+			{Compression: Deflate, TileWidth: 16, TileLength: 16},
+		} {
+			var buf bytes.Buffer
+			if err := Encode(&buf, src, opt); err != nil {
+				t.Fatalf("%d bytes, %+v: %v", bytesPerSample, opt, err)
+			}
+			img, err := Decode(&buf)
+			if err != nil {
+				t.Fatalf("%d bytes, %+v: %v", bytesPerSample, opt, err)
+			}
+			m, ok := img.(*NChannelImg)
+			if !ok {
+				t.Fatalf("%d bytes, %+v: got image of type %T", bytesPerSample, opt, img)
+			}
+			if m.Samples != 6 || m.BytesPerSample != bytesPerSample || !reflect.DeepEqual(m.InkNames, names) {
+				t.Errorf("%d bytes, %+v: got %d samples of %d bytes, inks %q", bytesPerSample, opt, m.Samples, m.BytesPerSample, m.InkNames)
+			}
+			if !bytes.Equal(m.Pix, src.Pix) {
+				t.Errorf("%d bytes, %+v: samples differ", bytesPerSample, opt)
+			}
+		}
+	}
+}
+
+// TestDecodeNChannel tests that separated images with spot colors are
+// decoded into an NChannelImg, whose At method shows their CMYK inks.
+func TestDecodeNChannel(t *testing.T) {
+	data := []byte{0x10, 0x20, 0x30, 0x40, 0x50}
+	b := buildTIFF(t, testPage{data, []ifdEntry{
+		{tImageWidth, dtShort, []uint32{1}},
+		{tImageLength, dtShort, []uint32{1}},
+		{tBitsPerSample, dtShort, []uint32{8, 8, 8, 8, 8}},
+		{tPhotometricInterpretation, dtShort, []uint32{pCMYK}},
+		{tStripOffsets, dtLong, []uint32{0}},
+		{tSamplesPerPixel, dtShort, []uint32{5}},
+		{tRowsPerStrip, dtShort, []uint32{1}},
+		{tStripByteCounts, dtLong, []uint32{uint32(len(data))}},
+		{tInkSet, dtShort, []uint32{inkSetNotCMYK}},
+		{tNumberOfInks, dtShort, []uint32{5}},
+	}})
+	c, err := DecodeConfig(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.ColorModel != color.CMYKModel {
+		t.Errorf("got color model %v, want color.CMYKModel", c.ColorModel)
+	}
+	img, err := Decode(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, ok := img.(*NChannelImg)
+	if !ok {
+		t.Fatalf("got image of type %T, want *NChannelImg", img)
+	}
+	if m.InkNames != nil {
+		t.Errorf("got ink names %q, want none", m.InkNames)
+	}
+	if got := m.Sample(0, 0, 4); got != 0x50 {
+		t.Errorf("got spot sample %#x, want 0x50", got)
+	}
+	if got, want := m.At(0, 0), (color.CMYK{0x10, 0x20, 0x30, 0x40}); got != want {
+		t.Errorf("got color %v, want %v", got, want)
+	}
+}
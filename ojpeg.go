@@ -0,0 +1,54 @@
+package tiff
+
+import "bytes"
+
+// Old-style JPEG compression, Compression 6, was specified by TIFF 6.0 and
+// replaced by Compression 7 in TIFF Technical Note 2, as its design was
+// flawed. Writers of such files mostly stored a complete JPEG stream,
+// pointed to by the JPEGInterchangeFormat entry, either for the whole image
+// or for just its tables. This package decodes the files of both kinds on
+// a best-effort basis; strips that are not complete JPEG streams, whose
+// tables are given by the JPEGQTables, JPEGDCTables and JPEGACTables
+// entries instead, cannot be decoded.
+
+// oldJPEGStream examines the JPEG stream of the old-style JPEG compressed
+// image with layout l given by the JPEGInterchangeFormat entry. If the
+// stream holds the whole image, l is changed to a single block made of it
+// and oldJPEGStream returns true. If the stream holds only tables, they are
+// used like those of the JPEGTables entry for decoding the strips or tiles.
+func (d *decoder) oldJPEGStream(l *blockLayout) (bool, error) {
+	offset := int64(d.firstVal(tJPEGInterchangeFormat))
+	n := int64(d.firstVal(tJPEGInterchangeFormatLength))
+	if n == 0 {
+		n = d.stripExtent(offset, l.samples)
+	}
+	data, err := readAt(d.r, offset, n)
+	if err != nil {
+		return false, err
+	}
+	if len(data) < 4 || data[0] != 0xff || data[1] != 0xd8 {
+		return false, FormatError("JPEGInterchangeFormat does not point to a JPEG stream")
+	}
+	// The markers of the entropy-coded data are escaped, so an SOS marker
+	// can only start the scan. A stream of the whole image is decoded as
+	// a block of the size of the page, to which readJPEG limits its frame.
+	if bytes.Contains(data, []byte{0xff, 0xda}) {
+		*l = blockLayout{
+			width:   d.config.Width,
+			height:  d.config.Height,
+			across:  minInt(1, d.config.Width),
+			down:    minInt(1, d.config.Height),
+			samples: l.samples,
+			planes:  1,
+			offsets: []uint{uint(offset)},
+			counts:  []uint{uint(len(data))},
+		}
+		return true, nil
+	}
+	// readJPEG expects tables that end with an EOI marker.
+	if !bytes.HasSuffix(data, []byte{0xff, 0xd9}) {
+		data = append(data, 0xff, 0xd9)
+	}
+	d.jpegTables = data
+	return false, nil
+}
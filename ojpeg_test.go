@@ -0,0 +1,106 @@
+package tiff
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"strings"
+	"testing"
+)
+
+func TestDecodeOldJPEG(t *testing.T) {
+	const w, h = 24, 16
+	src := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			src.Set(x, y, color.RGBA{uint8(x * 10), uint8(y * 15), 0x80, 0xff})
+		}
+	}
+	var jbuf bytes.Buffer
+	if err := jpeg.Encode(&jbuf, src, nil); err != nil {
+		t.Fatal(err)
+	}
+	stream := jbuf.Bytes()
+	decoded, err := jpeg.Decode(bytes.NewReader(stream))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := image.NewRGBA(decoded.Bounds())
+	draw.Draw(want, want.Rect, decoded, image.Point{}, draw.Src)
+
+	entries := func(extra ...ifdEntry) []ifdEntry {
+		return append([]ifdEntry{
+			{tImageWidth, dtShort, []uint32{w}},
+			{tImageLength, dtShort, []uint32{h}},
+			{tBitsPerSample, dtShort, []uint32{8, 8, 8}},
+			{tCompression, dtShort, []uint32{cJPEGOld}},
+			{tPhotometricInterpretation, dtShort, []uint32{pYCbCr}},
+		}, extra...)
+	}
+	tables, rest := splitJPEG(t, stream)
+	for _, tc := range []struct {
+		name string
+		page testPage
+	}{
+		{"whole image", testPage{stream, entries(
+			ifdEntry{tStripOffsets, dtLong, []uint32{0}},
+			ifdEntry{tSamplesPerPixel, dtShort, []uint32{3}},
+			ifdEntry{tRowsPerStrip, dtShort, []uint32{h}},
+			ifdEntry{tStripByteCounts, dtLong, []uint32{uint32(len(stream))}},
+			ifdEntry{tJPEGInterchangeFormat, dtLong, []uint32{8}},
+			ifdEntry{tJPEGInterchangeFormatLength, dtLong, []uint32{uint32(len(stream))}},
+		)}},
+		// Without JPEGInterchangeFormatLength and strips, the stream
+		// extends to the IFD.
+		{"no length", testPage{stream, entries(
+			ifdEntry{tSamplesPerPixel, dtShort, []uint32{3}},
+			ifdEntry{tJPEGInterchangeFormat, dtLong, []uint32{8}},
+		)}},
+		{"tables", testPage{append(append([]byte(nil), rest...), tables...), entries(
+			ifdEntry{tStripOffsets, dtLong, []uint32{0}},
+			ifdEntry{tSamplesPerPixel, dtShort, []uint32{3}},
+			ifdEntry{tRowsPerStrip, dtShort, []uint32{h}},
+			ifdEntry{tStripByteCounts, dtLong, []uint32{uint32(len(rest))}},
+			ifdEntry{tJPEGInterchangeFormat, dtLong, []uint32{uint32(8 + len(rest))}},
+			ifdEntry{tJPEGInterchangeFormatLength, dtLong, []uint32{uint32(len(tables))}},
+		)}},
+	} {
+		m, err := Decode(bytes.NewReader(buildTIFF(t, tc.page)))
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		compare(t, want, m)
+	}
+}
+
+// TestDecodeOldJPEGFrameSize tests that a whole-image stream whose frame
+// header claims more pixels than the page is rejected before image/jpeg
+// allocates the frame.
+func TestDecodeOldJPEGFrameSize(t *testing.T) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 8, 8)), nil); err != nil {
+		t.Fatal(err)
+	}
+	b := buf.Bytes()
+	sof := bytes.Index(b, []byte{0xff, 0xc0})
+	if sof < 0 {
+		t.Fatal("no SOF0 marker")
+	}
+	copy(b[sof+5:], []byte{0xff, 0xf0, 0xff, 0xf0})
+	page := testPage{b, []ifdEntry{
+		{tImageWidth, dtShort, []uint32{8}},
+		{tImageLength, dtShort, []uint32{8}},
+		{tBitsPerSample, dtShort, []uint32{8}},
+		{tCompression, dtShort, []uint32{cJPEGOld}},
+		{tPhotometricInterpretation, dtShort, []uint32{pBlackIsZero}},
+		{tJPEGInterchangeFormat, dtLong, []uint32{8}},
+		{tJPEGInterchangeFormatLength, dtLong, []uint32{uint32(len(b))}},
+	}}
+	_, err := DecodeWithOptions(bytes.NewReader(buildTIFF(t, page)), &DecodeOptions{MaxMemory: 1 << 20})
+	if err == nil || !strings.Contains(err.Error(), "JPEG frame") {
+		t.Errorf("got error %v, want an error about the JPEG frame", err)
+	}
+}
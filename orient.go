@@ -0,0 +1,155 @@
+package tiff
+
+import (
+	"image"
+	"image/color"
+)
+
+// Orient returns img transformed for display according to orientation, the
+// value of an Orientation tag (p. 36-37 of the spec): 2 to 4 mirror or
+// rotate the image by 180 degrees, 5 to 8 swap its width and height. The
+// returned image has its origin at (0, 0) and, like the result of Crop, the
+// same concrete type as img for the image types of the standard library and
+// this package. img itself is returned for orientation 1 and for values
+// outside of 1 to 8.
+func Orient(img image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	r := image.Rect(0, 0, w, h)
+	if orientation >= 5 {
+		r = image.Rect(0, 0, h, w)
+	}
+	// at returns the position in img of the pixel displayed at (x, y).
+	at := func(x, y int) (int, int) {
+		switch orientation {
+		case 2:
+			x = w - 1 - x
+		case 3:
+			x, y = w-1-x, h-1-y
+		case 4:
+			y = h - 1 - y
+		case 5:
+			x, y = y, x
+		case 6:
+			x, y = y, h-1-x
+		case 7:
+			x, y = w-1-y, h-1-x
+		case 8:
+			x, y = w-1-y, x
+		}
+		return b.Min.X + x, b.Min.Y + y
+	}
+
+	if m, ok := img.(*Float32Img); ok {
+		dst := NewFloat32(r)
+		for y := 0; y < r.Dy(); y++ {
+			for x := 0; x < r.Dx(); x++ {
+				dst.Pix[y*dst.Stride+x] = m.Float32At(at(x, y))
+			}
+		}
+		return dst
+	}
+	if m, ok := img.(*Int16Img); ok {
+		dst := NewInt16(r)
+		for y := 0; y < r.Dy(); y++ {
+			for x := 0; x < r.Dx(); x++ {
+				dst.Pix[y*dst.Stride+x] = m.Int16At(at(x, y))
+			}
+		}
+		return dst
+	}
+	if m, ok := img.(*Int32Img); ok {
+		dst := NewInt32(r)
+		for y := 0; y < r.Dy(); y++ {
+			for x := 0; x < r.Dx(); x++ {
+				dst.Pix[y*dst.Stride+x] = m.Int32At(at(x, y))
+			}
+		}
+		return dst
+	}
+
+	// Images with pixels of n bytes each are transformed by copying the
+	// bytes of each pixel.
+	var (
+		dst            image.Image
+		dstPix, srcPix []uint8
+		dstStride, n   int
+		srcOffset      func(x, y int) int
+	)
+	switch m := img.(type) {
+	case *image.Gray:
+		d := image.NewGray(r)
+		dst, dstPix, dstStride, srcPix, srcOffset, n = d, d.Pix, d.Stride, m.Pix, m.PixOffset, 1
+	case *image.Gray16:
+		d := image.NewGray16(r)
+		dst, dstPix, dstStride, srcPix, srcOffset, n = d, d.Pix, d.Stride, m.Pix, m.PixOffset, 2
+	case *image.Alpha:
+		d := image.NewAlpha(r)
+		dst, dstPix, dstStride, srcPix, srcOffset, n = d, d.Pix, d.Stride, m.Pix, m.PixOffset, 1
+	case *image.Alpha16:
+		d := image.NewAlpha16(r)
+		dst, dstPix, dstStride, srcPix, srcOffset, n = d, d.Pix, d.Stride, m.Pix, m.PixOffset, 2
+	case *image.RGBA:
+		d := image.NewRGBA(r)
+		dst, dstPix, dstStride, srcPix, srcOffset, n = d, d.Pix, d.Stride, m.Pix, m.PixOffset, 4
+	case *image.RGBA64:
+		d := image.NewRGBA64(r)
+		dst, dstPix, dstStride, srcPix, srcOffset, n = d, d.Pix, d.Stride, m.Pix, m.PixOffset, 8
+	case *image.NRGBA:
+		d := image.NewNRGBA(r)
+		dst, dstPix, dstStride, srcPix, srcOffset, n = d, d.Pix, d.Stride, m.Pix, m.PixOffset, 4
+	case *image.NRGBA64:
+		d := image.NewNRGBA64(r)
+		dst, dstPix, dstStride, srcPix, srcOffset, n = d, d.Pix, d.Stride, m.Pix, m.PixOffset, 8
+	case *image.CMYK:
+		d := image.NewCMYK(r)
+		dst, dstPix, dstStride, srcPix, srcOffset, n = d, d.Pix, d.Stride, m.Pix, m.PixOffset, 4
+	case *image.Paletted:
+		d := image.NewPaletted(r, append(color.Palette(nil), m.Palette...))
+		dst, dstPix, dstStride, srcPix, srcOffset, n = d, d.Pix, d.Stride, m.Pix, m.PixOffset, 1
+	case *CMYKAImg:
+		d := NewCMYKA(r)
+		dst, dstPix, dstStride, srcPix, srcOffset, n = d, d.Pix, d.Stride, m.Pix, m.PixOffset, 5
+	case *PremulCMYKAImg:
+		d := NewPremulCMYKA(r)
+		dst, dstPix, dstStride, srcPix, srcOffset, n = d, d.Pix, d.Stride, m.Pix, m.PixOffset, 5
+	case *CMYKA64Img:
+		d := NewCMYKA64(r)
+		dst, dstPix, dstStride, srcPix, srcOffset, n = d, d.Pix, d.Stride, m.Pix, m.PixOffset, 10
+	case *GrayAImg:
+		d := NewGrayA(r)
+		dst, dstPix, dstStride, srcPix, srcOffset, n = d, d.Pix, d.Stride, m.Pix, m.PixOffset, 2
+	case *GrayA16Img:
+		d := NewGrayA16(r)
+		dst, dstPix, dstStride, srcPix, srcOffset, n = d, d.Pix, d.Stride, m.Pix, m.PixOffset, 4
+	case *NChannelImg:
+		d := NewNChannel(r, m.Samples, m.BytesPerSample, m.InkNames)
+		dst, dstPix, dstStride, srcPix, srcOffset, n = d, d.Pix, d.Stride, m.Pix, m.PixOffset, m.Samples*m.BytesPerSample
+	case *LabImg:
+		d := NewLab(r, m.BytesPerSample)
+		dst, dstPix, dstStride, srcPix, srcOffset, n = d, d.Pix, d.Stride, m.Pix, m.PixOffset, 3*m.BytesPerSample
+	case *MultiSampleImg:
+		d := NewMultiSample(r, m.Samples, m.BytesPerSample)
+		dst, dstPix, dstStride, srcPix, srcOffset, n = d, d.Pix, d.Stride, m.Pix, m.PixOffset, m.Samples*m.BytesPerSample
+	default:
+		d := image.NewRGBA64(r)
+		for y := 0; y < r.Dy(); y++ {
+			for x := 0; x < r.Dx(); x++ {
+				d.Set(x, y, img.At(at(x, y)))
+			}
+		}
+		return d
+	}
+	for y := 0; y < r.Dy(); y++ {
+		i := y * dstStride
+		for x := 0; x < r.Dx(); x++ {
+			j := srcOffset(at(x, y))
+			copy(dstPix[i:i+n], srcPix[j:j+n])
+			i += n
+		}
+	}
+	return dst
+}
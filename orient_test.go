@@ -0,0 +1,91 @@
+package tiff
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"testing"
+)
+
+// TestOrient tests all orientations on an image of 3×2 pixels, for an
+// image type that is copied bytewise and for one that is not.
+func TestOrient(t *testing.T) {
+	src := image.NewGray(image.Rect(10, 20, 13, 22))
+	copy(src.Pix, []uint8{1, 2, 3, 4, 5, 6})
+	// want holds the displayed rows for each orientation.
+	want := map[int][][]uint8{
+		1: {{1, 2, 3}, {4, 5, 6}},
+		2: {{3, 2, 1}, {6, 5, 4}},
+		3: {{6, 5, 4}, {3, 2, 1}},
+		4: {{4, 5, 6}, {1, 2, 3}},
+		5: {{1, 4}, {2, 5}, {3, 6}},
+		6: {{4, 1}, {5, 2}, {6, 3}},
+		7: {{6, 3}, {5, 2}, {4, 1}},
+		8: {{3, 6}, {2, 5}, {1, 4}},
+	}
+	for o, rows := range want {
+		for _, img := range []image.Image{src, imageView{src}} {
+			m := Orient(img, o)
+			b := m.Bounds()
+			if o > 1 && b.Min != (image.Point{}) {
+				t.Errorf("orientation %d: got bounds %v", o, b)
+			}
+			if b.Dx() != len(rows[0]) || b.Dy() != len(rows) {
+				t.Fatalf("orientation %d: got size %v", o, b.Size())
+			}
+			for y, row := range rows {
+				for x, v := range row {
+					if got := color.GrayModel.Convert(m.At(b.Min.X+x, b.Min.Y+y)).(color.Gray).Y; got != v {
+						t.Errorf("orientation %d, %T: got %d at (%d, %d), want %d", o, img, got, x, y, v)
+					}
+				}
+			}
+		}
+	}
+}
+
+// TestDecodeAutoOrient tests that AutoOrient applies the Orientation tag.
+func TestDecodeAutoOrient(t *testing.T) {
+	b := buildTIFF(t, testPage{[]byte{1, 2, 3, 4, 5, 6}, []ifdEntry{
+		{tImageWidth, dtShort, []uint32{3}},
+		{tImageLength, dtShort, []uint32{2}},
+		{tBitsPerSample, dtShort, []uint32{8}},
+		{tPhotometricInterpretation, dtShort, []uint32{pBlackIsZero}},
+		{tStripOffsets, dtLong, []uint32{0}},
+		{tOrientation, dtShort, []uint32{6}},
+		{tRowsPerStrip, dtShort, []uint32{2}},
+		{tStripByteCounts, dtLong, []uint32{6}},
+	}})
+	img, err := Decode(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := img.Bounds().Size(); got != (image.Point{3, 2}) {
+		t.Errorf("without AutoOrient: got size %v, want (3,2)", got)
+	}
+	img, err = DecodeWithOptions(bytes.NewReader(b), &DecodeOptions{AutoOrient: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := img.(*image.Gray)
+	if got := m.Bounds().Size(); got != (image.Point{2, 3}) {
+		t.Fatalf("with AutoOrient: got size %v, want (2,3)", got)
+	}
+	if !bytes.Equal(m.Pix, []uint8{4, 1, 5, 2, 6, 3}) {
+		t.Errorf("with AutoOrient: got pixels %v", m.Pix)
+	}
+
+	// image.DecodeConfig applies the default options like image.Decode.
+	defer SetDefaultDecodeOptions(DefaultDecodeOptions())
+	SetDefaultDecodeOptions(DecodeOptions{AutoOrient: true})
+	c, _, err := image.DecodeConfig(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Width != 2 || c.Height != 3 {
+		t.Errorf("image.DecodeConfig with AutoOrient: got %dx%d, want 2x3", c.Width, c.Height)
+	}
+	if c, err := DecodeConfig(bytes.NewReader(b)); err != nil || c.Width != 3 || c.Height != 2 {
+		t.Errorf("DecodeConfig: got %dx%d, error %v, want 3x2", c.Width, c.Height, err)
+	}
+}
@@ -0,0 +1,135 @@
+package tiff
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// Photoshop image resource IDs (Adobe Photoshop File Formats
+// Specification, "Image Resource Blocks").
+const (
+	psResolutionInfo = 0x03ed
+	psCaption        = 0x03f0
+	psIPTC           = 0x0404
+)
+
+// A PhotoshopResource is an image resource block of the Photoshop tag.
+type PhotoshopResource struct {
+	ID   uint16
+	Name string
+	Data []byte
+}
+
+// PhotoshopInfo holds the image resource blocks of a Photoshop tag and the
+// metadata decoded from them.
+type PhotoshopInfo struct {
+	// Resources holds all resource blocks, in the order in which they are
+	// stored, including those that are not decoded.
+	Resources []PhotoshopResource
+
+	// XResolution and YResolution are the resolution of the image given
+	// by the ResolutionInfo resource, in pixels per ResolutionUnit.
+	// ResolutionUnit is 2 for inches and 3 for centimeters, as for the
+	// ResolutionUnit tag, or 0 if there is no ResolutionInfo resource.
+	XResolution, YResolution float64
+	ResolutionUnit           int
+
+	// Caption is the caption of the image, taken from the Caption resource
+	// or, if there is none, from the Caption/Abstract dataset of the
+	// IPTC-NAA resource.
+	Caption string
+}
+
+// ParsePhotoshop parses the value of a Photoshop tag (34377), which
+// consists of image resource blocks. The resolution and caption resources
+// are decoded; other resources are returned as they are. A value that ends
+// in the middle of a block is reported as an error.
+func ParsePhotoshop(data []byte) (*PhotoshopInfo, error) {
+	info := &PhotoshopInfo{}
+	for len(data) > 0 {
+		// Each block starts with a signature, which is "8BIM" except for
+		// some old or third-party blocks, and the resource ID.
+		if len(data) < 7 {
+			return nil, errors.New("tiff: truncated Photoshop resource block")
+		}
+		id := binary.BigEndian.Uint16(data[4:6])
+		// The name is a Pascal string padded to an even size.
+		n := 1 + int(data[6])
+		n += n % 2
+		if len(data) < 6+n+4 {
+			return nil, errors.New("tiff: truncated Photoshop resource block")
+		}
+		name := string(data[7 : 7+int(data[6])])
+		data = data[6+n:]
+		size := int64(binary.BigEndian.Uint32(data))
+		data = data[4:]
+		if size > int64(len(data)) {
+			return nil, errors.New("tiff: truncated Photoshop resource block")
+		}
+		r := PhotoshopResource{ID: id, Name: name, Data: data[:size]}
+		info.Resources = append(info.Resources, r)
+		// The data is padded to an even size as well, but the padding of
+		// the last block is sometimes missing.
+		if size%2 != 0 && size < int64(len(data)) {
+			size++
+		}
+		data = data[size:]
+
+		switch id {
+		case psResolutionInfo:
+			info.parseResolution(r.Data)
+		case psCaption:
+			if len(r.Data) > 0 && int(r.Data[0]) < len(r.Data) {
+				info.Caption = string(r.Data[1 : 1+int(r.Data[0])])
+			}
+		}
+	}
+	if info.Caption == "" {
+		for _, r := range info.Resources {
+			if r.ID == psIPTC {
+				info.Caption = iptcCaption(r.Data)
+				break
+			}
+		}
+	}
+	return info, nil
+}
+
+// parseResolution decodes a ResolutionInfo resource, which holds the
+// horizontal and vertical resolution as 16.16 fixed-point numbers, each
+// followed by its unit (1 for pixels per inch, 2 for pixels per cm) and the
+// unit used to display the width or height.
+func (info *PhotoshopInfo) parseResolution(p []byte) {
+	if len(p) < 16 {
+		return
+	}
+	unit := binary.BigEndian.Uint16(p[4:6])
+	if unit != 1 && unit != 2 {
+		return
+	}
+	info.XResolution = float64(binary.BigEndian.Uint32(p[0:4])) / 65536
+	info.YResolution = float64(binary.BigEndian.Uint32(p[8:12])) / 65536
+	info.ResolutionUnit = resPerInch
+	if unit == 2 {
+		info.ResolutionUnit = resPerCM
+	}
+}
+
+// iptcCaption returns the Caption/Abstract (2:120) dataset of the IPTC-NAA
+// data in p, or the empty string if there is none.
+func iptcCaption(p []byte) string {
+	// Each dataset starts with a tag marker, the record and dataset
+	// numbers and the size of the data. Datasets with an extended size
+	// are not used for captions and end the search.
+	for len(p) >= 5 && p[0] == 0x1c {
+		size := int(binary.BigEndian.Uint16(p[3:5]))
+		if size&0x8000 != 0 || 5+size > len(p) {
+			break
+		}
+		if p[1] == 2 && p[2] == 120 {
+			return string(p[5 : 5+size])
+		}
+		p = p[5+size:]
+	}
+	return ""
+}
@@ -0,0 +1,89 @@
+package tiff
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// psBlock returns an image resource block with the given ID, name and data.
+func psBlock(id uint16, name string, data []byte) []byte {
+	b := []byte("8BIM")
+	b = append(b, byte(id>>8), byte(id))
+	b = append(b, byte(len(name)))
+	b = append(b, name...)
+	if len(b)%2 != 0 {
+		b = append(b, 0)
+	}
+	var size [4]byte
+	binary.BigEndian.PutUint32(size[:], uint32(len(data)))
+	b = append(b, size[:]...)
+	b = append(b, data...)
+	if len(data)%2 != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+// TestParsePhotoshop tests that the resolution and caption are extracted
+// from the Photoshop tag of a decoded image, and that unknown blocks are
+// skipped.
+func TestParsePhotoshop(t *testing.T) {
+	caption := "Finish line, heat 3"
+	iptc := []byte{0x1c, 2, 0, 0, 2, 0, 4} // Record version.
+	iptc = append(iptc, 0x1c, 2, 120, 0, byte(len(caption)))
+	iptc = append(iptc, caption...)
+	resolution := []byte{
+		0x01, 0x2c, 0x80, 0x00, 0, 1, 0, 2, // 300.5 pixels per inch.
+		0x00, 0x48, 0x00, 0x00, 0, 1, 0, 2, // 72 pixels per inch.
+	}
+	var ps []byte
+	ps = append(ps, psBlock(0x0bb7, "", []byte{1, 2, 3})...)
+	ps = append(ps, psBlock(psResolutionInfo, "res", resolution)...)
+	ps = append(ps, psBlock(psIPTC, "", iptc)...)
+	value := make([]uint32, len(ps))
+	for i, b := range ps {
+		value[i] = uint32(b)
+	}
+	b := buildTIFF(t, testPage{make([]byte, 6), []ifdEntry{
+		{tImageWidth, dtShort, []uint32{3}},
+		{tImageLength, dtShort, []uint32{2}},
+		{tBitsPerSample, dtShort, []uint32{8}},
+		{tPhotometricInterpretation, dtShort, []uint32{pBlackIsZero}},
+		{tStripOffsets, dtLong, []uint32{0}},
+		{tRowsPerStrip, dtShort, []uint32{2}},
+		{tStripByteCounts, dtLong, []uint32{6}},
+		{tPhotoshop, dtUndefined, value},
+	}})
+
+	c, err := DecodeConfigFull(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var data []byte
+	for _, tag := range c.ExtraTags {
+		if tag.ID == tPhotoshop {
+			data = tag.Value.([]byte)
+		}
+	}
+	info, err := ParsePhotoshop(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(info.Resources) != 3 {
+		t.Fatalf("got %d resources, want 3", len(info.Resources))
+	}
+	if r := info.Resources[1]; r.ID != psResolutionInfo || r.Name != "res" || !bytes.Equal(r.Data, resolution) {
+		t.Errorf("got resource %+v", r)
+	}
+	if info.Caption != caption {
+		t.Errorf("got caption %q, want %q", info.Caption, caption)
+	}
+	if info.XResolution != 300.5 || info.YResolution != 72 || info.ResolutionUnit != resPerInch {
+		t.Errorf("got resolution %vx%v unit %d, want 300.5x72 unit %d", info.XResolution, info.YResolution, info.ResolutionUnit, resPerInch)
+	}
+
+	if _, err := ParsePhotoshop(ps[:len(ps)-10]); err == nil {
+		t.Error("truncated data: got nil error")
+	}
+}
@@ -0,0 +1,45 @@
+package tiff
+
+import "image"
+
+// The functions below implement the bounds arithmetic shared by the image
+// types of this package, whose pixels of size elements each are stored
+// row by row in a Pix slice, the rows being stride elements apart.
+
+// pixOffset returns the index of the first element of the pixel at (x, y)
+// in the Pix of an image with bounds rect.
+func pixOffset(rect image.Rectangle, stride, size, x, y int) int {
+	return (y-rect.Min.Y)*stride + (x-rect.Min.X)*size
+}
+
+// subPix returns the range [i, j) of the Pix of an image with bounds rect
+// that holds the pixels of r, which must be a non-empty rectangle within
+// rect. The range ends after the last pixel of r, so that the Pix of a
+// SubImage, sliced as Pix[i:j:j], cannot reach the pixels that follow r.
+func subPix(rect image.Rectangle, stride, size int, r image.Rectangle) (i, j int) {
+	i = pixOffset(rect, stride, size, r.Min.X, r.Min.Y)
+	j = pixOffset(rect, stride, size, r.Max.X-1, r.Max.Y-1) + size
+	return i, j
+}
+
+// opaqueAlpha reports whether all alpha samples of an image with bounds rect
+// are 0xff or, if n is 2, 0xffff. The alpha sample of each pixel starts at
+// element alpha of the pixel and is n bytes long.
+func opaqueAlpha(pix []uint8, rect image.Rectangle, stride, size, alpha, n int) bool {
+	if rect.Empty() {
+		return true
+	}
+	i0, i1 := alpha, rect.Dx()*size
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for i := i0; i < i1; i += size {
+			for _, a := range pix[i : i+n] {
+				if a != 0xff {
+					return false
+				}
+			}
+		}
+		i0 += stride
+		i1 += stride
+	}
+	return true
+}
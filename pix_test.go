@@ -0,0 +1,111 @@
+package tiff
+
+import (
+	"bytes"
+	"image"
+	"image/draw"
+	"reflect"
+	"testing"
+)
+
+// TestSubImage tests that the SubImage of each image type of the package
+// shares the pixels of the image within its bounds only.
+func TestSubImage(t *testing.T) {
+	type subImager interface {
+		image.Image
+		SubImage(image.Rectangle) image.Image
+		PixOffset(x, y int) int
+	}
+	r := image.Rect(-2, 1, 5, 6)
+	for _, m := range []subImager{
+		NewCMYKA(r), NewPremulCMYKA(r), NewCMYKA64(r), NewGrayA(r), NewGrayA16(r),
+		NewFloat32(r), NewInt16(r), NewInt32(r), NewLab(r, 2), NewMultiSample(r, 3, 2),
+		NewNChannel(r, 2, 1, []string{"a", "b"}),
+	} {
+		pix := reflect.ValueOf(m).Elem().FieldByName("Pix")
+		for i := 0; i < pix.Len(); i++ {
+			switch e := pix.Index(i); e.Kind() {
+			case reflect.Uint8:
+				e.SetUint(uint64(i * 7))
+			case reflect.Int16, reflect.Int32:
+				e.SetInt(int64(i * 7))
+			case reflect.Float32:
+				e.SetFloat(float64(i) / 7)
+			}
+		}
+		for _, rect := range []image.Rectangle{
+			image.Rect(0, 2, 3, 4),
+			image.Rect(-2, 5, 5, 6),
+			image.Rect(4, 1, 9, 9),
+		} {
+			sub := m.SubImage(rect).(subImager)
+			want := rect.Intersect(r)
+			if sub.Bounds() != want {
+				t.Errorf("%T: SubImage(%v) has bounds %v, want %v", m, rect, sub.Bounds(), want)
+				continue
+			}
+			for y := want.Min.Y; y < want.Max.Y; y++ {
+				for x := want.Min.X; x < want.Max.X; x++ {
+					if got, want := sub.At(x, y), m.At(x, y); got != want {
+						t.Fatalf("%T: SubImage(%v).At(%d, %d) is %v, want %v", m, rect, x, y, got, want)
+					}
+				}
+			}
+			// The Pix of the SubImage ends with its last pixel.
+			size := m.PixOffset(r.Min.X+1, r.Min.Y)
+			p := reflect.ValueOf(sub).Elem().FieldByName("Pix")
+			if n := sub.PixOffset(want.Max.X-1, want.Max.Y-1) + size; p.Len() != n || p.Cap() != n {
+				t.Errorf("%T: SubImage(%v) has a Pix of length %d and capacity %d, want %d", m, rect, p.Len(), p.Cap(), n)
+			}
+		}
+		if b := m.SubImage(image.Rect(10, 10, 20, 20)).Bounds(); !b.Empty() {
+			t.Errorf("%T: SubImage outside the image has bounds %v", m, b)
+		}
+	}
+}
+
+// TestSubImageOpaque tests that Opaque scans only the pixels of a SubImage.
+func TestSubImageOpaque(t *testing.T) {
+	r := image.Rect(0, 0, 4, 3)
+	inner := image.Rect(1, 1, 3, 2)
+	for _, m := range []interface {
+		draw.Image
+		Opaque() bool
+		SubImage(image.Rectangle) image.Image
+	}{NewCMYKA(r), NewPremulCMYKA(r), NewCMYKA64(r), NewGrayA(r), NewGrayA16(r)} {
+		for x := inner.Min.X; x < inner.Max.X; x++ {
+			m.Set(x, inner.Min.Y, CMYKA{A: 0xff})
+		}
+		if m.Opaque() {
+			t.Errorf("%T: Opaque reports true for a transparent image", m)
+		}
+		if sub := m.SubImage(inner).(interface{ Opaque() bool }); !sub.Opaque() {
+			t.Errorf("%T: Opaque reports false for an opaque SubImage", m)
+		}
+	}
+}
+
+// TestEncodeSubImage tests that SubImages, whose Pix ends with their last
+// pixel, are encoded like images of their own.
+func TestEncodeSubImage(t *testing.T) {
+	r := image.Rect(0, 0, 6, 5)
+	for _, m := range []draw.Image{NewCMYKA(r), NewGrayA(r), NewCMYKA64(r)} {
+		for y := 0; y < r.Dy(); y++ {
+			for x := 0; x < r.Dx(); x++ {
+				m.Set(x, y, CMYKA{uint8(x * 40), uint8(y * 50), 0, 0x20, 0xff})
+			}
+		}
+		sub := m.(interface {
+			SubImage(image.Rectangle) image.Image
+		}).SubImage(image.Rect(2, 1, 6, 5))
+		var buf bytes.Buffer
+		if err := Encode(&buf, sub, nil); err != nil {
+			t.Fatalf("%T: %v", m, err)
+		}
+		got, err := Decode(&buf)
+		if err != nil {
+			t.Fatalf("%T: %v", m, err)
+		}
+		compare(t, sub, got)
+	}
+}
@@ -0,0 +1,130 @@
+package tiff
+
+import (
+	"bufio"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/zlib"
+)
+
+// The decoder reuses the scratch space of the blocks it reads: the buffers
+// of their uncompressed data, the buffered readers of their compressed data
+// and the LZW and zlib decompressors with their dictionaries. The zlib
+// decompressors are those of github.com/klauspost/compress, which reuse
+// their Adler-32 checksum when they are reset, while those of compress/zlib
+// allocate a new one for each block. The pools are shared by all decoders,
+// so that decoding one image after another allocates little memory besides
+// the images themselves.
+var (
+	blockBuffers sync.Pool // *[]byte holding the uncompressed data of a block.
+	blockReaders = sync.Pool{New: func() interface{} {
+		b := new(blockReader)
+		b.br = bufio.NewReader(&b.s)
+		return b
+	}}
+	lzwReaders  = sync.Pool{New: func() interface{} { return new(lzwReader) }}
+	zlibReaders sync.Pool // io.ReadCloser returned by zlib.NewReader.
+)
+
+// scratchBuffer returns a buffer from blockBuffers, whose length and
+// contents are undefined, to hold the data of the current block. It is
+// returned to the pool by releaseScratch.
+func (d *decoder) scratchBuffer() *[]byte {
+	p, _ := blockBuffers.Get().(*[]byte)
+	if p == nil {
+		p = new([]byte)
+	}
+	d.scratch = append(d.scratch, p)
+	return p
+}
+
+// releaseScratch returns the buffers of the current block to blockBuffers
+// once the block has been decoded. d.buf must not be used afterwards.
+func (d *decoder) releaseScratch() {
+	for i, p := range d.scratch {
+		blockBuffers.Put(p)
+		d.scratch[i] = nil
+	}
+	d.scratch = d.scratch[:0]
+	d.buf = nil
+}
+
+// section reads the bytes from off to end of r, like an io.SectionReader that
+// can be reused.
+type section struct {
+	r        io.ReaderAt
+	off, end int64
+}
+
+func (s *section) Read(p []byte) (int, error) {
+	if s.off >= s.end {
+		return 0, io.EOF
+	}
+	if max := s.end - s.off; int64(len(p)) > max {
+		p = p[:max]
+	}
+	n, err := s.r.ReadAt(p, s.off)
+	s.off += int64(n)
+	if err == io.EOF && n == len(p) {
+		err = nil
+	}
+	return n, err
+}
+
+// A blockReader reads the compressed data of a block through a buffer,
+// which makes it an io.ByteReader, so that the decompressors do not
+// allocate buffers of their own.
+type blockReader struct {
+	s  section
+	br *bufio.Reader
+}
+
+// newBlockReader returns a blockReader from blockReaders that reads the n
+// bytes at offset of r. It is returned to the pool by release.
+func newBlockReader(r io.ReaderAt, offset, n int64) *blockReader {
+	b := blockReaders.Get().(*blockReader)
+	b.s = section{r, offset, offset + n}
+	b.br.Reset(&b.s)
+	return b
+}
+
+func (b *blockReader) Read(p []byte) (int, error) { return b.br.Read(p) }
+
+func (b *blockReader) ReadByte() (byte, error) { return b.br.ReadByte() }
+
+func (b *blockReader) release() {
+	b.s.r = nil
+	blockReaders.Put(b)
+}
+
+// newLZWReader returns an LZW decompressor of r from lzwReaders. It is
+// returned to the pool by releaseLZWReader.
+func newLZWReader(r io.ByteReader) *lzwReader {
+	z := lzwReaders.Get().(*lzwReader)
+	z.reset(r)
+	return z
+}
+
+func releaseLZWReader(z *lzwReader) {
+	z.reset(nil)
+	lzwReaders.Put(z)
+}
+
+// newZlibReader returns a zlib decompressor of r, reusing one from
+// zlibReaders if possible. It is returned to the pool by releaseZlibReader.
+func newZlibReader(r io.Reader) (io.ReadCloser, error) {
+	if z, ok := zlibReaders.Get().(io.ReadCloser); ok {
+		if err := z.(zlib.Resetter).Reset(r, nil); err != nil {
+			zlibReaders.Put(z)
+			return nil, err
+		}
+		return z, nil
+	}
+	return zlib.NewReader(r)
+}
+
+func releaseZlibReader(z io.ReadCloser) {
+	z.Close()
+	zlibReaders.Put(z)
+}
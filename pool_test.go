@@ -0,0 +1,41 @@
+package tiff
+
+import (
+	"bytes"
+	"image"
+	"testing"
+)
+
+// TestDecodeAllocs tests that the scratch space of blocks is reused, so
+// that the number of allocations of a decode does not grow with the number
+// of blocks.
+func TestDecodeAllocs(t *testing.T) {
+	if raceEnabled {
+		t.Skip("sync.Pool drops items with the race detector")
+	}
+	m := image.NewGray16(image.Rect(0, 0, 64, 64))
+	for i := range m.Pix {
+		m.Pix[i] = uint8(i * 7 >> 3)
+	}
+	dst := image.NewGray16(m.Rect)
+	allocs := func(opt *Options) float64 {
+		var buf bytes.Buffer
+		if err := Encode(&buf, m, opt); err != nil {
+			t.Fatal(err)
+		}
+		return testing.AllocsPerRun(10, func() {
+			if err := DecodeInto(bytes.NewReader(buf.Bytes()), dst); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+	for _, c := range []CompressionType{Uncompressed, LZW, Deflate, PackBits} {
+		one := allocs(&Options{Compression: c, RowsPerStrip: 64})
+		many := allocs(&Options{Compression: c, RowsPerStrip: 1})
+		// A few allocations are made for the longer StripOffsets and
+		// StripByteCounts entries and when a pool has been emptied.
+		if many > one+8 {
+			t.Errorf("compression %d: %v allocations for 64 strips, %v for one strip", c, many, one)
+		}
+	}
+}
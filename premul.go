@@ -0,0 +1,118 @@
+package tiff
+
+import (
+	"image"
+	"image/color"
+)
+
+// PremulCMYKAImg is an in-memory image like CMYKAImg whose C, M, Y and K
+// samples are premultiplied by alpha, as stored in CMYK files with
+// associated alpha. It is the CMYK counterpart of image.RGBA, which suits
+// compositing, as CMYKAImg is that of image.NRGBA. Its At method returns
+// CMYKA values, which are not premultiplied.
+type PremulCMYKAImg struct {
+	// Pix holds the image's pixels, in C, M, Y, K, A order. The pixel at
+	// (x, y) starts at Pix[(y-Rect.Min.Y)*Stride + (x-Rect.Min.X)*5].
+	Pix []uint8
+	// Stride is the Pix stride (in bytes) between vertically adjacent pixels.
+	Stride int
+	// Rect is the image's bounds.
+	Rect image.Rectangle
+}
+
+func (p *PremulCMYKAImg) ColorModel() color.Model { return CMYKAModel }
+
+func (p *PremulCMYKAImg) Bounds() image.Rectangle { return p.Rect }
+
+func (p *PremulCMYKAImg) At(x, y int) color.Color {
+	return p.CMYKAt(x, y)
+}
+
+// CMYKAt returns the color of the pixel at (x, y), with the C, M, Y and K
+// samples divided by alpha.
+func (p *PremulCMYKAImg) CMYKAt(x, y int) CMYKA {
+	if !(image.Point{x, y}.In(p.Rect)) {
+		return CMYKA{}
+	}
+	i := p.PixOffset(x, y)
+	s := p.Pix[i : i+5 : i+5] // Small cap improves performance, see https://golang.org/issue/27857
+	if s[4] == 0xff {
+		return CMYKA{s[0], s[1], s[2], s[3], 0xff}
+	}
+	v := [4]uint32{uint32(s[0]), uint32(s[1]), uint32(s[2]), uint32(s[3])}
+	unpremultiply(v[:], uint32(s[4]), 0xff)
+	return CMYKA{uint8(v[0]), uint8(v[1]), uint8(v[2]), uint8(v[3]), s[4]}
+}
+
+// PixOffset returns the index of the first element of Pix that corresponds to
+// the pixel at (x, y).
+func (p *PremulCMYKAImg) PixOffset(x, y int) int {
+	return pixOffset(p.Rect, p.Stride, 5, x, y)
+}
+
+func (p *PremulCMYKAImg) Set(x, y int, c color.Color) {
+	p.SetCMYKA(x, y, CMYKAModel.Convert(c).(CMYKA))
+}
+
+// SetCMYKA sets the pixel at (x, y) to c, whose C, M, Y and K samples are
+// multiplied by its alpha.
+func (p *PremulCMYKAImg) SetCMYKA(x, y int, c CMYKA) {
+	if !(image.Point{x, y}.In(p.Rect)) {
+		return
+	}
+	v := [4]uint32{uint32(c.C), uint32(c.M), uint32(c.Y), uint32(c.K)}
+	premultiply(v[:], uint32(c.A), 0xff)
+	i := p.PixOffset(x, y)
+	s := p.Pix[i : i+5 : i+5] // Small cap improves performance, see https://golang.org/issue/27857
+	s[0] = uint8(v[0])
+	s[1] = uint8(v[1])
+	s[2] = uint8(v[2])
+	s[3] = uint8(v[3])
+	s[4] = c.A
+}
+
+// SubImage returns an image representing the portion of the image p visible
+// through r. The returned value shares pixels with the original image.
+func (p *PremulCMYKAImg) SubImage(r image.Rectangle) image.Image {
+	r = r.Intersect(p.Rect)
+	// If r1 and r2 are Rectangles, r1.Intersect(r2) is not guaranteed to be inside
+	// either r1 or r2 if the intersection is empty. Without explicitly checking for
+	// this, the Pix[i:j:j] expression below can panic.
+	if r.Empty() {
+		return &PremulCMYKAImg{}
+	}
+	i, j := subPix(p.Rect, p.Stride, 5, r)
+	return &PremulCMYKAImg{
+		Pix:    p.Pix[i:j:j],
+		Stride: p.Stride,
+		Rect:   r,
+	}
+}
+
+// Opaque scans the entire image and reports whether it is fully opaque,
+// that is whether all its alpha samples are 0xff.
+func (p *PremulCMYKAImg) Opaque() bool {
+	return opaqueAlpha(p.Pix, p.Rect, p.Stride, 5, 4, 1)
+}
+
+// NewPremulCMYKA returns a new PremulCMYKAImg image with the given bounds.
+func NewPremulCMYKA(r image.Rectangle) *PremulCMYKAImg {
+	return &PremulCMYKAImg{
+		Pix:    make([]uint8, 5*r.Dx()*r.Dy()),
+		Stride: 5 * r.Dx(),
+		Rect:   r,
+	}
+}
+
+// premultiply multiplies the color samples v by the alpha value a. max is
+// the maximum value of a sample. 8-bit samples are rounded as by
+// color.NRGBA's RGBA method followed by a conversion to color.RGBA.
+func premultiply(v []uint32, a, max uint32) {
+	for i := range v {
+		if max == 0xff {
+			v[i] = v[i] * 0x101 * (a * 0x101) / 0xffff >> 8
+		} else {
+			v[i] = v[i] * a / max
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package tiff
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"reflect"
+	"testing"
+)
+
+// alphaImages returns an image with straight alpha of each color model
+// that DecodeOptions.Alpha applies to, with a range of colors and alphas.
+func alphaImages() []image.Image {
+	r := image.Rect(0, 0, 17, 5)
+	gray, gray16 := NewGrayA(r), NewGrayA16(r)
+	nrgba, nrgba64 := image.NewNRGBA(r), image.NewNRGBA64(r)
+	cmyka, cmyka64 := NewCMYKA(r), NewCMYKA64(r)
+	for y := 0; y < r.Dy(); y++ {
+		for x := 0; x < r.Dx(); x++ {
+			v, a := uint8(x*15), uint8(y*0x3f)
+			gray.SetGrayA(x, y, GrayA{v, a})
+			gray16.SetGrayA16(x, y, GrayA16{uint16(v) * 0x101, uint16(a) * 0x101})
+			nrgba.SetNRGBA(x, y, color.NRGBA{v, 0xff - v, v / 2, a})
+			nrgba64.SetNRGBA64(x, y, color.NRGBA64{uint16(v) * 0x101, 0xffff - uint16(v)*0x101, 0x1234, uint16(a) * 0x101})
+			cmyka.SetCMYKA(x, y, CMYKA{v, 0xff - v, v / 2, 0x40, a})
+			cmyka64.SetCMYKA64(x, y, CMYKA64{uint16(v) * 0x101, 0, 0x1234, 0x4000, uint16(a) * 0x101})
+		}
+	}
+	return []image.Image{gray, gray16, nrgba, nrgba64, cmyka, cmyka64}
+}
+
+func TestDecodeAlpha(t *testing.T) {
+	for _, src := range alphaImages() {
+		var buf bytes.Buffer
+		if err := Encode(&buf, src, &Options{Compression: LZW, RowsPerStrip: 2}); err != nil {
+			t.Fatal(err)
+		}
+		straight, err := DecodeWithOptions(bytes.NewReader(buf.Bytes()), &DecodeOptions{Alpha: AlphaStraight})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(straight, src) {
+			t.Errorf("%T: AlphaStraight decodes into another %T", src, straight)
+		}
+		premul, err := DecodeWithOptions(bytes.NewReader(buf.Bytes()), &DecodeOptions{Alpha: AlphaPremultiplied})
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := bytes.NewReader(buf.Bytes())
+		h, offset, err := readHeader(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d, err := newIFDDecoder(r, h, offset, &DecodeOptions{Alpha: AlphaPremultiplied})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d.config.ColorModel != premul.ColorModel() {
+			t.Errorf("%T: DecodeConfig reports another color model than that of the %T", src, premul)
+		}
+
+		var want image.Image
+		switch src := src.(type) {
+		case *GrayAImg, *image.NRGBA:
+			m := image.NewRGBA(src.Bounds())
+			for y := 0; y < src.Bounds().Dy(); y++ {
+				for x := 0; x < src.Bounds().Dx(); x++ {
+					m.Set(x, y, src.At(x, y))
+				}
+			}
+			want = m
+		case *GrayA16Img, *image.NRGBA64:
+			m := image.NewRGBA64(src.Bounds())
+			for y := 0; y < src.Bounds().Dy(); y++ {
+				for x := 0; x < src.Bounds().Dx(); x++ {
+					m.Set(x, y, src.At(x, y))
+				}
+			}
+			want = m
+		case *CMYKAImg:
+			m := NewPremulCMYKA(src.Bounds())
+			for y := 0; y < src.Bounds().Dy(); y++ {
+				for x := 0; x < src.Bounds().Dx(); x++ {
+					m.SetCMYKA(x, y, src.CMYKAt(x, y))
+				}
+			}
+			want = m
+		case *CMYKA64Img:
+			// No premultiplied 16-bit CMYK image type exists.
+			want = src
+		}
+		if !reflect.DeepEqual(premul, want) {
+			t.Errorf("%T: AlphaPremultiplied decodes into %T, which differs from the expected %T", src, premul, want)
+			continue
+		}
+
+		// Premultiplied samples are converted back to straight alpha.
+		buf.Reset()
+		if err := Encode(&buf, premul, &Options{Compression: LZW, RowsPerStrip: 2}); err != nil {
+			t.Fatal(err)
+		}
+		m, err := DecodeWithOptions(bytes.NewReader(buf.Bytes()), &DecodeOptions{Alpha: AlphaPremultiplied})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(m, premul) {
+			t.Errorf("%T: premultiplied samples are not kept as stored", premul)
+		}
+		m, err = DecodeWithOptions(bytes.NewReader(buf.Bytes()), &DecodeOptions{Alpha: AlphaStraight})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want = src
+		switch src.(type) {
+		case *GrayAImg:
+			// The gray image in an image.RGBA is encoded as RGB.
+			want = &image.NRGBA{}
+		case *GrayA16Img:
+			want = &image.NRGBA64{}
+		}
+		if reflect.TypeOf(m) != reflect.TypeOf(want) {
+			t.Errorf("%T: AlphaStraight decodes associated alpha into %T", src, m)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := Encode(&buf, image.NewNRGBA(image.Rect(0, 0, 1, 1)), nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := DecodeWithOptions(bytes.NewReader(buf.Bytes()), &DecodeOptions{Alpha: AlphaStraight + 1}); err == nil {
+		t.Error("invalid AlphaMode: got nil error")
+	}
+}
+
+func TestPremulCMYKA(t *testing.T) {
+	m := NewPremulCMYKA(image.Rect(0, 0, 4, 3))
+	m.SetCMYKA(1, 1, CMYKA{0xff, 0x80, 0, 0x40, 0x80})
+	if got, want := m.Pix[m.PixOffset(1, 1):][:5], []uint8{0x80, 0x40, 0, 0x20, 0x80}; !bytes.Equal(got, want) {
+		t.Errorf("got samples %v, want %v", got, want)
+	}
+	if got, want := m.CMYKAt(1, 1), (CMYKA{0xff, 0x7f, 0, 0x3f, 0x80}); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if m.Opaque() {
+		t.Error("got opaque image")
+	}
+	sub := m.SubImage(image.Rect(1, 1, 3, 3)).(*PremulCMYKAImg)
+	if got := sub.CMYKAt(1, 1); got != m.CMYKAt(1, 1) {
+		t.Errorf("SubImage: got %v", got)
+	}
+}
@@ -0,0 +1,109 @@
+package tiff
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// pyramidTileSize is the width and length of the tiles of a pyramid whose
+// Options do not set them.
+const pyramidTileSize = 256
+
+// Pyramid describes the reduced-resolution overviews of an image, which
+// viewers use to display it at smaller scales without reading the full
+// resolution. With Options.Pyramid, the overviews are written as pages
+// following the image, marked as reduced-resolution images by their
+// NewSubfileType, as GDAL does. They are tiled like the image; if Options
+// does not set a tile size, the image and the overviews use 256 by 256
+// tiles, so CCITTGroup4 compression and BaselineOnly cannot be used.
+type Pyramid struct {
+	// Levels is the number of overviews. Each overview is half the width
+	// and height of the preceding one, rounded up, so that the first one
+	// is 1/2 of the size of the image, the second one 1/4 and so on.
+	// Fewer overviews are written if the last one is a single pixel.
+	Levels int
+	// Resample, if not nil, returns src scaled down to width by height
+	// pixels. It is called with the preceding level for each overview. If
+	// it is nil, each pixel of an overview is the average of the 2x2
+	// pixels of the preceding level that it covers, stored in an image of
+	// the same type as the image if it is one of the image types of the
+	// standard library or this package that can be set, or in an
+	// image.RGBA64 otherwise.
+	Resample func(src image.Image, width, height int) image.Image
+}
+
+// writePyramid writes m with the options opt, followed by the overviews
+// described by opt.Pyramid.
+func (e *Encoder) writePyramid(m image.Image, opt *Options) error {
+	p := opt.Pyramid
+	if p.Levels < 0 {
+		return errors.New("tiff: negative number of pyramid levels")
+	}
+	o := *opt
+	o.Pyramid = nil
+	if o.TileWidth == 0 && o.TileLength == 0 {
+		o.TileWidth, o.TileLength = pyramidTileSize, pyramidTileSize
+	}
+	if err := e.WriteImage(m, &o); err != nil {
+		return err
+	}
+	// The overviews are written without the metadata of the image, but
+	// with its tiles and sample layout.
+	overview := reducedOptions(&o, 1)
+	overview.TileWidth, overview.TileLength = o.TileWidth, o.TileLength
+	overview.SparseFill = o.SparseFill
+	overview.WhiteIsZero, overview.Bilevel = o.WhiteIsZero, o.Bilevel
+	overview.Transformer = o.Transformer
+	resample := p.Resample
+	if resample == nil {
+		resample = halve
+	}
+	for i := 0; i < p.Levels; i++ {
+		b := m.Bounds()
+		if b.Dx() <= 1 && b.Dy() <= 1 {
+			break
+		}
+		w, h := (b.Dx()+1)/2, (b.Dy()+1)/2
+		m = resample(m, w, h)
+		if s := m.Bounds().Size(); s.X != w || s.Y != h {
+			return errors.New("tiff: Pyramid.Resample returned an image of the wrong size")
+		}
+		if err := e.WriteImage(m, overview); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// halve returns src scaled down to width by height pixels, which must be
+// half its size rounded up, by averaging each 2x2 block of pixels.
+func halve(src image.Image, width, height int) image.Image {
+	b := src.Bounds()
+	rect := image.Rect(b.Min.X, b.Min.Y, b.Min.X+width, b.Min.Y+height)
+	// Crop returns an image of the same type as src, whose pixels are
+	// overwritten below.
+	dst, ok := Crop(src, rect).(draw.Image)
+	if !ok {
+		dst = image.NewRGBA64(rect)
+	}
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			var sr, sg, sb, sa, n uint32
+			for j := b.Min.Y + 2*y; j < b.Min.Y+2*y+2 && j < b.Max.Y; j++ {
+				for i := b.Min.X + 2*x; i < b.Min.X+2*x+2 && i < b.Max.X; i++ {
+					cr, cg, cb, ca := src.At(i, j).RGBA()
+					sr, sg, sb, sa, n = sr+cr, sg+cg, sb+cb, sa+ca, n+1
+				}
+			}
+			dst.Set(rect.Min.X+x, rect.Min.Y+y, color.RGBA64{
+				uint16((sr + n/2) / n),
+				uint16((sg + n/2) / n),
+				uint16((sb + n/2) / n),
+				uint16((sa + n/2) / n),
+			})
+		}
+	}
+	return dst
+}
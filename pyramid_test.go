@@ -0,0 +1,100 @@
+package tiff
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"reflect"
+	"testing"
+)
+
+func TestEncodePyramid(t *testing.T) {
+	src := image.NewGray16(image.Rect(0, 0, 100, 60))
+	for y := 0; y < 60; y++ {
+		for x := 0; x < 100; x++ {
+			src.SetGray16(x, y, color.Gray16{uint16(x*600 + y*100)})
+		}
+	}
+	var buf bytes.Buffer
+	if err := Encode(&buf, src, &Options{Compression: LZW, Pyramid: &Pyramid{Levels: 3}}); err != nil {
+		t.Fatal(err)
+	}
+
+	var sizes [][2]uint64
+	var subfileTypes []interface{}
+	err := WalkIFDs(bytes.NewReader(buf.Bytes()), func(_ int64, tags map[uint16]Tag) error {
+		sizes = append(sizes, [2]uint64{tags[tImageWidth].Value.([]uint64)[0], tags[tImageLength].Value.([]uint64)[0]})
+		subfileTypes = append(subfileTypes, tags[tNewSubfileType].Value)
+		if got := tags[tTileWidth].Value; !reflect.DeepEqual(got, []uint64{pyramidTileSize}) {
+			t.Errorf("got TileWidth %v, want %d", got, pyramidTileSize)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := [][2]uint64{{100, 60}, {50, 30}, {25, 15}, {13, 8}}; !reflect.DeepEqual(sizes, want) {
+		t.Errorf("got sizes %v, want %v", sizes, want)
+	}
+	if want := []interface{}{nil, []uint64{1}, []uint64{1}, []uint64{1}}; !reflect.DeepEqual(subfileTypes, want) {
+		t.Errorf("got NewSubfileTypes %v, want %v", subfileTypes, want)
+	}
+
+	pages, err := DecodeAll(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	compare(t, src, pages[0])
+	// Each pixel of the first overview averages 2x2 pixels of the image,
+	// and the last pixel of the last overview the 4x4 pixels at the bottom
+	// right corner, as the overviews before it have even sizes.
+	o1 := pages[1].(*image.Gray16)
+	if got, want := o1.Gray16At(3, 2).Y, uint16(7*600+5*100-(600+100)/2); got != want {
+		t.Errorf("first overview: got %d, want %d", got, want)
+	}
+	o3 := pages[3].(*image.Gray16)
+	if got, want := o3.Gray16At(12, 7).Y, src.Gray16At(99, 59).Y-(3*600+3*100)/2; got != want {
+		t.Errorf("last overview: got %d, want %d", got, want)
+	}
+
+	// PageFilter skips the overviews.
+	pages, err = DecodeAllWithOptions(bytes.NewReader(buf.Bytes()), &DecodeOptions{
+		PageFilter: func(subfileType uint32) bool { return subfileType&1 == 0 },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pages) != 1 {
+		t.Errorf("PageFilter: got %d pages, want 1", len(pages))
+	}
+}
+
+func TestEncodePyramidLevels(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 5, 3))
+	var calls int
+	resample := func(src image.Image, width, height int) image.Image {
+		calls++
+		return image.NewRGBA(image.Rect(0, 0, width, height))
+	}
+	var buf bytes.Buffer
+	opts := &Options{TileWidth: 16, TileLength: 16, Pyramid: &Pyramid{Levels: 10, Resample: resample}}
+	if err := Encode(&buf, src, opts); err != nil {
+		t.Fatal(err)
+	}
+	// The overviews are 3x2, 2x1 and 1x1 pixels.
+	if calls != 3 {
+		t.Errorf("got %d calls of Resample, want 3", calls)
+	}
+	pages, err := DecodeAll(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pages) != 4 || pages[3].Bounds().Size() != (image.Point{1, 1}) {
+		t.Errorf("got %d pages", len(pages))
+	}
+
+	opts.Pyramid.Resample = func(src image.Image, width, height int) image.Image { return src }
+	if err := Encode(&buf, src, opts); err == nil {
+		t.Error("Resample returning the wrong size: got no error")
+	}
+}
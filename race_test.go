@@ -0,0 +1,8 @@
+//go:build race
+// +build race
+
+package tiff
+
+// raceEnabled reports whether the tests are built with the race detector,
+// with which sync.Pool drops items at random.
+const raceEnabled = true
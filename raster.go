@@ -0,0 +1,49 @@
+package tiff
+
+// A Raster holds the samples of a page as they are stored in the file,
+// decompressed and with the predictor undone but without any color
+// conversion: palette indices, inverted gray values, CMYK, YCbCr and Lab
+// samples and extra samples are returned as they are.
+type Raster struct {
+	Width, Height int
+	// SamplesPerPixel is the number of samples of each pixel, including
+	// extra samples.
+	SamplesPerPixel int
+	// BitsPerSample is the number of bits of each sample in the file.
+	BitsPerSample int
+	// SampleFormat is 1 for unsigned integer samples, 2 for signed integer
+	// samples and 3 for IEEE floating point samples.
+	SampleFormat int
+	// Pix holds the samples in row-major order, those of each pixel
+	// together, as returned by DecodeArray: the sample s of the pixel at
+	// (x, y) is at index (y*Width+x)*SamplesPerPixel+s. Its type is the
+	// narrowest of []uint8, []uint16, []uint32, []int16, []int32 and
+	// []float32 that holds the samples.
+	Pix interface{}
+}
+
+// RawRaster decodes the samples of the page into a Raster, like
+// DecodeArray. Pages whose samples cannot be represented as a Raster, such
+// as subsampled YCbCr pages, return an UnsupportedError.
+func (p *Page) RawRaster() (*Raster, error) {
+	d, err := readIFD(p.f.r, p.f.h, p.offset)
+	if err != nil {
+		return nil, err
+	}
+	pix, shape, _, err := d.decodeArray()
+	if err != nil {
+		return nil, err
+	}
+	sf := int(d.firstVal(tSampleFormat))
+	if sf == 0 {
+		sf = sfUint
+	}
+	return &Raster{
+		Width:           shape[1],
+		Height:          shape[0],
+		SamplesPerPixel: shape[2],
+		BitsPerSample:   int(d.bpp),
+		SampleFormat:    sf,
+		Pix:             pix,
+	}, nil
+}
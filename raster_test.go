@@ -0,0 +1,88 @@
+package tiff
+
+import (
+	"bytes"
+	"image"
+	"reflect"
+	"testing"
+)
+
+func TestRawRaster(t *testing.T) {
+	// CMYK samples are returned without conversion to RGB.
+	cmyk := image.NewCMYK(image.Rect(0, 0, 7, 3))
+	for i := range cmyk.Pix {
+		cmyk.Pix[i] = uint8(i * 13)
+	}
+	var buf bytes.Buffer
+	if err := Encode(&buf, cmyk, &Options{Compression: LZW, Predictor: true}); err != nil {
+		t.Fatal(err)
+	}
+	f, err := Open(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := f.Pages[0].RawRaster()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Raster{Width: 7, Height: 3, SamplesPerPixel: 4, BitsPerSample: 8, SampleFormat: sfUint, Pix: cmyk.Pix}
+	if !reflect.DeepEqual(r, want) {
+		t.Errorf("got %+v, want %+v", r, want)
+	}
+
+	// 12-bit samples, which Decode does not support, are packed MSB first.
+	const w, h = 3, 2
+	samples := []uint16{0xfff, 0x123, 0x800, 0x001, 0xabc, 0x7ff}
+	var data []byte
+	for y := 0; y < h; y++ {
+		var v, n uint
+		for _, s := range samples[y*w : (y+1)*w] {
+			v, n = v<<12|uint(s), n+12
+			for n >= 8 {
+				n -= 8
+				data = append(data, uint8(v>>n))
+			}
+		}
+		if n > 0 {
+			data = append(data, uint8(v<<(8-n)))
+		}
+	}
+	b := buildTIFF(t, testPage{data, []ifdEntry{
+		{tImageWidth, dtShort, []uint32{w}},
+		{tImageLength, dtShort, []uint32{h}},
+		{tBitsPerSample, dtShort, []uint32{12}},
+		{tPhotometricInterpretation, dtShort, []uint32{pBlackIsZero}},
+		{tStripOffsets, dtLong, []uint32{0}},
+		{tSamplesPerPixel, dtShort, []uint32{1}},
+		{tRowsPerStrip, dtShort, []uint32{h}},
+		{tStripByteCounts, dtLong, []uint32{uint32(len(data))}},
+	}})
+	if f, err = Open(bytes.NewReader(b)); err != nil {
+		t.Fatal(err)
+	}
+	if r, err = f.Pages[0].RawRaster(); err != nil {
+		t.Fatal(err)
+	}
+	if r.BitsPerSample != 12 || !reflect.DeepEqual(r.Pix, samples) {
+		t.Errorf("12-bit samples: got %d bits, %v, want %v", r.BitsPerSample, r.Pix, samples)
+	}
+
+	// Subsampled YCbCr samples cannot be returned pixel by pixel.
+	b = buildTIFF(t, testPage{make([]byte, 6), []ifdEntry{
+		{tImageWidth, dtShort, []uint32{2}},
+		{tImageLength, dtShort, []uint32{2}},
+		{tBitsPerSample, dtShort, []uint32{8, 8, 8}},
+		{tPhotometricInterpretation, dtShort, []uint32{pYCbCr}},
+		{tStripOffsets, dtLong, []uint32{0}},
+		{tSamplesPerPixel, dtShort, []uint32{3}},
+		{tRowsPerStrip, dtShort, []uint32{2}},
+		{tStripByteCounts, dtLong, []uint32{6}},
+		{tYCbCrSubSampling, dtShort, []uint32{2, 2}},
+	}})
+	if f, err = Open(bytes.NewReader(b)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Pages[0].RawRaster(); err == nil {
+		t.Error("subsampled YCbCr: got nil error")
+	}
+}
@@ -10,16 +10,22 @@
 package tiff
 
 import (
-	"compress/zlib"
+	"bytes"
+	"context"
 	"encoding/binary"
 	"fmt"
 	"image"
 	"image/color"
+	"image/draw"
+	"image/jpeg"
 	"io"
 	"io/ioutil"
 	"math"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
 
-	"github.com/hhrutter/lzw"
 	"golang.org/x/image/ccitt"
 )
 
@@ -38,21 +44,86 @@ func (e UnsupportedError) Error() string {
 	return "tiff: unsupported feature: " + string(e)
 }
 
+// A PartialError is returned together with a partially decoded image if
+// DecodeOptions.AllowPartial is set and some strips or tiles of the image
+// could not be decoded.
+type PartialError struct {
+	Blocks int   // Number of strips or tiles that could not be decoded.
+	Err    error // Error of the first of them.
+}
+
+func (e *PartialError) Error() string {
+	return fmt.Sprintf("tiff: %d strips or tiles could not be decoded: %v", e.Blocks, e.Err)
+}
+
+// Unwrap returns the error of the first block that could not be decoded.
+func (e *PartialError) Unwrap() error { return e.Err }
+
+// A CorruptionError reports a strip or tile whose uncompressed data is
+// shorter than its pixels need, such as after a damaged compressed stream,
+// whose rows would be misplaced if it were decoded. With
+// DecodeOptions.AllowPartial, such a block decodes as if all its samples
+// were zero.
+type CorruptionError struct {
+	Strip  int   // Index of the strip or tile in the StripOffsets or TileOffsets.
+	Offset int64 // Offset of its data in the file.
+	Len    int   // Number of bytes of uncompressed data.
+	Want   int   // Number of bytes that its pixels need.
+}
+
+func (e *CorruptionError) Error() string {
+	return fmt.Sprintf("tiff: strip or tile %d at offset %d holds %d bytes of pixel data, want %d", e.Strip, e.Offset, e.Len, e.Want)
+}
+
+// damage records the blocks that could not be decoded. It is shared by the
+// copies of a decoder that decode blocks concurrently.
+type damage struct {
+	mu     sync.Mutex
+	blocks int
+	err    error
+}
+
 var errNoPixels = FormatError("not enough pixel data")
 
 type decoder struct {
-	r         io.ReaderAt
-	byteOrder binary.ByteOrder
-	config    image.Config
-	mode      imageMode
-	bpp       uint
-	features  map[int][]uint
-	palette   []color.Color
+	r           io.ReaderAt
+	byteOrder   binary.ByteOrder
+	bigTIFF     bool // Whether the file is a BigTIFF file, with 64-bit offsets.
+	config      image.Config
+	mode        imageMode
+	bpp         uint
+	features    map[int][]uint
+	ascii       map[int]string
+	palette     []color.Color
+	inkNames    []string          // Names in the InkNames tag.
+	rationals   map[int][]float64 // Values of the YCbCrCoefficients and ReferenceBlackWhite tags.
+	extraTags   []Tag             // Entries that are preserved without being interpreted.
+	jpegTables  []byte            // Tables shared by the JPEG-compressed blocks, if any.
+	blobs       map[int][]byte    // Raw values of the ICCProfile, XMP and IPTC tags.
+	offset      int64             // Offset of the IFD in the file.
+	next        int64             // Offset of the next IFD, or 0 if this is the last one.
+	stretch     bool              // Whether to stretch samples to their full range.
+	workers     int               // Maximum number of blocks decoded concurrently, 0 for GOMAXPROCS.
+	autoOrient  bool              // Whether to apply the Orientation tag to the decoded image.
+	maxWidth    int               // Maximum image width, 0 for no limit.
+	maxHeight   int               // Maximum image height, 0 for no limit.
+	maxMemory   int64             // Maximum memory needed for decoding, 0 for no limit.
+	jpegMemory  int64             // Memory left for the frame of a JPEG block, 0 if not computed.
+	damage      *damage           // Blocks that could not be decoded, if partial images are allowed.
+	ctx         context.Context   // Context checked between blocks, if not nil.
+	transformer ColorTransformer  // Converter of CMYK images to RGB, if not nil.
+	alpha       AlphaMode         // Image types of images with alpha.
+	premul      bool              // Whether images with alpha are decoded with premultiplied alpha.
+	onProgress  func(done, total int64)
+	direct      image.Image // Whole image that the blocks are decoded into, for directPix.
 
-	buf   []byte
-	off   int    // Current offset in buf.
-	v     uint32 // Buffer value for reading with arbitrary bit depths.
-	nbits uint   // Remaining number of bits in v.
+	buf     []byte
+	pix     []byte    // Part of the Pix of direct that holds the current block, see directPix.
+	scratch []*[]byte // Buffers of the current block from blockBuffers.
+	off     int       // Current offset in buf.
+	v       uint32    // Buffer value for reading with arbitrary bit depths.
+	nbits   uint      // Remaining number of bits in v.
+	lsb     bool      // Whether readBits reverses the bits of each byte, see lsbFirst.
 }
 
 // firstVal returns the first uint of the features entry with the given tag,
@@ -65,30 +136,132 @@ func (d *decoder) firstVal(tag int) uint {
 	return f[0]
 }
 
-// ifdUint decodes the IFD entry in p, which must be of the Byte, Short
-// or Long type, and returns the decoded uint values.
-func (d *decoder) ifdUint(p []byte) (u []uint, err error) {
-	var raw []byte
-	if len(p) < ifdLen {
-		return nil, FormatError("bad IFD entry")
+// ifdData returns the data type, the number of values and the raw value
+// bytes of the IFD entry in p.
+func (d *decoder) ifdData(p []byte) (datatype uint16, count uint32, raw []byte, err error) {
+	if len(p) < d.entryLen() {
+		return 0, 0, nil, FormatError("bad IFD entry")
+	}
+
+	datatype = d.byteOrder.Uint16(p[2:4])
+	if dt := int(datatype); dt <= 0 || dt >= len(lengths) || lengths[dt] == 0 {
+		return 0, 0, nil, UnsupportedError("IFD entry datatype")
+	}
+
+	n := d.entryCount(p)
+	if n > uint64(math.MaxInt32/lengths[datatype]) {
+		return 0, 0, nil, FormatError("IFD data too large")
+	}
+	count = uint32(n)
+	// The value is stored in the entry if it fits into the 4 bytes (8 in
+	// BigTIFF files) after the count. Otherwise, they hold its offset.
+	value := p[8:12]
+	if d.bigTIFF {
+		value = p[12:20]
+	}
+	if datalen := lengths[datatype] * count; datalen > uint32(len(value)) {
+		raw, err = readAt(d.r, d.offsetValue(value), int64(datalen))
+	} else {
+		raw = value[:datalen]
+	}
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	return datatype, count, raw, nil
+}
+
+// entryLen returns the length of an IFD entry in bytes.
+func (d *decoder) entryLen() int {
+	if d.bigTIFF {
+		return bigIFDLen
+	}
+	return ifdLen
+}
+
+// entryCount returns the number of values of the IFD entry in p.
+func (d *decoder) entryCount(p []byte) uint64 {
+	if d.bigTIFF {
+		return d.byteOrder.Uint64(p[4:12])
 	}
+	return uint64(d.byteOrder.Uint32(p[4:8]))
+}
 
-	datatype := d.byteOrder.Uint16(p[2:4])
-	if dt := int(datatype); dt <= 0 || dt >= len(lengths) {
-		return nil, UnsupportedError("IFD entry datatype")
+// offsetValue decodes the file offset in p, which is 4 bytes long, or 8 in
+// BigTIFF files.
+func (d *decoder) offsetValue(p []byte) int64 {
+	if d.bigTIFF {
+		// Offsets beyond the range of int64 make reads fail.
+		if v := d.byteOrder.Uint64(p); v <= math.MaxInt64 {
+			return int64(v)
+		}
+		return -1
 	}
+	return int64(d.byteOrder.Uint32(p))
+}
 
-	count := d.byteOrder.Uint32(p[4:8])
-	if count > math.MaxInt32/lengths[datatype] {
-		return nil, FormatError("IFD data too large")
+// readEntries reads the entries of the IFD at offset and returns them
+// together with the offset of the next IFD. Some files end right after the
+// last entry, which is treated as the end of the IFD chain.
+func (d *decoder) readEntries(offset int64) ([]byte, int64, error) {
+	// The entries are preceded by their number, which is 2 bytes long (8
+	// in BigTIFF files), and followed by the offset of the next IFD, which
+	// is 4 bytes long (8 in BigTIFF files).
+	countLen, nextLen := 2, 4
+	if d.bigTIFF {
+		countLen, nextLen = 8, 8
+	}
+	p := make([]byte, countLen)
+	if _, err := d.r.ReadAt(p, offset); err != nil {
+		return nil, 0, err
 	}
-	if datalen := lengths[datatype] * count; datalen > 4 {
-		// The IFD contains a pointer to the real value.
-		raw = make([]byte, datalen)
-		_, err = d.r.ReadAt(raw, int64(d.byteOrder.Uint32(p[8:12])))
+	var n uint64
+	if d.bigTIFF {
+		n = d.byteOrder.Uint64(p)
 	} else {
-		raw = p[8 : 8+datalen]
+		n = uint64(d.byteOrder.Uint16(p))
 	}
+	if n > maxEntries {
+		return nil, 0, FormatError("too many IFD entries")
+	}
+
+	// All IFD entries are read in one chunk.
+	p = make([]byte, d.entryLen()*int(n))
+	if _, err := d.r.ReadAt(p, offset+int64(countLen)); err != nil {
+		return nil, 0, err
+	}
+	var next int64
+	q := make([]byte, nextLen)
+	if _, err := d.r.ReadAt(q, offset+int64(countLen+len(p))); err == nil {
+		next = d.offsetValue(q)
+	}
+	return p, next, nil
+}
+
+// ifdASCII decodes the IFD entry in p, which must be of the ASCII, Byte or
+// Undefined type, and returns the decoded string. The spec requires ASCII
+// values to end with a NUL byte, but some encoders omit it, so the string
+// ends at the first NUL byte or after all Count bytes, whichever comes first.
+func (d *decoder) ifdASCII(p []byte) (string, error) {
+	datatype, _, raw, err := d.ifdData(p)
+	if err != nil {
+		return "", err
+	}
+	switch datatype {
+	case dtASCII, dtByte, dtUndefined:
+	default:
+		return "", UnsupportedError("data type")
+	}
+	if i := bytes.IndexByte(raw, 0); i >= 0 {
+		raw = raw[:i]
+	}
+	return string(raw), nil
+}
+
+// ifdUint decodes the IFD entry in p, which must be of the Byte, Short,
+// Long, IFD or one of the 64-bit integer types, and returns the decoded uint
+// values.
+func (d *decoder) ifdUint(p []byte) (u []uint, err error) {
+	datatype, count, raw, err := d.ifdData(p)
 	if err != nil {
 		return nil, err
 	}
@@ -103,10 +276,21 @@ func (d *decoder) ifdUint(p []byte) (u []uint, err error) {
 		for i := uint32(0); i < count; i++ {
 			u[i] = uint(d.byteOrder.Uint16(raw[2*i : 2*(i+1)]))
 		}
-	case dtLong:
+	case dtLong, dtIFD:
 		for i := uint32(0); i < count; i++ {
 			u[i] = uint(d.byteOrder.Uint32(raw[4*i : 4*(i+1)]))
 		}
+	case dtLong8, dtSLong8, dtIFD8:
+		for i := uint32(0); i < count; i++ {
+			v := d.byteOrder.Uint64(raw[8*i : 8*(i+1)])
+			if datatype == dtSLong8 && int64(v) < 0 {
+				return nil, FormatError("negative IFD value")
+			}
+			if uint64(uint(v)) != v {
+				return nil, FormatError("IFD value too large")
+			}
+			u[i] = uint(v)
+		}
 	default:
 		return nil, UnsupportedError("data type")
 	}
@@ -118,8 +302,15 @@ func (d *decoder) ifdUint(p []byte) (u []uint, err error) {
 // entry and an error, if any.
 func (d *decoder) parseIFD(p []byte) (int, error) {
 	tag := d.byteOrder.Uint16(p[0:2])
+	// An entry without any values is degenerate. It is treated as if the
+	// tag were absent, so that defaults apply.
+	if d.entryCount(p) == 0 {
+		return int(tag), nil
+	}
 	switch tag {
-	case tBitsPerSample,
+	case tNewSubfileType,
+		tSubfileType,
+		tBitsPerSample,
 		tExtraSamples,
 		tPhotometricInterpretation,
 		tCompression,
@@ -134,8 +325,19 @@ func (d *decoder) parseIFD(p []byte) (int, error) {
 		tImageLength,
 		tImageWidth,
 		tFillOrder,
+		tOrientation,
+		tPlanarConfiguration,
+		tInkSet,
+		tNumberOfInks,
+		tSubIFDs,
+		tYCbCrSubSampling,
+		tYCbCrPositioning,
+		tMinSampleValue,
+		tMaxSampleValue,
 		tT4Options,
-		tT6Options:
+		tT6Options,
+		tJPEGInterchangeFormat,
+		tJPEGInterchangeFormatLength:
 		val, err := d.ifdUint(p)
 		if err != nil {
 			return 0, err
@@ -159,24 +361,91 @@ func (d *decoder) parseIFD(p []byte) (int, error) {
 				0xffff,
 			}
 		}
+	case tJPEGTables:
+		_, _, raw, err := d.ifdData(p)
+		if err != nil {
+			return 0, err
+		}
+		d.jpegTables = append([]byte(nil), raw...)
+	case tICCProfile, tXMP, tIPTC:
+		// These are opaque byte streams, whatever their data type.
+		// IPTC data in particular is often stored as Long values.
+		_, _, raw, err := d.ifdData(p)
+		if err != nil {
+			return 0, err
+		}
+		if d.blobs == nil {
+			d.blobs = make(map[int][]byte)
+		}
+		d.blobs[int(tag)] = append([]byte(nil), raw...)
+	case tYCbCrCoefficients, tReferenceBlackWhite:
+		t, err := d.ifdTag(p)
+		if err != nil {
+			return 0, err
+		}
+		v, ok := t.Value.([][2]uint32)
+		if !ok {
+			return 0, FormatError("YCbCr parameter is not rational")
+		}
+		if d.rationals == nil {
+			d.rationals = make(map[int][]float64)
+		}
+		for _, r := range v {
+			if r[1] == 0 {
+				return 0, FormatError("YCbCr parameter with zero denominator")
+			}
+			d.rationals[int(tag)] = append(d.rationals[int(tag)], float64(r[0])/float64(r[1]))
+		}
+	case tInkNames:
+		// The names are separated by NUL bytes.
+		_, _, raw, err := d.ifdData(p)
+		if err != nil {
+			return 0, err
+		}
+		d.inkNames = strings.Split(strings.TrimRight(string(raw), "\x00"), "\x00")
+	case tImageDescription, tHostComputer:
+		val, err := d.ifdASCII(p)
+		if err != nil {
+			return 0, err
+		}
+		d.ascii[int(tag)] = val
 	case tSampleFormat:
 		// Page 27 of the spec: If the SampleFormat is present and
 		// the value is not 1 [= unsigned integer data], a Baseline
 		// TIFF reader that cannot handle the SampleFormat value
-		// must terminate the import process gracefully.
+		// must terminate the import process gracefully. Signed and
+		// floating point samples are decoded for gray images only.
 		val, err := d.ifdUint(p)
 		if err != nil {
 			return 0, err
 		}
 		for _, v := range val {
-			if v != 1 {
+			if v != val[0] || v != sfUint && v != sfInt && v != sfFloat {
 				return 0, UnsupportedError("sample format")
 			}
 		}
+		d.features[int(tag)] = val
+	default:
+		if preservedTags[int(tag)] {
+			t, err := d.ifdTag(p)
+			if err != nil {
+				return 0, err
+			}
+			d.extraTags = append(d.extraTags, t)
+		}
 	}
 	return int(tag), nil
 }
 
+// preservedTags holds the tags that the decoder passes through to
+// SourceInfo.ExtraTags.
+var preservedTags = map[int]bool{
+	tClipPath:       true,
+	tXClipPathUnits: true,
+	tYClipPathUnits: true,
+	tPhotoshop:      true,
+}
+
 // readBits reads n bits from the internal buffer starting at the current offset.
 func (d *decoder) readBits(n uint) (v uint32, ok bool) {
 	for d.nbits < n {
@@ -184,7 +453,11 @@ func (d *decoder) readBits(n uint) (v uint32, ok bool) {
 		if d.off >= len(d.buf) {
 			return 0, false
 		}
-		d.v |= uint32(d.buf[d.off])
+		b := d.buf[d.off]
+		if d.lsb {
+			b = reversedBits[b]
+		}
+		d.v |= uint32(b)
 		d.off++
 		d.nbits += 8
 	}
@@ -201,6 +474,15 @@ func (d *decoder) flushBits() {
 	d.nbits = 0
 }
 
+// startRow positions d.off and the bit reader at the start of the given row
+// of a block that is width pixels of bitsPerPixel bits each wide. Rows start
+// at a byte boundary, and the rows of padded edge tiles extend past the
+// image.
+func (d *decoder) startRow(row, width int, bitsPerPixel uint) {
+	d.off = row * ((width*int(bitsPerPixel) + 7) / 8)
+	d.flushBits()
+}
+
 // minInt returns the smaller of x or y.
 func minInt(a, b int) int {
 	if a <= b {
@@ -209,48 +491,27 @@ func minInt(a, b int) int {
 	return b
 }
 
+// maxInt returns the larger of x or y.
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
 // decode decodes the raw data of an image.
 // It reads from d.buf and writes the strip or tile into dst.
 func (d *decoder) decode(dst image.Image, xmin, ymin, xmax, ymax int) error {
-	d.off = 0
-
-	// Apply horizontal predictor if necessary.
-	// In this case, p contains the color difference to the preceding pixel.
-	// See page 64-65 of the spec.
-	if d.firstVal(tPredictor) == prHorizontal {
-		switch d.bpp {
-		case 16:
-			var off int
-			n := 2 * len(d.features[tBitsPerSample]) // bytes per sample times samples per pixel
-			for y := ymin; y < ymax; y++ {
-				off += n
-				for x := 0; x < (xmax-xmin-1)*n; x += 2 {
-					if off+2 > len(d.buf) {
-						return errNoPixels
-					}
-					v0 := d.byteOrder.Uint16(d.buf[off-n : off-n+2])
-					v1 := d.byteOrder.Uint16(d.buf[off : off+2])
-					d.byteOrder.PutUint16(d.buf[off:off+2], v1+v0)
-					off += 2
-				}
-			}
-		case 8:
-			var off int
-			n := 1 * len(d.features[tBitsPerSample]) // bytes per sample times samples per pixel
-			for y := ymin; y < ymax; y++ {
-				off += n
-				for x := 0; x < (xmax-xmin-1)*n; x++ {
-					if off >= len(d.buf) {
-						return errNoPixels
-					}
-					d.buf[off] += d.buf[off-n]
-					off++
-				}
-			}
-		case 1:
-			return UnsupportedError("horizontal predictor with 1 BitsPerSample")
-		}
+	if d.transformer != nil {
+		return d.decodeTransformed(dst, xmin, ymin, xmax, ymax)
 	}
+	return d.decodeSamples(dst, xmin, ymin, xmax, ymax)
+}
+
+// decodeSamples implements decode, storing the samples in dst as they are
+// except for the conversions of alpha.
+func (d *decoder) decodeSamples(dst image.Image, xmin, ymin, xmax, ymax int) error {
+	d.off = 0
 
 	rMaxX := minInt(xmax, dst.Bounds().Max.X)
 	rMaxY := minInt(ymax, dst.Bounds().Max.Y)
@@ -259,6 +520,7 @@ func (d *decoder) decode(dst image.Image, xmin, ymin, xmax, ymax int) error {
 		if d.bpp == 16 {
 			img := dst.(*image.Gray16)
 			for y := ymin; y < rMaxY; y++ {
+				d.startRow(y-ymin, xmax-xmin, 16)
 				for x := xmin; x < rMaxX; x++ {
 					if d.off+2 > len(d.buf) {
 						return errNoPixels
@@ -270,14 +532,35 @@ func (d *decoder) decode(dst image.Image, xmin, ymin, xmax, ymax int) error {
 					}
 					img.SetGray16(x, y, color.Gray16{v})
 				}
-				if rMaxX == img.Bounds().Max.X {
-					d.off += 2 * (xmax - img.Bounds().Max.X)
+			}
+		} else if d.bpp == 1 || d.bpp == 2 || d.bpp == 4 {
+			// The samples are unpacked and then mapped to the levels
+			// of 8-bit gray.
+			img := dst.(*image.Gray)
+			max := 1<<d.bpp - 1
+			var levels [16]uint8
+			for v := 0; v <= int(max); v++ {
+				levels[v] = uint8(v * 0xff / int(max))
+				if d.mode == mGrayInvert {
+					levels[v] = 0xff - levels[v]
+				}
+			}
+			pix := img.Pix[img.PixOffset(xmin, ymin):]
+			n := rMaxX - xmin
+			if err := d.unpackRows(pix, img.Stride, n, xmax-xmin, ymin, rMaxY); err != nil {
+				return err
+			}
+			for y := 0; y < rMaxY-ymin; y++ {
+				row := pix[y*img.Stride:][:n]
+				for i, v := range row {
+					row[i] = levels[v]
 				}
 			}
 		} else {
 			img := dst.(*image.Gray)
 			max := uint32((1 << d.bpp) - 1)
 			for y := ymin; y < rMaxY; y++ {
+				d.startRow(y-ymin, xmax-xmin, d.bpp)
 				for x := xmin; x < rMaxX; x++ {
 					v, ok := d.readBits(d.bpp)
 					if !ok {
@@ -289,12 +572,99 @@ func (d *decoder) decode(dst image.Image, xmin, ymin, xmax, ymax int) error {
 					}
 					img.SetGray(x, y, color.Gray{uint8(v)})
 				}
-				d.flushBits()
+			}
+		}
+	case mGrayAlpha:
+		// Images with unassociated alpha are decoded into a GrayAImg or
+		// GrayA16Img. Otherwise each pixel's gray value is stored in all
+		// of the color channels of the destination image.
+		var pix []uint8
+		var stride int
+		grayA := false
+		switch img := dst.(type) {
+		case *image.RGBA:
+			pix, stride = img.Pix[img.PixOffset(xmin, ymin):], img.Stride
+		case *image.RGBA64:
+			pix, stride = img.Pix[img.PixOffset(xmin, ymin):], img.Stride
+		case *GrayAImg:
+			pix, stride, grayA = img.Pix[img.PixOffset(xmin, ymin):], img.Stride, true
+		case *GrayA16Img:
+			pix, stride, grayA = img.Pix[img.PixOffset(xmin, ymin):], img.Stride, true
+		}
+		invert := d.firstVal(tPhotometricInterpretation) == pWhiteIsZero
+		premultiplied := d.firstVal(tExtraSamples) == 1
+		bps := int(d.bpp / 8)
+		max := uint32(1)<<d.bpp - 1
+		for y := ymin; y < rMaxY; y++ {
+			d.startRow(y-ymin, xmax-xmin, 2*d.bpp)
+			i := (y - ymin) * stride
+			for x := xmin; x < rMaxX; x++ {
+				if d.off+2*bps > len(d.buf) {
+					return errNoPixels
+				}
+				var v, a uint32
+				if bps == 2 {
+					v = uint32(d.byteOrder.Uint16(d.buf[d.off:]))
+					a = uint32(d.byteOrder.Uint16(d.buf[d.off+2:]))
+				} else {
+					v, a = uint32(d.buf[d.off]), uint32(d.buf[d.off+1])
+				}
+				d.off += 2 * bps
+				if invert {
+					switch {
+					case !premultiplied:
+						v = max - v
+					case v <= a:
+						// The inverted gray value is premultiplied as well.
+						v = a - v
+					default:
+						v = 0
+					}
+				}
+				switch {
+				case premultiplied && grayA:
+					g := [1]uint32{v}
+					unpremultiply(g[:], a, max)
+					v = g[0]
+				case !premultiplied && !grayA:
+					g := [1]uint32{v}
+					premultiply(g[:], a, max)
+					v = g[0]
+				}
+				switch {
+				case grayA && bps == 2:
+					pix[i+0], pix[i+1] = uint8(v>>8), uint8(v)
+					pix[i+2], pix[i+3] = uint8(a>>8), uint8(a)
+					i += 4
+				case grayA:
+					pix[i+0], pix[i+1] = uint8(v), uint8(a)
+					i += 2
+				case bps == 2:
+					// The Pix of 16-bit images is in big-endian order.
+					for c := 0; c < 6; c += 2 {
+						pix[i+c] = uint8(v >> 8)
+						pix[i+c+1] = uint8(v)
+					}
+					pix[i+6], pix[i+7] = uint8(a>>8), uint8(a)
+					i += 8
+				default:
+					pix[i+0], pix[i+1], pix[i+2], pix[i+3] = uint8(v), uint8(v), uint8(v), uint8(a)
+					i += 4
+				}
 			}
 		}
 	case mPaletted:
 		img := dst.(*image.Paletted)
+		if d.bpp == 1 || d.bpp == 2 || d.bpp == 4 {
+			// Each byte is expanded to the indices of its pixels at
+			// once.
+			if err := d.unpackRows(img.Pix[img.PixOffset(xmin, ymin):], img.Stride, rMaxX-xmin, xmax-xmin, ymin, rMaxY); err != nil {
+				return err
+			}
+			break
+		}
 		for y := ymin; y < rMaxY; y++ {
+			d.startRow(y-ymin, xmax-xmin, d.bpp)
 			for x := xmin; x < rMaxX; x++ {
 				v, ok := d.readBits(d.bpp)
 				if !ok {
@@ -302,12 +672,45 @@ func (d *decoder) decode(dst image.Image, xmin, ymin, xmax, ymax int) error {
 				}
 				img.SetColorIndex(x, y, uint8(v))
 			}
-			d.flushBits()
+		}
+	case mPalettedAlpha:
+		// The colors of the palette are opaque, so they are combined
+		// with the alpha samples as straight alpha, whichever alpha the
+		// image is stored with.
+		var colors [256][3]uint32
+		for i, c := range d.palette {
+			r, g, b, _ := c.RGBA()
+			colors[i] = [3]uint32{r >> 8, g >> 8, b >> 8}
+		}
+		var pix []uint8
+		var stride int
+		switch img := dst.(type) {
+		case *image.NRGBA:
+			pix, stride = img.Pix[img.PixOffset(xmin, ymin):], img.Stride
+		case *image.RGBA:
+			pix, stride = img.Pix[img.PixOffset(xmin, ymin):], img.Stride
+		}
+		for y := ymin; y < rMaxY; y++ {
+			row := pix[(y-ymin)*stride:][:(rMaxX-xmin)*4]
+			off := (y - ymin) * (xmax - xmin) * 2
+			if off+len(row)/2 > len(d.buf) {
+				return errNoPixels
+			}
+			for i := 0; i < len(row); i += 4 {
+				v, a := colors[d.buf[off]], uint32(d.buf[off+1])
+				off += 2
+				if d.premul && a != 0xff {
+					premultiply(v[:], a, 0xff)
+				}
+				p := row[i : i+4 : i+4]
+				p[0], p[1], p[2], p[3] = uint8(v[0]), uint8(v[1]), uint8(v[2]), uint8(a)
+			}
 		}
 	case mRGB:
 		if d.bpp == 16 {
 			img := dst.(*image.RGBA64)
 			for y := ymin; y < rMaxY; y++ {
+				d.startRow(y-ymin, xmax-xmin, 48)
 				for x := xmin; x < rMaxX; x++ {
 					if d.off+6 > len(d.buf) {
 						return errNoPixels
@@ -337,65 +740,124 @@ func (d *decoder) decode(dst image.Image, xmin, ymin, xmax, ymax int) error {
 				}
 			}
 		}
-	case mNRGBA:
+	case mNRGBA, mRGBA:
+		// The alpha of the samples is converted if the destination image
+		// holds the other kind.
+		convert := (d.mode == mRGBA) != d.premul
+		var v [3]uint32
 		if d.bpp == 16 {
-			img := dst.(*image.NRGBA64)
+			var pix []uint8
+			var stride int
+			switch img := dst.(type) {
+			case *image.NRGBA64:
+				pix, stride = img.Pix[img.PixOffset(xmin, ymin):], img.Stride
+			case *image.RGBA64:
+				pix, stride = img.Pix[img.PixOffset(xmin, ymin):], img.Stride
+			}
 			for y := ymin; y < rMaxY; y++ {
+				d.startRow(y-ymin, xmax-xmin, 64)
+				i := (y - ymin) * stride
 				for x := xmin; x < rMaxX; x++ {
 					if d.off+8 > len(d.buf) {
 						return errNoPixels
 					}
-					r := d.byteOrder.Uint16(d.buf[d.off+0 : d.off+2])
-					g := d.byteOrder.Uint16(d.buf[d.off+2 : d.off+4])
-					b := d.byteOrder.Uint16(d.buf[d.off+4 : d.off+6])
-					a := d.byteOrder.Uint16(d.buf[d.off+6 : d.off+8])
+					for s := range v {
+						v[s] = uint32(d.byteOrder.Uint16(d.buf[d.off+2*s:]))
+					}
+					a := uint32(d.byteOrder.Uint16(d.buf[d.off+6:]))
 					d.off += 8
-					img.SetNRGBA64(x, y, color.NRGBA64{r, g, b, a})
+					switch {
+					case !convert || a == 0xffff:
+					case d.premul:
+						premultiply(v[:], a, 0xffff)
+					default:
+						unpremultiply(v[:], a, 0xffff)
+					}
+					// The Pix of 16-bit images is in big-endian order.
+					p := pix[i : i+8 : i+8]
+					p[0], p[1] = uint8(v[0]>>8), uint8(v[0])
+					p[2], p[3] = uint8(v[1]>>8), uint8(v[1])
+					p[4], p[5] = uint8(v[2]>>8), uint8(v[2])
+					p[6], p[7] = uint8(a>>8), uint8(a)
+					i += 8
 				}
 			}
-		} else {
-			img := dst.(*image.NRGBA)
-			for y := ymin; y < rMaxY; y++ {
-				min := img.PixOffset(xmin, y)
-				max := img.PixOffset(rMaxX, y)
-				i0, i1 := (y-ymin)*(xmax-xmin)*4, (y-ymin+1)*(xmax-xmin)*4
-				if i1 > len(d.buf) {
-					return errNoPixels
+			break
+		}
+		var pix []uint8
+		var stride int
+		switch img := dst.(type) {
+		case *image.NRGBA:
+			pix, stride = img.Pix[img.PixOffset(xmin, ymin):], img.Stride
+		case *image.RGBA:
+			pix, stride = img.Pix[img.PixOffset(xmin, ymin):], img.Stride
+		}
+		for y := ymin; y < rMaxY; y++ {
+			row := pix[(y-ymin)*stride:][:(rMaxX-xmin)*4]
+			i0, i1 := (y-ymin)*(xmax-xmin)*4, (y-ymin+1)*(xmax-xmin)*4
+			if i1 > len(d.buf) {
+				return errNoPixels
+			}
+			copy(row, d.buf[i0:i1])
+			if !convert {
+				continue
+			}
+			for i := 0; i < len(row); i += 4 {
+				p := row[i : i+4 : i+4]
+				if p[3] == 0xff {
+					continue
 				}
-				copy(img.Pix[min:max], d.buf[i0:i1])
+				v = [3]uint32{uint32(p[0]), uint32(p[1]), uint32(p[2])}
+				if d.premul {
+					premultiply(v[:], uint32(p[3]), 0xff)
+				} else {
+					unpremultiply(v[:], uint32(p[3]), 0xff)
+				}
+				p[0], p[1], p[2] = uint8(v[0]), uint8(v[1]), uint8(v[2])
 			}
 		}
-	case mRGBA:
+	case mCMYK:
 		if d.bpp == 16 {
-			img := dst.(*image.RGBA64)
+			img := dst.(*CMYKA64Img)
+			n := 2 * len(d.features[tBitsPerSample])
 			for y := ymin; y < rMaxY; y++ {
-				for x := xmin; x < rMaxX; x++ {
-					if d.off+8 > len(d.buf) {
-						return errNoPixels
+				min := img.PixOffset(xmin, y)
+				max := img.PixOffset(rMaxX, y)
+				off := (y - ymin) * (xmax - xmin) * n
+				if off+(max-min)/10*n > len(d.buf) {
+					return errNoPixels
+				}
+				for i := min; i < max; i += 10 {
+					// CMYKA64Img's Pix is in big-endian order.
+					for j := 0; j < 8; j += 2 {
+						v := d.byteOrder.Uint16(d.buf[off+j : off+j+2])
+						img.Pix[i+j+0] = uint8(v >> 8)
+						img.Pix[i+j+1] = uint8(v)
 					}
-					r := d.byteOrder.Uint16(d.buf[d.off+0 : d.off+2])
-					g := d.byteOrder.Uint16(d.buf[d.off+2 : d.off+4])
-					b := d.byteOrder.Uint16(d.buf[d.off+4 : d.off+6])
-					a := d.byteOrder.Uint16(d.buf[d.off+6 : d.off+8])
-					d.off += 8
-					img.SetRGBA64(x, y, color.RGBA64{r, g, b, a})
+					img.Pix[i+8] = 0xff
+					img.Pix[i+9] = 0xff
+					off += n
 				}
 			}
-		} else {
-			img := dst.(*image.RGBA)
+			break
+		}
+		img := dst.(*image.CMYK)
+		if n := len(d.features[tBitsPerSample]); n > 4 {
+			// Extra samples of unspecified data are skipped.
 			for y := ymin; y < rMaxY; y++ {
 				min := img.PixOffset(xmin, y)
 				max := img.PixOffset(rMaxX, y)
-				i0, i1 := (y-ymin)*(xmax-xmin)*4, (y-ymin+1)*(xmax-xmin)*4
-				if i1 > len(d.buf) {
+				off := (y - ymin) * (xmax - xmin) * n
+				if off+(max-min)/4*n > len(d.buf) {
 					return errNoPixels
 				}
-				copy(img.Pix[min:max], d.buf[i0:i1])
+				for i := min; i < max; i += 4 {
+					copy(img.Pix[i:i+4], d.buf[off:off+4])
+					off += n
+				}
 			}
+			break
 		}
-	case mCMYK:
-		// d.bpp must be 8
-		img := dst.(*image.CMYK)
 		for y := ymin; y < rMaxY; y++ {
 			min := img.PixOffset(xmin, y)
 			max := img.PixOffset(rMaxX, y)
@@ -407,87 +869,336 @@ func (d *decoder) decode(dst image.Image, xmin, ymin, xmax, ymax int) error {
 		}
 
 	case mCMYKA:
-		// d.bpp must be 8
-		img := dst.(*CMYKAImg)
+		// CMYKAImg and CMYKA64Img hold unassociated alpha and
+		// PremulCMYKAImg associated (premultiplied) alpha, so the samples
+		// are converted if they are stored with the other kind.
+		associated := d.firstVal(tExtraSamples) == 1
+		_, premul := dst.(*PremulCMYKAImg)
+		if d.bpp == 16 {
+			img := dst.(*CMYKA64Img)
+			var v [5]uint32
+			for y := ymin; y < rMaxY; y++ {
+				min := img.PixOffset(xmin, y)
+				max := img.PixOffset(rMaxX, y)
+				off := (y - ymin) * (xmax - xmin) * 10
+				for i := min; i < max; i += 10 {
+					if off+10 > len(d.buf) {
+						return errNoPixels
+					}
+					for s := range v {
+						v[s] = uint32(d.byteOrder.Uint16(d.buf[off+2*s:]))
+					}
+					off += 10
+					if associated {
+						unpremultiply(v[:4], v[4], 0xffff)
+					}
+					// CMYKA64Img's Pix is in big-endian order.
+					for s := range v {
+						img.Pix[i+2*s] = uint8(v[s] >> 8)
+						img.Pix[i+2*s+1] = uint8(v[s])
+					}
+				}
+			}
+			break
+		}
+		var pix []uint8
+		var stride int
+		switch img := dst.(type) {
+		case *CMYKAImg:
+			pix, stride = img.Pix[img.PixOffset(xmin, ymin):], img.Stride
+		case *PremulCMYKAImg:
+			pix, stride = img.Pix[img.PixOffset(xmin, ymin):], img.Stride
+		}
+		var v [4]uint32
 		for y := ymin; y < rMaxY; y++ {
-			min := img.PixOffset(xmin, y)
-			max := img.PixOffset(rMaxX, y)
+			row := pix[(y-ymin)*stride:][:(rMaxX-xmin)*5]
 			i0, i1 := (y-ymin)*(xmax-xmin)*5, (y-ymin+1)*(xmax-xmin)*5
 			if i1 > len(d.buf) {
 				return errNoPixels
 			}
-			copy(img.Pix[min:max], d.buf[i0:i1])
+			copy(row, d.buf[i0:i1])
+			if associated == premul {
+				continue
+			}
+			for i := 0; i < len(row); i += 5 {
+				p := row[i : i+5 : i+5]
+				if p[4] == 0xff {
+					continue
+				}
+				for s := range v {
+					v[s] = uint32(p[s])
+				}
+				if premul {
+					premultiply(v[:], uint32(p[4]), 0xff)
+				} else {
+					unpremultiply(v[:], uint32(p[4]), 0xff)
+				}
+				for s := range v {
+					p[s] = uint8(v[s])
+				}
+			}
 		}
-
-	}
-
-	return nil
-}
-
-func newDecoder(r io.Reader) (*decoder, error) {
-	d := &decoder{
-		r:        newReaderAt(r),
-		features: make(map[int][]uint),
-	}
-
-	p := make([]byte, 8)
-	if _, err := d.r.ReadAt(p, 0); err != nil {
-		return nil, err
-	}
-	switch string(p[0:4]) {
-	case leHeader:
-		d.byteOrder = binary.LittleEndian
-	case beHeader:
-		d.byteOrder = binary.BigEndian
-	default:
-		return nil, FormatError("malformed header")
-	}
-
-	ifdOffset := int64(d.byteOrder.Uint32(p[4:8]))
-
-	// The first two bytes contain the number of entries (12 bytes each).
-	if _, err := d.r.ReadAt(p[0:2], ifdOffset); err != nil {
-		return nil, err
-	}
-	numItems := int(d.byteOrder.Uint16(p[0:2]))
-
-	// All IFD entries are read in one chunk.
-	p = make([]byte, ifdLen*numItems)
-	if _, err := d.r.ReadAt(p, ifdOffset+2); err != nil {
-		return nil, err
-	}
-
-	prevTag := -1
-	for i := 0; i < len(p); i += ifdLen {
-		tag, err := d.parseIFD(p[i : i+ifdLen])
-		if err != nil {
-			return nil, err
+	case mRaw, mNChannel:
+		img, ok := dst.(*MultiSampleImg)
+		if !ok {
+			img = dst.(*NChannelImg).multiSample()
 		}
-		if tag <= prevTag {
-			return nil, FormatError("tags are not sorted in ascending order")
+		n := img.Samples * img.BytesPerSample
+		for y := ymin; y < rMaxY; y++ {
+			min := img.PixOffset(xmin, y)
+			max := img.PixOffset(rMaxX, y)
+			switch d.bpp {
+			case 16:
+				off := (y - ymin) * (xmax - xmin) * n
+				for i := min; i < max; i += 2 {
+					if off+2 > len(d.buf) {
+						return errNoPixels
+					}
+					// MultiSampleImg's Pix is in big-endian order.
+					v := d.byteOrder.Uint16(d.buf[off : off+2])
+					img.Pix[i+0] = uint8(v >> 8)
+					img.Pix[i+1] = uint8(v)
+					off += 2
+				}
+			case 8:
+				i0, i1 := (y-ymin)*(xmax-xmin)*n, (y-ymin)*(xmax-xmin)*n+max-min
+				if i1 > len(d.buf) {
+					return errNoPixels
+				}
+				copy(img.Pix[min:max], d.buf[i0:i1])
+			default:
+				d.startRow(y-ymin, xmax-xmin, uint(img.Samples)*d.bpp)
+				for i := min; i < max; i++ {
+					v, ok := d.readBits(d.bpp)
+					if !ok {
+						return errNoPixels
+					}
+					img.Pix[i] = uint8(v)
+				}
+			}
 		}
-		prevTag = tag
-	}
-
-	d.config.Width = int(d.firstVal(tImageWidth))
-	d.config.Height = int(d.firstVal(tImageLength))
+	case mYCbCr:
+		img := dst.(*image.RGBA)
+		sh, sv := d.subsampling()
+		conv := d.ycbcrConverter()
+		unitLen := sh*sv + 2
+		unitsAcross := (xmax - xmin + sh - 1) / sh
+		// The chroma samples of each unit apply to all its pixels.
+		for y := ymin; y < rMaxY; y++ {
+			by := y - ymin
+			row := by / sv * unitsAcross * unitLen
+			pix := img.Pix[img.PixOffset(xmin, y):]
+			for x := xmin; x < rMaxX; x++ {
+				bx := x - xmin
+				u := row + bx/sh*unitLen
+				if u+unitLen > len(d.buf) {
+					return errNoPixels
+				}
+				s := d.buf[u : u+unitLen : u+unitLen]
+				r, g, b := conv.rgb(s[by%sv*sh+bx%sh], s[sh*sv], s[sh*sv+1])
+				pix[4*bx+0], pix[4*bx+1], pix[4*bx+2], pix[4*bx+3] = r, g, b, 0xff
+			}
+		}
+	case mLab:
+		// ICCLab images store a* and b* with an offset of half their
+		// range instead of as signed values; flipping their top bit
+		// converts them to the encoding of CIELab.
+		bps := int(d.bpp / 8)
+		var flip uint32
+		if d.firstVal(tPhotometricInterpretation) == pICCLab {
+			flip = 0x80 << (8 * uint(bps-1))
+		}
+		var set func(x, y int, l, a, b uint32)
+		switch img := dst.(type) {
+		case *LabImg:
+			set = func(x, y int, l, a, b uint32) {
+				i := img.PixOffset(x, y)
+				if bps == 2 {
+					s := img.Pix[i : i+6 : i+6]
+					s[0], s[1], s[2], s[3], s[4], s[5] = uint8(l>>8), uint8(l), uint8(a>>8), uint8(a), uint8(b>>8), uint8(b)
+					return
+				}
+				s := img.Pix[i : i+3 : i+3]
+				s[0], s[1], s[2] = uint8(l), uint8(a), uint8(b)
+			}
+		case *image.RGBA64:
+			set = func(x, y int, l, a, b uint32) {
+				img.Set(x, y, labSamples(l, a, b, 2))
+			}
+		case *image.RGBA:
+			set = func(x, y int, l, a, b uint32) {
+				img.Set(x, y, labSamples(l, a, b, 1))
+			}
+		}
+		for y := ymin; y < rMaxY; y++ {
+			off := (y - ymin) * (xmax - xmin) * 3 * bps
+			for x := xmin; x < rMaxX; x++ {
+				if off+3*bps > len(d.buf) {
+					return errNoPixels
+				}
+				var v [3]uint32
+				for s := range v {
+					if bps == 2 {
+						v[s] = uint32(d.byteOrder.Uint16(d.buf[off:]))
+					} else {
+						v[s] = uint32(d.buf[off])
+					}
+					off += bps
+				}
+				set(x, y, v[0], v[1]^flip, v[2]^flip)
+			}
+		}
+	case mFloat32:
+		img := dst.(*Float32Img)
+		for y := ymin; y < rMaxY; y++ {
+			d.startRow(y-ymin, xmax-xmin, 32)
+			for x := xmin; x < rMaxX; x++ {
+				if d.off+4 > len(d.buf) {
+					return errNoPixels
+				}
+				img.SetFloat32(x, y, math.Float32frombits(d.byteOrder.Uint32(d.buf[d.off:d.off+4])))
+				d.off += 4
+			}
+		}
+	case mInt16:
+		img := dst.(*Int16Img)
+		for y := ymin; y < rMaxY; y++ {
+			d.startRow(y-ymin, xmax-xmin, 16)
+			for x := xmin; x < rMaxX; x++ {
+				if d.off+2 > len(d.buf) {
+					return errNoPixels
+				}
+				img.SetInt16(x, y, int16(d.byteOrder.Uint16(d.buf[d.off:d.off+2])))
+				d.off += 2
+			}
+		}
+	case mInt32:
+		img := dst.(*Int32Img)
+		for y := ymin; y < rMaxY; y++ {
+			d.startRow(y-ymin, xmax-xmin, 32)
+			for x := xmin; x < rMaxX; x++ {
+				if d.off+4 > len(d.buf) {
+					return errNoPixels
+				}
+				img.SetInt32(x, y, int32(d.byteOrder.Uint32(d.buf[d.off:d.off+4])))
+				d.off += 4
+			}
+		}
+	}
 
-	if _, ok := d.features[tBitsPerSample]; !ok {
-		// Default is 1 per specification.
-		d.features[tBitsPerSample] = []uint{1}
+	return nil
+}
+
+// A header describes the format of a TIFF file as given by its header.
+type header struct {
+	byteOrder binary.ByteOrder
+	bigTIFF   bool // Whether the file is a BigTIFF file, with 64-bit offsets.
+}
+
+// readHeader reads the TIFF header from r and returns the format of the
+// file and the offset of its first IFD. Both classic TIFF and BigTIFF
+// headers are accepted.
+func readHeader(r io.ReaderAt) (header, int64, error) {
+	p := make([]byte, 8)
+	if _, err := r.ReadAt(p, 0); err != nil {
+		return header{}, 0, err
+	}
+	var h header
+	switch string(p[0:4]) {
+	case leHeader:
+		h.byteOrder = binary.LittleEndian
+	case beHeader:
+		h.byteOrder = binary.BigEndian
+	case bigLEHeader:
+		h.byteOrder, h.bigTIFF = binary.LittleEndian, true
+	case bigBEHeader:
+		h.byteOrder, h.bigTIFF = binary.BigEndian, true
+	default:
+		return header{}, 0, FormatError("malformed header")
+	}
+	if !h.bigTIFF {
+		return h, int64(h.byteOrder.Uint32(p[4:8])), nil
+	}
+
+	// The BigTIFF header continues with the size of offsets, which is
+	// always 8, a reserved zero and the 8 byte offset of the first IFD.
+	if h.byteOrder.Uint16(p[4:6]) != 8 || h.byteOrder.Uint16(p[6:8]) != 0 {
+		return header{}, 0, FormatError("malformed BigTIFF header")
+	}
+	if _, err := r.ReadAt(p, 8); err != nil {
+		return header{}, 0, err
+	}
+	d := &decoder{byteOrder: h.byteOrder, bigTIFF: true}
+	return h, d.offsetValue(p), nil
+}
+
+func newDecoder(r io.Reader) (*decoder, error) {
+	ra := newReaderAt(r)
+	h, ifdOffset, err := readHeader(ra)
+	if err != nil {
+		return nil, err
+	}
+	return newIFDDecoder(ra, h, ifdOffset, nil)
+}
+
+// newIFDDecoder returns a decoder for the image described by the IFD at
+// ifdOffset in r, applying opts, which may be nil.
+func newIFDDecoder(r io.ReaderAt, h header, ifdOffset int64, opts *DecodeOptions) (*decoder, error) {
+	d, err := readIFD(r, h, ifdOffset)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.applyOptions(opts); err != nil {
+		return nil, err
+	}
+
+	if d.firstVal(tSampleFormat) == sfFloat {
+		if d.bpp != 32 || len(d.features[tBitsPerSample]) != 1 {
+			return nil, UnsupportedError("floating point samples other than single 32-bit ones")
+		}
+		d.mode = mFloat32
+		d.config.ColorModel = color.Gray16Model
+		return d, nil
+	}
+	if d.firstVal(tSampleFormat) == sfInt {
+		if d.bpp != 16 && d.bpp != 32 || len(d.features[tBitsPerSample]) != 1 {
+			return nil, UnsupportedError("signed integer samples other than single 16- or 32-bit ones")
+		}
+		d.mode = mInt16
+		if d.bpp == 32 {
+			d.mode = mInt32
+		}
+		d.config.ColorModel = color.Gray16Model
+		return d, nil
 	}
-	d.bpp = d.firstVal(tBitsPerSample)
 	switch d.bpp {
 	case 0:
 		return nil, FormatError("BitsPerSample must not be 0")
 	case 1, 8, 16:
 		// Nothing to do, these are accepted by this implementation.
+	case 2, 4:
+		// Packed samples are unpacked for gray and paletted images.
+		switch d.firstVal(tPhotometricInterpretation) {
+		case pWhiteIsZero, pBlackIsZero, pPaletted:
+			if len(d.features[tBitsPerSample]) == 1 {
+				break
+			}
+			fallthrough
+		default:
+			return nil, UnsupportedError(fmt.Sprintf("BitsPerSample of %v", d.bpp))
+		}
 	default:
 		return nil, UnsupportedError(fmt.Sprintf("BitsPerSample of %v", d.bpp))
 	}
 
 	// Determine the image mode.
-	switch d.firstVal(tPhotometricInterpretation) {
+	photometric := d.firstVal(tPhotometricInterpretation)
+	if c := d.firstVal(tCompression); photometric == pYCbCr && (c == cJPEG || c == cJPEGOld) {
+		// image/jpeg converts the YCbCr samples of JPEG data, which
+		// are subsampled as given by the JPEG stream itself, to RGB.
+		photometric = pRGB
+	}
+	switch photometric {
 	case pRGB:
 		if d.bpp == 16 {
 			for _, b := range d.features[tBitsPerSample] {
@@ -520,18 +1231,8 @@ func newDecoder(r io.Reader) (*decoder, error) {
 			switch d.firstVal(tExtraSamples) {
 			case 1:
 				d.mode = mRGBA
-				if d.bpp == 16 {
-					d.config.ColorModel = color.RGBA64Model
-				} else {
-					d.config.ColorModel = color.RGBAModel
-				}
 			case 2:
 				d.mode = mNRGBA
-				if d.bpp == 16 {
-					d.config.ColorModel = color.NRGBA64Model
-				} else {
-					d.config.ColorModel = color.NRGBAModel
-				}
 			default:
 				return nil, FormatError("wrong number of samples for RGB")
 			}
@@ -541,36 +1242,99 @@ func newDecoder(r io.Reader) (*decoder, error) {
 	case pPaletted:
 		d.mode = mPaletted
 		d.config.ColorModel = color.Palette(d.palette)
-	case pWhiteIsZero:
-		d.mode = mGrayInvert
-		if d.bpp == 16 {
-			d.config.ColorModel = color.Gray16Model
-		} else {
-			d.config.ColorModel = color.GrayModel
+		if len(d.features[tBitsPerSample]) == 2 {
+			// A palette index followed by an alpha sample.
+			for _, b := range d.features[tBitsPerSample] {
+				if b != 8 {
+					return nil, UnsupportedError("paletted with alpha and BitsPerSample other than 8")
+				}
+			}
+			switch d.firstVal(tExtraSamples) {
+			case 1, 2:
+			default:
+				return nil, FormatError("wrong number of samples for paletted")
+			}
+			d.mode = mPalettedAlpha
+		}
+	case pWhiteIsZero, pBlackIsZero:
+		if len(d.features[tBitsPerSample]) == 2 {
+			// A gray sample followed by an alpha sample.
+			if d.bpp == 1 {
+				return nil, UnsupportedError("gray with alpha and BitsPerSample of 1")
+			}
+			d.mode = mGrayAlpha
+			switch d.firstVal(tExtraSamples) {
+			case 1, 2:
+			default:
+				return nil, FormatError("wrong number of samples for gray")
+			}
+			break
+		}
+		if photometric == pWhiteIsZero {
+			d.mode = mGrayInvert
+			if d.bpp == 16 {
+				d.config.ColorModel = color.Gray16Model
+			} else {
+				d.config.ColorModel = color.GrayModel
+			}
+			break
 		}
-	case pBlackIsZero:
 		d.mode = mGray
 		if d.bpp == 16 {
 			d.config.ColorModel = color.Gray16Model
 		} else {
 			d.config.ColorModel = color.GrayModel
 		}
+	case pTransMask:
+		// A transparency mask is decoded like a BlackIsZero image, with
+		// a value of 0xff for the pixels that are part of the image.
+		if d.bpp != 1 || len(d.features[tBitsPerSample]) != 1 {
+			return nil, FormatError("transparency mask with BitsPerSample other than 1")
+		}
+		d.mode = mGray
+		d.config.ColorModel = color.GrayModel
 	case pCMYK:
-		d.mode = mCMYK
-		if d.bpp == 16 {
-			return nil, UnsupportedError(fmt.Sprintf("CMYKAImg BitsPerSample of %v", d.bpp))
+		for _, b := range d.features[tBitsPerSample] {
+			if b != d.bpp {
+				return nil, FormatError("mixed BitsPerSample for CMYK")
+			}
 		}
-		d.config.ColorModel = color.CMYKModel
-
-		switch len(d.features[tBitsPerSample]) {
+		samples := len(d.features[tBitsPerSample])
+		if inks, ok := d.features[tNumberOfInks]; ok && inks[0] != 4 || samples > 5 {
+			// Inks other than CMYK, typically spot colors.
+			if d.bpp != 8 && d.bpp != 16 {
+				return nil, UnsupportedError(fmt.Sprintf("separated image with BitsPerSample of %d", d.bpp))
+			}
+			d.mode = mNChannel
+			d.config.ColorModel = color.CMYKModel
+			if d.bpp == 16 {
+				d.config.ColorModel = CMYKA64Model
+			}
+			break
+		}
+		switch samples {
 		case 4:
 			d.mode = mCMYK
 			d.config.ColorModel = color.CMYKModel
+			if d.bpp == 16 {
+				// 16-bit CMYK is decoded into an opaque CMYKA64Img.
+				d.config.ColorModel = CMYKA64Model
+			}
 		case 5:
 			switch d.firstVal(tExtraSamples) {
-			case 1:
+			case 0:
+				// The fifth sample holds unspecified data, which is
+				// ignored. Some writers, e.g. GraphicsMagick, store
+				// an alpha channel this way.
+				d.mode = mCMYK
+				d.config.ColorModel = color.CMYKModel
+				if d.bpp == 16 {
+					d.config.ColorModel = CMYKA64Model
+				}
+			case 1, 2:
+				// The alpha is converted by decode as selected by
+				// DecodeOptions.Alpha.
 				d.mode = mCMYKA
-				d.config.ColorModel = CMYKAModel
 			default:
 				return nil, FormatError("wrong number of samples for CMYKAImg")
 			}
@@ -578,10 +1342,117 @@ func newDecoder(r io.Reader) (*decoder, error) {
 			return nil, FormatError("wrong number of samples for CMYKAImg")
 		}
 
+	case pYCbCr:
+		if len(d.features[tBitsPerSample]) != 3 {
+			return nil, UnsupportedError("YCbCr image without 3 samples per pixel")
+		}
+		for _, b := range d.features[tBitsPerSample] {
+			if b != 8 {
+				return nil, UnsupportedError("BitsPerSample for YCbCr")
+			}
+		}
+		if d.firstVal(tPlanarConfiguration) == pcPlanar {
+			return nil, UnsupportedError("planar YCbCr image")
+		}
+		h, v := d.subsampling()
+		switch {
+		case h != 1 && h != 2 && h != 4 || v != 1 && v != 2 && v != 4 || v > h:
+			return nil, FormatError("invalid YCbCrSubSampling")
+		case (h != 1 || v != 1) && d.firstVal(tPredictor) == prHorizontal:
+			return nil, UnsupportedError("predictor with subsampled YCbCr")
+		}
+		if len(d.rationals[tYCbCrCoefficients]) < 3 && d.rationals[tYCbCrCoefficients] != nil ||
+			len(d.rationals[tReferenceBlackWhite]) < 6 && d.rationals[tReferenceBlackWhite] != nil {
+			return nil, FormatError("wrong number of YCbCr parameters")
+		}
+		d.mode = mYCbCr
+		d.config.ColorModel = color.RGBAModel
+
+	case pCIELab, pICCLab:
+		if len(d.features[tBitsPerSample]) != 3 {
+			return nil, UnsupportedError("CIELab image without 3 samples per pixel")
+		}
+		for _, b := range d.features[tBitsPerSample] {
+			if b != d.bpp || b != 8 && b != 16 {
+				return nil, UnsupportedError("BitsPerSample for CIELab")
+			}
+		}
+		d.mode = mLab
+		switch {
+		case opts != nil && opts.RawLab:
+			d.config.ColorModel = LabModel
+		case d.bpp == 16:
+			d.config.ColorModel = color.RGBA64Model
+		default:
+			d.config.ColorModel = color.RGBAModel
+		}
+
 	default:
-		return nil, UnsupportedError("color model")
+		if opts == nil || !opts.RawOnUnknownPhotometric {
+			return nil, UnsupportedError("color model")
+		}
+		d.mode = mRaw
+		if d.bpp == 16 {
+			d.config.ColorModel = color.Gray16Model
+		} else {
+			d.config.ColorModel = color.GrayModel
+		}
+	}
+	if d.transformer != nil {
+		switch {
+		case d.bpp != 8 || d.mode != mCMYK && d.mode != mCMYKA:
+			// Only 8-bit CMYK samples are converted.
+			d.transformer = nil
+		case d.mode == mCMYK:
+			d.config.ColorModel = color.RGBAModel
+		}
+	}
+	d.applyAlpha()
+
+	return d, nil
+}
+
+// readIFD reads the IFD at ifdOffset in r into a new decoder, without
+// determining the image mode. It is used by DecodeArray, which does not
+// interpret the samples.
+func readIFD(r io.ReaderAt, h header, ifdOffset int64) (*decoder, error) {
+	d := &decoder{
+		r:         r,
+		byteOrder: h.byteOrder,
+		bigTIFF:   h.bigTIFF,
+		features:  make(map[int][]uint),
+		ascii:     make(map[int]string),
+		offset:    ifdOffset,
+	}
+
+	p, next, err := d.readEntries(ifdOffset)
+	if err != nil {
+		return nil, err
+	}
+	d.next = next
+
+	n := d.entryLen()
+	prevTag := -1
+	for i := 0; i < len(p); i += n {
+		tag, err := d.parseIFD(p[i : i+n])
+		if err != nil {
+			return nil, err
+		}
+		if tag <= prevTag {
+			return nil, FormatError("tags are not sorted in ascending order")
+		}
+		prevTag = tag
 	}
 
+	d.config.Width = int(d.firstVal(tImageWidth))
+	d.config.Height = int(d.firstVal(tImageLength))
+
+	if _, ok := d.features[tBitsPerSample]; !ok {
+		// Default is 1 per specification.
+		d.features[tBitsPerSample] = []uint{1}
+	}
+	d.bpp = d.firstVal(tBitsPerSample)
+	d.lsb = d.lsbFirst()
 	return d, nil
 }
 
@@ -595,173 +1466,1983 @@ func DecodeConfig(r io.Reader) (image.Config, error) {
 	return d.config, nil
 }
 
-func ccittFillOrder(tiffFillOrder uint) ccitt.Order {
-	if tiffFillOrder == 2 {
-		return ccitt.LSB
-	}
-	return ccitt.MSB
+// ConfigFull holds the color model and dimensions of a TIFF image together
+// with additional information taken from its IFD.
+type ConfigFull struct {
+	image.Config
+	// Orientation is the value of the Orientation tag, describing how the
+	// image is to be rotated or mirrored for display (p. 36-37 of the
+	// spec). Values 5 to 8 denote a rotation by 90 or 270 degrees, which
+	// swaps the displayed width and height. It is 1 if the tag is absent.
+	Orientation int
+	// Pages is the number of IFDs in the chain of IFDs of the file, which
+	// includes those of reduced-resolution images and transparency masks
+	// but not SubIFDs.
+	Pages int
+	SourceInfo
 }
 
-// Decode reads a TIFF image from r and returns it as an image.Image.
-// The type of Image returned depends on the contents of the TIFF.
-func Decode(r io.Reader) (img image.Image, err error) {
+// DecodeConfigFull returns the color model, dimensions, orientation and
+// source information of the first image of a TIFF file, and the number of
+// its pages, without decoding any pixels.
+func DecodeConfigFull(r io.Reader) (ConfigFull, error) {
 	d, err := newDecoder(r)
 	if err != nil {
-		return
+		return ConfigFull{}, err
 	}
+	return d.configFull()
+}
 
-	blockPadding := false
-	blockWidth := d.config.Width
-	blockHeight := d.config.Height
-	blocksAcross := 1
-	blocksDown := 1
+// configFull returns the ConfigFull of the decoder's image.
+func (d *decoder) configFull() (ConfigFull, error) {
+	pages, err := d.pageCount()
+	if err != nil {
+		return ConfigFull{}, err
+	}
+	c := ConfigFull{
+		Config:      d.config,
+		Orientation: int(d.firstVal(tOrientation)),
+		Pages:       pages,
+		SourceInfo:  *d.sourceInfo(),
+	}
+	if c.Orientation == 0 {
+		c.Orientation = 1
+	}
+	return c, nil
+}
 
-	if d.config.Width == 0 {
-		blocksAcross = 0
+// pageCount returns the number of IFDs in the chain that starts with the
+// decoder's IFD. Only the entry counts and the offsets of the following
+// IFDs are read.
+func (d *decoder) pageCount() (int, error) {
+	seen := map[int64]bool{d.offset: true}
+	n := 1
+	for offset := d.next; offset != 0; n++ {
+		if seen[offset] {
+			return 0, FormatError("IFD chain contains a loop")
+		}
+		seen[offset] = true
+		_, next, err := d.readEntries(offset)
+		if err != nil {
+			return 0, err
+		}
+		offset = next
 	}
-	if d.config.Height == 0 {
-		blocksDown = 0
+	return n, nil
+}
+
+// uncompressedSize returns the length in bytes of the uncompressed pixel data
+// of the decoder's image. Rows of pixels with fewer than 8 bits are padded to
+// full bytes, as in the TIFF file itself.
+func (d *decoder) uncompressedSize() int64 {
+	var bitsPerPixel int64
+	for _, b := range d.features[tBitsPerSample] {
+		bitsPerPixel += int64(b)
 	}
+	rowLen := (int64(d.config.Width)*bitsPerPixel + 7) / 8
+	return rowLen * int64(d.config.Height)
+}
 
-	var blockOffsets, blockCounts []uint
+// UncompressedSize returns the length in bytes of the uncompressed pixel data
+// of the first image in the TIFF file r, that is its width times its height
+// times the size of a pixel, without decoding the image. It can be used to
+// estimate the memory needed by Decode.
+func UncompressedSize(r io.Reader) (int64, error) {
+	d, err := newDecoder(r)
+	if err != nil {
+		return 0, err
+	}
+	return d.uncompressedSize(), nil
+}
 
-	if int(d.firstVal(tTileWidth)) != 0 {
-		blockPadding = true
+// UncompressedSizeAll is like UncompressedSize but returns the sum of the
+// sizes of all images in a multi-page TIFF file.
+func UncompressedSizeAll(r io.Reader) (int64, error) {
+	var size int64
+	err := forEachPage(newReaderAt(r), nil, func(d *decoder) error {
+		size += d.uncompressedSize()
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return size, nil
+}
 
-		blockWidth = int(d.firstVal(tTileWidth))
-		blockHeight = int(d.firstVal(tTileLength))
+// SourceInfo describes how an image is stored in a TIFF file, together with
+// its descriptive metadata.
+type SourceInfo struct {
+	// Compression is the value of the Compression tag.
+	Compression int
+	// Photometric is the value of the PhotometricInterpretation tag.
+	Photometric int
+	// BitsPerSample holds the number of bits of each sample of a pixel, as
+	// given by the BitsPerSample tag; SamplesPerPixel is its length.
+	BitsPerSample   []int
+	SamplesPerPixel int
+	// SampleFormat is the value of the SampleFormat tag: 1 for unsigned
+	// integer samples, which is the default, 2 for signed integer ones or
+	// 3 for floating point ones.
+	SampleFormat int
+	// PlanarConfig is the value of the PlanarConfiguration tag: 1 if the
+	// samples of each pixel are stored together, which is the default, or
+	// 2 if each sample is stored in its own plane.
+	PlanarConfig int
+	// Predictor is the value of the Predictor tag, or 1 (no prediction)
+	// if the tag is absent.
+	Predictor int
+	// TileWidth and TileLength are the dimensions of the tiles of a tiled
+	// image. They are zero if the image is stored in strips.
+	TileWidth, TileLength int
+	// RowsPerStrip is the number of rows in each strip except possibly the
+	// last one, or zero for a tiled image.
+	RowsPerStrip int
+	// Blocks is the number of strips or tiles of the image, counting those
+	// of each plane separately.
+	Blocks int
 
-		if blockWidth != 0 {
-			blocksAcross = (d.config.Width + blockWidth - 1) / blockWidth
-		}
-		if blockHeight != 0 {
-			blocksDown = (d.config.Height + blockHeight - 1) / blockHeight
-		}
+	// ImageDescription is the value of the ImageDescription tag.
+	ImageDescription string
+	// HostComputer is the value of the HostComputer tag, which names the
+	// computer or operating system on which the image was created.
+	HostComputer string
+	// ICCProfile is the embedded ICC profile, which defines the color
+	// space of the samples, or nil if there is none.
+	ICCProfile []byte
+	// XMP and IPTC are the XMP packet and the IPTC-NAA record of the
+	// image, or nil if there are none.
+	XMP, IPTC []byte
 
-		blockCounts = d.features[tTileByteCounts]
-		blockOffsets = d.features[tTileOffsets]
+	// Palette holds the colors of the ColorMap tag as color.RGBA64 values
+	// with all 16 bits of their entries, or is nil if there is none. It is
+	// the only palette of paletted images with an alpha sample, which are
+	// decoded into an image.NRGBA or image.RGBA.
+	Palette color.Palette
+	// ExtraTags holds the entries that the decoder does not interpret but
+	// preserves, so that they can be written back by Encode. These are the
+	// ClipPath, XClipPathUnits and YClipPathUnits tags of clipping paths
+	// and the Photoshop tag, whose value ParsePhotoshop parses.
+	ExtraTags []Tag
+}
 
-	} else {
-		if int(d.firstVal(tRowsPerStrip)) != 0 {
-			blockHeight = int(d.firstVal(tRowsPerStrip))
-		}
+// sourceInfo returns the SourceInfo of the decoder's image.
+func (d *decoder) sourceInfo() *SourceInfo {
+	s := &SourceInfo{
+		Compression: int(d.firstVal(tCompression)),
+		Photometric: int(d.firstVal(tPhotometricInterpretation)),
+		Predictor:   int(d.firstVal(tPredictor)),
+		TileWidth:   int(d.firstVal(tTileWidth)),
+		TileLength:  int(d.firstVal(tTileLength)),
+
+		SampleFormat: int(d.firstVal(tSampleFormat)),
+		PlanarConfig: int(d.firstVal(tPlanarConfiguration)),
 
-		if blockHeight != 0 {
-			blocksDown = (d.config.Height + blockHeight - 1) / blockHeight
+		ImageDescription: d.ascii[tImageDescription],
+		HostComputer:     d.ascii[tHostComputer],
+		ICCProfile:       d.blobs[tICCProfile],
+		XMP:              d.blobs[tXMP],
+		IPTC:             d.blobs[tIPTC],
+
+		ExtraTags: d.extraTags,
+	}
+	if d.palette != nil {
+		s.Palette = color.Palette(d.palette)
+	}
+	if s.Compression == 0 {
+		s.Compression = cNone
+	}
+	if s.Predictor == 0 {
+		s.Predictor = prNone
+	}
+	for _, b := range d.features[tBitsPerSample] {
+		s.BitsPerSample = append(s.BitsPerSample, int(b))
+	}
+	s.SamplesPerPixel = len(s.BitsPerSample)
+	if s.SampleFormat == 0 {
+		s.SampleFormat = sfUint
+	}
+	if s.PlanarConfig == 0 {
+		s.PlanarConfig = pcChunky
+	}
+	if s.TileWidth == 0 {
+		s.RowsPerStrip = int(d.firstVal(tRowsPerStrip))
+		if s.RowsPerStrip == 0 || s.RowsPerStrip > d.config.Height {
+			s.RowsPerStrip = d.config.Height
 		}
+		s.Blocks = len(d.features[tStripOffsets])
+	} else {
+		s.Blocks = len(d.features[tTileOffsets])
+	}
+	return s
+}
 
-		blockOffsets = d.features[tStripOffsets]
-		blockCounts = d.features[tStripByteCounts]
+// Options returns encoding options that store an image the way described by
+// s, as far as Encode supports it, including its ExtraTags. Registered
+// codecs that can encode are kept; compression schemes that Encode cannot
+// write are replaced by LZW, and tile dimensions that are not multiples of
+// 16 are replaced by a single strip. Gray images keep their photometric
+// interpretation, bilevel ones their bit depth of 1 and images stored in
+// strips their RowsPerStrip.
+func (s *SourceInfo) Options() *Options {
+	opt := &Options{
+		Predictor:        s.Predictor == prHorizontal,
+		HostComputer:     s.HostComputer,
+		ImageDescription: s.ImageDescription,
+		ICCProfile:       s.ICCProfile,
+		XMP:              s.XMP,
+		IPTC:             s.IPTC,
+		ExtraTags:        s.ExtraTags,
+		WhiteIsZero:      s.Photometric == pWhiteIsZero,
+	}
+	if (s.Photometric == pWhiteIsZero || s.Photometric == pBlackIsZero) && len(s.BitsPerSample) == 1 && s.BitsPerSample[0] == 1 {
+		opt.Bilevel = true
+	}
+	switch s.Compression {
+	case cNone:
+		opt.Compression = Uncompressed
+	case cDeflate, cDeflateOld:
+		opt.Compression = Deflate
+	case cPackBits:
+		opt.Compression = PackBits
+	case cG4:
+		opt.Compression = CCITTGroup4
+	default:
+		opt.Compression = LZW
+		if c, ok := registeredCodec(uint32(s.Compression)); ok && c.enc != nil {
+			opt.Compression = RegisteredCompression(uint16(s.Compression))
+		}
+	}
+	// CCITTGroup4 compressed images cannot be tiled.
+	if opt.Compression != CCITTGroup4 && s.TileWidth > 0 && s.TileLength > 0 && s.TileWidth%16 == 0 && s.TileLength%16 == 0 {
+		opt.TileWidth, opt.TileLength = s.TileWidth, s.TileLength
+	} else if s.TileWidth == 0 {
+		opt.RowsPerStrip = s.RowsPerStrip
 	}
+	return opt
+}
 
-	// Check if we have the right number of strips/tiles, offsets and counts.
-	if n := blocksAcross * blocksDown; len(blockOffsets) < n || len(blockCounts) < n {
-		return nil, FormatError("inconsistent header")
+// reversedBits maps a byte to the byte with its bits in reverse order.
+var reversedBits = func() (t [256]uint8) {
+	for b := range t {
+		for i := uint(0); i < 8; i++ {
+			t[b] |= uint8(b>>i&1) << (7 - i)
+		}
 	}
+	return t
+}()
 
-	imgRect := image.Rect(0, 0, d.config.Width, d.config.Height)
-	switch d.mode {
-	case mGray, mGrayInvert:
-		if d.bpp == 16 {
-			img = image.NewGray16(imgRect)
-		} else {
-			img = image.NewGray(imgRect)
+// packedSamples maps a byte of samples of 1, 2 or 4 bits (index 0, 1 or 2)
+// to the values of its 8, 4 or 2 samples, for a FillOrder of 1 (index 0)
+// or 2 (index 1). With a FillOrder of 2, the bits of each byte are in
+// reverse order, as in libtiff.
+var packedSamples = func() (t [3][2][256][8]uint8) {
+	for k := uint(0); k < 3; k++ {
+		bits := uint(1) << k
+		mask := uint8(1)<<bits - 1
+		for b := 0; b < 256; b++ {
+			rev := reversedBits[b]
+			for i := uint(0); i < 8/bits; i++ {
+				shift := 8 - bits*(i+1)
+				t[k][0][b][i] = uint8(b) >> shift & mask
+				t[k][1][b][i] = rev >> shift & mask
+			}
 		}
-	case mPaletted:
-		img = image.NewPaletted(imgRect, d.palette)
-	case mNRGBA:
-		if d.bpp == 16 {
-			img = image.NewNRGBA64(imgRect)
-		} else {
-			img = image.NewNRGBA(imgRect)
+	}
+	return t
+}()
+
+// unpackRows stores the samples of the rows ymin to ymax-1 of the block in
+// d.buf, whose rows are width samples of d.bpp bits each wide, in dst, one
+// sample per byte. Only the first n samples of each row are stored; row y
+// starts at dst[(y-ymin)*stride]. d.bpp must be 1, 2 or 4.
+func (d *decoder) unpackRows(dst []uint8, stride, n, width, ymin, ymax int) error {
+	k := 0
+	for 1<<uint(k) < d.bpp {
+		k++
+	}
+	order := 0
+	if d.lsbFirst() {
+		order = 1
+	}
+	table := &packedSamples[k][order]
+	perByte := 8 / int(d.bpp)
+	rowLen := (width + perByte - 1) / perByte
+	for y := ymin; y < ymax; y++ {
+		off := (y - ymin) * rowLen
+		if off+(n+perByte-1)/perByte > len(d.buf) {
+			return errNoPixels
 		}
-	case mRGB, mRGBA:
-		if d.bpp == 16 {
-			img = image.NewRGBA64(imgRect)
-		} else {
-			img = image.NewRGBA(imgRect)
+		pix := dst[(y-ymin)*stride:][:n]
+		for x := 0; x < n; x += perByte {
+			copy(pix[x:], table[d.buf[off+x/perByte]][:perByte])
 		}
-	case mCMYK:
-		img = image.NewCMYK(imgRect)
+	}
+	return nil
+}
 
-	case mCMYKA:
-		img = NewCMYKA(imgRect)
-	}
-
-	for i := 0; i < blocksAcross; i++ {
-		blkW := blockWidth
-		if !blockPadding && i == blocksAcross-1 && d.config.Width%blockWidth != 0 {
-			blkW = d.config.Width % blockWidth
-		}
-		for j := 0; j < blocksDown; j++ {
-			blkH := blockHeight
-			if !blockPadding && j == blocksDown-1 && d.config.Height%blockHeight != 0 {
-				blkH = d.config.Height % blockHeight
-			}
-			offset := int64(blockOffsets[j*blocksAcross+i])
-			n := int64(blockCounts[j*blocksAcross+i])
-			// LSBToMSB := d.firstVal(tFillOrder) == 2
-			// order := ccitt.MSB
-			// if LSBToMSB {
-			// 	order = ccitt.LSB
-			// }
-			switch d.firstVal(tCompression) {
-
-			// According to the spec, Compression does not have a default value,
-			// but some tools interpret a missing Compression value as none so we do
-			// the same.
-			case cNone, 0:
-				if b, ok := d.r.(*buffer); ok {
-					d.buf, err = b.Slice(int(offset), int(n))
-				} else {
-					d.buf = make([]byte, n)
-					_, err = d.r.ReadAt(d.buf, offset)
-				}
-			case cG3:
-				inv := d.firstVal(tPhotometricInterpretation) == pWhiteIsZero
-				order := ccittFillOrder(d.firstVal(tFillOrder))
-				r := ccitt.NewReader(io.NewSectionReader(d.r, offset, n), order, ccitt.Group3, blkW, blkH, &ccitt.Options{Invert: inv, Align: false})
-				d.buf, err = ioutil.ReadAll(r)
-			case cG4:
-				inv := d.firstVal(tPhotometricInterpretation) == pWhiteIsZero
-				order := ccittFillOrder(d.firstVal(tFillOrder))
-				r := ccitt.NewReader(io.NewSectionReader(d.r, offset, n), order, ccitt.Group4, blkW, blkH, &ccitt.Options{Invert: inv, Align: false})
-				d.buf, err = ioutil.ReadAll(r)
-			case cLZW:
-				r := lzw.NewReader(io.NewSectionReader(d.r, offset, n), true)
-				d.buf, err = ioutil.ReadAll(r)
-				r.Close()
-			case cDeflate, cDeflateOld:
-				var r io.ReadCloser
-				r, err = zlib.NewReader(io.NewSectionReader(d.r, offset, n))
-				if err != nil {
-					return nil, err
-				}
-				d.buf, err = ioutil.ReadAll(r)
-				r.Close()
-			case cPackBits:
-				d.buf, err = unpackBits(io.NewSectionReader(d.r, offset, n))
-			default:
-				err = UnsupportedError(fmt.Sprintf("compression value %d", d.firstVal(tCompression)))
-			}
+// lsbFirst reports whether the bits of the uncompressed data are stored with
+// the least significant bit of each byte first, as indicated by a FillOrder
+// of 2 (p. 32 of the spec). The CCITT decoders take the fill order into
+// account themselves.
+func (d *decoder) lsbFirst() bool {
+	switch d.firstVal(tCompression) {
+	case cG3, cG4:
+		return false
+	}
+	return d.firstVal(tFillOrder) == 2
+}
+
+func ccittFillOrder(tiffFillOrder uint) ccitt.Order {
+	if tiffFillOrder == 2 {
+		return ccitt.LSB
+	}
+	return ccitt.MSB
+}
+
+// blockLen returns the length in bytes of the uncompressed data of a strip
+// or tile that is blkW pixels wide and blkH pixels high, with the given
+// number of samples per pixel.
+func (d *decoder) blockLen(blkW, blkH, samples int) int {
+	if d.mode == mYCbCr {
+		// The samples are stored in units of h×v pixels, which hold
+		// their luma samples followed by one Cb and one Cr sample.
+		h, v := d.subsampling()
+		return (blkW + h - 1) / h * ((blkH + v - 1) / v) * (h*v + 2)
+	}
+	bitsPerRow := blkW * int(d.bpp) * samples
+	return (bitsPerRow + 7) / 8 * blkH
+}
+
+// blockData returns the uncompressed data of the strip or tile k of n bytes
+// at offset, with the predictor undone. The block is blkW pixels wide and
+// blkH pixels high, with the given number of samples per pixel. A
+// *CorruptionError is returned if the data is too short for the block.
+func (d *decoder) blockData(k int, offset, n int64, blkW, blkH, samples int) ([]byte, error) {
+	if n == 0 {
+		// A block with a zero byte count occupies no space in the
+		// file. GDAL writes such sparse blocks for regions that hold
+		// no data; they decode as if all their samples were zero.
+		return make([]byte, d.blockLen(blkW, blkH, samples)), nil
+	}
+	buf, err := d.readBlock(offset, n, blkW, blkH, samples)
+	if err != nil {
+		return nil, err
+	}
+	// Data beyond the block, which some writers pad strips with, is
+	// ignored, but the rows of a short block cannot be placed reliably.
+	if want := d.blockLen(blkW, blkH, samples); len(buf) < want {
+		return nil, &CorruptionError{Strip: k, Offset: offset, Len: len(buf), Want: want}
+	}
+	switch d.firstVal(tPredictor) {
+	case prHorizontal:
+		if err := d.unpredict(buf, blkW, blkH, samples); err != nil {
+			return nil, err
+		}
+	case prFloatingPoint:
+		if err := d.unpredictFloat(buf, blkW, blkH, samples); err != nil {
+			return nil, err
+		}
+	}
+	return buf, nil
+}
+
+// unpredict undoes the horizontal predictor in buf, which holds rows of width
+// pixels with the given number of samples per pixel. The predictor stores
+// the difference of each sample to the corresponding sample of the preceding
+// pixel (p. 64-65 of the spec).
+func (d *decoder) unpredict(buf []byte, width, height, samples int) error {
+	switch d.bpp {
+	case 16:
+		var off int
+		n := 2 * samples // bytes per sample times samples per pixel
+		for y := 0; y < height; y++ {
+			off += n
+			for x := 0; x < (width-1)*n; x += 2 {
+				if off+2 > len(buf) {
+					return errNoPixels
+				}
+				v0 := d.byteOrder.Uint16(buf[off-n : off-n+2])
+				v1 := d.byteOrder.Uint16(buf[off : off+2])
+				d.byteOrder.PutUint16(buf[off:off+2], v1+v0)
+				off += 2
+			}
+		}
+	case 32:
+		var off int
+		n := 4 * samples // bytes per sample times samples per pixel
+		for y := 0; y < height; y++ {
+			off += n
+			for x := 0; x < (width-1)*n; x += 4 {
+				if off+4 > len(buf) {
+					return errNoPixels
+				}
+				v0 := d.byteOrder.Uint32(buf[off-n : off-n+4])
+				v1 := d.byteOrder.Uint32(buf[off : off+4])
+				d.byteOrder.PutUint32(buf[off:off+4], v1+v0)
+				off += 4
+			}
+		}
+	case 8:
+		var off int
+		n := 1 * samples // bytes per sample times samples per pixel
+		for y := 0; y < height; y++ {
+			off += n
+			for x := 0; x < (width-1)*n; x++ {
+				if off >= len(buf) {
+					return errNoPixels
+				}
+				buf[off] += buf[off-n]
+				off++
+			}
+		}
+	case 1:
+		return UnsupportedError("horizontal predictor with 1 BitsPerSample")
+	}
+	return nil
+}
+
+// unpredictFloat undoes the floating point predictor in buf, which holds
+// rows of width pixels with the given number of samples per pixel. The
+// predictor splits each row into byte planes, the most significant bytes of
+// all samples first, and stores the difference of each byte to the byte
+// one pixel before it (Adobe's TIFF Technical Note 3). The samples are
+// written back in the byte order of the file.
+func (d *decoder) unpredictFloat(buf []byte, width, height, samples int) error {
+	var bps int
+	switch d.bpp {
+	case 16, 32, 64:
+		bps = int(d.bpp / 8)
+	default:
+		return UnsupportedError(fmt.Sprintf("floating point predictor with %d BitsPerSample", d.bpp))
+	}
+	wc := width * samples // samples per row
+	n := wc * bps         // bytes per row
+	if height*n > len(buf) {
+		return errNoPixels
+	}
+	tmp := make([]byte, n)
+	for y := 0; y < height; y++ {
+		row := buf[y*n : (y+1)*n]
+		for i := samples; i < n; i++ {
+			row[i] += row[i-samples]
+		}
+		copy(tmp, row)
+		for i := 0; i < wc; i++ {
+			var v uint64
+			for b := 0; b < bps; b++ {
+				v = v<<8 | uint64(tmp[b*wc+i])
+			}
+			switch bps {
+			case 2:
+				d.byteOrder.PutUint16(row[i*2:], uint16(v))
+			case 4:
+				d.byteOrder.PutUint32(row[i*4:], uint32(v))
+			default:
+				d.byteOrder.PutUint64(row[i*8:], v)
+			}
+		}
+	}
+	return nil
+}
+
+// interleave merges the planes of a block stored with PlanarConfiguration 2,
+// each holding n samples of bps bytes, into a buffer in which the samples of
+// each pixel are stored contiguously.
+func interleave(planes [][]byte, n, bps int) ([]byte, error) {
+	spp := len(planes)
+	buf := make([]byte, n*spp*bps)
+	for p, plane := range planes {
+		if len(plane) < n*bps {
+			return nil, errNoPixels
+		}
+		for i := 0; i < n; i++ {
+			copy(buf[(i*spp+p)*bps:(i*spp+p+1)*bps], plane[i*bps:(i+1)*bps])
+		}
+	}
+	return buf, nil
+}
+
+// readBlock reads the n bytes of compressed strip or tile data at offset
+// and returns the uncompressed data. blkW and blkH are the dimensions of
+// the block in pixels, which have the given number of samples each.
+func (d *decoder) readBlock(offset, n int64, blkW, blkH, samples int) (buf []byte, err error) {
+	size := d.blockLen(blkW, blkH, samples)
+	switch d.firstVal(tCompression) {
+
+	// According to the spec, Compression does not have a default value,
+	// but some tools interpret a missing Compression value as none so we do
+	// the same.
+	case cNone, 0:
+		if b, ok := d.r.(*buffer); ok && d.pix == nil {
+			buf, err = b.Slice(int(offset), int(n))
+			break
+		}
+		br := newBlockReader(d.r, offset, n)
+		buf, err = d.readAll(br, size)
+		br.release()
+		if err == nil && int64(len(buf)) < n && len(buf) < size {
+			err = io.ErrUnexpectedEOF
+		}
+	case cG3:
+		inv := d.firstVal(tPhotometricInterpretation) == pWhiteIsZero
+		order := ccittFillOrder(d.firstVal(tFillOrder))
+		r := ccitt.NewReader(io.NewSectionReader(d.r, offset, n), order, ccitt.Group3, blkW, blkH, &ccitt.Options{Invert: inv, Align: false})
+		buf, err = ioutil.ReadAll(r)
+	case cG4:
+		inv := d.firstVal(tPhotometricInterpretation) == pWhiteIsZero
+		order := ccittFillOrder(d.firstVal(tFillOrder))
+		r := ccitt.NewReader(io.NewSectionReader(d.r, offset, n), order, ccitt.Group4, blkW, blkH, &ccitt.Options{Invert: inv, Align: false})
+		buf, err = ioutil.ReadAll(r)
+	case cLZW:
+		br := newBlockReader(d.r, offset, n)
+		r := newLZWReader(br)
+		buf, err = d.readAll(r, size)
+		releaseLZWReader(r)
+		br.release()
+	case cDeflate, cDeflateOld:
+		br := newBlockReader(d.r, offset, n)
+		var r io.ReadCloser
+		if r, err = newZlibReader(br); err == nil {
+			buf, err = d.readAll(r, size)
+			releaseZlibReader(r)
+		}
+		br.release()
+	case cPackBits:
+		br := newBlockReader(d.r, offset, n)
+		dst := d.pix
+		if dst == nil {
+			dst = *d.scratchBuffer()
+		}
+		buf, err = unpackBits(br, dst[:0], size)
+		if d.pix == nil && buf != nil {
+			*d.scratch[len(d.scratch)-1] = buf
+		}
+		br.release()
+	case cJPEG, cJPEGOld:
+		buf, err = d.readJPEG(offset, n, blkW, blkH)
+	default:
+		c, ok := registeredCodec(uint32(d.firstVal(tCompression)))
+		if !ok {
+			return nil, UnsupportedError(fmt.Sprintf("compression value %d", d.firstVal(tCompression)))
+		}
+		br := newBlockReader(d.r, offset, n)
+		r := c.dec(br)
+		buf, err = d.readAll(r, size)
+		if closer, ok := r.(io.Closer); ok {
+			closer.Close()
+		}
+		br.release()
+	}
+	return buf, err
+}
+
+// readAll reads the decompressed data of a block of size bytes from r until
+// EOF or until size bytes are read, so that data that decompresses to much
+// more than the block does not use memory. The data is read into d.pix if
+// it is not nil, and into a scratch buffer otherwise, which grows as the
+// data arrives.
+func (d *decoder) readAll(r io.Reader, size int) ([]byte, error) {
+	if d.pix != nil {
+		n, err := io.ReadFull(r, d.pix[:size])
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = nil
+		}
+		return d.pix[:n], err
+	}
+	p := d.scratchBuffer()
+	buf := (*p)[:0]
+	if cap(buf) == 0 {
+		buf = make([]byte, 0, minInt(size, 512))
+	}
+	for len(buf) < size {
+		if len(buf) == cap(buf) {
+			buf = append(buf, 0)[:len(buf)]
+		}
+		n, err := r.Read(buf[len(buf):minInt(cap(buf), size)])
+		buf = buf[:len(buf)+n]
+		if err != nil {
+			*p = buf
+			if err == io.EOF {
+				err = nil
+			}
+			return buf, err
+		}
+	}
+	*p = buf
+	return buf, nil
+}
+
+// readJPEG reads the n bytes of JPEG data of a block at offset and returns
+// its samples, with the samples of each pixel stored contiguously. blkW and
+// blkH are the dimensions of the block in pixels.
+func (d *decoder) readJPEG(offset, n int64, blkW, blkH int) ([]byte, error) {
+	if d.bpp != 8 {
+		return nil, UnsupportedError(fmt.Sprintf("JPEG compression with BitsPerSample of %v", d.bpp))
+	}
+	data, err := readAt(d.r, offset, n)
+	if err != nil {
+		return nil, err
+	}
+	// The JPEGTables entry holds the tables shared by all blocks as an
+	// abbreviated JPEG stream, which ends with an EOI marker. The stream
+	// of a block, which starts with an SOI marker, continues it.
+	if t := d.jpegTables; len(t) >= 4 && len(data) >= 2 {
+		data = append(append(make([]byte, 0, len(t)+len(data)), t[:len(t)-2]...), data[2:]...)
+	}
+	if err := d.checkJPEGFrame(data, blkW, blkH); err != nil {
+		return nil, err
+	}
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	samples := len(d.features[tBitsPerSample])
+	buf := make([]byte, blkW*blkH*samples)
+	bounds := img.Bounds()
+	w, h := minInt(bounds.Dx(), blkW), minInt(bounds.Dy(), blkH)
+	switch m := img.(type) {
+	case *image.Gray:
+		if samples != 1 {
+			return nil, FormatError("JPEG data does not match SamplesPerPixel")
+		}
+		for y := 0; y < h; y++ {
+			copy(buf[y*blkW:y*blkW+w], m.Pix[y*m.Stride:])
+		}
+	case *image.CMYK:
+		if samples != 4 {
+			return nil, FormatError("JPEG data does not match SamplesPerPixel")
+		}
+		for y := 0; y < h; y++ {
+			copy(buf[4*y*blkW:4*(y*blkW+w)], m.Pix[y*m.Stride:])
+		}
+	default:
+		if samples != 3 {
+			return nil, FormatError("JPEG data does not match SamplesPerPixel")
+		}
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				r, g, b, _ := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+				i := 3 * (y*blkW + x)
+				buf[i+0], buf[i+1], buf[i+2] = uint8(r>>8), uint8(g>>8), uint8(b>>8)
+			}
+		}
+	}
+	return buf, nil
+}
+
+// checkJPEGFrame returns an error if the frame of the JPEG stream data of a
+// block of blkW by blkH pixels is larger than the block, rounded up to
+// whole 16 by 16 MCUs, or needs more memory than is left by
+// DecodeOptions.MaxMemory, so that the size given by the frame header
+// cannot make jpeg.Decode allocate more than the TIFF file calls for.
+func (d *decoder) checkJPEGFrame(data []byte, blkW, blkH int) error {
+	c, err := jpeg.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	if c.Width > (blkW+15)/16*16 || c.Height > (blkH+15)/16*16 {
+		return FormatError(fmt.Sprintf("JPEG frame of %dx%d pixels in a block of %dx%d", c.Width, c.Height, blkW, blkH))
+	}
+	if d.maxMemory == 0 {
+		return nil
+	}
+	budget := d.jpegMemory
+	if budget == 0 {
+		budget = d.maxMemory
+	}
+	components := int64(3)
+	switch c.ColorModel {
+	case color.GrayModel:
+		components = 1
+	case color.CMYKModel:
+		components = 4
+	}
+	if need := int64(c.Width) * int64(c.Height) * components; need > budget {
+		return fmt.Errorf("tiff: decoding a JPEG frame of %dx%d pixels needs about %d bytes, more than the %d left", c.Width, c.Height, need, budget)
+	}
+	return nil
+}
+
+// Decode reads a TIFF image from r and returns it as an image.Image.
+// The type of Image returned depends on the contents of the TIFF.
+// If r is an io.ReaderAt, such as an *os.File or a *bytes.Reader, only the
+// IFD and the strips or tiles of the image are read from it; otherwise r is
+// buffered in memory up to the end of the data that is needed.
+func Decode(r io.Reader) (img image.Image, err error) {
+	d, err := newDecoder(r)
+	if err != nil {
+		return
+	}
+	return d.decodeImage()
+}
+
+// DecodeOptions are the decoding parameters.
+type DecodeOptions struct {
+	// ForcePredictor, if not zero, overrides the Predictor tag of the
+	// image: 1 means no predictor, 2 the horizontal predictor and 3 the
+	// floating point predictor. Some encoders apply the horizontal
+	// predictor but store a Predictor of 1, so that the image decodes with
+	// visible horizontal smearing. Such files cannot be told apart from
+	// correct ones reliably, so this package does not detect them; set
+	// ForcePredictor to 2 to read them.
+	ForcePredictor int
+	// PageFilter, if not nil, is called by DecodeAllWithOptions with the
+	// NewSubfileType value of each page; pages for which it returns false
+	// are skipped without being examined further, so they need not be
+	// supported by this package. The value is a bit mask: bit 0 (1) marks
+	// a reduced resolution version of another page, such as a thumbnail,
+	// bit 1 (2) a single page of a multi-page document and bit 2 (4) a
+	// transparency mask. Pages without a NewSubfileType tag have the value
+	// 0, unless they have an old SubfileType tag, which is translated.
+	PageFilter func(subfileType uint32) bool
+	// RawOnUnknownPhotometric makes images with a PhotometricInterpretation
+	// that this package does not handle, such as LogLuv or CFA, decode
+	// into a MultiSampleImg holding their samples as stored, instead of
+	// failing with an UnsupportedError. The samples are not interpreted.
+	RawOnUnknownPhotometric bool
+	// RawLab makes images with a PhotometricInterpretation of CIELab or
+	// ICCLab decode into a LabImg holding their samples, instead of being
+	// converted to sRGB in an image.RGBA or image.RGBA64.
+	RawLab bool
+	// StretchToRange makes the decoder map 8 and 16 bit samples linearly
+	// from the range given by the MinSampleValue and MaxSampleValue tags to
+	// the full range of their bit depth, for images whose samples do not
+	// use the full range. Samples outside the range are clamped. It has no
+	// effect on paletted images and images without these tags.
+	StretchToRange bool
+	// Workers is the maximum number of strips or tiles that are
+	// decompressed concurrently. If it is zero, runtime.GOMAXPROCS(0) is
+	// used; 1 decodes the blocks one after the other.
+	Workers int
+	// AutoOrient makes the decoder apply the Orientation tag of an image
+	// with Orient, so that it is returned the way it is to be displayed.
+	// The tag itself is still reported by DecodeConfigFull and
+	// DecodeWithMetadata, and DecodeConfig reports the dimensions as
+	// stored, while image.DecodeConfig reports the displayed ones if
+	// AutoOrient is set with SetDefaultDecodeOptions.
+	AutoOrient bool
+	// MaxWidth and MaxHeight, if not zero, are the largest image
+	// dimensions that are decoded; larger images fail with an error
+	// before any pixel data is read.
+	MaxWidth, MaxHeight int
+	// MaxMemory, if not zero, limits the memory in bytes that decoding an
+	// image may need, which is estimated from the size of the decoded
+	// image and of the strips or tiles that are decompressed at once.
+	// Images that need more fail with an error before any pixel data is
+	// read.
+	//
+	// Images decoded through image.Decode are limited to 1<<18 pixels in
+	// either dimension and 1 GiB of memory by default, so that untrusted
+	// input cannot exhaust memory; see SetDefaultDecodeOptions. Decode and
+	// the other functions of this package apply no limits unless they are
+	// given DecodeOptions.
+	MaxMemory int64
+	// AllowPartial makes the decoder recover what it can from damaged or
+	// truncated files: strips or tiles that cannot be read or decompressed
+	// are decoded as if all their samples were zero, the same as sparse
+	// blocks, and the image is returned together with a *PartialError.
+	// The IFD and the strip or tile offsets must still be intact. Reader.Next
+	// and DecodeAllWithOptions continue with the next page after a
+	// PartialError.
+	AllowPartial bool
+	// Alpha selects whether images with an alpha channel are decoded into
+	// image types with premultiplied or straight alpha, whichever alpha
+	// they are stored with, so that no conversion pass is needed.
+	Alpha AlphaMode
+	// OnProgress, if not nil, is called after each strip or tile is
+	// decoded, with the number of strips or tiles decoded so far and the
+	// total number to be decoded for the image or region. The calls are
+	// not concurrent, even if blocks are decoded concurrently.
+	OnProgress func(done, total int64)
+	// Transformer, if not nil, converts the colors of 8-bit CMYK images,
+	// which are then decoded into an image.RGBA or, if they have an alpha
+	// channel, into an image.RGBA or image.NRGBA as selected by Alpha, like
+	// RGB images. It must be safe for concurrent use, as strips and tiles
+	// may be decoded concurrently.
+	Transformer ColorTransformer
+}
+
+// An AlphaMode selects the image types that gray, RGB and CMYK images with
+// an alpha channel are decoded into. The samples are converted while the
+// strips or tiles are decoded.
+type AlphaMode int
+
+const (
+	// AlphaAsStored decodes gray and RGB images with associated alpha
+	// into image.RGBA or image.RGBA64, and those with unassociated alpha
+	// into GrayAImg, GrayA16Img, image.NRGBA or image.NRGBA64. CMYK images
+	// are decoded into CMYKAImg or CMYKA64Img.
+	AlphaAsStored AlphaMode = iota
+	// AlphaPremultiplied decodes gray and RGB images into image.RGBA or
+	// image.RGBA64 and 8-bit CMYK images into PremulCMYKAImg. 16-bit CMYK
+	// images are still decoded into CMYKA64Img.
+	AlphaPremultiplied
+	// AlphaStraight decodes gray images into GrayAImg or GrayA16Img, RGB
+	// images into image.NRGBA or image.NRGBA64 and CMYK images into
+	// CMYKAImg or CMYKA64Img.
+	AlphaStraight
+)
+
+// Limits applied by default to images decoded through image.Decode.
+const (
+	defaultMaxSize   = 1 << 18
+	defaultMaxMemory = 1 << 30
+)
+
+// defaultOptions holds the options of the images decoded through
+// image.Decode, as set by SetDefaultDecodeOptions.
+var defaultOptions = struct {
+	sync.Mutex
+	opts DecodeOptions
+}{opts: DecodeOptions{
+	MaxWidth:  defaultMaxSize,
+	MaxHeight: defaultMaxSize,
+	MaxMemory: defaultMaxMemory,
+}}
+
+// SetDefaultDecodeOptions sets the options with which images are decoded
+// through image.Decode, which calls the decoding function that this package
+// registers with the image package, so that frameworks that decode images
+// generically apply the limits, output types and tolerance chosen by the
+// program. image.DecodeConfig applies them, too, except for the limits, and
+// reports the color model and dimensions that image.Decode returns. The
+// options replace the default ones, including their limits;
+// DefaultDecodeOptions returns the current options to be modified. The
+// functions of this package are not affected. SetDefaultDecodeOptions is
+// safe for concurrent use.
+func SetDefaultDecodeOptions(opts DecodeOptions) {
+	defaultOptions.Lock()
+	defaultOptions.opts = opts
+	defaultOptions.Unlock()
+}
+
+// DefaultDecodeOptions returns the options with which images are decoded
+// through image.Decode: those set by SetDefaultDecodeOptions or, initially,
+// a MaxWidth and MaxHeight of 1<<18 and a MaxMemory of 1 GiB.
+func DefaultDecodeOptions() DecodeOptions {
+	defaultOptions.Lock()
+	defer defaultOptions.Unlock()
+	return defaultOptions.opts
+}
+
+// decodeLimited is the decoding function registered with the image package.
+func decodeLimited(r io.Reader) (image.Image, error) {
+	opts := DefaultDecodeOptions()
+	return DecodeWithOptions(r, &opts)
+}
+
+// decodeConfigLimited is the configuration function registered with the
+// image package. It applies the default options like decodeLimited, so that
+// image.DecodeConfig reports the color model and, with AutoOrient, the
+// dimensions of the image that image.Decode returns. The limits are not
+// checked, so that the dimensions of too large images can be inspected.
+func decodeConfigLimited(r io.Reader) (image.Config, error) {
+	opts := DefaultDecodeOptions()
+	ra := newReaderAt(r)
+	h, ifdOffset, err := readHeader(ra)
+	if err != nil {
+		return image.Config{}, err
+	}
+	d, err := newIFDDecoder(ra, h, ifdOffset, &opts)
+	if err != nil {
+		return image.Config{}, err
+	}
+	c := d.config
+	if o := d.firstVal(tOrientation); opts.AutoOrient && o >= 5 && o <= 8 {
+		// Orientations 5 to 8 rotate the image by 90 or 270 degrees.
+		c.Width, c.Height = c.Height, c.Width
+	}
+	return c, nil
+}
+
+// DecodeWithOptions reads a TIFF image from r like Decode, using the given
+// options. If opts is nil, it behaves like Decode.
+func DecodeWithOptions(r io.Reader, opts *DecodeOptions) (image.Image, error) {
+	ra := newReaderAt(r)
+	h, ifdOffset, err := readHeader(ra)
+	if err != nil {
+		return nil, err
+	}
+	d, err := newIFDDecoder(ra, h, ifdOffset, opts)
+	if err != nil {
+		return nil, err
+	}
+	return d.decodeImage()
+}
+
+// DecodeContext is like DecodeWithOptions, but stops decoding when ctx is
+// done: ctx is checked before each strip or tile, and its error is returned
+// once it is canceled or its deadline passes.
+func DecodeContext(ctx context.Context, r io.Reader, opts *DecodeOptions) (image.Image, error) {
+	ra := newReaderAt(r)
+	h, ifdOffset, err := readHeader(ra)
+	if err != nil {
+		return nil, err
+	}
+	d, err := newIFDDecoder(ra, h, ifdOffset, opts)
+	if err != nil {
+		return nil, err
+	}
+	d.ctx = ctx
+	return d.decodeImage()
+}
+
+// applyOptions adjusts the decoder according to opts, which may be nil.
+func (d *decoder) applyOptions(opts *DecodeOptions) error {
+	if opts == nil {
+		return nil
+	}
+	switch opts.ForcePredictor {
+	case 0:
+	case prNone, prHorizontal, prFloatingPoint:
+		d.features[tPredictor] = []uint{uint(opts.ForcePredictor)}
+	default:
+		return UnsupportedError(fmt.Sprintf("predictor value %d", opts.ForcePredictor))
+	}
+	d.stretch = opts.StretchToRange
+	if opts.Workers < 0 {
+		return fmt.Errorf("tiff: invalid number of workers %d", opts.Workers)
+	}
+	d.workers = opts.Workers
+	d.autoOrient = opts.AutoOrient
+	if opts.MaxWidth < 0 || opts.MaxHeight < 0 || opts.MaxMemory < 0 {
+		return fmt.Errorf("tiff: negative decoding limit")
+	}
+	d.maxWidth, d.maxHeight, d.maxMemory = opts.MaxWidth, opts.MaxHeight, opts.MaxMemory
+	if opts.AllowPartial {
+		d.damage = new(damage)
+	}
+	d.onProgress = opts.OnProgress
+	if opts.Alpha < AlphaAsStored || opts.Alpha > AlphaStraight {
+		return fmt.Errorf("tiff: invalid AlphaMode %d", opts.Alpha)
+	}
+	d.alpha = opts.Alpha
+	d.transformer = opts.Transformer
+	return nil
+}
+
+// applyAlpha sets d.premul and the color model for images with alpha,
+// according to d.alpha and the alpha they are stored with.
+func (d *decoder) applyAlpha() {
+	switch d.mode {
+	case mGrayAlpha, mPalettedAlpha, mRGBA, mNRGBA, mCMYKA:
+	default:
+		return
+	}
+	switch d.alpha {
+	case AlphaAsStored:
+		d.premul = d.firstVal(tExtraSamples) == 1 && (d.mode != mCMYKA || d.transformer != nil)
+	case AlphaPremultiplied:
+		d.premul = d.mode != mCMYKA || d.bpp != 16
+	case AlphaStraight:
+		d.premul = false
+	}
+	wide := d.bpp == 16
+	switch {
+	case d.mode == mCMYKA && d.transformer != nil:
+		// The image is decoded into an RGB type.
+		if d.premul {
+			d.config.ColorModel = color.RGBAModel
+		} else {
+			d.config.ColorModel = color.NRGBAModel
+		}
+	case d.mode == mCMYKA && wide:
+		d.config.ColorModel = CMYKA64Model
+	case d.mode == mCMYKA:
+		d.config.ColorModel = CMYKAModel
+	case d.mode == mGrayAlpha && !d.premul && wide:
+		d.config.ColorModel = GrayA16Model
+	case d.mode == mGrayAlpha && !d.premul:
+		d.config.ColorModel = GrayAModel
+	case d.premul && wide:
+		d.config.ColorModel = color.RGBA64Model
+	case d.premul:
+		d.config.ColorModel = color.RGBAModel
+	case wide:
+		d.config.ColorModel = color.NRGBA64Model
+	default:
+		d.config.ColorModel = color.NRGBAModel
+	}
+}
+
+// checkLimits returns an error if decoding the image with layout l exceeds
+// the limits set by DecodeOptions.
+func (d *decoder) checkLimits(l *blockLayout) error {
+	if d.maxWidth > 0 && d.config.Width > d.maxWidth {
+		return fmt.Errorf("tiff: image width %d exceeds the maximum of %d", d.config.Width, d.maxWidth)
+	}
+	if d.maxHeight > 0 && d.config.Height > d.maxHeight {
+		return fmt.Errorf("tiff: image height %d exceeds the maximum of %d", d.config.Height, d.maxHeight)
+	}
+	if d.maxMemory == 0 {
+		return nil
+	}
+	// The estimate is computed in floating point, which cannot overflow.
+	need := float64(d.config.Width) * float64(d.config.Height) * float64(d.pixelSize())
+	block := float64(l.width) * float64(l.height) * float64(d.bpp) * float64(l.samples) / 8
+	if l.planes > 1 {
+		// The planes are interleaved into a second buffer.
+		block *= 2
+	}
+	workers := d.numWorkers(l.across * l.down)
+	need += block * float64(workers)
+	if need > float64(d.maxMemory) {
+		return fmt.Errorf("tiff: decoding the image needs about %.0f bytes, more than the maximum of %d", need, d.maxMemory)
+	}
+	// The JPEG decoder allocates an image for the frame of each block,
+	// which must fit into the memory that remains.
+	d.jpegMemory = (d.maxMemory - int64(need)) / int64(workers)
+	if d.jpegMemory < 1 {
+		d.jpegMemory = 1
+	}
+	return nil
+}
+
+// DecodeAll reads all images of a multi-page TIFF from r and returns them
+// in the order in which their IFDs are chained in the file.
+func DecodeAll(r io.Reader) ([]image.Image, error) {
+	return DecodeAllWithOptions(r, nil)
+}
+
+// DecodeAllWithOptions reads the images of a multi-page TIFF from r like
+// DecodeAll, using the given options. If opts is nil, it behaves like
+// DecodeAll.
+func DecodeAllWithOptions(r io.Reader, opts *DecodeOptions) ([]image.Image, error) {
+	var imgs []image.Image
+	var partial error // The first PartialError.
+	err := forEachPage(newReaderAt(r), opts, func(d *decoder) error {
+		img, err := d.decodeImage()
+		if _, ok := err.(*PartialError); ok {
+			if partial == nil {
+				partial = err
+			}
+		} else if err != nil {
+			return err
+		}
+		imgs = append(imgs, img)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return imgs, partial
+}
+
+// subfileType returns the NewSubfileType value of the image. The values of
+// the old SubfileType tag (p. 40 of the spec) are translated.
+func (d *decoder) subfileType() uint32 {
+	if _, ok := d.features[tNewSubfileType]; ok {
+		return uint32(d.firstVal(tNewSubfileType))
+	}
+	switch d.firstVal(tSubfileType) {
+	case 2: // Reduced-resolution image data.
+		return 1
+	case 3: // A single page of a multi-page image.
+		return 2
+	}
+	return 0
+}
+
+// DecodeArray reads a TIFF image from r and returns its raw samples in
+// row-major order with shape [height, width, samplesPerPixel]. No
+// photometric interpretation is applied: palette indices, inverted gray
+// values and extra samples are returned as stored. The data is a []uint8
+// for images with up to 8 bits per sample, a []uint16 for those with up to
+// 16, a []uint32 for those with up to 24 or with 32, a []int16 or []int32
+// for 16- and 32-bit signed integer images and a []float32 for 32-bit
+// floating point images, as indicated by dtype, which is "uint8", "uint16",
+// "uint32", "int16", "int32" or "float32". The samples of subsampled YCbCr
+// images are not supported.
+func DecodeArray(r io.Reader) (data interface{}, shape [3]int, dtype string, err error) {
+	ra := newReaderAt(r)
+	hdr, ifdOffset, err := readHeader(ra)
+	if err != nil {
+		return nil, shape, "", err
+	}
+	d, err := readIFD(ra, hdr, ifdOffset)
+	if err != nil {
+		return nil, shape, "", err
+	}
+	return d.decodeArray()
+}
+
+// decodeArray implements DecodeArray for the image of d, which need not have
+// been created by newIFDDecoder.
+func (d *decoder) decodeArray() (data interface{}, shape [3]int, dtype string, err error) {
+	if c := d.firstVal(tCompression); d.firstVal(tPhotometricInterpretation) == pYCbCr && c != cJPEG && c != cJPEGOld {
+		if h, v := d.subsampling(); h != 1 || v != 1 {
+			return nil, shape, "", UnsupportedError("raw samples of subsampled YCbCr images")
+		}
+	}
+	l, err := d.layout()
+	if err != nil {
+		return nil, shape, "", err
+	}
+
+	w, h, c := d.config.Width, d.config.Height, l.samples
+	shape = [3]int{h, w, c}
+	var u8 []uint8
+	var u16 []uint16
+	var u32 []uint32
+	var i16 []int16
+	var i32 []int32
+	var f32 []float32
+	float := d.firstVal(tSampleFormat) == sfFloat
+	signed := d.firstVal(tSampleFormat) == sfInt
+	switch {
+	case float && d.bpp == 32:
+		f32 = make([]float32, w*h*c)
+		data, dtype = f32, "float32"
+	case float:
+		return nil, shape, "", UnsupportedError(fmt.Sprintf("floating point BitsPerSample of %v", d.bpp))
+	case signed && d.bpp == 16:
+		i16 = make([]int16, w*h*c)
+		data, dtype = i16, "int16"
+	case signed && d.bpp == 32:
+		i32 = make([]int32, w*h*c)
+		data, dtype = i32, "int32"
+	case signed:
+		return nil, shape, "", UnsupportedError(fmt.Sprintf("signed integer BitsPerSample of %v", d.bpp))
+	case d.bpp >= 1 && d.bpp <= 8:
+		u8 = make([]uint8, w*h*c)
+		data, dtype = u8, "uint8"
+	case d.bpp > 8 && d.bpp <= 16:
+		u16 = make([]uint16, w*h*c)
+		data, dtype = u16, "uint16"
+	case d.bpp > 16 && d.bpp <= 24 || d.bpp == 32:
+		u32 = make([]uint32, w*h*c)
+		data, dtype = u32, "uint32"
+	default:
+		return nil, shape, "", UnsupportedError(fmt.Sprintf("BitsPerSample of %v", d.bpp))
+	}
+
+	err = d.decodeBlocks(l, func(d *decoder, xmin, ymin, xmax, ymax int) error {
+		n := (xmax - xmin) * c
+		rowLen := (n*int(d.bpp) + 7) / 8
+		width := (minInt(xmax, w) - xmin) * c
+		buf := d.buf
+		for y := ymin; y < minInt(ymax, h); y++ {
+			row := buf[minInt((y-ymin)*rowLen, len(buf)):]
+			row = row[:minInt(rowLen, len(row))]
+			i := (y*w + xmin) * c
+			switch d.bpp {
+			case 8:
+				copy(u8[i:i+width], row)
+			case 16:
+				for x := 0; x < width && 2*x+1 < len(row); x++ {
+					v := d.byteOrder.Uint16(row[2*x:])
+					if signed {
+						i16[i+x] = int16(v)
+					} else {
+						u16[i+x] = v
+					}
+				}
+			case 32:
+				for x := 0; x < width && 4*x+3 < len(row); x++ {
+					v := d.byteOrder.Uint32(row[4*x:])
+					switch {
+					case float:
+						f32[i+x] = math.Float32frombits(v)
+					case signed:
+						i32[i+x] = int32(v)
+					default:
+						u32[i+x] = v
+					}
+				}
+			default:
+				d.buf, d.off, d.nbits, d.v = row, 0, 0, 0
+				for x := 0; x < width; x++ {
+					v, ok := d.readBits(d.bpp)
+					if !ok {
+						return errNoPixels
+					}
+					switch {
+					case u8 != nil:
+						u8[i+x] = uint8(v)
+					case u16 != nil:
+						u16[i+x] = uint16(v)
+					default:
+						u32[i+x] = v
+					}
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, shape, "", err
+	}
+	return data, shape, dtype, nil
+}
+
+// forEachPage calls fn with a decoder for each IFD of the TIFF file in r,
+// created with opts, stopping at the first error.
+func forEachPage(r io.ReaderAt, opts *DecodeOptions, fn func(d *decoder) error) error {
+	p, err := newReader(r, opts)
+	if err != nil {
+		return err
+	}
+	for {
+		d, err := p.nextDecoder()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(d); err != nil {
+			return err
+		}
+	}
+}
+
+// A Reader decodes the pages of a multi-page TIFF file one at a time, so
+// that only one page needs to be held in memory.
+type Reader struct {
+	r      io.ReaderAt
+	h      header
+	opts   *DecodeOptions
+	offset int64 // Offset of the next IFD, or 0 after the last one.
+	seen   map[int64]bool
+	err    error
+	page   *rowReader // Page read by ReadRows, if any.
+}
+
+// NewReader returns a Reader for the TIFF file in r, after reading its
+// header. If r is not an io.ReaderAt, the whole file is buffered in memory,
+// because the pages can be stored in any order; pass an *os.File or another
+// io.ReaderAt to avoid that.
+func NewReader(r io.Reader) (*Reader, error) {
+	return newReader(newReaderAt(r), nil)
+}
+
+func newReader(r io.ReaderAt, opts *DecodeOptions) (*Reader, error) {
+	h, offset, err := readHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	return &Reader{r: r, h: h, opts: opts, offset: offset, seen: make(map[int64]bool)}, nil
+}
+
+// Next decodes the next page of the file, in the order in which the IFDs
+// are chained. It returns io.EOF after the last page. After any other
+// error, Next keeps returning that error. If the rows of a page are being
+// read by ReadRows, Next skips the rest of that page; if Config has been
+// called but no rows have been read, Next decodes that page.
+func (p *Reader) Next() (image.Image, error) {
+	var d *decoder
+	if p.page != nil && p.page.row == 0 {
+		d = p.page.d
+	}
+	p.page = nil
+	if d == nil {
+		var err error
+		if d, err = p.nextDecoder(); err != nil {
+			return nil, err
+		}
+	}
+	img, err := d.decodeImage()
+	if _, ok := err.(*PartialError); ok {
+		return img, err
+	}
+	if err != nil {
+		p.err = err
+		return nil, err
+	}
+	return img, nil
+}
+
+// nextDecoder returns a decoder for the next IFD of the file. IFDs rejected
+// by the PageFilter of the Reader's options are skipped before their image
+// is examined, so that pages which this package cannot decode do not fail.
+func (p *Reader) nextDecoder() (*decoder, error) {
+	for {
+		if p.err != nil {
+			return nil, p.err
+		}
+		if p.offset == 0 {
+			return nil, io.EOF
+		}
+		if p.seen[p.offset] {
+			p.err = FormatError("IFD chain contains a loop")
+			return nil, p.err
+		}
+		p.seen[p.offset] = true
+		if p.opts != nil && p.opts.PageFilter != nil {
+			raw, err := readIFD(p.r, p.h, p.offset)
 			if err != nil {
+				p.err = err
 				return nil, err
 			}
+			if !p.opts.PageFilter(raw.subfileType()) {
+				p.offset = raw.next
+				continue
+			}
+		}
+		d, err := newIFDDecoder(p.r, p.h, p.offset, p.opts)
+		if err != nil {
+			p.err = err
+			return nil, err
+		}
+		p.offset = d.next
+		return d, nil
+	}
+}
+
+// SubImages returns a Reader for each of the SubIFDs of the current page,
+// which is the next page that Next returns or whose rows ReadRows reads. In
+// pyramidal files and DNG files, they hold reduced-resolution versions of
+// the page, such as thumbnails, which can be decoded without decoding the
+// page itself. Each Reader starts with the image of its SubIFD and
+// continues with the IFDs chained to it, if any. SubImages returns no
+// Readers if the page has no SubIFDs tag, and io.EOF after the last page.
+func (p *Reader) SubImages() ([]*Reader, error) {
+	if _, err := p.Config(); err != nil {
+		return nil, err
+	}
+	offsets := p.page.d.features[tSubIFDs]
+	subs := make([]*Reader, len(offsets))
+	for i, off := range offsets {
+		if off == 0 {
+			return nil, FormatError("zero SubIFDs offset")
+		}
+		subs[i] = &Reader{r: p.r, h: p.h, opts: p.opts, offset: int64(off), seen: make(map[int64]bool)}
+	}
+	return subs, nil
+}
+
+// A blockLayout describes how the pixel data of an image is divided into
+// strips or tiles, which are both called blocks here.
+type blockLayout struct {
+	width, height int  // Dimensions of a block in pixels.
+	across, down  int  // Number of blocks per row and column.
+	padding       bool // Whether blocks at the edges are padded (tiles).
+	samples       int  // Samples per pixel.
+	planes        int  // Number of planes; 1 unless the image is planar.
+	offsets       []uint
+	counts        []uint
+}
+
+// layout returns the block layout of the decoder's image.
+func (d *decoder) layout() (*blockLayout, error) {
+	l := &blockLayout{
+		width:   d.config.Width,
+		height:  d.config.Height,
+		across:  1,
+		down:    1,
+		samples: len(d.features[tBitsPerSample]),
+		planes:  1,
+	}
+
+	if d.config.Width == 0 {
+		l.across = 0
+	}
+	if d.config.Height == 0 {
+		l.down = 0
+	}
+
+	if int(d.firstVal(tTileWidth)) != 0 {
+		l.padding = true
+
+		l.width = int(d.firstVal(tTileWidth))
+		l.height = int(d.firstVal(tTileLength))
+
+		if l.width != 0 {
+			l.across = (d.config.Width + l.width - 1) / l.width
+		}
+		if l.height != 0 {
+			l.down = (d.config.Height + l.height - 1) / l.height
+		}
 
-			xmin := i * blockWidth
-			ymin := j * blockHeight
-			xmax := xmin + blkW
-			ymax := ymin + blkH
-			err = d.decode(img, xmin, ymin, xmax, ymax)
+		l.counts = d.features[tTileByteCounts]
+		l.offsets = d.features[tTileOffsets]
+
+	} else {
+		if int(d.firstVal(tRowsPerStrip)) != 0 {
+			l.height = int(d.firstVal(tRowsPerStrip))
+		}
+
+		if l.height != 0 {
+			l.down = (d.config.Height + l.height - 1) / l.height
+		}
+
+		l.offsets = d.features[tStripOffsets]
+		l.counts = d.features[tStripByteCounts]
+		if len(l.counts) == 0 && len(l.offsets) == 1 {
+			// Some encoders omit StripByteCounts for images stored
+			// in a single strip.
+			l.counts = []uint{uint(d.stripExtent(int64(l.offsets[0]), l.samples))}
+		}
+	}
+
+	if d.firstVal(tCompression) == cJPEGOld && d.firstVal(tJPEGInterchangeFormat) != 0 {
+		if full, err := d.oldJPEGStream(l); full || err != nil {
+			return l, err
+		}
+	}
+
+	// With PlanarConfiguration 2, each sample of a pixel is stored in its own
+	// plane. All strips or tiles of the first plane come first, followed by
+	// those of the second plane and so on.
+	if d.firstVal(tPlanarConfiguration) == pcPlanar && l.samples > 1 {
+		if d.bpp < 8 {
+			return nil, UnsupportedError(fmt.Sprintf("planar configuration with BitsPerSample of %v", d.bpp))
+		}
+		l.planes = l.samples
+	}
+
+	// Check if we have the right number of strips/tiles, offsets and counts.
+	if n := l.across * l.down * l.planes; len(l.offsets) < n || len(l.counts) < n {
+		return nil, FormatError("inconsistent header")
+	}
+	return l, nil
+}
+
+// stripExtent returns the length of the single strip at offset of an image
+// without StripByteCounts. An uncompressed strip holds exactly the pixels of
+// the image. A compressed strip is assumed to extend to the next IFD or to
+// the end of the file, whichever comes first.
+func (d *decoder) stripExtent(offset int64, samples int) int64 {
+	if c := d.firstVal(tCompression); c == cNone || c == 0 {
+		return int64(d.blockLen(d.config.Width, d.config.Height, samples))
+	}
+	end := int64(-1)
+	for _, b := range []int64{d.offset, d.next} {
+		if b > offset && (end < 0 || b < end) {
+			end = b
+		}
+	}
+	if end < 0 {
+		end = readerSize(d.r)
+	}
+	return end - offset
+}
+
+// decodeBlocks reads the strips or tiles of the image laid out as described
+// by l. For each block, it loads the block with loadBlock and calls fn with
+// the decoder holding the block data and the pixel bounds of the block.
+//
+// Up to d.workers blocks are decoded concurrently, each by its own copy of
+// d, so fn must only write to the part of its destination that belongs to
+// the block.
+func (d *decoder) decodeBlocks(l *blockLayout, fn func(d *decoder, xmin, ymin, xmax, ymax int) error) error {
+	return d.decodeBlockRange(l, image.Rect(0, 0, l.across, l.down), fn)
+}
+
+// numWorkers returns the number of blocks that are decoded concurrently if
+// n blocks are decoded.
+func (d *decoder) numWorkers(n int) int {
+	workers := d.workers
+	if workers == 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	if workers > n {
+		workers = n
+	}
+	return workers
+}
+
+// decodeBlockRange is like decodeBlocks, but reads only the blocks whose
+// column and row in the layout are within blocks.
+func (d *decoder) decodeBlockRange(l *blockLayout, blocks image.Rectangle, fn func(d *decoder, xmin, ymin, xmax, ymax int) error) error {
+	total := int64(blocks.Dx() * blocks.Dy())
+	var (
+		progressMu sync.Mutex
+		done       int64
+	)
+	// block decodes a block after checking the context, which is not
+	// treated as damage, and reports its completion.
+	block := func(d *decoder, i, j int) error {
+		if d.ctx != nil {
+			if err := d.ctx.Err(); err != nil {
+				return err
+			}
+		}
+		if err := d.decodeBlock(l, i, j, fn); err != nil {
+			return err
+		}
+		if d.onProgress != nil {
+			progressMu.Lock()
+			done++
+			d.onProgress(done, total)
+			progressMu.Unlock()
+		}
+		return nil
+	}
+	workers := d.numWorkers(int(total))
+	if workers <= 1 {
+		for i := blocks.Min.X; i < blocks.Max.X; i++ {
+			for j := blocks.Min.Y; j < blocks.Max.Y; j++ {
+				if err := block(d, i, j); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		err   error
+		queue = make(chan image.Point)
+	)
+	failed := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return err != nil
+	}
+	for w := 0; w < workers; w++ {
+		wd := *d
+		wd.buf, wd.scratch = nil, nil
+		wg.Add(1)
+		go func(d *decoder) {
+			defer wg.Done()
+			for b := range queue {
+				if e := block(d, b.X, b.Y); e != nil {
+					mu.Lock()
+					if err == nil {
+						err = e
+					}
+					mu.Unlock()
+				}
+			}
+		}(&wd)
+	}
+	for i := blocks.Min.X; i < blocks.Max.X && !failed(); i++ {
+		for j := blocks.Min.Y; j < blocks.Max.Y && !failed(); j++ {
+			queue <- image.Point{i, j}
+		}
+	}
+	close(queue)
+	wg.Wait()
+	return err
+}
+
+// decodeBlock loads the block in column i and row j of the layout l and
+// calls fn with it.
+func (d *decoder) decodeBlock(l *blockLayout, i, j int, fn func(d *decoder, xmin, ymin, xmax, ymax int) error) error {
+	defer d.releaseScratch()
+	blkW, blkH, err := d.loadBlock(l, i, j)
+	xmin := i * l.width
+	ymin := j * l.height
+	if err == nil && d.pix == nil {
+		err = fn(d, xmin, ymin, xmin+blkW, ymin+blkH)
+	}
+	d.pix = nil
+	if err == nil || d.damage == nil {
+		return err
+	}
+	d.damage.mu.Lock()
+	d.damage.blocks++
+	if d.damage.err == nil {
+		d.damage.err = err
+	}
+	d.damage.mu.Unlock()
+	d.buf = make([]byte, d.blockLen(blkW, blkH, l.samples))
+	return fn(d, xmin, ymin, xmin+blkW, ymin+blkH)
+}
+
+// loadBlock stores the uncompressed data of the block in column i and row j
+// of the layout l in d.buf, with the samples of each pixel stored
+// contiguously, and returns the dimensions of the block. The dimensions of
+// padded edge tiles exceed the image.
+func (d *decoder) loadBlock(l *blockLayout, i, j int) (blkW, blkH int, err error) {
+	blkW = l.width
+	if !l.padding && i == l.across-1 && d.config.Width%l.width != 0 {
+		blkW = d.config.Width % l.width
+	}
+	// The last strip holds only the rows that remain after the preceding
+	// strips, which may be fewer than RowsPerStrip. Tiles, by contrast,
+	// are always padded.
+	blkH = l.height
+	if !l.padding && j == l.down-1 && d.config.Height%l.height != 0 {
+		blkH = d.config.Height % l.height
+	}
+	k := j*l.across + i
+	d.pix = d.directPix(l, j*l.height, blkW, blkH)
+	if l.planes == 1 {
+		d.buf, err = d.blockData(k, int64(l.offsets[k]), int64(l.counts[k]), blkW, blkH, l.samples)
+	} else {
+		data := make([][]byte, l.planes)
+		for p := range data {
+			pk := p*l.across*l.down + k
+			data[p], err = d.blockData(pk, int64(l.offsets[pk]), int64(l.counts[pk]), blkW, blkH, 1)
 			if err != nil {
-				return nil, err
+				return blkW, blkH, err
 			}
 		}
+		d.buf, err = interleave(data, blkW*blkH, int(d.bpp/8))
+	}
+	if err == nil && d.stretch {
+		d.stretchSamples(l.samples)
+	}
+	if err == nil && d.pix != nil {
+		d.finishDirect(l.samples)
+	}
+	return blkW, blkH, err
+}
+
+// stretchSamples linearly maps the samples in d.buf from the range given by
+// the MinSampleValue and MaxSampleValue tags to the full range of their bit
+// depth. Samples outside the range are clamped. Only 8 and 16 bit samples
+// of images that are not paletted are stretched.
+func (d *decoder) stretchSamples(samples int) {
+	if d.mode == mPaletted || d.mode == mPalettedAlpha || (d.bpp != 8 && d.bpp != 16) {
+		return
+	}
+	full := uint(1)<<d.bpp - 1
+	mins, maxs := d.features[tMinSampleValue], d.features[tMaxSampleValue]
+	lo, hi := make([]uint, samples), make([]uint, samples)
+	stretch := false
+	for s := range lo {
+		lo[s], hi[s] = 0, full
+		// The tags should have a value for each sample, but some writers
+		// store a single value for all of them.
+		if len(mins) > s {
+			lo[s] = mins[s]
+		} else if len(mins) > 0 {
+			lo[s] = mins[0]
+		}
+		if len(maxs) > s {
+			hi[s] = maxs[s]
+		} else if len(maxs) > 0 {
+			hi[s] = maxs[0]
+		}
+		if lo[s] >= hi[s] || hi[s] > full {
+			lo[s], hi[s] = 0, full
+		}
+		if lo[s] != 0 || hi[s] != full {
+			stretch = true
+		}
+	}
+	if !stretch {
+		return
+	}
+	n := int(d.bpp / 8)
+	for i, k := 0, 0; i+n <= len(d.buf); i, k = i+n, k+1 {
+		s := k % samples
+		var v uint
+		if n == 2 {
+			v = uint(d.byteOrder.Uint16(d.buf[i:]))
+		} else {
+			v = uint(d.buf[i])
+		}
+		switch {
+		case v <= lo[s]:
+			v = 0
+		case v >= hi[s]:
+			v = full
+		default:
+			v = (v - lo[s]) * full / (hi[s] - lo[s])
+		}
+		if n == 2 {
+			d.byteOrder.PutUint16(d.buf[i:], uint16(v))
+		} else {
+			d.buf[i] = uint8(v)
+		}
+	}
+}
+
+// decodeImage decodes the image described by the decoder's IFD.
+func (d *decoder) decodeImage() (image.Image, error) {
+	l, err := d.layout()
+	if err != nil {
+		return nil, err
+	}
+	if err := d.checkLimits(l); err != nil {
+		return nil, err
+	}
+	img := d.newImage(image.Rect(0, 0, d.config.Width, d.config.Height))
+	d.direct = img
+	err = d.decodeBlocks(l, func(d *decoder, xmin, ymin, xmax, ymax int) error {
+		return d.decode(img, xmin, ymin, xmax, ymax)
+	})
+	d.direct = nil
+	if err != nil {
+		return nil, err
+	}
+	if d.autoOrient {
+		img = Orient(img, int(d.firstVal(tOrientation)))
+	}
+	if d.damage != nil && d.damage.blocks > 0 {
+		return img, &PartialError{Blocks: d.damage.blocks, Err: d.damage.err}
+	}
+	return img, nil
+}
+
+// DecodeInto reads a TIFF image from r like Decode, but decodes it into dst
+// instead of a newly allocated image, so that the pixel buffer of dst can be
+// reused for many images of the same format. dst must have the concrete type
+// of the image that Decode returns for r and the bounds (0, 0)-(width,
+// height); otherwise an error is returned and dst is unchanged. The palette
+// of a paletted dst is set to that of the image. All pixels of dst are
+// overwritten.
+func DecodeInto(r io.Reader, dst draw.Image) error {
+	d, err := newDecoder(r)
+	if err != nil {
+		return err
+	}
+	l, err := d.layout()
+	if err != nil {
+		return err
+	}
+	want := d.newImage(image.Rectangle{})
+	if reflect.TypeOf(dst) != reflect.TypeOf(want) {
+		return fmt.Errorf("tiff: cannot decode into %T, the image decodes into %T", dst, want)
+	}
+	if r := image.Rect(0, 0, d.config.Width, d.config.Height); dst.Bounds() != r {
+		return fmt.Errorf("tiff: cannot decode into bounds %v, the image has bounds %v", dst.Bounds(), r)
+	}
+	if p, ok := dst.(*image.Paletted); ok {
+		p.Palette = d.palette
+	}
+	d.direct = dst
+	return d.decodeBlocks(l, func(d *decoder, xmin, ymin, xmax, ymax int) error {
+		return d.decode(dst, xmin, ymin, xmax, ymax)
+	})
+}
+
+// unpremultiply divides the color samples v, which are premultiplied by the
+// alpha value a, by a. max is the maximum value of a sample. Samples greater
+// than a, which are invalid, become max; all samples become 0 if a is 0.
+func unpremultiply(v []uint32, a, max uint32) {
+	for i := range v {
+		switch {
+		case a == 0:
+			v[i] = 0
+		case v[i] >= a:
+			v[i] = max
+		default:
+			v[i] = v[i] * max / a
+		}
+	}
+}
+
+// pixelSize returns the number of bytes per pixel of the image returned by
+// newImage.
+func (d *decoder) pixelSize() int {
+	if d.transformer != nil {
+		return 4
+	}
+	n := 1
+	if d.bpp == 16 {
+		n = 2
+	}
+	switch d.mode {
+	case mGrayAlpha:
+		if !d.premul {
+			return 2 * n
+		}
+		return 4 * n
+	case mNRGBA, mRGB, mRGBA, mPalettedAlpha:
+		return 4 * n
+	case mCMYK:
+		if n == 2 {
+			return 10
+		}
+		return 4
+	case mCMYKA:
+		return 5 * n
+	case mRaw, mNChannel:
+		return len(d.features[tBitsPerSample]) * n
+	case mYCbCr:
+		return 4
+	case mLab:
+		if d.config.ColorModel == LabModel {
+			return 3 * n
+		}
+		return 4 * n
+	case mInt16:
+		return 2
+	case mFloat32, mInt32:
+		return 4
+	}
+	return n
+}
+
+// newImage returns a new image with bounds r of the type that the decoder's
+// image is decoded into.
+func (d *decoder) newImage(r image.Rectangle) image.Image {
+	if d.transformer != nil {
+		if d.mode == mCMYKA && !d.premul {
+			return image.NewNRGBA(r)
+		}
+		return image.NewRGBA(r)
+	}
+	switch d.mode {
+	case mGray, mGrayInvert:
+		if d.bpp == 16 {
+			return image.NewGray16(r)
+		}
+		return image.NewGray(r)
+	case mGrayAlpha:
+		switch {
+		case d.premul && d.bpp == 16:
+			return image.NewRGBA64(r)
+		case d.premul:
+			return image.NewRGBA(r)
+		case d.bpp == 16:
+			return NewGrayA16(r)
+		}
+		return NewGrayA(r)
+	case mPaletted:
+		return image.NewPaletted(r, d.palette)
+	case mPalettedAlpha:
+		if d.premul {
+			return image.NewRGBA(r)
+		}
+		return image.NewNRGBA(r)
+	case mNRGBA, mRGBA:
+		switch {
+		case d.premul && d.bpp == 16:
+			return image.NewRGBA64(r)
+		case d.premul:
+			return image.NewRGBA(r)
+		case d.bpp == 16:
+			return image.NewNRGBA64(r)
+		}
+		return image.NewNRGBA(r)
+	case mRGB:
+		if d.bpp == 16 {
+			return image.NewRGBA64(r)
+		}
+		return image.NewRGBA(r)
+	case mCMYK:
+		if d.bpp == 16 {
+			return NewCMYKA64(r)
+		}
+		return image.NewCMYK(r)
+	case mCMYKA:
+		switch {
+		case d.bpp == 16:
+			return NewCMYKA64(r)
+		case d.premul:
+			return NewPremulCMYKA(r)
+		}
+		return NewCMYKA(r)
+	case mRaw:
+		n := 1
+		if d.bpp == 16 {
+			n = 2
+		}
+		return NewMultiSample(r, len(d.features[tBitsPerSample]), n)
+	case mYCbCr:
+		return image.NewRGBA(r)
+	case mLab:
+		switch {
+		case d.config.ColorModel == LabModel && d.bpp == 16:
+			return NewLab(r, 2)
+		case d.config.ColorModel == LabModel:
+			return NewLab(r, 1)
+		case d.bpp == 16:
+			return image.NewRGBA64(r)
+		}
+		return image.NewRGBA(r)
+	case mNChannel:
+		n := 1
+		if d.bpp == 16 {
+			n = 2
+		}
+		return NewNChannel(r, len(d.features[tBitsPerSample]), n, d.inkNames)
+	case mFloat32:
+		return NewFloat32(r)
+	case mInt16:
+		return NewInt16(r)
+	case mInt32:
+		return NewInt32(r)
+	}
+	return nil
+}
+
+// DecodePreview decodes only the first strip or the top-left tile of the
+// first image of the TIFF file in r, which is a cheap way to get an idea of
+// the image. The returned image is partial: its bounds are those of the
+// block, clipped to the bounds of the full image. DecodePreview also returns
+// information about how the image is stored, including its metadata.
+func DecodePreview(r io.ReaderAt) (image.Image, *SourceInfo, error) {
+	h, ifdOffset, err := readHeader(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	d, err := newIFDDecoder(r, h, ifdOffset, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	l, err := d.layout()
+	if err != nil {
+		return nil, nil, err
+	}
+	if l.across == 0 || l.down == 0 {
+		return d.newImage(image.Rectangle{}), d.sourceInfo(), nil
+	}
+	blkW, blkH, err := d.loadBlock(l, 0, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	rect := image.Rect(0, 0, blkW, blkH).Intersect(image.Rect(0, 0, d.config.Width, d.config.Height))
+	img := d.newImage(rect)
+	if err := d.decode(img, 0, 0, blkW, blkH); err != nil {
+		return nil, nil, err
+	}
+	return img, d.sourceInfo(), nil
+}
+
+// DecodeRegion decodes the part of the first image of the TIFF file in r
+// that lies within rect. Only the strips or tiles that intersect rect are
+// read and decompressed, so that small regions of large images can be
+// decoded cheaply. The bounds of the returned image are rect clipped to the
+// bounds of the full image; it is an error if they are empty.
+func DecodeRegion(r io.ReaderAt, rect image.Rectangle) (image.Image, error) {
+	h, ifdOffset, err := readHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	d, err := newIFDDecoder(r, h, ifdOffset, nil)
+	if err != nil {
+		return nil, err
+	}
+	return d.decodeRegion(rect)
+}
+
+// decodeRegion decodes the part of the decoder's image that lies within
+// rect, as described for DecodeRegion.
+func (d *decoder) decodeRegion(rect image.Rectangle) (image.Image, error) {
+	l, err := d.layout()
+	if err != nil {
+		return nil, err
+	}
+	full := image.Rect(0, 0, d.config.Width, d.config.Height)
+	if !rect.Overlaps(full) || l.across == 0 || l.down == 0 {
+		return nil, fmt.Errorf("tiff: region %v does not intersect the image bounds %v", rect, full)
+	}
+	rect = rect.Intersect(full)
+
+	// The image is decoded into a buffer covering the blocks that
+	// intersect rect, so that their pixels can be written as a whole.
+	blocks := image.Rect(
+		rect.Min.X/l.width, rect.Min.Y/l.height,
+		(rect.Max.X+l.width-1)/l.width, (rect.Max.Y+l.height-1)/l.height,
+	)
+	img := d.newImage(image.Rect(
+		blocks.Min.X*l.width, blocks.Min.Y*l.height,
+		blocks.Max.X*l.width, blocks.Max.Y*l.height,
+	).Intersect(full))
+	err = d.decodeBlockRange(l, blocks, func(d *decoder, xmin, ymin, xmax, ymax int) error {
+		return d.decode(img, xmin, ymin, xmax, ymax)
+	})
+	if err != nil {
+		return nil, err
 	}
-	return
+	return img.(interface {
+		SubImage(image.Rectangle) image.Image
+	}).SubImage(rect), nil
 }
 
 func init() {
-	image.RegisterFormat("tiff", leHeader, Decode, DecodeConfig)
-	image.RegisterFormat("tiff", beHeader, Decode, DecodeConfig)
+	image.RegisterFormat("tiff", leHeader, decodeLimited, decodeConfigLimited)
+	image.RegisterFormat("tiff", beHeader, decodeLimited, decodeConfigLimited)
+	image.RegisterFormat("tiff", bigLEHeader, decodeLimited, decodeConfigLimited)
+	image.RegisterFormat("tiff", bigBEHeader, decodeLimited, decodeConfigLimited)
 }
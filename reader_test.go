@@ -6,16 +6,25 @@ package tiff
 
 import (
 	"bytes"
+	"compress/zlib"
+	"context"
 	"encoding/binary"
 	"encoding/hex"
 	"errors"
 	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"io"
 	"io/ioutil"
 	"os"
+	"reflect"
 	"strings"
 	"testing"
 
 	_ "image/png"
+
+	"github.com/hhrutter/lzw"
 )
 
 const testdataDir = "testdata/"
@@ -69,7 +78,7 @@ func TestUnpackBits(t *testing.T) {
 		"\xaa\xaa\xaa\x80\x00\x2a\xaa\xaa\xaa\xaa\x80\x00\x2a\x22\xaa\xaa\xaa\xaa\xaa\xaa\xaa\xaa\xaa\xaa",
 	}}
 	for _, u := range unpackBitsTests {
-		buf, err := unpackBits(strings.NewReader(u.compressed))
+		buf, err := unpackBits(strings.NewReader(u.compressed), nil, len(u.uncompressed))
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -401,22 +410,2202 @@ func TestLargeIFDEntry(t *testing.T) {
 	}
 }
 
-// benchmarkDecode benchmarks the decoding of an image.
-func benchmarkDecode(b *testing.B, filename string) {
-	b.StopTimer()
-	contents, err := ioutil.ReadFile(testdataDir + filename)
+// testPage is a page of a TIFF file built by buildTIFF. The StripOffsets
+// and TileOffsets entries of ifd are relative to the start of data, except
+// for those of blocks with a zero byte count.
+type testPage struct {
+	data []byte
+	ifd  []ifdEntry
+}
+
+// buildTIFF returns a little-endian TIFF file with the given pages. The data
+// of each page is followed by its IFD.
+func buildTIFF(t testing.TB, pages ...testPage) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(leHeader)
+	binary.Write(&buf, binary.LittleEndian, uint32(8+len(pages[0].data)))
+	for i, p := range pages {
+		base := buf.Len()
+		buf.Write(p.data)
+		ifd := make([]ifdEntry, len(p.ifd))
+		copy(ifd, p.ifd)
+		for j, e := range ifd {
+			var counts int
+			switch e.tag {
+			case tStripOffsets:
+				counts = tStripByteCounts
+			case tTileOffsets:
+				counts = tTileByteCounts
+			default:
+				continue
+			}
+			data := make([]uint32, len(e.data))
+			for k, off := range e.data {
+				data[k] = off
+				for _, c := range ifd {
+					if c.tag == counts && c.data[k] != 0 {
+						data[k] += uint32(base)
+					}
+				}
+			}
+			ifd[j].data = data
+		}
+		var ifdBuf bytes.Buffer
+		if err := writeIFD(&ifdBuf, int64(buf.Len()), ifd, 0, enc, false); err != nil {
+			t.Fatal(err)
+		}
+		b := ifdBuf.Bytes()
+		if i+1 < len(pages) {
+			next := buf.Len() + len(b) + len(pages[i+1].data)
+			binary.LittleEndian.PutUint32(b[2+ifdLen*len(ifd):], uint32(next))
+		}
+		buf.Write(b)
+	}
+	return buf.Bytes()
+}
+
+// TestDecodeSparseTiles tests that tiles with a zero byte count, as written
+// by GDAL for sparse images, decode as zero samples.
+func TestDecodeSparseTiles(t *testing.T) {
+	const size, tileSize = 32, 16
+	tile := bytes.Repeat([]byte{0x80}, tileSize*tileSize)
+	data := append(append([]byte(nil), tile...), tile...)
+	b := buildTIFF(t, testPage{data, []ifdEntry{
+		{tImageWidth, dtShort, []uint32{size}},
+		{tImageLength, dtShort, []uint32{size}},
+		{tBitsPerSample, dtShort, []uint32{8}},
+		{tCompression, dtShort, []uint32{cNone}},
+		{tPhotometricInterpretation, dtShort, []uint32{pBlackIsZero}},
+		{tSamplesPerPixel, dtShort, []uint32{1}},
+		{tTileWidth, dtShort, []uint32{tileSize}},
+		{tTileLength, dtShort, []uint32{tileSize}},
+		// The top right and bottom left tiles are sparse.
+		{tTileOffsets, dtLong, []uint32{0, 0, 0, tileSize * tileSize}},
+		{tTileByteCounts, dtLong, []uint32{tileSize * tileSize, 0, 0, tileSize * tileSize}},
+	}})
+	img, err := Decode(bytes.NewReader(b))
 	if err != nil {
-		b.Fatal(err)
+		t.Fatal(err)
 	}
-	r := &buffer{buf: contents}
-	b.StartTimer()
-	for i := 0; i < b.N; i++ {
-		_, err := Decode(r)
+	m := img.(*image.Gray)
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			want := uint8(0x80)
+			if (x < tileSize) != (y < tileSize) {
+				want = 0
+			}
+			if got := m.GrayAt(x, y).Y; got != want {
+				t.Fatalf("pixel at (%d, %d): got %#x, want %#x", x, y, got, want)
+			}
+		}
+	}
+}
+
+// TestDecodePaddedTiles tests that images whose edge tiles extend past the
+// image are decoded correctly for sample layouts with a row length other
+// than a whole number of 8-bit pixels.
+func TestDecodePaddedTiles(t *testing.T) {
+	const w, h, tileSize = 20, 18, 16
+	for _, tc := range []struct {
+		name        string
+		photometric uint32
+		bits        uint32
+		samples     int
+		want        func(s []uint32) color.Color
+	}{
+		{"gray1", pBlackIsZero, 1, 1, func(s []uint32) color.Color { return color.Gray{uint8(s[0] * 0xff)} }},
+		{"gray16", pBlackIsZero, 16, 1, func(s []uint32) color.Color { return color.Gray16{uint16(s[0])} }},
+		{"paletted1", pPaletted, 1, 1, func(s []uint32) color.Color {
+			v := uint16(s[0] * 0xffff)
+			return color.RGBA64{v, v, v, 0xffff}
+		}},
+		{"rgb16", pRGB, 16, 3, func(s []uint32) color.Color {
+			return color.RGBA64{uint16(s[0]), uint16(s[1]), uint16(s[2]), 0xffff}
+		}},
+	} {
+		max := uint32(1)<<tc.bits - 1
+		sample := func(x, y, i int) uint32 {
+			if x >= w || y >= h {
+				// Padding, which must not show up in the image.
+				return max
+			}
+			return uint32(x*7+y*13+i*5) % (max + 1)
+		}
+		rowLen := (tileSize*tc.samples*int(tc.bits) + 7) / 8
+		var data []byte
+		var offsets, counts []uint32
+		for ty := 0; ty < h; ty += tileSize {
+			for tx := 0; tx < w; tx += tileSize {
+				offsets = append(offsets, uint32(len(data)))
+				counts = append(counts, uint32(tileSize*rowLen))
+				for y := ty; y < ty+tileSize; y++ {
+					row := make([]byte, rowLen)
+					for k := 0; k < tileSize*tc.samples; k++ {
+						v := sample(tx+k/tc.samples, y, k%tc.samples)
+						if tc.bits == 16 {
+							binary.LittleEndian.PutUint16(row[2*k:], uint16(v))
+							continue
+						}
+						bit := k * int(tc.bits)
+						row[bit/8] |= byte(v << (8 - tc.bits - uint32(bit%8)))
+					}
+					data = append(data, row...)
+				}
+			}
+		}
+		bits := make([]uint32, tc.samples)
+		for i := range bits {
+			bits[i] = tc.bits
+		}
+		ifd := []ifdEntry{
+			{tImageWidth, dtShort, []uint32{w}},
+			{tImageLength, dtShort, []uint32{h}},
+			{tBitsPerSample, dtShort, bits},
+			{tPhotometricInterpretation, dtShort, []uint32{tc.photometric}},
+			{tSamplesPerPixel, dtShort, []uint32{uint32(tc.samples)}},
+			{tTileWidth, dtShort, []uint32{tileSize}},
+			{tTileLength, dtShort, []uint32{tileSize}},
+			{tTileOffsets, dtLong, offsets},
+			{tTileByteCounts, dtLong, counts},
+		}
+		if tc.photometric == pPaletted {
+			colorMap := []uint32{0, 0xffff, 0, 0xffff, 0, 0xffff}
+			ifd = append(ifd, ifdEntry{tColorMap, dtShort, colorMap})
+		}
+		img, err := Decode(bytes.NewReader(buildTIFF(t, testPage{data, ifd})))
 		if err != nil {
-			b.Fatal("Decode:", err)
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if got := img.Bounds(); got != image.Rect(0, 0, w, h) {
+			t.Errorf("%s: got bounds %v", tc.name, got)
+			continue
+		}
+	loop:
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				s := make([]uint32, tc.samples)
+				for i := range s {
+					s[i] = sample(x, y, i)
+				}
+				r0, g0, b0, a0 := tc.want(s).RGBA()
+				r1, g1, b1, a1 := img.At(x, y).RGBA()
+				if r0 != r1 || g0 != g1 || b0 != b1 || a0 != a1 {
+					t.Errorf("%s: pixel at (%d, %d): got %v, want %v", tc.name, x, y, img.At(x, y), tc.want(s))
+					break loop
+				}
+			}
 		}
 	}
 }
 
-func BenchmarkDecodeCompressed(b *testing.B)   { benchmarkDecode(b, "video-001.tiff") }
-func BenchmarkDecodeUncompressed(b *testing.B) { benchmarkDecode(b, "video-001-uncompressed.tiff") }
+// splitJPEG splits the JPEG stream b into an abbreviated stream holding its
+// quantization and Huffman tables, as stored in a JPEGTables entry, and the
+// stream of the image without them.
+func splitJPEG(t *testing.T, b []byte) (tables, data []byte) {
+	tables, data = []byte{0xff, 0xd8}, []byte{0xff, 0xd8}
+	for i := 2; ; {
+		if i+4 > len(b) || b[i] != 0xff {
+			t.Fatal("invalid JPEG stream")
+		}
+		if b[i+1] == 0xda {
+			// The scan runs to the end of the stream.
+			data = append(data, b[i:]...)
+			break
+		}
+		n := 2 + (int(b[i+2])<<8 | int(b[i+3]))
+		if b[i+1] == 0xdb || b[i+1] == 0xc4 {
+			tables = append(tables, b[i:i+n]...)
+		} else {
+			data = append(data, b[i:i+n]...)
+		}
+		i += n
+	}
+	return append(tables, 0xff, 0xd9), data
+}
+
+// TestDecodeJPEG tests that JPEG-compressed strips and tiles, with and
+// without shared tables, decode like the JPEG data itself.
+func TestDecodeJPEG(t *testing.T) {
+	src, err := openImage("video-001.tiff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const w, h = 150, 103
+	gray := image.NewGray(src.Bounds())
+	draw.Draw(gray, gray.Rect, src, image.Point{}, draw.Src)
+
+	for _, tc := range []struct {
+		name        string
+		m           image.Image
+		photometric uint32
+		tile        int // Tile size, or 0 for strips of 32 rows.
+		tables      bool
+	}{
+		{"YCbCr strips", src, pYCbCr, 0, true},
+		{"YCbCr tiles", src, pYCbCr, 64, true},
+		{"YCbCr self-contained", src, pYCbCr, 0, false},
+		{"gray tiles", gray, pBlackIsZero, 32, true},
+	} {
+		blkW, blkH := w, 32
+		if tc.tile != 0 {
+			blkW, blkH = tc.tile, tc.tile
+		}
+		samples := 3
+		if tc.photometric == pBlackIsZero {
+			samples = 1
+		}
+		// want holds the pixels as decoded by image/jpeg.
+		want := image.NewRGBA(src.Bounds())
+		var data, jpegTables []byte
+		var offsets, counts []uint32
+		for y := 0; y < h; y += blkH {
+			for x := 0; x < w; x += blkW {
+				r := image.Rect(x, y, x+blkW, y+blkH)
+				if tc.tile == 0 {
+					r = r.Intersect(src.Bounds())
+				}
+				// The pixels of padded tiles outside the image
+				// are black.
+				blk := image.NewRGBA(r)
+				draw.Draw(blk, r, tc.m, r.Min, draw.Src)
+				var buf bytes.Buffer
+				if tc.photometric == pBlackIsZero {
+					g := image.NewGray(r)
+					draw.Draw(g, r, blk, r.Min, draw.Src)
+					err = jpeg.Encode(&buf, g, nil)
+				} else {
+					err = jpeg.Encode(&buf, blk, nil)
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				b := buf.Bytes()
+				dec, err := jpeg.Decode(bytes.NewReader(b))
+				if err != nil {
+					t.Fatal(err)
+				}
+				draw.Draw(want, r, dec, dec.Bounds().Min, draw.Src)
+				if tc.tables {
+					jpegTables, b = splitJPEG(t, b)
+				}
+				offsets = append(offsets, uint32(len(data)))
+				counts = append(counts, uint32(len(b)))
+				data = append(data, b...)
+			}
+		}
+		bits := []uint32{8, 8, 8}[:samples]
+		ifd := []ifdEntry{
+			{tImageWidth, dtShort, []uint32{w}},
+			{tImageLength, dtShort, []uint32{h}},
+			{tBitsPerSample, dtShort, bits},
+			{tCompression, dtShort, []uint32{cJPEG}},
+			{tPhotometricInterpretation, dtShort, []uint32{tc.photometric}},
+			{tSamplesPerPixel, dtShort, []uint32{uint32(samples)}},
+		}
+		if tc.tile != 0 {
+			ifd = append(ifd,
+				ifdEntry{tTileWidth, dtShort, []uint32{uint32(blkW)}},
+				ifdEntry{tTileLength, dtShort, []uint32{uint32(blkH)}},
+				ifdEntry{tTileOffsets, dtLong, offsets},
+				ifdEntry{tTileByteCounts, dtLong, counts},
+			)
+		} else {
+			ifd = append(ifd,
+				ifdEntry{tStripOffsets, dtLong, offsets},
+				ifdEntry{tRowsPerStrip, dtShort, []uint32{uint32(blkH)}},
+				ifdEntry{tStripByteCounts, dtLong, counts},
+			)
+		}
+		if tc.tables {
+			tables := make([]uint32, len(jpegTables))
+			for i, v := range jpegTables {
+				tables[i] = uint32(v)
+			}
+			ifd = append(ifd, ifdEntry{tJPEGTables, dtUndefined, tables})
+		}
+		img, err := Decode(bytes.NewReader(buildTIFF(t, testPage{data, ifd})))
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		compare(t, want, img)
+	}
+}
+
+// TestDecodeJPEGFrameSize tests that the JPEG stream of a tile whose frame
+// header claims more pixels than the tile is rejected before image/jpeg
+// allocates the frame.
+func TestDecodeJPEGFrameSize(t *testing.T) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 8, 8)), nil); err != nil {
+		t.Fatal(err)
+	}
+	b := buf.Bytes()
+	sof := bytes.Index(b, []byte{0xff, 0xc0})
+	if sof < 0 {
+		t.Fatal("no SOF0 marker")
+	}
+	// The frame header holds the height and the width after the
+	// precision.
+	copy(b[sof+5:], []byte{0xff, 0xf0, 0xff, 0xf0})
+	ifd := []ifdEntry{
+		{tImageWidth, dtShort, []uint32{8}},
+		{tImageLength, dtShort, []uint32{8}},
+		{tBitsPerSample, dtShort, []uint32{8}},
+		{tCompression, dtShort, []uint32{cJPEG}},
+		{tPhotometricInterpretation, dtShort, []uint32{pBlackIsZero}},
+		{tTileWidth, dtShort, []uint32{8}},
+		{tTileLength, dtShort, []uint32{8}},
+		{tTileOffsets, dtLong, []uint32{0}},
+		{tTileByteCounts, dtLong, []uint32{uint32(len(b))}},
+	}
+	_, err := DecodeWithOptions(bytes.NewReader(buildTIFF(t, testPage{b, ifd})), &DecodeOptions{MaxMemory: 1 << 20})
+	if _, ok := err.(FormatError); !ok {
+		t.Errorf("got error %v, want a FormatError", err)
+	}
+
+	// A 64x64 tile needs 4096 bytes for the image and for the block
+	// buffer, which leave too little of 10000 bytes for the frame.
+	buf.Reset()
+	if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 64, 64)), nil); err != nil {
+		t.Fatal(err)
+	}
+	b = buf.Bytes()
+	ifd[0].data, ifd[1].data = []uint32{64}, []uint32{64}
+	ifd[5].data, ifd[6].data = []uint32{64}, []uint32{64}
+	ifd[8].data = []uint32{uint32(len(b))}
+	tiff := buildTIFF(t, testPage{b, ifd})
+	if _, err := DecodeWithOptions(bytes.NewReader(tiff), &DecodeOptions{MaxMemory: 10000}); err == nil {
+		t.Error("got nil error for a JPEG frame exceeding MaxMemory")
+	}
+	if _, err := DecodeWithOptions(bytes.NewReader(tiff), &DecodeOptions{MaxMemory: 1 << 20}); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestDecodeConfigFullOrientation tests that DecodeConfigFull reports the
+// Orientation tag, defaulting to 1 if it is absent.
+func TestDecodeConfigFullOrientation(t *testing.T) {
+	ifd := []ifdEntry{
+		{tImageWidth, dtShort, []uint32{3}},
+		{tImageLength, dtShort, []uint32{2}},
+		{tBitsPerSample, dtShort, []uint32{8}},
+		{tPhotometricInterpretation, dtShort, []uint32{pBlackIsZero}},
+		{tStripOffsets, dtLong, []uint32{0}},
+		{tRowsPerStrip, dtShort, []uint32{2}},
+		{tStripByteCounts, dtLong, []uint32{6}},
+	}
+	data := make([]byte, 6)
+	for _, want := range []int{1, 6, 8} {
+		entries := ifd
+		if want != 1 {
+			entries = append(append([]ifdEntry(nil), ifd...), ifdEntry{tOrientation, dtShort, []uint32{uint32(want)}})
+		}
+		c, err := DecodeConfigFull(bytes.NewReader(buildTIFF(t, testPage{data, entries})))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.Orientation != want {
+			t.Errorf("got orientation %d, want %d", c.Orientation, want)
+		}
+		if c.Width != 3 || c.Height != 2 {
+			t.Errorf("got size %dx%d, want 3x2", c.Width, c.Height)
+		}
+	}
+}
+
+// TestDecodeConfigFullLayout tests that DecodeConfigFull reports the sample
+// format and storage layout of the first page and the number of pages.
+func TestDecodeConfigFullLayout(t *testing.T) {
+	for _, tc := range []struct {
+		m     image.Image
+		opts  *Options
+		pages int
+		want  SourceInfo
+	}{
+		{
+			image.NewNRGBA(image.Rect(0, 0, 40, 20)), &Options{Compression: LZW, TileWidth: 16, TileLength: 16}, 1,
+			SourceInfo{Compression: cLZW, Photometric: pRGB, BitsPerSample: []int{8, 8, 8, 8}, SamplesPerPixel: 4, TileWidth: 16, TileLength: 16, Blocks: 6},
+		},
+		{
+			image.NewGray16(image.Rect(0, 0, 7, 5)), &Options{Compression: Deflate}, 3,
+			SourceInfo{Compression: cDeflate, Photometric: pBlackIsZero, BitsPerSample: []int{16}, SamplesPerPixel: 1, RowsPerStrip: 5, Blocks: 1},
+		},
+	} {
+		var buf bytes.Buffer
+		e := NewEncoder(&buf)
+		for i := 0; i < tc.pages; i++ {
+			if err := e.WriteImage(tc.m, tc.opts); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := e.Close(); err != nil {
+			t.Fatal(err)
+		}
+		c, err := DecodeConfigFull(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.Pages != tc.pages {
+			t.Errorf("%T: got %d pages, want %d", tc.m, c.Pages, tc.pages)
+		}
+		got := SourceInfo{
+			Compression:     c.Compression,
+			Photometric:     c.Photometric,
+			BitsPerSample:   c.BitsPerSample,
+			SamplesPerPixel: c.SamplesPerPixel,
+			TileWidth:       c.TileWidth,
+			TileLength:      c.TileLength,
+			RowsPerStrip:    c.RowsPerStrip,
+			Blocks:          c.Blocks,
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%T: got %+v, want %+v", tc.m, got, tc.want)
+		}
+		if c.SampleFormat != sfUint || c.PlanarConfig != pcChunky {
+			t.Errorf("%T: got SampleFormat %d and PlanarConfig %d", tc.m, c.SampleFormat, c.PlanarConfig)
+		}
+	}
+}
+
+// multiPageTIFF returns a TIFF file with three pages: an uncompressed 4x3
+// gray image, a Deflate-compressed 2x2 gray image and an uncompressed 3x1 RGB
+// image.
+func multiPageTIFF(t *testing.T) []byte {
+	var deflated bytes.Buffer
+	zw := zlib.NewWriter(&deflated)
+	zw.Write([]byte{0x10, 0x20, 0x30, 0x40})
+	zw.Close()
+	gray := func(w, h, compression, n int) []ifdEntry {
+		return []ifdEntry{
+			{tImageWidth, dtShort, []uint32{uint32(w)}},
+			{tImageLength, dtShort, []uint32{uint32(h)}},
+			{tBitsPerSample, dtShort, []uint32{8}},
+			{tCompression, dtShort, []uint32{uint32(compression)}},
+			{tPhotometricInterpretation, dtShort, []uint32{pBlackIsZero}},
+			{tStripOffsets, dtLong, []uint32{0}},
+			{tRowsPerStrip, dtShort, []uint32{uint32(h)}},
+			{tStripByteCounts, dtLong, []uint32{uint32(n)}},
+		}
+	}
+	return buildTIFF(t,
+		testPage{bytes.Repeat([]byte{0x7f}, 12), gray(4, 3, cNone, 12)},
+		testPage{deflated.Bytes(), gray(2, 2, cDeflate, deflated.Len())},
+		testPage{[]byte{0xff, 0, 0, 0, 0xff, 0, 0, 0, 0xff}, []ifdEntry{
+			{tImageWidth, dtShort, []uint32{3}},
+			{tImageLength, dtShort, []uint32{1}},
+			{tBitsPerSample, dtShort, []uint32{8, 8, 8}},
+			{tCompression, dtShort, []uint32{cNone}},
+			{tPhotometricInterpretation, dtShort, []uint32{pRGB}},
+			{tStripOffsets, dtLong, []uint32{0}},
+			{tSamplesPerPixel, dtShort, []uint32{3}},
+			{tRowsPerStrip, dtShort, []uint32{1}},
+			{tStripByteCounts, dtLong, []uint32{9}},
+		}},
+	)
+}
+
+// TestDecodeAll tests that DecodeAll returns all pages of a multi-page file.
+func TestDecodeAll(t *testing.T) {
+	imgs, err := DecodeAll(bytes.NewReader(multiPageTIFF(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []image.Rectangle{image.Rect(0, 0, 4, 3), image.Rect(0, 0, 2, 2), image.Rect(0, 0, 3, 1)}
+	if len(imgs) != len(want) {
+		t.Fatalf("got %d images, want %d", len(imgs), len(want))
+	}
+	for i, img := range imgs {
+		if img.Bounds() != want[i] {
+			t.Errorf("image %d: got bounds %v, want %v", i, img.Bounds(), want[i])
+		}
+	}
+	if g := imgs[1].(*image.Gray); g.GrayAt(1, 1).Y != 0x40 {
+		t.Errorf("image 1: got pixel %#x at (1, 1), want 0x40", g.GrayAt(1, 1).Y)
+	}
+}
+
+// TestReader tests that a Reader returns the pages of a file one at a time
+// and io.EOF after the last one.
+func TestReader(t *testing.T) {
+	var pages []image.Image
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	for i := 0; i < 3; i++ {
+		m := image.NewGray(image.Rect(0, 0, 4+i, 3))
+		for j := range m.Pix {
+			m.Pix[j] = uint8(i*50 + j)
+		}
+		pages = append(pages, m)
+		if err := e.WriteImage(m, &Options{Compression: LZW}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+	b := buf.Bytes()
+
+	r, err := NewReader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range pages {
+		got, err := r.Next()
+		if err != nil {
+			t.Fatalf("page %d: %v", i, err)
+		}
+		compare(t, want, got)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := r.Next(); err != io.EOF {
+			t.Errorf("after the last page: got error %v, want io.EOF", err)
+		}
+	}
+
+	// Make the last IFD point back to the first one.
+	var offsets []int64
+	WalkIFDs(bytes.NewReader(b), func(offset int64, tags map[uint16]Tag) error {
+		offsets = append(offsets, offset)
+		return nil
+	})
+	last := offsets[len(offsets)-1]
+	n := int64(binary.LittleEndian.Uint16(b[last:]))
+	binary.LittleEndian.PutUint32(b[last+2+n*ifdLen:], uint32(offsets[0]))
+	r, err = NewReader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < len(pages); i++ {
+		if _, err := r.Next(); err != nil {
+			t.Fatalf("page %d: %v", i, err)
+		}
+	}
+	if _, err := r.Next(); err == nil || err == io.EOF {
+		t.Errorf("loop in the IFD chain: got error %v", err)
+	}
+}
+
+// TestReaderSubImages tests that the SubIFDs of a page can be decoded
+// without decoding the page.
+func TestReaderSubImages(t *testing.T) {
+	gray := func(w, h uint32, offset uint32) []ifdEntry {
+		return []ifdEntry{
+			{tImageWidth, dtShort, []uint32{w}},
+			{tImageLength, dtShort, []uint32{h}},
+			{tBitsPerSample, dtShort, []uint32{8}},
+			{tPhotometricInterpretation, dtShort, []uint32{pBlackIsZero}},
+			{tStripOffsets, dtLong, []uint32{offset}},
+			{tRowsPerStrip, dtShort, []uint32{h}},
+			{tStripByteCounts, dtLong, []uint32{w * h}},
+		}
+	}
+	// The file holds the pixel data of the page and its two SubIFDs,
+	// followed by the IFD of the page and the SubIFDs.
+	var data []byte
+	var want []*image.Gray
+	for _, size := range []int{8, 4, 2} {
+		m := image.NewGray(image.Rect(0, 0, size, size))
+		for i := range m.Pix {
+			m.Pix[i] = uint8(size*16 + i)
+		}
+		want = append(want, m)
+		data = append(data, m.Pix...)
+	}
+	sub1 := gray(4, 4, 8+64)
+	sub2 := gray(2, 2, 8+64+16)
+	page := append(gray(8, 8, 0), ifdEntry{tSubIFDs, dtLong, []uint32{0, 0}})
+	off1 := 8 + int64(len(data)) + ifdSize(page, false)
+	off2 := off1 + ifdSize(sub1, false)
+	page[len(page)-1].data = []uint32{uint32(off1), uint32(off2)}
+	b := buildTIFF(t, testPage{data, page})
+	buf := bytes.NewBuffer(b)
+	if err := writeIFD(buf, off1, sub1, 0, enc, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeIFD(buf, off2, sub2, 0, enc, false); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	subs, err := r.SubImages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(subs) != 2 {
+		t.Fatalf("got %d SubIFDs, want 2", len(subs))
+	}
+	// The SubIFDs are decoded in reverse order, before the page.
+	for i := len(subs) - 1; i >= 0; i-- {
+		m, err := subs[i].Next()
+		if err != nil {
+			t.Fatalf("SubIFD %d: %v", i, err)
+		}
+		compare(t, want[i+1], m)
+		if _, err := subs[i].Next(); err != io.EOF {
+			t.Errorf("SubIFD %d: after the last image: got error %v, want io.EOF", i, err)
+		}
+	}
+	m, err := r.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	compare(t, want[0], m)
+	if subs, err := r.SubImages(); len(subs) != 0 || err != io.EOF {
+		t.Errorf("after the last page: got %d SubIFDs, error %v", len(subs), err)
+	}
+}
+
+// TestDecodeAllPageFilter tests that DecodeAllWithOptions skips the pages
+// rejected by PageFilter.
+func TestDecodeAllPageFilter(t *testing.T) {
+	page := func(w, h, subfileTag, subfileType uint32) testPage {
+		return testPage{make([]byte, w*h), []ifdEntry{
+			{int(subfileTag), dtLong, []uint32{subfileType}},
+			{tImageWidth, dtShort, []uint32{w}},
+			{tImageLength, dtShort, []uint32{h}},
+			{tBitsPerSample, dtShort, []uint32{8}},
+			{tPhotometricInterpretation, dtShort, []uint32{pBlackIsZero}},
+			{tStripOffsets, dtLong, []uint32{0}},
+			{tRowsPerStrip, dtShort, []uint32{h}},
+			{tStripByteCounts, dtLong, []uint32{w * h}},
+		}}
+	}
+	b := buildTIFF(t,
+		page(8, 6, tNewSubfileType, 0),
+		page(4, 3, tNewSubfileType, 1), // Thumbnail of the first page.
+		page(8, 6, tNewSubfileType, 2),
+		page(4, 3, tSubfileType, 2), // Reduced resolution, old style.
+	)
+	fullResolution := func(subfileType uint32) bool { return subfileType&1 == 0 }
+	imgs, err := DecodeAllWithOptions(bytes.NewReader(b), &DecodeOptions{PageFilter: fullResolution})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(imgs) != 2 {
+		t.Fatalf("got %d images, want 2", len(imgs))
+	}
+	for i, img := range imgs {
+		if want := image.Rect(0, 0, 8, 6); img.Bounds() != want {
+			t.Errorf("image %d: got bounds %v, want %v", i, img.Bounds(), want)
+		}
+	}
+
+	imgs, err = DecodeAllWithOptions(bytes.NewReader(b), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(imgs) != 4 {
+		t.Errorf("without PageFilter: got %d images, want 4", len(imgs))
+	}
+
+	// A skipped page is not examined, so its unsupported photometric
+	// interpretation causes no error.
+	unsupported := page(4, 3, tNewSubfileType, 1)
+	unsupported.ifd[4].data = []uint32{42}
+	b = buildTIFF(t, page(8, 6, tNewSubfileType, 0), unsupported)
+	if _, err := DecodeAll(bytes.NewReader(b)); err == nil {
+		t.Fatal("DecodeAll: got nil error for an unsupported page")
+	}
+	imgs, err = DecodeAllWithOptions(bytes.NewReader(b), &DecodeOptions{PageFilter: fullResolution})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(imgs) != 1 {
+		t.Errorf("with an unsupported thumbnail: got %d images, want 1", len(imgs))
+	}
+}
+
+// TestDecodePlanarPredictor tests that the horizontal predictor is undone
+// within each plane of an image with PlanarConfiguration 2, giving the same
+// pixels as the chunky equivalent.
+func TestDecodePlanarPredictor(t *testing.T) {
+	const w, h, rowsPerStrip = 5, 4, 2
+	sample := func(x, y, s int) uint8 { return uint8(x*x*7 + y*31 + s*50) }
+
+	// The chunky data holds w*3 predicted samples per row.
+	var chunky []byte
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			for s := 0; s < 3; s++ {
+				v := sample(x, y, s)
+				if x > 0 {
+					v -= sample(x-1, y, s)
+				}
+				chunky = append(chunky, v)
+			}
+		}
+	}
+	// The planar data holds all strips of the red plane, followed by those
+	// of the green and blue planes.
+	var planar []byte
+	var offsets, counts []uint32
+	for s := 0; s < 3; s++ {
+		for y0 := 0; y0 < h; y0 += rowsPerStrip {
+			offsets = append(offsets, uint32(len(planar)))
+			counts = append(counts, w*rowsPerStrip)
+			for y := y0; y < y0+rowsPerStrip; y++ {
+				for x := 0; x < w; x++ {
+					v := sample(x, y, s)
+					if x > 0 {
+						v -= sample(x-1, y, s)
+					}
+					planar = append(planar, v)
+				}
+			}
+		}
+	}
+
+	ifd := func(planarConfig int, offsets, counts []uint32) []ifdEntry {
+		return []ifdEntry{
+			{tImageWidth, dtShort, []uint32{w}},
+			{tImageLength, dtShort, []uint32{h}},
+			{tBitsPerSample, dtShort, []uint32{8, 8, 8}},
+			{tPhotometricInterpretation, dtShort, []uint32{pRGB}},
+			{tStripOffsets, dtLong, offsets},
+			{tSamplesPerPixel, dtShort, []uint32{3}},
+			{tRowsPerStrip, dtShort, []uint32{rowsPerStrip}},
+			{tStripByteCounts, dtLong, counts},
+			{tPlanarConfiguration, dtShort, []uint32{uint32(planarConfig)}},
+			{tPredictor, dtShort, []uint32{prHorizontal}},
+		}
+	}
+	img0, err := Decode(bytes.NewReader(buildTIFF(t, testPage{chunky, ifd(pcChunky,
+		[]uint32{0, w * 3 * rowsPerStrip}, []uint32{w * 3 * rowsPerStrip, w * 3 * rowsPerStrip})})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	img1, err := Decode(bytes.NewReader(buildTIFF(t, testPage{planar, ifd(pcPlanar, offsets, counts)})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	compare(t, img0, img1)
+	if c := img1.(*image.RGBA).RGBAAt(4, 3); c.R != sample(4, 3, 0) || c.G != sample(4, 3, 1) || c.B != sample(4, 3, 2) {
+		t.Errorf("got %v at (4, 3), want %d %d %d", c, sample(4, 3, 0), sample(4, 3, 1), sample(4, 3, 2))
+	}
+}
+
+// TestDecodeGrayAlpha tests decoding gray images with an alpha sample.
+func TestDecodeGrayAlpha(t *testing.T) {
+	for _, tc := range []struct {
+		photometric, extra, bps uint32
+		data                    []byte
+		want                    color.Color
+	}{
+		{pBlackIsZero, 2, 8, []byte{0x40, 0x80}, GrayA{0x40, 0x80}},
+		{pWhiteIsZero, 2, 8, []byte{0x40, 0x80}, GrayA{0xbf, 0x80}},
+		{pWhiteIsZero, 1, 8, []byte{0x20, 0x80}, color.RGBA{0x60, 0x60, 0x60, 0x80}},
+		{pBlackIsZero, 1, 16, []byte{0x34, 0x12, 0x00, 0x80}, color.RGBA64{0x1234, 0x1234, 0x1234, 0x8000}},
+		{pBlackIsZero, 2, 16, []byte{0x34, 0x12, 0x00, 0x80}, GrayA16{0x1234, 0x8000}},
+	} {
+		b := buildTIFF(t, testPage{tc.data, []ifdEntry{
+			{tImageWidth, dtShort, []uint32{1}},
+			{tImageLength, dtShort, []uint32{1}},
+			{tBitsPerSample, dtShort, []uint32{tc.bps, tc.bps}},
+			{tPhotometricInterpretation, dtShort, []uint32{tc.photometric}},
+			{tStripOffsets, dtLong, []uint32{0}},
+			{tSamplesPerPixel, dtShort, []uint32{2}},
+			{tRowsPerStrip, dtShort, []uint32{1}},
+			{tStripByteCounts, dtLong, []uint32{uint32(len(tc.data))}},
+			{tExtraSamples, dtShort, []uint32{tc.extra}},
+		}})
+		m, err := Decode(bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := m.At(0, 0); got != tc.want {
+			t.Errorf("photometric %d, extra samples %d, %d bits: got %#v, want %#v", tc.photometric, tc.extra, tc.bps, got, tc.want)
+		}
+	}
+}
+
+// TestDecodeAssociatedCMYKA tests that CMYK samples premultiplied by an
+// associated alpha are divided by it, and that unassociated ones are not.
+func TestDecodeAssociatedCMYKA(t *testing.T) {
+	for _, tc := range []struct {
+		extra, bps uint32
+		data       []byte
+		want       color.Color
+	}{
+		{2, 8, []byte{0x10, 0x20, 0x30, 0x90, 0x80}, CMYKA{0x10, 0x20, 0x30, 0x90, 0x80}},
+		{1, 8, []byte{0x10, 0x20, 0x30, 0x90, 0x80}, CMYKA{0x1f, 0x3f, 0x5f, 0xff, 0x80}},
+		{1, 8, []byte{0x10, 0x20, 0x30, 0x40, 0x00}, CMYKA{}},
+		{1, 16, []byte{0x00, 0x10, 0x00, 0x20, 0x00, 0x30, 0x00, 0x40, 0x00, 0x80}, CMYKA64{0x1fff, 0x3fff, 0x5fff, 0x7fff, 0x8000}},
+	} {
+		bps := []uint32{tc.bps, tc.bps, tc.bps, tc.bps, tc.bps}
+		b := buildTIFF(t, testPage{tc.data, []ifdEntry{
+			{tImageWidth, dtShort, []uint32{1}},
+			{tImageLength, dtShort, []uint32{1}},
+			{tBitsPerSample, dtShort, bps},
+			{tPhotometricInterpretation, dtShort, []uint32{pCMYK}},
+			{tStripOffsets, dtLong, []uint32{0}},
+			{tSamplesPerPixel, dtShort, []uint32{5}},
+			{tRowsPerStrip, dtShort, []uint32{1}},
+			{tStripByteCounts, dtLong, []uint32{uint32(len(tc.data))}},
+			{tExtraSamples, dtShort, []uint32{tc.extra}},
+		}})
+		m, err := Decode(bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := m.At(0, 0); got != tc.want {
+			t.Errorf("extra samples %d, %d bits, %v: got %#v, want %#v", tc.extra, tc.bps, tc.data, got, tc.want)
+		}
+	}
+}
+
+// TestDecodePlanar tests that images stored with PlanarConfiguration 2, in
+// strips or tiles, decode to the same pixels as their chunky equivalent.
+func TestDecodePlanar(t *testing.T) {
+	const w, h = 20, 18
+	sample := func(x, y, s int) uint16 { return uint16(x*977 + y*131 + s*4099) }
+	for _, tc := range []struct {
+		name                         string
+		photometric, bps, spp, extra uint32
+		tiled                        bool
+	}{
+		{"gray+alpha", pBlackIsZero, 8, 2, 2, false},
+		{"gray16+alpha", pWhiteIsZero, 16, 2, 1, true},
+		{"rgb16", pRGB, 16, 3, 0, true},
+		{"rgba", pRGB, 8, 4, 2, false},
+		{"cmyk", pCMYK, 8, 4, 0, true},
+		{"cmyka", pCMYK, 8, 5, 1, false},
+	} {
+		build := func(planarConfig uint32) []byte {
+			bw, bh := w, 7
+			if tc.tiled {
+				bw, bh = 16, 16
+			}
+			planes := 1
+			if planarConfig == pcPlanar {
+				planes = int(tc.spp)
+			}
+			var data []byte
+			var offsets, counts []uint32
+			for p := 0; p < planes; p++ {
+				for y0 := 0; y0 < h; y0 += bh {
+					for x0 := 0; x0 < w; x0 += bw {
+						offsets = append(offsets, uint32(len(data)))
+						start := len(data)
+						y1 := y0 + bh
+						if !tc.tiled {
+							y1 = minInt(y1, h)
+						}
+						for y := y0; y < y1; y++ {
+							for x := x0; x < x0+bw; x++ {
+								for s := 0; s < int(tc.spp); s++ {
+									if planes > 1 && s != p {
+										continue
+									}
+									var v uint16
+									if x < w && y < h {
+										v = sample(x, y, s)
+									}
+									if tc.bps == 16 {
+										data = append(data, uint8(v), uint8(v>>8))
+									} else {
+										data = append(data, uint8(v))
+									}
+								}
+							}
+						}
+						counts = append(counts, uint32(len(data)-start))
+					}
+				}
+			}
+			bps := make([]uint32, tc.spp)
+			for i := range bps {
+				bps[i] = tc.bps
+			}
+			ifd := []ifdEntry{
+				{tImageWidth, dtShort, []uint32{w}},
+				{tImageLength, dtShort, []uint32{h}},
+				{tBitsPerSample, dtShort, bps},
+				{tPhotometricInterpretation, dtShort, []uint32{tc.photometric}},
+			}
+			if tc.tiled {
+				ifd = append(ifd,
+					ifdEntry{tSamplesPerPixel, dtShort, []uint32{tc.spp}},
+					ifdEntry{tPlanarConfiguration, dtShort, []uint32{planarConfig}},
+					ifdEntry{tTileWidth, dtShort, []uint32{uint32(bw)}},
+					ifdEntry{tTileLength, dtShort, []uint32{uint32(bh)}},
+					ifdEntry{tTileOffsets, dtLong, offsets},
+					ifdEntry{tTileByteCounts, dtLong, counts},
+				)
+			} else {
+				ifd = append(ifd,
+					ifdEntry{tStripOffsets, dtLong, offsets},
+					ifdEntry{tSamplesPerPixel, dtShort, []uint32{tc.spp}},
+					ifdEntry{tRowsPerStrip, dtShort, []uint32{uint32(bh)}},
+					ifdEntry{tStripByteCounts, dtLong, counts},
+					ifdEntry{tPlanarConfiguration, dtShort, []uint32{planarConfig}},
+				)
+			}
+			if tc.extra != 0 {
+				ifd = append(ifd, ifdEntry{tExtraSamples, dtShort, []uint32{tc.extra}})
+			}
+			return buildTIFF(t, testPage{data, ifd})
+		}
+		chunky, err := Decode(bytes.NewReader(build(pcChunky)))
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		planar, err := Decode(bytes.NewReader(build(pcPlanar)))
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		compare(t, chunky, planar)
+	}
+}
+
+// TestUncompressedSize tests that UncompressedSize and UncompressedSizeAll
+// report the size of the pixel data without decoding it.
+func TestUncompressedSize(t *testing.T) {
+	f, err := os.Open(testdataDir + "video-001.tiff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	// video-001.tiff is a 150x103 RGB image with 8 bits per sample.
+	if n, err := UncompressedSize(f); err != nil || n != 150*103*3 {
+		t.Errorf("video-001.tiff: got %d, %v, want %d", n, err, 150*103*3)
+	}
+
+	// bw-uncompressed.tiff is a 153x55 bilevel image with 20 bytes per row.
+	b, err := ioutil.ReadFile(testdataDir + "bw-uncompressed.tiff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := UncompressedSize(bytes.NewReader(b)); err != nil || n != 20*55 {
+		t.Errorf("bw-uncompressed.tiff: got %d, %v, want %d", n, err, 20*55)
+	}
+
+	if n, err := UncompressedSizeAll(bytes.NewReader(multiPageTIFF(t))); err != nil || n != 12+4+9 {
+		t.Errorf("multi-page file: got %d, %v, want %d", n, err, 12+4+9)
+	}
+}
+
+// TestDecodeLong8 tests that IFD entries of the 64-bit integer types, which
+// belong to BigTIFF, are understood in classic TIFF files, too.
+func TestDecodeLong8(t *testing.T) {
+	data := []byte{1, 2, 3, 4, 5, 6}
+	// The data of an ifdEntry holds each 64-bit value as its low and high
+	// 32 bits.
+	b := buildTIFF(t, testPage{data, []ifdEntry{
+		{tImageWidth, dtShort, []uint32{3}},
+		{tImageLength, dtShort, []uint32{2}},
+		{tBitsPerSample, dtShort, []uint32{8}},
+		{tPhotometricInterpretation, dtShort, []uint32{pBlackIsZero}},
+		{tStripOffsets, dtLong8, []uint32{0, 0}},
+		{tRowsPerStrip, dtShort, []uint32{2}},
+		{tStripByteCounts, dtSLong8, []uint32{6, 0}},
+	}})
+	img, err := Decode(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(img.(*image.Gray).Pix, data) {
+		t.Errorf("got pixels %v, want %v", img.(*image.Gray).Pix, data)
+	}
+}
+
+// TestDecodeASCII tests that ASCII values end at the first NUL byte, or after
+// Count bytes if the NUL byte is missing.
+func TestDecodeASCII(t *testing.T) {
+	for _, tc := range []struct {
+		value, want string
+	}{
+		{"hello", "hello"},
+		{"hello\x00", "hello"},
+		{"two\x00strings\x00", "two"},
+		{"", ""},
+	} {
+		value := make([]uint32, len(tc.value))
+		for i := range value {
+			value[i] = uint32(tc.value[i])
+		}
+		ifd := []ifdEntry{
+			{tImageWidth, dtShort, []uint32{1}},
+			{tImageLength, dtShort, []uint32{1}},
+			{tBitsPerSample, dtShort, []uint32{8}},
+			{tPhotometricInterpretation, dtShort, []uint32{pBlackIsZero}},
+			{tImageDescription, dtASCII, value},
+			{tStripOffsets, dtLong, []uint32{0}},
+			{tRowsPerStrip, dtShort, []uint32{1}},
+			{tStripByteCounts, dtLong, []uint32{1}},
+		}
+		// Place a non-NUL byte right after the value, which must not be
+		// included in the string.
+		b := buildTIFF(t, testPage{[]byte{'x'}, ifd})
+		b = append(b, 'x')
+		c, err := DecodeConfigFull(bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.ImageDescription != tc.want {
+			t.Errorf("%q: got %q, want %q", tc.value, c.ImageDescription, tc.want)
+		}
+	}
+}
+
+// TestDecodeShortLastStrip tests decoding an image whose height is not a
+// multiple of RowsPerStrip, so that the last strip holds fewer rows.
+func TestDecodeShortLastStrip(t *testing.T) {
+	const w, h, rowsPerStrip = 3, 5, 2
+	pix := make([]byte, w*h)
+	for i := range pix {
+		pix[i] = byte(10 * i)
+	}
+	predicted := make([]byte, len(pix))
+	for i := range pix {
+		predicted[i] = pix[i]
+		if i%w != 0 {
+			predicted[i] -= pix[i-1]
+		}
+	}
+	for _, tc := range []struct {
+		name      string
+		data      []byte
+		lastCount uint32
+		predictor uint32
+	}{
+		{"exact", pix, w, prNone},
+		// Some encoders pad the last strip to RowsPerStrip rows.
+		{"padded", append(append([]byte(nil), pix...), make([]byte, w)...), w * rowsPerStrip, prNone},
+		{"predictor", predicted, w, prHorizontal},
+	} {
+		b := buildTIFF(t, testPage{tc.data, []ifdEntry{
+			{tImageWidth, dtShort, []uint32{w}},
+			{tImageLength, dtShort, []uint32{h}},
+			{tBitsPerSample, dtShort, []uint32{8}},
+			{tPhotometricInterpretation, dtShort, []uint32{pBlackIsZero}},
+			{tStripOffsets, dtLong, []uint32{0, w * rowsPerStrip, 2 * w * rowsPerStrip}},
+			{tRowsPerStrip, dtShort, []uint32{rowsPerStrip}},
+			{tStripByteCounts, dtLong, []uint32{w * rowsPerStrip, w * rowsPerStrip, tc.lastCount}},
+			{tPredictor, dtShort, []uint32{tc.predictor}},
+		}})
+		img, err := Decode(bytes.NewReader(b))
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if got := img.(*image.Gray).Pix; !bytes.Equal(got, pix) {
+			t.Errorf("%s: got pixels %v, want %v", tc.name, got, pix)
+		}
+	}
+}
+
+// TestDecodeForcePredictor tests decoding an LZW compressed image to which
+// the horizontal predictor was applied although its Predictor tag is 1.
+// buildBigTIFF returns a BigTIFF file in the given byte order with a single
+// page holding data, which starts at offset 16. The values of ifd must be of
+// the Short, Long, Long8 or ASCII type; values of the Long8 type consist of
+// two words.
+func buildBigTIFF(order binary.ByteOrder, data []byte, ifd []ifdEntry) []byte {
+	var b []byte
+	if order == binary.LittleEndian {
+		b = append(b, bigLEHeader...)
+	} else {
+		b = append(b, bigBEHeader...)
+	}
+	b = append(b, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0)
+	order.PutUint16(b[4:], 8)
+	b = append(b, data...)
+	for len(b)%8 != 0 {
+		b = append(b, 0)
+	}
+	order.PutUint64(b[8:], uint64(len(b)))
+
+	ifdOffset := len(b)
+	b = append(b, make([]byte, 8+bigIFDLen*len(ifd)+8)...)
+	order.PutUint64(b[ifdOffset:], uint64(len(ifd)))
+	for i, e := range ifd {
+		var value []byte
+		for j := 0; j < len(e.data); j++ {
+			switch e.datatype {
+			case dtASCII:
+				value = append(value, byte(e.data[j]))
+			case dtShort:
+				var v [2]byte
+				order.PutUint16(v[:], uint16(e.data[j]))
+				value = append(value, v[:]...)
+			case dtLong:
+				var v [4]byte
+				order.PutUint32(v[:], e.data[j])
+				value = append(value, v[:]...)
+			case dtLong8:
+				var v [8]byte
+				order.PutUint64(v[:], uint64(e.data[j+1])<<32|uint64(e.data[j]))
+				value = append(value, v[:]...)
+				j++
+			}
+		}
+		p := b[ifdOffset+8+bigIFDLen*i:]
+		order.PutUint16(p[0:], uint16(e.tag))
+		order.PutUint16(p[2:], uint16(e.datatype))
+		order.PutUint64(p[4:], uint64(len(e.data)/words(e.datatype)))
+		if len(value) <= 8 {
+			copy(p[12:20], value)
+		} else {
+			order.PutUint64(p[12:], uint64(len(b)))
+			b = append(b, value...)
+		}
+	}
+	return b
+}
+
+// TestDecodeBigTIFF tests that BigTIFF files of both byte orders are
+// decoded, also by image.Decode.
+func TestDecodeBigTIFF(t *testing.T) {
+	data := []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}
+	desc := "a BigTIFF image"
+	value := make([]uint32, len(desc)+1)
+	for i := range desc {
+		value[i] = uint32(desc[i])
+	}
+	ifd := []ifdEntry{
+		{tImageWidth, dtLong, []uint32{2}},
+		{tImageLength, dtShort, []uint32{2}},
+		{tBitsPerSample, dtShort, []uint32{8, 8, 8}},
+		{tPhotometricInterpretation, dtShort, []uint32{pRGB}},
+		{tImageDescription, dtASCII, value},
+		{tStripOffsets, dtLong8, []uint32{16, 0}},
+		{tSamplesPerPixel, dtShort, []uint32{3}},
+		{tRowsPerStrip, dtShort, []uint32{2}},
+		{tStripByteCounts, dtLong8, []uint32{12, 0}},
+	}
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		b := buildBigTIFF(order, data, ifd)
+		c, err := DecodeConfigFull(bytes.NewReader(b))
+		if err != nil {
+			t.Fatalf("%v: %v", order, err)
+		}
+		if c.Width != 2 || c.Height != 2 || c.ImageDescription != desc {
+			t.Errorf("%v: got %dx%d, description %q", order, c.Width, c.Height, c.ImageDescription)
+		}
+		img, err := Decode(bytes.NewReader(b))
+		if err != nil {
+			t.Fatalf("%v: %v", order, err)
+		}
+		m := img.(*image.RGBA)
+		for i := 0; i < 4; i++ {
+			if got, want := m.Pix[4*i:4*i+3], data[3*i:3*i+3]; !bytes.Equal(got, want) {
+				t.Errorf("%v: pixel %d: got %v, want %v", order, i, got, want)
+			}
+		}
+		if _, format, err := image.Decode(bytes.NewReader(b)); err != nil || format != "tiff" {
+			t.Errorf("%v: image.Decode: got format %q, error %v", order, format, err)
+		}
+		n := 0
+		err = WalkIFDs(bytes.NewReader(b), func(_ int64, tags map[uint16]Tag) error {
+			n++
+			if got := tags[tStripOffsets]; got.Type != TypeLong8 || !reflect.DeepEqual(got.Value, []uint64{16}) {
+				t.Errorf("%v: got StripOffsets %+v", order, got)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 1 {
+			t.Errorf("%v: got %d IFDs, want 1", order, n)
+		}
+	}
+
+	// The size of offsets must be 8.
+	b := buildBigTIFF(binary.LittleEndian, data, ifd)
+	b[4] = 4
+	if _, err := Decode(bytes.NewReader(b)); err == nil {
+		t.Error("bad offset size: got nil error")
+	}
+}
+
+// TestDecodeZeroCount tests that entries with a Count of 0 are treated as
+// absent.
+func TestDecodeZeroCount(t *testing.T) {
+	data := []byte{1, 2, 3, 4, 5, 6}
+	b := buildTIFF(t, testPage{data, []ifdEntry{
+		{tImageWidth, dtShort, []uint32{3}},
+		{tImageLength, dtShort, []uint32{2}},
+		{tBitsPerSample, dtShort, []uint32{8}},
+		{tCompression, dtShort, []uint32{}},
+		{tPhotometricInterpretation, dtShort, []uint32{pBlackIsZero}},
+		{tImageDescription, dtASCII, []uint32{}},
+		{tStripOffsets, dtLong, []uint32{0}},
+		{tRowsPerStrip, dtShort, []uint32{2}},
+		{tStripByteCounts, dtLong, []uint32{6}},
+		{tPredictor, dtShort, []uint32{}},
+		{tColorMap, dtShort, []uint32{}},
+		{tExtraSamples, dtShort, []uint32{}},
+		{tClipPath, dtByte, []uint32{}},
+	}})
+	img, err := Decode(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := img.(*image.Gray).Pix; !bytes.Equal(got, data) {
+		t.Errorf("got pixels %v, want %v", got, data)
+	}
+	err = WalkIFDs(bytes.NewReader(b), func(_ int64, tags map[uint16]Tag) error {
+		if got := tags[tColorMap]; got.Count != 0 || !reflect.DeepEqual(got.Value, []uint64{}) {
+			t.Errorf("got ColorMap %+v, want an empty value", got)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestDecodeStretchToRange tests that DecodeOptions.StretchToRange maps
+// the samples of an image from its MinSampleValue and MaxSampleValue to the
+// full range.
+func TestDecodeStretchToRange(t *testing.T) {
+	data := make([]byte, 8)
+	for i, v := range []uint16{1000, 1500, 3000, 4000} {
+		binary.LittleEndian.PutUint16(data[2*i:], v)
+	}
+	b := buildTIFF(t, testPage{data, []ifdEntry{
+		{tImageWidth, dtShort, []uint32{4}},
+		{tImageLength, dtShort, []uint32{1}},
+		{tBitsPerSample, dtShort, []uint32{16}},
+		{tPhotometricInterpretation, dtShort, []uint32{pBlackIsZero}},
+		{tStripOffsets, dtLong, []uint32{0}},
+		{tRowsPerStrip, dtShort, []uint32{1}},
+		{tStripByteCounts, dtLong, []uint32{8}},
+		{tMinSampleValue, dtShort, []uint32{1500}},
+		{tMaxSampleValue, dtShort, []uint32{3000}},
+	}})
+
+	for _, tc := range []struct {
+		stretch bool
+		want    []uint16
+	}{
+		{false, []uint16{1000, 1500, 3000, 4000}},
+		{true, []uint16{0, 0, 0xffff, 0xffff}},
+	} {
+		img, err := DecodeWithOptions(bytes.NewReader(b), &DecodeOptions{StretchToRange: tc.stretch})
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := img.(*image.Gray16)
+		for x, want := range tc.want {
+			if got := m.Gray16At(x, 0).Y; got != want {
+				t.Errorf("stretch %v, x=%d: got %d, want %d", tc.stretch, x, got, want)
+			}
+		}
+	}
+
+	// A value inside the range is mapped linearly.
+	binary.LittleEndian.PutUint16(b[8:], 2250)
+	img, err := DecodeWithOptions(bytes.NewReader(b), &DecodeOptions{StretchToRange: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := img.(*image.Gray16).Gray16At(0, 0).Y, uint16(0xffff/2); got != want {
+		t.Errorf("midpoint: got %d, want %d", got, want)
+	}
+}
+
+func TestDecodeForcePredictor(t *testing.T) {
+	const w, h = 8, 2
+	pix := []byte{
+		10, 20, 30, 40, 50, 60, 70, 80,
+		200, 190, 180, 170, 160, 150, 140, 130,
+	}
+	var buf bytes.Buffer
+	lw := lzw.NewWriter(&buf, true)
+	if err := encodeGray(lw, pix, w, h, w, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := lw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	b := buildTIFF(t, testPage{buf.Bytes(), []ifdEntry{
+		{tImageWidth, dtShort, []uint32{w}},
+		{tImageLength, dtShort, []uint32{h}},
+		{tBitsPerSample, dtShort, []uint32{8}},
+		{tCompression, dtShort, []uint32{cLZW}},
+		{tPhotometricInterpretation, dtShort, []uint32{pBlackIsZero}},
+		{tStripOffsets, dtLong, []uint32{0}},
+		{tRowsPerStrip, dtShort, []uint32{h}},
+		{tStripByteCounts, dtLong, []uint32{uint32(buf.Len())}},
+		{tPredictor, dtShort, []uint32{prNone}},
+	}})
+
+	img, err := DecodeWithOptions(bytes.NewReader(b), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := img.(*image.Gray).Pix; bytes.Equal(got, pix) {
+		t.Error("without ForcePredictor: got the original pixels, want the predicted ones")
+	}
+	img, err = DecodeWithOptions(bytes.NewReader(b), &DecodeOptions{ForcePredictor: prHorizontal})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := img.(*image.Gray).Pix; !bytes.Equal(got, pix) {
+		t.Errorf("with ForcePredictor: got pixels %v, want %v", got, pix)
+	}
+	if _, err := DecodeWithOptions(bytes.NewReader(b), &DecodeOptions{ForcePredictor: 3}); err == nil {
+		t.Error("got nil error for ForcePredictor 3")
+	}
+}
+
+// TestDecodeNoStripByteCounts tests decoding a compressed single-strip image
+// without StripByteCounts, whose strip extends to the next IFD or to the end
+// of the file.
+func TestDecodeNoStripByteCounts(t *testing.T) {
+	const w, h = 5, 3
+	pix := make([]byte, w*h)
+	for i := range pix {
+		pix[i] = byte(i * 17)
+	}
+	var deflated bytes.Buffer
+	zw := zlib.NewWriter(&deflated)
+	zw.Write(pix)
+	zw.Close()
+	ifd := func(offset uint32) []ifdEntry {
+		return []ifdEntry{
+			{tImageWidth, dtShort, []uint32{w}},
+			{tImageLength, dtShort, []uint32{h}},
+			{tBitsPerSample, dtShort, []uint32{8}},
+			{tCompression, dtShort, []uint32{cDeflate}},
+			{tPhotometricInterpretation, dtShort, []uint32{pBlackIsZero}},
+			{tStripOffsets, dtLong, []uint32{offset}},
+		}
+	}
+
+	// The strip is followed by the IFD. Without StripByteCounts, buildTIFF
+	// does not relocate the offset, so it is given relative to the file.
+	before := buildTIFF(t, testPage{deflated.Bytes(), ifd(8)})
+
+	// The strip follows the IFD at the end of the file.
+	var after bytes.Buffer
+	after.WriteString(leHeader)
+	binary.Write(&after, binary.LittleEndian, uint32(8))
+	if err := writeIFD(&after, 8, ifd(uint32(8+2+6*ifdLen+4)), 0, enc, false); err != nil {
+		t.Fatal(err)
+	}
+	after.Write(deflated.Bytes())
+
+	for _, tc := range []struct {
+		name string
+		r    io.Reader
+	}{
+		{"before IFD", bytes.NewReader(before)},
+		{"at end", bytes.NewReader(after.Bytes())},
+		// A reader that is not an io.ReaderAt is buffered.
+		{"at end, buffered", struct{ io.Reader }{bytes.NewReader(after.Bytes())}},
+	} {
+		img, err := Decode(tc.r)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if got := img.(*image.Gray).Pix; !bytes.Equal(got, pix) {
+			t.Errorf("%s: got pixels %v, want %v", tc.name, got, pix)
+		}
+	}
+}
+
+// bilevelPalettedTIFF returns a w by h paletted image with 1 bit per sample
+// whose rows are stored in data, and the given FillOrder.
+func bilevelPalettedTIFF(t testing.TB, w, h int, data []byte, fillOrder uint32) []byte {
+	colorMap := make([]uint32, 3*2)
+	colorMap[1], colorMap[3], colorMap[5] = 0xffff, 0x8000, 0xffff
+	return buildTIFF(t, testPage{data, []ifdEntry{
+		{tImageWidth, dtShort, []uint32{uint32(w)}},
+		{tImageLength, dtShort, []uint32{uint32(h)}},
+		{tBitsPerSample, dtShort, []uint32{1}},
+		{tPhotometricInterpretation, dtShort, []uint32{pPaletted}},
+		{tFillOrder, dtShort, []uint32{fillOrder}},
+		{tStripOffsets, dtLong, []uint32{0}},
+		{tRowsPerStrip, dtShort, []uint32{uint32(h)}},
+		{tStripByteCounts, dtLong, []uint32{uint32(len(data))}},
+		{tColorMap, dtShort, colorMap},
+	}})
+}
+
+// TestDecodeBilevelPaletted tests that the indices of a paletted image with
+// 1 bit per sample match the bits of the source, in both fill orders.
+func TestDecodeBilevelPaletted(t *testing.T) {
+	const w, h = 13, 3
+	rowLen := (w + 7) / 8
+	data := []byte{0xa5, 0x3f, 0x0f, 0x80, 0xff, 0xf8}
+	for _, fillOrder := range []uint32{1, 2} {
+		img, err := Decode(bytes.NewReader(bilevelPalettedTIFF(t, w, h, data, fillOrder)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := img.(*image.Paletted)
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				shift := 7 - uint(x%8)
+				if fillOrder == 2 {
+					shift = uint(x % 8)
+				}
+				want := data[y*rowLen+x/8] >> shift & 1
+				if got := m.ColorIndexAt(x, y); got != want {
+					t.Errorf("FillOrder %d: at (%d, %d): got index %d, want %d", fillOrder, x, y, got, want)
+				}
+			}
+		}
+	}
+}
+
+// TestDecodePackedSamples tests that gray and paletted images with 1, 2 or
+// 4 bits per sample are unpacked in both fill orders, with gray levels
+// scaled to 8 bits.
+func TestDecodePackedSamples(t *testing.T) {
+	const w, h = 7, 3
+	reverse := func(b byte) (r byte) {
+		for i := uint(0); i < 8; i++ {
+			r |= (b >> i & 1) << (7 - i)
+		}
+		return r
+	}
+	for _, bps := range []uint32{1, 2, 4} {
+		perByte := 8 / int(bps)
+		rowLen := (w + perByte - 1) / perByte
+		max := 1<<bps - 1
+		sample := func(x, y int) int { return (x*3 + y*5) & max }
+		for _, photometric := range []uint32{pBlackIsZero, pWhiteIsZero, pPaletted} {
+			for _, fillOrder := range []uint32{1, 2} {
+				data := make([]byte, rowLen*h)
+				for y := 0; y < h; y++ {
+					for x := 0; x < w; x++ {
+						shift := uint(8 - int(bps)*(x%perByte+1))
+						data[y*rowLen+x/perByte] |= byte(sample(x, y)) << shift
+					}
+				}
+				if fillOrder == 2 {
+					for i, b := range data {
+						data[i] = reverse(b)
+					}
+				}
+				ifd := []ifdEntry{
+					{tImageWidth, dtShort, []uint32{w}},
+					{tImageLength, dtShort, []uint32{h}},
+					{tBitsPerSample, dtShort, []uint32{bps}},
+					{tPhotometricInterpretation, dtShort, []uint32{photometric}},
+					{tFillOrder, dtShort, []uint32{fillOrder}},
+					{tStripOffsets, dtLong, []uint32{0}},
+					{tRowsPerStrip, dtShort, []uint32{h}},
+					{tStripByteCounts, dtLong, []uint32{uint32(len(data))}},
+				}
+				if photometric == pPaletted {
+					ifd = append(ifd, ifdEntry{tColorMap, dtShort, make([]uint32, 3<<bps)})
+				}
+				img, err := Decode(bytes.NewReader(buildTIFF(t, testPage{data, ifd})))
+				if err != nil {
+					t.Fatalf("%d bits, photometric %d, FillOrder %d: %v", bps, photometric, fillOrder, err)
+				}
+				for y := 0; y < h; y++ {
+					for x := 0; x < w; x++ {
+						var got, want int
+						switch m := img.(type) {
+						case *image.Paletted:
+							got, want = int(m.ColorIndexAt(x, y)), sample(x, y)
+						case *image.Gray:
+							got, want = int(m.GrayAt(x, y).Y), sample(x, y)*0xff/max
+							if photometric == pWhiteIsZero {
+								want = 0xff - want
+							}
+						}
+						if got != want {
+							t.Errorf("%d bits, photometric %d, FillOrder %d: at (%d, %d): got %d, want %d", bps, photometric, fillOrder, x, y, got, want)
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+// TestDecodePalettedAlpha tests that paletted images with an alpha sample
+// are decoded into an image.NRGBA or image.RGBA, and that the 16 bits of
+// their ColorMap entries are reported in SourceInfo.Palette.
+func TestDecodePalettedAlpha(t *testing.T) {
+	colorMap := []uint32{
+		0xffff, 0x1234, 0x0000, // Red.
+		0x0000, 0x5678, 0x8080, // Green.
+		0x0000, 0x9abc, 0xffff, // Blue.
+	}
+	ifd := func(extraSamples uint32) []ifdEntry {
+		return []ifdEntry{
+			{tImageWidth, dtShort, []uint32{3}},
+			{tImageLength, dtShort, []uint32{1}},
+			{tBitsPerSample, dtShort, []uint32{8, 8}},
+			{tPhotometricInterpretation, dtShort, []uint32{pPaletted}},
+			{tStripOffsets, dtLong, []uint32{0}},
+			{tSamplesPerPixel, dtShort, []uint32{2}},
+			{tRowsPerStrip, dtShort, []uint32{1}},
+			{tStripByteCounts, dtLong, []uint32{6}},
+			{tColorMap, dtShort, colorMap},
+			{tExtraSamples, dtShort, []uint32{extraSamples}},
+		}
+	}
+	data := []byte{0, 0xff, 1, 0x80, 2, 0}
+	straight := []uint8{0xff, 0, 0, 0xff, 0x12, 0x56, 0x9a, 0x80, 0, 0x80, 0xff, 0}
+	premul := []uint8{0xff, 0, 0, 0xff, 0x09, 0x2b, 0x4d, 0x80, 0, 0, 0, 0}
+	for _, tc := range []struct {
+		extraSamples uint32
+		alpha        AlphaMode
+		want         image.Image
+	}{
+		{2, AlphaAsStored, &image.NRGBA{Pix: straight, Stride: 12, Rect: image.Rect(0, 0, 3, 1)}},
+		{1, AlphaAsStored, &image.RGBA{Pix: premul, Stride: 12, Rect: image.Rect(0, 0, 3, 1)}},
+		{2, AlphaPremultiplied, &image.RGBA{Pix: premul, Stride: 12, Rect: image.Rect(0, 0, 3, 1)}},
+		{1, AlphaStraight, &image.NRGBA{Pix: straight, Stride: 12, Rect: image.Rect(0, 0, 3, 1)}},
+	} {
+		b := buildTIFF(t, testPage{data, ifd(tc.extraSamples)})
+		m, err := DecodeWithOptions(bytes.NewReader(b), &DecodeOptions{Alpha: tc.alpha})
+		if err != nil {
+			t.Fatalf("ExtraSamples %d, alpha %d: %v", tc.extraSamples, tc.alpha, err)
+		}
+		if !reflect.DeepEqual(m, tc.want) {
+			t.Errorf("ExtraSamples %d, alpha %d: got %v, want %v", tc.extraSamples, tc.alpha, m, tc.want)
+		}
+	}
+
+	c, err := DecodeConfigFull(bytes.NewReader(buildTIFF(t, testPage{data, ifd(2)})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := color.Palette{
+		color.RGBA64{0xffff, 0x0000, 0x0000, 0xffff},
+		color.RGBA64{0x1234, 0x5678, 0x9abc, 0xffff},
+		color.RGBA64{0x0000, 0x8080, 0xffff, 0xffff},
+	}
+	if !reflect.DeepEqual(c.SourceInfo.Palette, want) {
+		t.Errorf("got palette %v, want %v", c.SourceInfo.Palette, want)
+	}
+	if c.ColorModel != color.NRGBAModel {
+		t.Errorf("got color model %v, want color.NRGBAModel", c.ColorModel)
+	}
+}
+
+// TestDecodePreview tests that DecodePreview decodes the first tile or
+// strip only.
+func TestDecodePreview(t *testing.T) {
+	for _, tc := range []struct {
+		filename string
+		want     image.Rectangle
+	}{
+		{"video-001-tile-64x64.tiff", image.Rect(0, 0, 64, 64)},
+		{"video-001-strip-64.tiff", image.Rect(0, 0, 150, 64)},
+		{"video-001.tiff", image.Rect(0, 0, 150, 103)},
+	} {
+		b, err := ioutil.ReadFile(testdataDir + tc.filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		preview, info, err := DecodePreview(bytes.NewReader(b))
+		if err != nil {
+			t.Fatalf("%s: %v", tc.filename, err)
+		}
+		if preview.Bounds() != tc.want {
+			t.Errorf("%s: got bounds %v, want %v", tc.filename, preview.Bounds(), tc.want)
+		}
+		if info == nil {
+			t.Fatalf("%s: got nil SourceInfo", tc.filename)
+		}
+		img, err := Decode(bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		compare(t, img.(*image.RGBA).SubImage(tc.want), preview)
+	}
+}
+
+// TestDecodeWorkers tests that decoding blocks concurrently gives the same
+// image as decoding them one after the other, and reports errors of single
+// blocks.
+func TestDecodeWorkers(t *testing.T) {
+	img, err := openImage("video-001.tiff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := Encode(&buf, img, &Options{Compression: LZW, Predictor: true, TileWidth: 16, TileLength: 16}); err != nil {
+		t.Fatal(err)
+	}
+	b := buf.Bytes()
+	for _, workers := range []int{0, 1, 2, 7, 1000} {
+		// A plain io.Reader is buffered internally, which must be safe
+		// for concurrent use as well.
+		for _, r := range []io.Reader{bytes.NewReader(b), struct{ io.Reader }{bytes.NewReader(b)}} {
+			m, err := DecodeWithOptions(r, &DecodeOptions{Workers: workers})
+			if err != nil {
+				t.Fatalf("%d workers: %v", workers, err)
+			}
+			compare(t, img, m)
+		}
+	}
+
+	// Corrupt the LZW data of a tile in the middle of the image.
+	d, err := newDecoder(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	k := len(d.features[tTileOffsets]) / 2
+	off, n := d.features[tTileOffsets][k], d.features[tTileByteCounts][k]
+	corrupt := append([]byte(nil), b...)
+	for i := off; i < off+n; i++ {
+		corrupt[i] = 0xff
+	}
+	if _, err := DecodeWithOptions(bytes.NewReader(corrupt), &DecodeOptions{Workers: 4}); err == nil {
+		t.Error("corrupt tile: got nil error")
+	}
+	if _, err := DecodeWithOptions(bytes.NewReader(b), &DecodeOptions{Workers: -1}); err == nil {
+		t.Error("negative Workers: got nil error")
+	}
+}
+
+// TestDecodeInto tests that DecodeInto reuses a destination image of the
+// right type and bounds and rejects others.
+func TestDecodeInto(t *testing.T) {
+	for _, name := range []string{"video-001.tiff", "video-001-paletted.tiff", "video-001-gray-16bit.tiff"} {
+		b, err := ioutil.ReadFile(testdataDir + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := Decode(bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Decode into an image of the right type that still holds pixels.
+		dst := Orient(want, 3).(draw.Image)
+		if p, ok := dst.(*image.Paletted); ok {
+			p.Palette = nil
+		}
+		if err := DecodeInto(bytes.NewReader(b), dst); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		compare(t, want, dst)
+
+		if err := DecodeInto(bytes.NewReader(b), image.NewRGBA64(want.Bounds())); err == nil {
+			t.Errorf("%s: got nil error for the wrong type", name)
+		}
+		small := Crop(want, image.Rect(0, 0, 10, 10)).(draw.Image)
+		if err := DecodeInto(bytes.NewReader(b), small); err == nil {
+			t.Errorf("%s: got nil error for the wrong bounds", name)
+		}
+	}
+}
+
+// TestDecodeLimits tests that DecodeOptions.MaxWidth, MaxHeight and
+// MaxMemory, and the defaults of image.Decode, reject images before their
+// memory is allocated.
+func TestDecodeLimits(t *testing.T) {
+	huge := buildTIFF(t, testPage{make([]byte, 1), []ifdEntry{
+		{tImageWidth, dtLong, []uint32{200000}},
+		{tImageLength, dtLong, []uint32{200000}},
+		{tBitsPerSample, dtShort, []uint32{8}},
+		{tPhotometricInterpretation, dtShort, []uint32{pBlackIsZero}},
+		{tStripOffsets, dtLong, []uint32{0}},
+		{tRowsPerStrip, dtLong, []uint32{200000}},
+		{tStripByteCounts, dtLong, []uint32{1}},
+	}})
+	if _, _, err := image.Decode(bytes.NewReader(huge)); err == nil {
+		t.Error("image.Decode: got nil error for 200000x200000 pixels")
+	}
+	for _, opts := range []DecodeOptions{{MaxWidth: 100000}, {MaxHeight: 100000}, {MaxMemory: 1 << 30}} {
+		if _, err := DecodeWithOptions(bytes.NewReader(huge), &opts); err == nil {
+			t.Errorf("%+v: got nil error", opts)
+		}
+	}
+
+	b, err := ioutil.ReadFile(testdataDir + "video-001.tiff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := Decode(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := want.Bounds().Size()
+	m, err := DecodeWithOptions(bytes.NewReader(b), &DecodeOptions{MaxWidth: size.X, MaxHeight: size.Y, MaxMemory: 1 << 24})
+	if err != nil {
+		t.Fatal(err)
+	}
+	compare(t, want, m)
+	if _, err := DecodeWithOptions(bytes.NewReader(b), &DecodeOptions{MaxMemory: 1 << 16}); err == nil {
+		t.Error("MaxMemory too small: got nil error")
+	}
+	if _, err := DecodeWithOptions(bytes.NewReader(b), &DecodeOptions{MaxMemory: -1}); err == nil {
+		t.Error("negative MaxMemory: got nil error")
+	}
+
+	// A strip that decompresses to much more than its pixels is cut off.
+	pix := []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+	var z bytes.Buffer
+	w := zlib.NewWriter(&z)
+	w.Write(pix)
+	w.Write(make([]byte, 1<<20))
+	w.Close()
+	bomb := buildTIFF(t, testPage{z.Bytes(), []ifdEntry{
+		{tImageWidth, dtShort, []uint32{4}},
+		{tImageLength, dtShort, []uint32{4}},
+		{tBitsPerSample, dtShort, []uint32{8}},
+		{tCompression, dtShort, []uint32{cDeflate}},
+		{tPhotometricInterpretation, dtShort, []uint32{pBlackIsZero}},
+		{tStripOffsets, dtLong, []uint32{0}},
+		{tRowsPerStrip, dtShort, []uint32{4}},
+		{tStripByteCounts, dtLong, []uint32{uint32(z.Len())}},
+	}})
+	m, err = DecodeWithOptions(bytes.NewReader(bomb), &DecodeOptions{MaxMemory: 1 << 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := m.(*image.Gray).Pix; !bytes.Equal(got, pix) {
+		t.Errorf("got pixels %v, want %v", got, pix)
+	}
+}
+
+func TestSetDefaultDecodeOptions(t *testing.T) {
+	defer SetDefaultDecodeOptions(DefaultDecodeOptions())
+	b, err := ioutil.ReadFile(testdataDir + "video-001.tiff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := DefaultDecodeOptions()
+	opts.MaxWidth = 10
+	SetDefaultDecodeOptions(opts)
+	if _, _, err := image.Decode(bytes.NewReader(b)); err == nil {
+		t.Error("image.Decode: got nil error for an image wider than MaxWidth")
+	}
+
+	var buf bytes.Buffer
+	if err := Encode(&buf, NewCMYKA(image.Rect(0, 0, 3, 2)), nil); err != nil {
+		t.Fatal(err)
+	}
+	SetDefaultDecodeOptions(DecodeOptions{Alpha: AlphaPremultiplied})
+	m, _, err := image.Decode(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := m.(*PremulCMYKAImg); !ok {
+		t.Errorf("image.Decode returns a %T, want a *PremulCMYKAImg", m)
+	}
+}
+
+// TestDecodeAllowPartial tests that AllowPartial decodes the intact strips
+// of an image and zero samples for strips that are corrupt or lie beyond
+// the end of the file.
+func TestDecodeAllowPartial(t *testing.T) {
+	pix := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	page := testPage{pix, []ifdEntry{
+		{tImageWidth, dtShort, []uint32{2}},
+		{tImageLength, dtShort, []uint32{6}},
+		{tBitsPerSample, dtShort, []uint32{8}},
+		{tPhotometricInterpretation, dtShort, []uint32{pWhiteIsZero}},
+		{tStripOffsets, dtLong, []uint32{0, 4, 1 << 20}},
+		{tRowsPerStrip, dtShort, []uint32{2}},
+		{tStripByteCounts, dtLong, []uint32{4, 4, 4}},
+	}}
+	b := buildTIFF(t, page, page)
+	if _, err := Decode(bytes.NewReader(b)); err == nil {
+		t.Fatal("got nil error without AllowPartial")
+	}
+	want := []byte{254, 253, 252, 251, 250, 249, 248, 247, 255, 255, 255, 255}
+	check := func(img image.Image, err error) {
+		t.Helper()
+		if pe, ok := err.(*PartialError); !ok || pe.Blocks != 1 {
+			t.Fatalf("got error %v, want a PartialError for 1 block", err)
+		}
+		if got := img.(*image.Gray).Pix; !bytes.Equal(got, want) {
+			t.Errorf("got pixels %v, want %v", got, want)
+		}
+	}
+	opts := &DecodeOptions{AllowPartial: true, Workers: 2}
+	check(DecodeWithOptions(bytes.NewReader(b), opts))
+
+	imgs, err := DecodeAllWithOptions(bytes.NewReader(b), opts)
+	if len(imgs) != 2 {
+		t.Fatalf("DecodeAllWithOptions: got %d images, %v", len(imgs), err)
+	}
+	check(imgs[1], err)
+}
+
+// TestDecodeCorruption tests that a strip with too little pixel data is
+// reported by a CorruptionError and, with AllowPartial, decoded as zero
+// samples without affecting the following strips.
+func TestDecodeCorruption(t *testing.T) {
+	pix := []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11}
+	b := buildTIFF(t, testPage{pix, []ifdEntry{
+		{tImageWidth, dtShort, []uint32{2}},
+		{tImageLength, dtShort, []uint32{6}},
+		{tBitsPerSample, dtShort, []uint32{8}},
+		{tPhotometricInterpretation, dtShort, []uint32{pBlackIsZero}},
+		{tStripOffsets, dtLong, []uint32{0, 4, 7}},
+		{tRowsPerStrip, dtShort, []uint32{2}},
+		{tStripByteCounts, dtLong, []uint32{4, 3, 4}},
+	}})
+	check := func(err error) {
+		t.Helper()
+		ce, ok := err.(*CorruptionError)
+		if !ok {
+			t.Fatalf("got error %v, want a CorruptionError", err)
+		}
+		if ce.Strip != 1 || ce.Len != 3 || ce.Want != 4 || ce.Offset <= 0 {
+			t.Errorf("got %+v", ce)
+		}
+	}
+	_, err := Decode(bytes.NewReader(b))
+	check(err)
+
+	img, err := DecodeWithOptions(bytes.NewReader(b), &DecodeOptions{AllowPartial: true})
+	pe, ok := err.(*PartialError)
+	if !ok {
+		t.Fatalf("AllowPartial: got error %v, want a PartialError", err)
+	}
+	check(pe.Unwrap())
+	if got, want := img.(*image.Gray).Pix, []byte{1, 2, 3, 4, 0, 0, 0, 0, 8, 9, 10, 11}; !bytes.Equal(got, want) {
+		t.Errorf("got pixels %v, want %v", got, want)
+	}
+}
+
+// recordingReaderAt is an io.ReaderAt that records the offsets it is read at.
+type recordingReaderAt struct {
+	r       io.ReaderAt
+	offsets []int64
+}
+
+func (r *recordingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	r.offsets = append(r.offsets, off)
+	return r.r.ReadAt(p, off)
+}
+
+// TestDecodeRegion tests that DecodeRegion decodes the pixels of the region
+// and reads only the blocks that intersect it.
+func TestDecodeRegion(t *testing.T) {
+	for _, tc := range []struct {
+		filename string
+		rect     image.Rectangle
+		want     image.Rectangle
+		blocks   []int // Indices of the blocks that must be read.
+	}{
+		{"video-001-tile-64x64.tiff", image.Rect(70, 10, 100, 20), image.Rect(70, 10, 100, 20), []int{1}},
+		{"video-001-tile-64x64.tiff", image.Rect(60, 60, 70, 70), image.Rect(60, 60, 70, 70), []int{0, 1, 3, 4}},
+		{"video-001-tile-64x64.tiff", image.Rect(140, 90, 200, 200), image.Rect(140, 90, 150, 103), []int{5}},
+		{"video-001-strip-64.tiff", image.Rect(10, 70, 20, 80), image.Rect(10, 70, 20, 80), []int{1}},
+		{"video-001.tiff", image.Rect(-5, -5, 5, 5), image.Rect(0, 0, 5, 5), []int{0}},
+	} {
+		b, err := ioutil.ReadFile(testdataDir + tc.filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := &recordingReaderAt{r: bytes.NewReader(b)}
+		region, err := DecodeRegion(r, tc.rect)
+		if err != nil {
+			t.Fatalf("%s %v: %v", tc.filename, tc.rect, err)
+		}
+		if region.Bounds() != tc.want {
+			t.Errorf("%s %v: got bounds %v, want %v", tc.filename, tc.rect, region.Bounds(), tc.want)
+		}
+		img, err := Decode(bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		compare(t, img.(*image.RGBA).SubImage(tc.want), region)
+
+		d, err := newDecoder(bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		l, err := d.layout()
+		if err != nil {
+			t.Fatal(err)
+		}
+		read := make(map[int]bool)
+		for _, off := range r.offsets {
+			for k := range l.offsets {
+				if off >= int64(l.offsets[k]) && off < int64(l.offsets[k]+l.counts[k]) {
+					read[k] = true
+				}
+			}
+		}
+		want := make(map[int]bool)
+		for _, k := range tc.blocks {
+			want[k] = true
+		}
+		if !reflect.DeepEqual(read, want) {
+			t.Errorf("%s %v: read blocks %v, want %v", tc.filename, tc.rect, read, want)
+		}
+	}
+
+	b, err := ioutil.ReadFile(testdataDir + "video-001.tiff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := DecodeRegion(bytes.NewReader(b), image.Rect(150, 0, 160, 10)); err == nil {
+		t.Error("region outside the image: got nil error")
+	}
+}
+
+// readerAtOnly is an io.Reader that must be used as an io.ReaderAt only.
+type readerAtOnly struct {
+	t *testing.T
+	recordingReaderAt
+}
+
+func (r *readerAtOnly) Read(p []byte) (int, error) {
+	r.t.Error("the input was read sequentially")
+	return 0, io.EOF
+}
+
+// TestDecodeReaderAt tests that an input that is an io.ReaderAt is read at
+// the offsets of the data that is needed instead of being buffered, so that
+// decoding the first page of a file does not read the pixel data of the
+// following pages.
+func TestDecodeReaderAt(t *testing.T) {
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	for i := 0; i < 3; i++ {
+		if err := e.WriteImage(image.NewGray(image.Rect(0, 0, 64, 64)), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// strips holds the offset and length of the pixel data of each page.
+	var strips [][2]int64
+	if err := WalkIFDs(bytes.NewReader(buf.Bytes()), func(_ int64, tags map[uint16]Tag) error {
+		off := tags[tStripOffsets].Value.([]uint64)[0]
+		n := tags[tStripByteCounts].Value.([]uint64)[0]
+		strips = append(strips, [2]int64{int64(off), int64(n)})
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	r := &readerAtOnly{t: t, recordingReaderAt: recordingReaderAt{r: bytes.NewReader(buf.Bytes())}}
+	if _, err := Decode(r); err != nil {
+		t.Fatal(err)
+	}
+	for _, off := range r.offsets {
+		for i, s := range strips[1:] {
+			if off >= s[0] && off < s[0]+s[1] {
+				t.Errorf("Decode read at offset %d, in the pixel data of page %d", off, i+1)
+			}
+		}
+	}
+
+	r.offsets = nil
+	pages, err := DecodeAll(r)
+	if err != nil || len(pages) != 3 {
+		t.Fatalf("DecodeAll: got %d pages, %v", len(pages), err)
+	}
+}
+
+// TestDecodeArray tests that DecodeArray returns the same samples as Decode.
+func TestDecodeArray(t *testing.T) {
+	for _, tc := range []struct {
+		filename string
+		dtype    string
+		c        int
+	}{
+		{"video-001.tiff", "uint8", 3},
+		{"video-001-tile-64x64.tiff", "uint8", 3},
+		{"video-001-gray-16bit.tiff", "uint16", 1},
+		{"bw-uncompressed.tiff", "uint8", 1},
+	} {
+		b, err := ioutil.ReadFile(testdataDir + tc.filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, shape, dtype, err := DecodeArray(bytes.NewReader(b))
+		if err != nil {
+			t.Fatalf("%s: %v", tc.filename, err)
+		}
+		img, err := Decode(bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		bounds := img.Bounds()
+		if want := [3]int{bounds.Dy(), bounds.Dx(), tc.c}; shape != want || dtype != tc.dtype {
+			t.Fatalf("%s: got shape %v and dtype %q, want %v and %q", tc.filename, shape, dtype, want, tc.dtype)
+		}
+		for y := 0; y < shape[0]; y++ {
+			for x := 0; x < shape[1]; x++ {
+				i := (y*shape[1] + x) * shape[2]
+				var want, got []uint32
+				switch m := img.(type) {
+				case *image.RGBA:
+					p := m.RGBAAt(x, y)
+					want = []uint32{uint32(p.R), uint32(p.G), uint32(p.B)}
+					u8 := data.([]uint8)
+					got = []uint32{uint32(u8[i]), uint32(u8[i+1]), uint32(u8[i+2])}
+				case *image.Gray16:
+					want = []uint32{uint32(m.Gray16At(x, y).Y)}
+					got = []uint32{uint32(data.([]uint16)[i])}
+				case *image.Gray:
+					// The bilevel test image is WhiteIsZero, so its
+					// samples are returned as stored, inverted.
+					want = []uint32{1 - uint32(m.GrayAt(x, y).Y)/0xff}
+					got = []uint32{uint32(data.([]uint8)[i])}
+				default:
+					t.Fatalf("%s: unexpected image type %T", tc.filename, img)
+				}
+				if !reflect.DeepEqual(got, want) {
+					t.Fatalf("%s: at (%d, %d): got %v, want %v", tc.filename, x, y, got, want)
+				}
+			}
+		}
+	}
+}
+
+// TestDecodeArrayFillOrder tests that DecodeArray honors a FillOrder of 2
+// for sub-byte samples that are read bit by bit.
+func TestDecodeArrayFillOrder(t *testing.T) {
+	const w, h = 5, 2
+	// Each row holds 5 pixels of 3 samples of 2 bits, padded to 4 bytes.
+	data := []byte{
+		0x1b, 0x1b, 0x1b, 0xc0,
+		0xe4, 0xe4, 0xe4, 0x00,
+	}
+	want := []uint8{
+		0, 1, 2, 3, 0, 1, 2, 3, 0, 1, 2, 3, 3, 0, 0,
+		3, 2, 1, 0, 3, 2, 1, 0, 3, 2, 1, 0, 0, 0, 0,
+	}
+	for _, fillOrder := range []uint32{1, 2} {
+		stored := append([]byte(nil), data...)
+		if fillOrder == 2 {
+			for i, b := range stored {
+				stored[i] = reversedBits[b]
+			}
+		}
+		b := buildTIFF(t, testPage{stored, []ifdEntry{
+			{tImageWidth, dtShort, []uint32{w}},
+			{tImageLength, dtShort, []uint32{h}},
+			{tBitsPerSample, dtShort, []uint32{2, 2, 2}},
+			{tPhotometricInterpretation, dtShort, []uint32{pRGB}},
+			{tFillOrder, dtShort, []uint32{fillOrder}},
+			{tStripOffsets, dtLong, []uint32{0}},
+			{tSamplesPerPixel, dtShort, []uint32{3}},
+			{tRowsPerStrip, dtShort, []uint32{h}},
+			{tStripByteCounts, dtLong, []uint32{uint32(len(stored))}},
+		}})
+		got, _, _, err := DecodeArray(bytes.NewReader(b))
+		if err != nil {
+			t.Fatalf("FillOrder %d: %v", fillOrder, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("FillOrder %d: got %v, want %v", fillOrder, got, want)
+		}
+	}
+}
+
+// benchmarkDecode benchmarks the decoding of an image.
+func benchmarkDecode(b *testing.B, filename string) {
+	b.StopTimer()
+	contents, err := ioutil.ReadFile(testdataDir + filename)
+	if err != nil {
+		b.Fatal(err)
+	}
+	r := &buffer{buf: contents}
+	b.StartTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := Decode(r)
+		if err != nil {
+			b.Fatal("Decode:", err)
+		}
+	}
+}
+
+func BenchmarkDecodeCompressed(b *testing.B)   { benchmarkDecode(b, "video-001.tiff") }
+func BenchmarkDecodeUncompressed(b *testing.B) { benchmarkDecode(b, "video-001-uncompressed.tiff") }
+
+func BenchmarkDecodeBilevelPaletted(b *testing.B) {
+	const w, h = 2000, 1000
+	data := make([]byte, (w+7)/8*h)
+	for i := range data {
+		data[i] = uint8(i * 37)
+	}
+	r := &buffer{buf: bilevelPalettedTIFF(b, w, h, data, 1)}
+	b.SetBytes(w * h)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := Decode(r); err != nil {
+			b.Fatal("Decode:", err)
+		}
+	}
+}
+
+func benchmarkDecodeWorkers(b *testing.B, workers int) {
+	m := image.NewGray(image.Rect(0, 0, 2048, 2048))
+	for i := range m.Pix {
+		m.Pix[i] = uint8(i * 37 >> 5)
+	}
+	var buf bytes.Buffer
+	if err := Encode(&buf, m, &Options{Compression: LZW, TileWidth: 256, TileLength: 256}); err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(len(m.Pix)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := DecodeWithOptions(bytes.NewReader(buf.Bytes()), &DecodeOptions{Workers: workers}); err != nil {
+			b.Fatal("Decode:", err)
+		}
+	}
+}
+
+func BenchmarkDecodeSequential(b *testing.B) { benchmarkDecodeWorkers(b, 1) }
+func BenchmarkDecodeParallel(b *testing.B)   { benchmarkDecodeWorkers(b, 0) }
+
+func TestDecodeContext(t *testing.T) {
+	src, err := openImage("video-001.tiff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := Encode(&buf, src, &Options{Compression: LZW, TileWidth: 32, TileLength: 32}); err != nil {
+		t.Fatal(err)
+	}
+
+	b := src.Bounds()
+	for _, workers := range []int{1, 4} {
+		var calls, last, total int64
+		m, err := DecodeContext(context.Background(), bytes.NewReader(buf.Bytes()), &DecodeOptions{
+			Workers: workers,
+			OnProgress: func(done, n int64) {
+				calls++
+				if done != last+1 {
+					t.Errorf("workers %d: progress %d after %d", workers, done, last)
+				}
+				last, total = done, n
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		compare(t, src, m)
+		if want := int64((b.Dx()+31)/32) * int64((b.Dy()+31)/32); calls != want || last != want || total != want {
+			t.Errorf("workers %d: %d calls, progress %d of %d, want %d", workers, calls, last, total, want)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		var decoded int64
+		_, err = DecodeContext(ctx, bytes.NewReader(buf.Bytes()), &DecodeOptions{
+			Workers:      workers,
+			AllowPartial: true,
+			OnProgress: func(done, n int64) {
+				decoded = done
+				if done == 2 {
+					cancel()
+				}
+			},
+		})
+		if err != context.Canceled {
+			t.Errorf("workers %d: got %v after canceling, want %v", workers, err, context.Canceled)
+		}
+		if decoded >= total {
+			t.Errorf("workers %d: all %d tiles decoded after canceling", workers, decoded)
+		}
+	}
+}
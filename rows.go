@@ -0,0 +1,132 @@
+package tiff
+
+import (
+	"fmt"
+	"image"
+	"io"
+)
+
+// rowReader holds the state of the page whose rows are read by
+// Reader.ReadRows.
+type rowReader struct {
+	d    *decoder
+	l    *blockLayout // Layout of the page, nil before the first row is read.
+	row  int          // Next row to be read.
+	band image.Image  // Decoded block row holding row, nil if not decoded.
+}
+
+// Config returns the color model and dimensions of the current page, which
+// is the next page that Next returns or whose rows ReadRows reads. It
+// returns io.EOF after the last page.
+func (p *Reader) Config() (image.Config, error) {
+	if p.page == nil {
+		d, err := p.nextDecoder()
+		if err != nil {
+			return image.Config{}, err
+		}
+		p.page = &rowReader{d: d}
+	}
+	return p.page.d.config, nil
+}
+
+// ReadRows decodes up to n rows of the current page into dst and returns the
+// number of rows decoded. Rows are decoded one strip, or one row of tiles,
+// at a time, so that pages larger than memory can be processed, unless they
+// are stored in a single strip. Each row is stored in dst as in the Pix
+// field of the image that Next would return for the page, without padding:
+// 1 byte per pixel for image.Gray and image.Paletted images, 2 for a
+// GrayAImg, 4 for image.RGBA and so on; Config tells which. dst must have
+// room for n rows. Pages with floating point or signed integer samples
+// cannot be read by ReadRows; it returns an UnsupportedError for them.
+//
+// After the last row of the page, ReadRows returns 0 and io.EOF; the next
+// call then continues with the following page. After the last page,
+// ReadRows keeps returning io.EOF.
+func (p *Reader) ReadRows(dst []byte, n int) (int, error) {
+	if _, err := p.Config(); err != nil {
+		return 0, err
+	}
+	r := p.page
+	d := r.d
+	if r.l == nil {
+		if d.mode == mFloat32 {
+			return 0, UnsupportedError("ReadRows with floating point samples")
+		}
+		if d.mode == mInt16 || d.mode == mInt32 {
+			return 0, UnsupportedError("ReadRows with signed integer samples")
+		}
+		l, err := d.layout()
+		if err != nil {
+			p.err = err
+			return 0, err
+		}
+		r.l = l
+	}
+	rowLen := d.config.Width * d.pixelSize()
+	if n < 0 || len(dst) < n*rowLen {
+		return 0, fmt.Errorf("tiff: ReadRows buffer of %d bytes too short for %d rows of %d bytes", len(dst), n, rowLen)
+	}
+	if r.row == d.config.Height {
+		p.page = nil
+		return 0, io.EOF
+	}
+	k := 0
+	for ; k < n && r.row < d.config.Height; k, r.row = k+1, r.row+1 {
+		if r.band == nil || r.row >= r.band.Bounds().Max.Y {
+			j := r.row / r.l.height
+			band := d.newImage(image.Rect(0, j*r.l.height, d.config.Width, minInt((j+1)*r.l.height, d.config.Height)))
+			err := d.decodeBlockRange(r.l, image.Rect(0, j, r.l.across, j+1), func(d *decoder, xmin, ymin, xmax, ymax int) error {
+				return d.decode(band, xmin, ymin, xmax, ymax)
+			})
+			if err != nil {
+				p.err = err
+				return k, err
+			}
+			r.band = band
+		}
+		pix, stride := imagePix(r.band)
+		i := (r.row - r.band.Bounds().Min.Y) * stride
+		copy(dst[k*rowLen:(k+1)*rowLen], pix[i:i+rowLen])
+	}
+	return k, nil
+}
+
+// imagePix returns the Pix and Stride fields of an image returned by
+// decoder.newImage, except for a Float32Img, Int16Img or Int32Img.
+func imagePix(img image.Image) ([]uint8, int) {
+	switch m := img.(type) {
+	case *image.Gray:
+		return m.Pix, m.Stride
+	case *image.Gray16:
+		return m.Pix, m.Stride
+	case *image.RGBA:
+		return m.Pix, m.Stride
+	case *image.RGBA64:
+		return m.Pix, m.Stride
+	case *image.NRGBA:
+		return m.Pix, m.Stride
+	case *image.NRGBA64:
+		return m.Pix, m.Stride
+	case *image.CMYK:
+		return m.Pix, m.Stride
+	case *image.Paletted:
+		return m.Pix, m.Stride
+	case *CMYKAImg:
+		return m.Pix, m.Stride
+	case *PremulCMYKAImg:
+		return m.Pix, m.Stride
+	case *CMYKA64Img:
+		return m.Pix, m.Stride
+	case *GrayAImg:
+		return m.Pix, m.Stride
+	case *GrayA16Img:
+		return m.Pix, m.Stride
+	case *NChannelImg:
+		return m.Pix, m.Stride
+	case *LabImg:
+		return m.Pix, m.Stride
+	case *MultiSampleImg:
+		return m.Pix, m.Stride
+	}
+	return nil, 0
+}
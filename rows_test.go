@@ -0,0 +1,96 @@
+package tiff
+
+import (
+	"bytes"
+	"image"
+	"io"
+	"testing"
+)
+
+func TestReadRows(t *testing.T) {
+	src, err := openImage("video-001.tiff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gray := image.NewGray16(src.Bounds())
+	for y := src.Bounds().Min.Y; y < src.Bounds().Max.Y; y++ {
+		for x := src.Bounds().Min.X; x < src.Bounds().Max.X; x++ {
+			gray.Set(x, y, src.At(x, y))
+		}
+	}
+	// The file holds a tiled page and a page of strips.
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	if err := e.WriteImage(src, &Options{Compression: LZW, TileWidth: 32, TileLength: 16}); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.WriteImage(gray, &Options{Compression: Deflate}); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []image.Image{src, gray} {
+		c, err := r.Config()
+		if err != nil {
+			t.Fatalf("page %d: %v", i, err)
+		}
+		b := image.Rect(0, 0, c.Width, c.Height)
+		if c.ColorModel != want.ColorModel() {
+			t.Fatalf("page %d: got color model %v", i, c.ColorModel)
+		}
+		var got image.Image
+		var pix []byte
+		if i == 0 {
+			m := image.NewRGBA(b)
+			got, pix = m, m.Pix
+		} else {
+			m := image.NewGray16(b)
+			got, pix = m, m.Pix
+		}
+		rowLen := len(pix) / c.Height
+		for y := 0; ; {
+			n, err := r.ReadRows(pix[y*rowLen:], minInt(5, c.Height-y))
+			y += n
+			if err == io.EOF {
+				if y != c.Height {
+					t.Fatalf("page %d: got io.EOF after %d rows", i, y)
+				}
+				break
+			}
+			if err != nil {
+				t.Fatalf("page %d: %v", i, err)
+			}
+		}
+		compare(t, want, got)
+	}
+	if _, err := r.ReadRows(nil, 0); err != io.EOF {
+		t.Errorf("after the last page: got error %v, want io.EOF", err)
+	}
+
+	// Next decodes a page whose Config has been read, and skips the rest
+	// of a page whose rows are being read.
+	r, err = NewReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Config(); err != nil {
+		t.Fatal(err)
+	}
+	m, err := r.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	compare(t, src, m)
+	if n, err := r.ReadRows(make([]byte, 2*src.Bounds().Dx()*2), 2); n != 2 || err != nil {
+		t.Fatalf("got %d rows, %v", n, err)
+	}
+	if _, err := r.Next(); err != io.EOF {
+		t.Errorf("got error %v, want io.EOF", err)
+	}
+}
@@ -0,0 +1,24 @@
+package tiff
+
+import "io"
+
+// SplitPages decodes each page of the multi-page TIFF file in r and encodes
+// it as a single-page TIFF file to the writer returned by newWriter, which
+// is called with the zero-based index of the page. Each page is encoded
+// with the options returned by the Options method of its SourceInfo, so
+// that its compression, predictor and tiling are kept where possible.
+func SplitPages(r io.ReaderAt, newWriter func(page int) (io.Writer, error)) error {
+	page := 0
+	return forEachPage(r, nil, func(d *decoder) error {
+		img, err := d.decodeImage()
+		if err != nil {
+			return err
+		}
+		w, err := newWriter(page)
+		if err != nil {
+			return err
+		}
+		page++
+		return Encode(w, img, d.sourceInfo().Options())
+	})
+}
@@ -0,0 +1,101 @@
+package tiff
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+// TestSplitPages tests that SplitPages writes each page of a multi-page file
+// to its own single-page file, keeping the compression of the page.
+func TestSplitPages(t *testing.T) {
+	src := multiPageTIFF(t)
+	want, err := DecodeAll(bytes.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var pages []*bytes.Buffer
+	err = SplitPages(bytes.NewReader(src), func(page int) (io.Writer, error) {
+		if page != len(pages) {
+			t.Errorf("got page %d, want %d", page, len(pages))
+		}
+		pages = append(pages, new(bytes.Buffer))
+		return pages[page], nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pages) != len(want) {
+		t.Fatalf("got %d pages, want %d", len(pages), len(want))
+	}
+	compressions := []int{cNone, cDeflate, cNone}
+	for i, p := range pages {
+		imgs, err := DecodeAll(bytes.NewReader(p.Bytes()))
+		if err != nil {
+			t.Fatalf("page %d: %v", i, err)
+		}
+		if len(imgs) != 1 {
+			t.Fatalf("page %d: got %d images, want 1", i, len(imgs))
+		}
+		compare(t, want[i], imgs[0])
+
+		d, err := newDecoder(bytes.NewReader(p.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c := d.sourceInfo().Compression; c != compressions[i] {
+			t.Errorf("page %d: got compression %d, want %d", i, c, compressions[i])
+		}
+	}
+}
+
+// TestSplitPagesBilevel tests that SplitPages keeps the photometric
+// interpretation and the bit depth of bilevel pages.
+func TestSplitPagesBilevel(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		compression int
+	}{
+		{"bw-uncompressed.tiff", cNone},
+		{"bw-packbits.tiff", cPackBits},
+		{"bw-gopher_ccittGroup4.tiff", cG4},
+	} {
+		src, err := ioutil.ReadFile(testdataDir + tc.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		srcInfo := sourceInfoOf(t, src)
+		var out bytes.Buffer
+		err = SplitPages(bytes.NewReader(src), func(int) (io.Writer, error) { return &out, nil })
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		info := sourceInfoOf(t, out.Bytes())
+		if info.Compression != tc.compression || info.Photometric != srcInfo.Photometric || !reflect.DeepEqual(info.BitsPerSample, []int{1}) {
+			t.Errorf("%s: got compression %d, photometric %d and BitsPerSample %v, want %d, %d and [1]",
+				tc.name, info.Compression, info.Photometric, info.BitsPerSample, tc.compression, srcInfo.Photometric)
+		}
+		want, err := Decode(bytes.NewReader(src))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := Decode(&out)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		compare(t, want, got)
+	}
+}
+
+// sourceInfoOf returns the SourceInfo of the first page of the file b.
+func sourceInfoOf(t *testing.T, b []byte) *SourceInfo {
+	t.Helper()
+	d, err := newDecoder(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d.sourceInfo()
+}
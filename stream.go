@@ -0,0 +1,157 @@
+package tiff
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// NewStreamEncoder returns an Encoder that writes the file to w in a single
+// pass, without buffering the compressed data of a page: the strips or tiles
+// of each page are compressed straight into w, and the IFDs of all pages,
+// whose offsets of the pixel data are only known then, are written after
+// the pixel data of the last page by Close. The output is entirely
+// determined by the images and their options.
+//
+// The header of the file, which holds the offset of the first IFD, is
+// written first with an offset of 0. If w is an io.WriteSeeker, Close seeks
+// back to complete it and returns to the end of the file, which starts at
+// the position of w when the first image is written. Otherwise, such as for
+// a pipe or the parts of a multipart upload, the first bytes of the output
+// must be replaced by those returned by Header after Close.
+//
+// As the pixel data is written before it is known whether the file exceeds
+// 4 GB, a BigTIFF file is written only if Options.ForceBigTIFF is set for
+// the first page.
+func NewStreamEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w, order: binary.LittleEndian, stream: true}
+}
+
+// Header returns the header of a file written by an Encoder returned by
+// NewStreamEncoder, which is 8 bytes long, or 16 for a BigTIFF file. It
+// returns nil until Close has been called successfully.
+func (e *Encoder) Header() []byte {
+	return e.header
+}
+
+// streamPage writes the pixel data of a page and its sub-IFDs to e.w and
+// adds the IFD of the page, given by entries, to e.pending.
+func (e *Encoder) streamPage(px pixels, opt *Options, compression uint32, level, workers int, predictor, tiled bool, rowsPerStrip int, extra []ifdEntry, subs []subIFD, entries func(dataOffset int64, lens []int) []ifdEntry) error {
+	if err := e.startStream(opt != nil && opt.ForceBigTIFF); err != nil {
+		return err
+	}
+	if err := e.checkExtra(extra); err != nil {
+		return err
+	}
+
+	cw := &countWriter{w: e.w}
+	var lens []int
+	var err error
+	if tiled {
+		err = encodeTiles(px.pix, px.dx, px.dy, px.stride, px.bpp, opt.TileWidth, opt.TileLength, px.enc, compression, level, predictor, px.sparse, workers, func(t []byte) error {
+			lens = append(lens, len(t))
+			_, err := cw.Write(t)
+			return err
+		})
+	} else {
+		lens, err = px.encodeStrips(cw, compression, level, workers, predictor, rowsPerStrip)
+	}
+	if err != nil {
+		return err
+	}
+	return e.endStreamPage(cw.n, func(dataOffset int64) []ifdEntry {
+		return entries(dataOffset, lens)
+	}, subs)
+}
+
+// startStream writes the header of the file before the first page of a
+// stream Encoder, in the BigTIFF format if forceBig is true.
+func (e *Encoder) startStream(forceBig bool) error {
+	if e.pos != 0 {
+		return nil
+	}
+	e.big = forceBig
+	if s, ok := e.w.(io.Seeker); ok {
+		var err error
+		if e.start, err = s.Seek(0, io.SeekCurrent); err != nil {
+			return err
+		}
+	}
+	if err := e.link(0); err != nil {
+		return err
+	}
+	e.pos = 8
+	if e.big {
+		e.pos = 16
+	}
+	return nil
+}
+
+// endStreamPage writes the sub-IFDs of a page of a stream Encoder after its
+// n bytes of pixel data and adds the IFD of the page, given by entries for
+// the offset of the pixel data, to e.pending.
+func (e *Encoder) endStreamPage(n int64, entries func(dataOffset int64) []ifdEntry, subs []subIFD) error {
+	dataOffset := e.pos
+	e.pos += n
+
+	// The sub-IFDs and the IFDs must begin on a word boundary (8 bytes in
+	// BigTIFF files).
+	pad := padLen(int(e.pos%int64(e.align())), e.align())
+	if _, err := e.w.Write(make([]byte, pad)); err != nil {
+		return err
+	}
+	e.pos += int64(pad)
+	d := entries(dataOffset)
+	for _, sub := range subs {
+		d = append(d, e.ifdPointer(sub.tag, e.pos))
+		if err := writeIFD(e.w, e.pos, sub.entries, 0, e.order, e.big); err != nil {
+			return err
+		}
+		e.pos += ifdSize(sub.entries, e.big)
+	}
+	if !e.big && e.pos > maxClassicSize {
+		return errors.New("tiff: file exceeds 4 GB; set Options.ForceBigTIFF for its first page")
+	}
+	e.pending = append(e.pending, d)
+	return nil
+}
+
+// closeStream writes the IFDs of the pages written by a stream Encoder and
+// completes the header of the file.
+func (e *Encoder) closeStream() error {
+	if len(e.pending) == 0 {
+		return errors.New("tiff: no image written")
+	}
+	e.closed = true
+	first := e.pos
+	for i, d := range e.pending {
+		var next int64
+		if i+1 < len(e.pending) {
+			next = e.pos + ifdSize(d, e.big)
+		}
+		if !e.big && e.pos+ifdSize(d, false) > maxClassicSize {
+			return errors.New("tiff: file exceeds 4 GB; set Options.ForceBigTIFF for its first page")
+		}
+		if err := writeIFD(e.w, e.pos, d, next, e.order, e.big); err != nil {
+			return err
+		}
+		e.pos += ifdSize(d, e.big)
+	}
+
+	// The offset of the first IFD follows the first 4 bytes of the
+	// header, or the first 8 in a BigTIFF file.
+	h := e.fileHeader(first)
+	if ws, ok := e.w.(io.WriteSeeker); ok {
+		if _, err := ws.Seek(e.start, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := ws.Write(h); err != nil {
+			return err
+		}
+		if _, err := ws.Seek(e.start+e.pos, io.SeekStart); err != nil {
+			return err
+		}
+	}
+	e.header = h
+	return nil
+}
@@ -0,0 +1,102 @@
+package tiff
+
+import (
+	"bytes"
+	"image"
+	"reflect"
+	"testing"
+)
+
+func TestStreamEncoder(t *testing.T) {
+	src, err := openImage("video-001.tiff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gray := image.NewGray(image.Rect(0, 0, 30, 20))
+	for i := range gray.Pix {
+		gray.Pix[i] = uint8(i * 7)
+	}
+	for _, first := range []*Options{
+		{Compression: LZW, RowsPerStrip: 16, Exif: &Exif{ISO: 100}},
+		{Compression: Deflate, Predictor: true, TileWidth: 32, TileLength: 32, ForceBigTIFF: true},
+		nil,
+	} {
+		write := func(e *Encoder) {
+			t.Helper()
+			if err := e.WriteImage(src, first); err != nil {
+				t.Fatal(err)
+			}
+			if err := e.WriteImage(gray, &Options{Compression: LZW, TileWidth: 16, TileLength: 16}); err != nil {
+				t.Fatal(err)
+			}
+			if err := e.Close(); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// A writer that cannot seek is left with a zero IFD offset in the
+		// header, which the caller completes.
+		var buf bytes.Buffer
+		e := NewStreamEncoder(&buf)
+		write(e)
+		// The offset fills the second half of the header.
+		h := e.Header()
+		if !bytes.Equal(buf.Bytes()[len(h)/2:len(h)], make([]byte, len(h)/2)) {
+			t.Errorf("%+v: the header was already completed", first)
+		}
+		copy(buf.Bytes(), h)
+		pages, err := DecodeAll(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("%+v: %v", first, err)
+		}
+		if len(pages) != 2 {
+			t.Fatalf("%+v: got %d pages, want 2", first, len(pages))
+		}
+		compare(t, src, pages[0])
+		compare(t, gray, pages[1])
+		if _, m, err := DecodeWithMetadata(bytes.NewReader(buf.Bytes())); err != nil || (first != nil && first.Exif != nil) != (m.Exif != nil) {
+			t.Errorf("%+v: got Exif %v, %v", first, m.Exif, err)
+		}
+
+		// Close completes the header of a writer that can seek, and the
+		// output does not depend on the writer.
+		sb := &seekBuffer{buf: []byte("prefix")}
+		sb.off = len(sb.buf)
+		write(NewStreamEncoder(sb))
+		if got := sb.buf[len("prefix"):]; !bytes.Equal(got, buf.Bytes()) {
+			t.Errorf("%+v: output to io.WriteSeeker differs", first)
+		}
+		if sb.off != len(sb.buf) {
+			t.Errorf("%+v: writer left at %d, want %d", first, sb.off, len(sb.buf))
+		}
+	}
+}
+
+func TestStreamEncoderErrors(t *testing.T) {
+	var buf bytes.Buffer
+	e := NewStreamEncoder(&buf)
+	if err := e.Close(); err == nil {
+		t.Error("Close without image: got no error")
+	}
+	if h := e.Header(); h != nil {
+		t.Errorf("got header %v before Close", h)
+	}
+
+	defer func(n int64) { maxClassicSize = n }(maxClassicSize)
+	maxClassicSize = 100
+	e = NewStreamEncoder(&buf)
+	if err := e.WriteImage(image.NewGray(image.Rect(0, 0, 20, 20)), nil); err == nil {
+		t.Error("image exceeding the size of a classic TIFF file: got no error")
+	}
+	buf.Reset()
+	e = NewStreamEncoder(&buf)
+	if err := e.WriteImage(image.NewGray(image.Rect(0, 0, 20, 20)), &Options{ForceBigTIFF: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if h := e.Header(); !reflect.DeepEqual(h[:4], []byte(bigLEHeader)) {
+		t.Errorf("got header %q, want BigTIFF", h)
+	}
+}
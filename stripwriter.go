@@ -0,0 +1,314 @@
+package tiff
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image/color"
+	"io"
+	"math"
+)
+
+// A StripWriter writes a single-page TIFF file whose pixel data is passed one
+// strip of rows at a time, so that images larger than memory can be written.
+// Each strip is compressed and written as soon as it is passed; the IFD
+// follows the last strip and is written by Close, which then stores its
+// offset in the header of the file.
+type StripWriter struct {
+	w            io.WriteSeeker
+	e            *Encoder
+	opt          *Options
+	start        int64 // Offset of the file in w.
+	width        int
+	height       int
+	rowsPerStrip int
+	bpp          int // Bytes per pixel.
+	enc          pixelEncoder
+	invert       bool       // Whether the samples are inverted (WhiteIsZero).
+	format       []ifdEntry // Entries describing the format of the samples.
+	compression  uint32
+	predictor    bool
+	rows         int     // Rows written so far.
+	offsets      []int64 // Offsets of the strips written so far.
+	counts       []int64 // Byte counts of the strips written so far.
+	next         int64   // Offset after the last strip.
+	err          error   // First error, after which all methods fail.
+}
+
+// NewStripWriter returns a StripWriter that writes an image of width by
+// height pixels to w, divided into strips of rowsPerStrip rows, except for
+// the last strip, which holds the remaining rows.
+//
+// model determines the format of the samples and of the pixel data passed
+// to WriteStrip, which is laid out like the Pix field of the corresponding
+// image type: color.GrayModel (image.Gray), color.Gray16Model
+// (image.Gray16), color.RGBAModel (image.RGBA), color.RGBA64Model
+// (image.RGBA64), color.NRGBAModel (image.NRGBA), color.NRGBA64Model
+// (image.NRGBA64), color.CMYKModel (image.CMYK) or a color.Palette
+// (image.Paletted).
+//
+// opt is used as for Encode, except that the image cannot be tiled and
+// CCITTGroup4 compression and BaselineOnly are not supported. If the image
+// may not fit into 4 GB, the file is written as a BigTIFF file. The file
+// starts at the current position of w, and w must stay positioned after the
+// data written so far between calls.
+func NewStripWriter(w io.WriteSeeker, width, height, rowsPerStrip int, model color.Model, opt *Options) (*StripWriter, error) {
+	if width <= 0 || height <= 0 || int64(width) > math.MaxUint32 || int64(height) > math.MaxUint32 {
+		return nil, fmt.Errorf("tiff: invalid image size %dx%d", width, height)
+	}
+	if rowsPerStrip <= 0 {
+		return nil, fmt.Errorf("tiff: invalid number of rows per strip %d", rowsPerStrip)
+	}
+	compression, predictor, tiled, err := encodingParams(opt)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case tiled:
+		return nil, errors.New("tiff: StripWriter does not write tiled images")
+	case compression == cG4:
+		return nil, errors.New("tiff: StripWriter does not support CCITTGroup4 compression")
+	case opt != nil && opt.BaselineOnly:
+		return nil, errors.New("tiff: StripWriter does not support BaselineOnly")
+	}
+	s := &StripWriter{
+		w:            w,
+		e:            NewEncoder(w),
+		opt:          opt,
+		width:        width,
+		height:       height,
+		rowsPerStrip: minInt(rowsPerStrip, height),
+		compression:  compression,
+		predictor:    predictor,
+	}
+	if err := s.setFormat(model, opt != nil && opt.WhiteIsZero); err != nil {
+		return nil, err
+	}
+	s.e.order = fileOrder(opt)
+	s.enc = s.e.swapSamples(s.enc, s.format)
+	// LZW and Deflate data can be slightly larger than the uncompressed
+	// samples, so BigTIFF is used well before the limit.
+	size := int64(width) * int64(height) * int64(s.bpp)
+	s.e.big = opt != nil && opt.ForceBigTIFF || size+size/8 > maxClassicSize
+	if s.start, err = w.Seek(0, io.SeekCurrent); err != nil {
+		return nil, err
+	}
+	// The header is written with a zero offset of the IFD, which Close
+	// replaces.
+	if err := s.e.link(0); err != nil {
+		return nil, err
+	}
+	s.next = 8
+	if s.e.big {
+		s.next = 16
+	}
+	return s, nil
+}
+
+// setFormat sets the encoding of the samples of the image according to model.
+func (s *StripWriter) setFormat(model color.Model, whiteIsZero bool) error {
+	rgb := func(bps int, extraSamples uint32) {
+		s.format = []ifdEntry{
+			{tBitsPerSample, dtShort, []uint32{uint32(bps), uint32(bps), uint32(bps), uint32(bps)}},
+			{tPhotometricInterpretation, dtShort, []uint32{pRGB}},
+			{tSamplesPerPixel, dtShort, []uint32{4}},
+			{tExtraSamples, dtShort, []uint32{extraSamples}},
+		}
+	}
+	gray := func(bps int) {
+		p := uint32(pBlackIsZero)
+		if whiteIsZero {
+			p, s.invert = pWhiteIsZero, true
+		}
+		s.format = []ifdEntry{
+			{tBitsPerSample, dtShort, []uint32{uint32(bps)}},
+			{tPhotometricInterpretation, dtShort, []uint32{p}},
+			{tSamplesPerPixel, dtShort, []uint32{1}},
+		}
+	}
+	switch model {
+	case color.GrayModel:
+		gray(8)
+		s.bpp, s.enc = 1, encodeGray
+	case color.Gray16Model:
+		gray(16)
+		s.bpp, s.enc = 2, encodeGray16
+	case color.RGBAModel:
+		rgb(8, 1) // Associated alpha.
+		s.bpp, s.enc = 4, encodeRGBA
+	case color.RGBA64Model:
+		rgb(16, 1)
+		s.bpp, s.enc = 8, encodeRGBA64
+	case color.NRGBAModel:
+		rgb(8, 2) // Unassociated alpha.
+		s.bpp, s.enc = 4, encodeRGBA
+	case color.NRGBA64Model:
+		rgb(16, 2)
+		s.bpp, s.enc = 8, encodeRGBA64
+	case color.CMYKModel:
+		s.format = []ifdEntry{
+			{tBitsPerSample, dtShort, []uint32{8, 8, 8, 8}},
+			{tPhotometricInterpretation, dtShort, []uint32{pCMYK}},
+			{tSamplesPerPixel, dtShort, []uint32{4}},
+		}
+		s.bpp, s.enc = 4, encodeCMYK
+	default:
+		p, ok := model.(color.Palette)
+		if !ok {
+			return fmt.Errorf("tiff: StripWriter does not support color model %T", model)
+		}
+		colorMap := make([]uint32, 256*3)
+		for i := 0; i < 256 && i < len(p); i++ {
+			r, g, b, _ := p[i].RGBA()
+			colorMap[i+0*256] = r
+			colorMap[i+1*256] = g
+			colorMap[i+2*256] = b
+		}
+		s.format = []ifdEntry{
+			{tBitsPerSample, dtShort, []uint32{8}},
+			{tPhotometricInterpretation, dtShort, []uint32{pPaletted}},
+			{tSamplesPerPixel, dtShort, []uint32{1}},
+			{tColorMap, dtShort, colorMap},
+		}
+		s.bpp, s.enc = 1, encodeGray
+	}
+	return nil
+}
+
+// WriteStrip compresses and writes the next strip of the image. pix holds
+// its rows of pixels without padding, laid out as described for
+// NewStripWriter; its length must be that of rowsPerStrip rows, or of the
+// remaining rows for the last strip.
+func (s *StripWriter) WriteStrip(pix []byte) error {
+	if s.err != nil {
+		return s.err
+	}
+	if s.rows == s.height {
+		return errors.New("tiff: all strips have been written")
+	}
+	rows := minInt(s.rowsPerStrip, s.height-s.rows)
+	if want := rows * s.width * s.bpp; len(pix) != want {
+		return fmt.Errorf("tiff: strip of %d bytes, want %d", len(pix), want)
+	}
+	if s.invert {
+		pix = invertBytes(pix)
+	}
+	cw := &countWriter{w: s.w}
+	var err error
+	if s.compression == cNone {
+		err = s.enc(cw, pix, s.width, rows, s.width*s.bpp, s.predictor)
+	} else {
+		level := 0
+		if s.opt != nil {
+			level = s.opt.CompressionLevel
+		}
+		var dst io.WriteCloser
+		if dst, err = newCompressor(cw, s.compression, level, rows); err == nil {
+			if err = s.enc(dst, pix, s.width, rows, s.width*s.bpp, s.predictor); err == nil {
+				err = dst.Close()
+			}
+		}
+	}
+	if err == nil && !s.e.big && s.next+cw.n > maxClassicSize {
+		err = errors.New("tiff: file exceeds 4 GB; set Options.ForceBigTIFF")
+	}
+	if err != nil {
+		s.err = err
+		return err
+	}
+	s.offsets = append(s.offsets, s.next)
+	s.counts = append(s.counts, cw.n)
+	s.next += cw.n
+	s.rows += rows
+	return nil
+}
+
+// Close writes the IFD of the image after its last strip and completes the
+// header of the file. All strips must have been written. It does not close
+// the underlying writer, which is left positioned at the end of the file.
+func (s *StripWriter) Close() error {
+	if s.err != nil {
+		return s.err
+	}
+	s.err = errors.New("tiff: StripWriter is closed")
+	if s.rows < s.height {
+		return fmt.Errorf("tiff: StripWriter closed after %d of %d rows", s.rows, s.height)
+	}
+	ifd, extra, subs, err := pageEntries(s.opt, append([]ifdEntry(nil), s.format...))
+	if err != nil {
+		return err
+	}
+	if err := s.e.checkExtra(extra); err != nil {
+		return err
+	}
+	d := append(ifd, imageEntries(s.width, s.height, s.compression, s.opt)...)
+	d = append(d,
+		s.e.offsetEntry(tStripOffsets, s.offsets),
+		shortOrLong(tRowsPerStrip, s.rowsPerStrip),
+		s.e.offsetEntry(tStripByteCounts, s.counts),
+	)
+	if s.predictor {
+		d = append(d, ifdEntry{tPredictor, dtShort, []uint32{prHorizontal}})
+	}
+	d = append(d, extra...)
+
+	// The IFD must begin on a word boundary (8 bytes in BigTIFF files).
+	align := s.e.align()
+	pad := padLen(int(s.next%int64(align)), align)
+	ifdOffset := s.next + int64(pad)
+	for i := range subs {
+		subs[i].offset = ifdOffset
+		d = append(d, s.e.ifdPointer(subs[i].tag, ifdOffset))
+		ifdOffset += ifdSize(subs[i].entries, s.e.big)
+	}
+	if !s.e.big && ifdOffset+ifdSize(d, false) > maxClassicSize {
+		return errors.New("tiff: file exceeds 4 GB; set Options.ForceBigTIFF")
+	}
+	if _, err := s.w.Write(make([]byte, pad)); err != nil {
+		return err
+	}
+	for _, sub := range subs {
+		if err := writeIFD(s.w, sub.offset, sub.entries, 0, s.e.order, s.e.big); err != nil {
+			return err
+		}
+	}
+	s.e.ifd, s.e.offset = d, ifdOffset
+	if err := s.e.Close(); err != nil {
+		return err
+	}
+
+	// The offset of the IFD follows the first 4 bytes of the header, or
+	// the first 8 in a BigTIFF file.
+	end, err := s.w.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if s.e.big {
+		_, err = s.w.Seek(s.start+8, io.SeekStart)
+		if err == nil {
+			err = binary.Write(s.w, s.e.order, uint64(ifdOffset))
+		}
+	} else {
+		_, err = s.w.Seek(s.start+4, io.SeekStart)
+		if err == nil {
+			err = binary.Write(s.w, s.e.order, uint32(ifdOffset))
+		}
+	}
+	if err != nil {
+		return err
+	}
+	_, err = s.w.Seek(end, io.SeekStart)
+	return err
+}
+
+// countWriter is an io.Writer that counts the bytes written to it.
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	return n, err
+}
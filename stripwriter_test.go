@@ -0,0 +1,158 @@
+package tiff
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"io"
+	"testing"
+)
+
+// seekBuffer is an in-memory io.WriteSeeker.
+type seekBuffer struct {
+	buf []byte
+	off int
+}
+
+func (b *seekBuffer) Write(p []byte) (int, error) {
+	if end := b.off + len(p); end > len(b.buf) {
+		b.buf = append(b.buf, make([]byte, end-len(b.buf))...)
+	}
+	n := copy(b.buf[b.off:], p)
+	b.off += n
+	return n, nil
+}
+
+func (b *seekBuffer) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += int64(b.off)
+	case io.SeekEnd:
+		offset += int64(len(b.buf))
+	}
+	if offset < 0 {
+		return 0, errors.New("negative offset")
+	}
+	b.off = int(offset)
+	return offset, nil
+}
+
+// writeStrips writes the pixels pix of a dx by dy image, stored without
+// padding, with a StripWriter in strips of rows rows.
+func writeStrips(w io.WriteSeeker, pix []byte, dx, dy, rows int, model color.Model, opt *Options) error {
+	s, err := NewStripWriter(w, dx, dy, rows, model, opt)
+	if err != nil {
+		return err
+	}
+	rowLen := len(pix) / dy
+	for y := 0; y < dy; y += rows {
+		if err := s.WriteStrip(pix[y*rowLen : minInt(y+rows, dy)*rowLen]); err != nil {
+			return err
+		}
+	}
+	return s.Close()
+}
+
+func TestStripWriter(t *testing.T) {
+	src, err := openImage("video-001.tiff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := src.Bounds()
+	r := image.Rect(0, 0, b.Dx(), b.Dy())
+	gray := image.NewGray(r)
+	draw.Draw(gray, r, src, b.Min, draw.Src)
+	nrgba64 := image.NewNRGBA64(r)
+	draw.Draw(nrgba64, r, src, b.Min, draw.Src)
+	paletted := image.NewPaletted(r, palette.Plan9)
+	draw.Draw(paletted, r, src, b.Min, draw.Src)
+	rgba := image.NewRGBA(r)
+	draw.Draw(rgba, r, src, b.Min, draw.Src)
+
+	for _, m := range []struct {
+		img   image.Image
+		pix   []byte
+		model color.Model
+	}{
+		{gray, gray.Pix, color.GrayModel},
+		{nrgba64, nrgba64.Pix, color.NRGBA64Model},
+		{paletted, paletted.Pix, paletted.Palette},
+		{rgba, rgba.Pix, color.RGBAModel},
+	} {
+		for _, opt := range []*Options{
+			nil,
+			{Compression: LZW, Predictor: true},
+			{Compression: Deflate, HostComputer: "test", ForceBigTIFF: true},
+			{WhiteIsZero: true, Exif: &Exif{ISO: 200}},
+			{Compression: LZW, Predictor: true, ByteOrder: binary.BigEndian, ForceBigTIFF: true},
+		} {
+			// The file is written after some other data.
+			w := &seekBuffer{}
+			w.Write([]byte("prefix"))
+			if err := writeStrips(w, m.pix, r.Dx(), r.Dy(), 7, m.model, opt); err != nil {
+				t.Fatalf("%T, %+v: %v", m.img, opt, err)
+			}
+			if w.off != len(w.buf) {
+				t.Errorf("%T, %+v: writer at %d after Close, want %d", m.img, opt, w.off, len(w.buf))
+			}
+			got, md, err := DecodeWithMetadata(bytes.NewReader(w.buf[len("prefix"):]))
+			if err != nil {
+				t.Fatalf("%T, %+v: %v", m.img, opt, err)
+			}
+			compare(t, m.img, got)
+			if opt != nil && opt.Exif != nil && (md.Exif == nil || md.Exif.ISO != 200) {
+				t.Errorf("%T: got Exif %+v", m.img, md.Exif)
+			}
+		}
+	}
+}
+
+func TestStripWriterErrors(t *testing.T) {
+	pix := make([]byte, 10*5)
+	if _, err := NewStripWriter(&seekBuffer{}, 10, 5, 2, color.Alpha16Model, nil); err == nil {
+		t.Error("unsupported color model: got nil error")
+	}
+	if _, err := NewStripWriter(&seekBuffer{}, 10, 5, 2, color.GrayModel, &Options{TileWidth: 16, TileLength: 16}); err == nil {
+		t.Error("tiles: got nil error")
+	}
+	if _, err := NewStripWriter(&seekBuffer{}, 0, 5, 2, color.GrayModel, nil); err == nil {
+		t.Error("zero width: got nil error")
+	}
+
+	s, err := NewStripWriter(&seekBuffer{}, 10, 5, 2, color.GrayModel, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.WriteStrip(pix[:10]); err == nil {
+		t.Error("short strip: got nil error")
+	}
+	if err := s.WriteStrip(pix[:20]); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err == nil {
+		t.Error("missing strips: got nil error")
+	}
+
+	s, err = NewStripWriter(&seekBuffer{}, 10, 5, 2, color.GrayModel, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range []int{20, 20, 10} {
+		if err := s.WriteStrip(pix[:n]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.WriteStrip(pix[:10]); err == nil {
+		t.Error("strip after the last one: got nil error")
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err == nil {
+		t.Error("second Close: got nil error")
+	}
+}
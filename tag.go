@@ -0,0 +1,276 @@
+package tiff
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// A DataType is the type of the values of an IFD entry (p. 15-16 of the
+// spec, and the BigTIFF extension for the 64-bit types).
+type DataType uint16
+
+// The data types of IFD entries.
+const (
+	TypeByte      DataType = dtByte
+	TypeASCII     DataType = dtASCII
+	TypeShort     DataType = dtShort
+	TypeLong      DataType = dtLong
+	TypeRational  DataType = dtRational
+	TypeSByte     DataType = dtSByte
+	TypeUndefined DataType = dtUndefined
+	TypeSShort    DataType = dtSShort
+	TypeSLong     DataType = dtSLong
+	TypeSRational DataType = dtSRational
+	TypeFloat     DataType = dtFloat
+	TypeDouble    DataType = dtDouble
+	TypeIFD       DataType = dtIFD
+	TypeLong8     DataType = dtLong8
+	TypeSLong8    DataType = dtSLong8
+	TypeIFD8      DataType = dtIFD8
+)
+
+// A Tag is a single entry of an Image File Directory.
+type Tag struct {
+	ID    uint16
+	Type  DataType
+	Count uint32 // Number of values, as stored in the entry.
+	// Value holds the values of the entry, depending on Type:
+	//
+	//   - a string for ASCII, ending at the first NUL byte,
+	//   - a []byte for Byte and Undefined,
+	//   - a []uint64 for Short, Long, Long8, IFD and IFD8,
+	//   - a []int64 for SByte, SShort, SLong and SLong8,
+	//   - a [][2]uint32 (numerator, denominator) for Rational,
+	//   - a [][2]int32 for SRational,
+	//   - a []float64 for Float and Double.
+	//
+	// Value is nil if Type is not one of the above.
+	Value interface{}
+}
+
+// tagValue decodes the raw value bytes of an IFD entry of the given data
+// type and count.
+func tagValue(byteOrder binary.ByteOrder, datatype DataType, count uint32, raw []byte) interface{} {
+	switch datatype {
+	case TypeASCII:
+		if i := bytes.IndexByte(raw, 0); i >= 0 {
+			raw = raw[:i]
+		}
+		return string(raw)
+	case TypeByte, TypeUndefined:
+		return append([]byte(nil), raw...)
+	case TypeShort, TypeLong, TypeLong8, TypeIFD, TypeIFD8:
+		v := make([]uint64, count)
+		for i := range v {
+			switch datatype {
+			case TypeShort:
+				v[i] = uint64(byteOrder.Uint16(raw[2*i:]))
+			case TypeLong, TypeIFD:
+				v[i] = uint64(byteOrder.Uint32(raw[4*i:]))
+			default:
+				v[i] = byteOrder.Uint64(raw[8*i:])
+			}
+		}
+		return v
+	case TypeSByte, TypeSShort, TypeSLong, TypeSLong8:
+		v := make([]int64, count)
+		for i := range v {
+			switch datatype {
+			case TypeSByte:
+				v[i] = int64(int8(raw[i]))
+			case TypeSShort:
+				v[i] = int64(int16(byteOrder.Uint16(raw[2*i:])))
+			case TypeSLong:
+				v[i] = int64(int32(byteOrder.Uint32(raw[4*i:])))
+			default:
+				v[i] = int64(byteOrder.Uint64(raw[8*i:]))
+			}
+		}
+		return v
+	case TypeRational:
+		v := make([][2]uint32, count)
+		for i := range v {
+			v[i] = [2]uint32{byteOrder.Uint32(raw[8*i:]), byteOrder.Uint32(raw[8*i+4:])}
+		}
+		return v
+	case TypeSRational:
+		v := make([][2]int32, count)
+		for i := range v {
+			v[i] = [2]int32{int32(byteOrder.Uint32(raw[8*i:])), int32(byteOrder.Uint32(raw[8*i+4:]))}
+		}
+		return v
+	case TypeFloat, TypeDouble:
+		v := make([]float64, count)
+		for i := range v {
+			if datatype == TypeFloat {
+				v[i] = float64(math.Float32frombits(byteOrder.Uint32(raw[4*i:])))
+			} else {
+				v[i] = math.Float64frombits(byteOrder.Uint64(raw[8*i:]))
+			}
+		}
+		return v
+	}
+	return nil
+}
+
+// ifdTag decodes the IFD entry in p. Entries of an unknown data type are
+// returned without a value.
+func (d *decoder) ifdTag(p []byte) (Tag, error) {
+	t := Tag{
+		ID:    d.byteOrder.Uint16(p[0:2]),
+		Type:  DataType(d.byteOrder.Uint16(p[2:4])),
+		Count: uint32(d.entryCount(p)),
+	}
+	if int(t.Type) < len(lengths) && lengths[t.Type] != 0 {
+		datatype, count, raw, err := d.ifdData(p)
+		if err != nil {
+			return Tag{}, err
+		}
+		t.Value = tagValue(d.byteOrder, DataType(datatype), count, raw)
+	}
+	return t, nil
+}
+
+// entry converts t into an ifdEntry for writing. The type of t.Value must
+// match t.Type as documented for Tag; t.Count is ignored.
+func (t Tag) entry() (ifdEntry, error) {
+	e := ifdEntry{tag: int(t.ID), datatype: int(t.Type)}
+	bad := func() (ifdEntry, error) {
+		return ifdEntry{}, fmt.Errorf("tiff: value of type %T for tag %d of type %d", t.Value, t.ID, t.Type)
+	}
+	split := func(v uint64) {
+		e.data = append(e.data, uint32(v), uint32(v>>32))
+	}
+	switch v := t.Value.(type) {
+	case string:
+		if t.Type != TypeASCII {
+			return bad()
+		}
+		for i := 0; i < len(v); i++ {
+			e.data = append(e.data, uint32(v[i]))
+		}
+		// ASCII values end with a NUL byte.
+		e.data = append(e.data, 0)
+	case []byte:
+		if t.Type != TypeByte && t.Type != TypeUndefined {
+			return bad()
+		}
+		for _, b := range v {
+			e.data = append(e.data, uint32(b))
+		}
+	case []uint64:
+		for _, u := range v {
+			switch t.Type {
+			case TypeShort:
+				if u > math.MaxUint16 {
+					return ifdEntry{}, fmt.Errorf("tiff: value %d for tag %d overflows Short", u, t.ID)
+				}
+				e.data = append(e.data, uint32(u))
+			case TypeLong, TypeIFD:
+				if u > math.MaxUint32 {
+					return ifdEntry{}, fmt.Errorf("tiff: value %d for tag %d overflows Long", u, t.ID)
+				}
+				e.data = append(e.data, uint32(u))
+			case TypeLong8, TypeIFD8:
+				split(u)
+			default:
+				return bad()
+			}
+		}
+	case []int64:
+		for _, i := range v {
+			switch t.Type {
+			case TypeSByte, TypeSShort, TypeSLong:
+				bits := 8 * uint(lengths[t.Type])
+				if i < -1<<(bits-1) || i >= 1<<(bits-1) {
+					return ifdEntry{}, fmt.Errorf("tiff: value %d for tag %d overflows its type", i, t.ID)
+				}
+				e.data = append(e.data, uint32(i))
+			case TypeSLong8:
+				split(uint64(i))
+			default:
+				return bad()
+			}
+		}
+	case [][2]uint32:
+		if t.Type != TypeRational {
+			return bad()
+		}
+		for _, r := range v {
+			e.data = append(e.data, r[0], r[1])
+		}
+	case [][2]int32:
+		if t.Type != TypeSRational {
+			return bad()
+		}
+		for _, r := range v {
+			e.data = append(e.data, uint32(r[0]), uint32(r[1]))
+		}
+	case []float64:
+		for _, f := range v {
+			switch t.Type {
+			case TypeFloat:
+				e.data = append(e.data, math.Float32bits(float32(f)))
+			case TypeDouble:
+				split(math.Float64bits(f))
+			default:
+				return bad()
+			}
+		}
+	default:
+		return bad()
+	}
+	return e, nil
+}
+
+// readTags reads all entries of the IFD at offset in r and returns them
+// together with the offset of the next IFD.
+func readTags(r io.ReaderAt, h header, offset int64) (map[uint16]Tag, int64, error) {
+	d := &decoder{r: r, byteOrder: h.byteOrder, bigTIFF: h.bigTIFF}
+	p, next, err := d.readEntries(offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	tags := make(map[uint16]Tag)
+	n := d.entryLen()
+	for i := 0; i < len(p); i += n {
+		t, err := d.ifdTag(p[i : i+n])
+		if err != nil {
+			return nil, 0, err
+		}
+		tags[t.ID] = t
+	}
+	return tags, next, nil
+}
+
+// WalkIFDs calls fn for each IFD of the TIFF file in r, in the order in
+// which they are chained, with the offset of the IFD in the file and its
+// entries keyed by tag. The values of the entries are decoded, but the
+// images are not. WalkIFDs stops at the first error returned by fn and
+// reports an error if the chain of IFDs contains a loop.
+func WalkIFDs(r io.ReaderAt, fn func(offset int64, tags map[uint16]Tag) error) error {
+	h, offset, err := readHeader(r)
+	if err != nil {
+		return err
+	}
+	seen := make(map[int64]bool)
+	for offset != 0 {
+		if seen[offset] {
+			return FormatError("IFD chain contains a loop")
+		}
+		seen[offset] = true
+		tags, next, err := readTags(r, h, offset)
+		if err != nil {
+			return err
+		}
+		if err := fn(offset, tags); err != nil {
+			return err
+		}
+		offset = next
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package tiff
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+// TestWalkIFDs tests that WalkIFDs visits all IFDs of a two-page file with
+// their offsets and decoded tags.
+func TestWalkIFDs(t *testing.T) {
+	page := func(w, h uint32, desc string) testPage {
+		value := make([]uint32, len(desc)+1)
+		for i := range desc {
+			value[i] = uint32(desc[i])
+		}
+		return testPage{make([]byte, w*h), []ifdEntry{
+			{tImageWidth, dtShort, []uint32{w}},
+			{tImageLength, dtShort, []uint32{h}},
+			{tBitsPerSample, dtShort, []uint32{8}},
+			{tPhotometricInterpretation, dtShort, []uint32{pBlackIsZero}},
+			{tImageDescription, dtASCII, value},
+			{tStripOffsets, dtLong, []uint32{0}},
+			{tRowsPerStrip, dtShort, []uint32{h}},
+			{tStripByteCounts, dtLong, []uint32{w * h}},
+			{tXResolution, dtRational, []uint32{300, 1}},
+		}}
+	}
+	b := buildTIFF(t, page(4, 3, "first"), page(2, 2, "second"))
+
+	// The first IFD follows the pixel data of the first page, the second
+	// one the pixel data of the second page.
+	first := int64(binary.LittleEndian.Uint32(b[4:8]))
+	second := first + 2 + ifdLen*9 + 4 + 8 + 6 + 4
+	wantOffsets := []int64{first, second}
+	wantWidths := []uint64{4, 2}
+	wantDescs := []string{"first", "second"}
+
+	var offsets []int64
+	err := WalkIFDs(bytes.NewReader(b), func(offset int64, tags map[uint16]Tag) error {
+		i := len(offsets)
+		offsets = append(offsets, offset)
+		if len(tags) != 9 {
+			t.Errorf("IFD %d: got %d tags, want 9", i, len(tags))
+		}
+		if got := tags[tImageWidth]; got.Type != TypeShort || !reflect.DeepEqual(got.Value, []uint64{wantWidths[i]}) {
+			t.Errorf("IFD %d: got ImageWidth %+v", i, got)
+		}
+		if got := tags[tImageDescription].Value; got != wantDescs[i] {
+			t.Errorf("IFD %d: got ImageDescription %q, want %q", i, got, wantDescs[i])
+		}
+		if got := tags[tXResolution].Value; !reflect.DeepEqual(got, [][2]uint32{{300, 1}}) {
+			t.Errorf("IFD %d: got XResolution %v", i, got)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(offsets, wantOffsets) {
+		t.Errorf("got offsets %v, want %v", offsets, wantOffsets)
+	}
+
+	// Make the second IFD point back to the first one.
+	binary.LittleEndian.PutUint32(b[second+2+ifdLen*9:], uint32(first))
+	if err := WalkIFDs(bytes.NewReader(b), func(int64, map[uint16]Tag) error { return nil }); err == nil {
+		t.Error("got nil error for a loop in the IFD chain")
+	}
+}
+
+// TestTagEntry tests that tags of all data types are written as they are
+// read.
+func TestTagEntry(t *testing.T) {
+	tags := []Tag{
+		{ID: 1000, Type: TypeByte, Value: []byte{1, 2, 3, 4, 5}},
+		{ID: 1001, Type: TypeASCII, Value: "hello"},
+		{ID: 1002, Type: TypeShort, Value: []uint64{1, 0xffff}},
+		{ID: 1003, Type: TypeLong, Value: []uint64{0xffffffff}},
+		{ID: 1004, Type: TypeRational, Value: [][2]uint32{{1, 3}, {2, 7}}},
+		{ID: 1005, Type: TypeSByte, Value: []int64{-128, 127}},
+		{ID: 1006, Type: TypeUndefined, Value: []byte{0xff}},
+		{ID: 1007, Type: TypeSShort, Value: []int64{-32768, 5}},
+		{ID: 1008, Type: TypeSLong, Value: []int64{-1 << 31}},
+		{ID: 1009, Type: TypeSRational, Value: [][2]int32{{-1, 3}}},
+		{ID: 1010, Type: TypeFloat, Value: []float64{1.5, -0.25}},
+		{ID: 1011, Type: TypeDouble, Value: []float64{1e300}},
+		{ID: 1012, Type: TypeIFD, Value: []uint64{8}},
+		{ID: 1013, Type: TypeLong8, Value: []uint64{1 << 40}},
+		{ID: 1014, Type: TypeSLong8, Value: []int64{-1 << 40}},
+		{ID: 1015, Type: TypeIFD8, Value: []uint64{1<<63 + 1}},
+	}
+	ifd := make([]ifdEntry, len(tags))
+	for i, tag := range tags {
+		e, err := tag.entry()
+		if err != nil {
+			t.Fatal(err)
+		}
+		ifd[i] = e
+	}
+	var buf bytes.Buffer
+	buf.WriteString(leHeader)
+	binary.Write(&buf, binary.LittleEndian, uint32(8))
+	if err := writeIFD(&buf, 8, ifd, 0, enc, false); err != nil {
+		t.Fatal(err)
+	}
+	err := WalkIFDs(bytes.NewReader(buf.Bytes()), func(_ int64, got map[uint16]Tag) error {
+		for _, want := range tags {
+			g := got[want.ID]
+			if g.Type != want.Type || !reflect.DeepEqual(g.Value, want.Value) {
+				t.Errorf("tag %d: got %+v, want %+v", want.ID, g, want)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tag := range []Tag{
+		{ID: 1000, Type: TypeShort, Value: []uint64{0x10000}},
+		{ID: 1000, Type: TypeSByte, Value: []int64{128}},
+		{ID: 1000, Type: TypeShort, Value: "text"},
+		{ID: 1000, Type: TypeLong, Value: 42},
+	} {
+		if _, err := tag.entry(); err == nil {
+			t.Errorf("%+v: got nil error", tag)
+		}
+	}
+}
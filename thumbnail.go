@@ -0,0 +1,84 @@
+package tiff
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// Thumbnail describes the preview of an image written with
+// Options.Thumbnail. The preview is written as the page following the
+// image, and its overviews if Options.Pyramid is set, marked as a
+// reduced-resolution image by its NewSubfileType, so that file browsers
+// can show it without decoding the image. It is an RGBA image, or a gray
+// image if the image is gray, compressed like the image unless the image
+// is CCITTGroup4 compressed, and written in a single strip.
+type Thumbnail struct {
+	// MaxSize is the maximum width and height of the preview. The image is
+	// scaled down to fit, preserving its aspect ratio, by averaging the
+	// pixels covered by each pixel of the preview. No preview is written
+	// if the image already fits.
+	MaxSize int
+}
+
+// writeThumbnail writes m with the options opt, followed by the preview
+// described by opt.Thumbnail.
+func (e *Encoder) writeThumbnail(m image.Image, opt *Options) error {
+	t := opt.Thumbnail
+	if t.MaxSize <= 0 {
+		return errors.New("tiff: thumbnail size must be positive")
+	}
+	o := *opt
+	o.Thumbnail = nil
+	if err := e.WriteImage(m, &o); err != nil {
+		return err
+	}
+	b := m.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= t.MaxSize && h <= t.MaxSize {
+		return nil
+	}
+	// The longer side is scaled to MaxSize, the other one in proportion.
+	if w >= h {
+		w, h = t.MaxSize, maxInt(1, (h*t.MaxSize+w/2)/w)
+	} else {
+		w, h = maxInt(1, (w*t.MaxSize+h/2)/h), t.MaxSize
+	}
+	return e.WriteImage(shrink(m, w, h), reducedOptions(&o, 1))
+}
+
+// shrink returns src scaled down to width by height pixels, each pixel
+// being the average of the pixels of src that it covers. The result is an
+// *image.Gray if the color model of src is color.GrayModel or
+// color.Gray16Model, and an *image.RGBA otherwise.
+func shrink(src image.Image, width, height int) image.Image {
+	b := src.Bounds()
+	gray := src.ColorModel() == color.GrayModel || src.ColorModel() == color.Gray16Model
+	var dst draw.Image
+	if gray {
+		dst = image.NewGray(image.Rect(0, 0, width, height))
+	} else {
+		dst = image.NewRGBA(image.Rect(0, 0, width, height))
+	}
+	for y := 0; y < height; y++ {
+		y0, y1 := b.Min.Y+y*b.Dy()/height, b.Min.Y+(y+1)*b.Dy()/height
+		for x := 0; x < width; x++ {
+			x0, x1 := b.Min.X+x*b.Dx()/width, b.Min.X+(x+1)*b.Dx()/width
+			var sr, sg, sb, sa, n uint64
+			for j := y0; j < y1; j++ {
+				for i := x0; i < x1; i++ {
+					r, g, b, a := src.At(i, j).RGBA()
+					sr, sg, sb, sa, n = sr+uint64(r), sg+uint64(g), sb+uint64(b), sa+uint64(a), n+1
+				}
+			}
+			dst.Set(x, y, color.RGBA64{
+				uint16((sr + n/2) / n),
+				uint16((sg + n/2) / n),
+				uint16((sb + n/2) / n),
+				uint16((sa + n/2) / n),
+			})
+		}
+	}
+	return dst
+}
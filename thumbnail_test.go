@@ -0,0 +1,70 @@
+package tiff
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"reflect"
+	"testing"
+)
+
+func TestEncodeThumbnail(t *testing.T) {
+	src := image.NewGray(image.Rect(10, 10, 110, 50))
+	for y := 10; y < 50; y++ {
+		for x := 10; x < 110; x++ {
+			src.SetGray(x, y, color.Gray{uint8(x + y)})
+		}
+	}
+	var buf bytes.Buffer
+	if err := Encode(&buf, src, &Options{Compression: LZW, Thumbnail: &Thumbnail{MaxSize: 20}}); err != nil {
+		t.Fatal(err)
+	}
+	var subfileTypes []interface{}
+	err := WalkIFDs(bytes.NewReader(buf.Bytes()), func(_ int64, tags map[uint16]Tag) error {
+		subfileTypes = append(subfileTypes, tags[tNewSubfileType].Value)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []interface{}{nil, []uint64{1}}; !reflect.DeepEqual(subfileTypes, want) {
+		t.Errorf("got NewSubfileTypes %v, want %v", subfileTypes, want)
+	}
+	pages, err := DecodeAll(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	compare(t, src, pages[0])
+	thumb, ok := pages[1].(*image.Gray)
+	if !ok || thumb.Bounds() != image.Rect(0, 0, 20, 8) {
+		t.Fatalf("got thumbnail %T of %v, want 20x8 gray", pages[1], pages[1].Bounds())
+	}
+	// Each pixel averages 5x5 pixels.
+	if got, want := thumb.GrayAt(1, 2).Y, uint8(15+2+20+2); got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	// The thumbnail follows the overviews of a pyramid, and images that
+	// fit have none.
+	rgba := image.NewRGBA(image.Rect(0, 0, 40, 100))
+	buf.Reset()
+	if err := Encode(&buf, rgba, &Options{Pyramid: &Pyramid{Levels: 1}, Thumbnail: &Thumbnail{MaxSize: 30}}); err != nil {
+		t.Fatal(err)
+	}
+	if pages, err = DecodeAll(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if len(pages) != 3 || pages[2].Bounds() != image.Rect(0, 0, 12, 30) {
+		t.Errorf("got %d pages", len(pages))
+	}
+	buf.Reset()
+	if err := Encode(&buf, rgba, &Options{Thumbnail: &Thumbnail{MaxSize: 100}}); err != nil {
+		t.Fatal(err)
+	}
+	if c, err := DecodeConfigFull(bytes.NewReader(buf.Bytes())); err != nil || c.Pages != 1 {
+		t.Errorf("got %d pages, %v", c.Pages, err)
+	}
+	if err := Encode(&buf, rgba, &Options{Thumbnail: &Thumbnail{}}); err == nil {
+		t.Error("zero MaxSize: got no error")
+	}
+}
@@ -0,0 +1,168 @@
+package tiff
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// A ColorTransformer converts colors between CMYK and RGB, such as with ICC
+// profiles by way of a color management module, in place of the formulas
+// of color.CMYKToRGB and color.RGBToCMYK. It is used by the decoder with
+// DecodeOptions.Transformer, the encoder with Options.Transformer and by
+// TransformCMYKAToNRGBA and TransformNRGBAToCMYKA. Rows of pixels are
+// converted at once, so that a CMM is not called for each pixel.
+type ColorTransformer interface {
+	// CMYKToRGB converts the pixels in src, which holds C, M, Y, K
+	// samples, to R, G, B samples in dst. dst has room for exactly the
+	// pixels of src, 3 bytes for each 4 bytes of src.
+	CMYKToRGB(dst, src []uint8)
+	// RGBToCMYK converts the pixels in src, which holds R, G, B samples,
+	// to C, M, Y, K samples in dst. dst has room for exactly the pixels
+	// of src, 4 bytes for each 3 bytes of src.
+	RGBToCMYK(dst, src []uint8)
+}
+
+// NaiveTransformer is the ColorTransformer that converts colors like the
+// rest of this package and the image/color package does, without regard
+// to color profiles.
+var NaiveTransformer ColorTransformer = naiveTransformer{}
+
+type naiveTransformer struct{}
+
+func (naiveTransformer) CMYKToRGB(dst, src []uint8) {
+	for i, j := 0, 0; i+4 <= len(src); i, j = i+4, j+3 {
+		dst[j], dst[j+1], dst[j+2] = color.CMYKToRGB(src[i], src[i+1], src[i+2], src[i+3])
+	}
+}
+
+func (naiveTransformer) RGBToCMYK(dst, src []uint8) {
+	for i, j := 0, 0; i+3 <= len(src); i, j = i+3, j+4 {
+		dst[j], dst[j+1], dst[j+2], dst[j+3] = color.RGBToCMYK(src[i], src[i+1], src[i+2])
+	}
+}
+
+// TransformCMYKAToNRGBA is like ConvertCMYKAToNRGBA, but converts the
+// colors with t. The alpha samples are copied.
+func TransformCMYKAToNRGBA(dst *image.NRGBA, src *CMYKAImg, rect image.Rectangle, t ColorTransformer) {
+	r := rect.Intersect(dst.Rect).Intersect(src.Rect)
+	if r.Empty() {
+		return
+	}
+	n := r.Dx()
+	cmyk, rgb := make([]uint8, 4*n), make([]uint8, 3*n)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		s := src.Pix[src.PixOffset(r.Min.X, y):][:5*n]
+		d := dst.Pix[dst.PixOffset(r.Min.X, y):][:4*n]
+		for i := 0; i < n; i++ {
+			copy(cmyk[4*i:4*i+4], s[5*i:5*i+4])
+		}
+		t.CMYKToRGB(rgb, cmyk)
+		for i := 0; i < n; i++ {
+			copy(d[4*i:4*i+3], rgb[3*i:3*i+3])
+			d[4*i+3] = s[5*i+4]
+		}
+	}
+}
+
+// TransformNRGBAToCMYKA is like ConvertNRGBAToCMYKA, but converts the
+// colors with t. The alpha samples are copied.
+func TransformNRGBAToCMYKA(dst *CMYKAImg, src *image.NRGBA, rect image.Rectangle, t ColorTransformer) {
+	r := rect.Intersect(dst.Rect).Intersect(src.Rect)
+	if r.Empty() {
+		return
+	}
+	n := r.Dx()
+	rgb, cmyk := make([]uint8, 3*n), make([]uint8, 4*n)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		s := src.Pix[src.PixOffset(r.Min.X, y):][:4*n]
+		d := dst.Pix[dst.PixOffset(r.Min.X, y):][:5*n]
+		for i := 0; i < n; i++ {
+			copy(rgb[3*i:3*i+3], s[4*i:4*i+3])
+		}
+		t.RGBToCMYK(cmyk, rgb)
+		for i := 0; i < n; i++ {
+			copy(d[5*i:5*i+4], cmyk[4*i:4*i+4])
+			d[5*i+4] = s[4*i+3]
+		}
+	}
+}
+
+// decodeTransformed implements decode for CMYK images that are converted to
+// the RGB image dst, an *image.RGBA or *image.NRGBA, with d.transformer.
+// The samples of the block are decoded into a CMYK image first.
+func (d *decoder) decodeTransformed(dst image.Image, xmin, ymin, xmax, ymax int) error {
+	r := image.Rect(xmin, ymin, xmax, ymax).Intersect(dst.Bounds())
+	if r.Empty() {
+		return nil
+	}
+	var cmyk *image.CMYK
+	var cmyka *CMYKAImg
+	if d.mode == mCMYKA {
+		cmyka = NewCMYKA(r)
+		if err := d.decodeSamples(cmyka, xmin, ymin, xmax, ymax); err != nil {
+			return err
+		}
+		cmyk = cmyka.DropAlpha()
+	} else {
+		cmyk = image.NewCMYK(r)
+		if err := d.decodeSamples(cmyk, xmin, ymin, xmax, ymax); err != nil {
+			return err
+		}
+	}
+
+	var pix []uint8
+	var stride int
+	premul := false
+	switch img := dst.(type) {
+	case *image.RGBA:
+		pix, stride, premul = img.Pix[img.PixOffset(r.Min.X, r.Min.Y):], img.Stride, true
+	case *image.NRGBA:
+		pix, stride = img.Pix[img.PixOffset(r.Min.X, r.Min.Y):], img.Stride
+	}
+	n := r.Dx()
+	rgb := make([]uint8, 3*n)
+	var v [3]uint32
+	for y := 0; y < r.Dy(); y++ {
+		d.transformer.CMYKToRGB(rgb, cmyk.Pix[y*cmyk.Stride:][:4*n])
+		row := pix[y*stride:][:4*n]
+		for i := 0; i < n; i++ {
+			p := row[4*i : 4*i+4 : 4*i+4]
+			p[0], p[1], p[2], p[3] = rgb[3*i], rgb[3*i+1], rgb[3*i+2], 0xff
+			if cmyka == nil {
+				continue
+			}
+			a := cmyka.Pix[y*cmyka.Stride+5*i+4]
+			p[3] = a
+			if premul && a != 0xff {
+				v = [3]uint32{uint32(p[0]), uint32(p[1]), uint32(p[2])}
+				premultiply(v[:], uint32(a), 0xff)
+				p[0], p[1], p[2] = uint8(v[0]), uint8(v[1]), uint8(v[2])
+			}
+		}
+	}
+	return nil
+}
+
+// transformToCMYK returns m converted to an image.CMYK, or a CMYKAImg if m
+// is not opaque, with t, if m has an 8-bit RGB or YCbCr color model.
+// Other images are returned as they are.
+func transformToCMYK(m image.Image, t ColorTransformer) image.Image {
+	switch m.ColorModel() {
+	case color.RGBAModel, color.NRGBAModel, color.YCbCrModel, color.NYCbCrAModel:
+	default:
+		return m
+	}
+	b := m.Bounds()
+	src, ok := m.(*image.NRGBA)
+	if !ok {
+		src = image.NewNRGBA(b)
+		draw.Draw(src, b, m, b.Min, draw.Src)
+	}
+	dst := NewCMYKA(b)
+	TransformNRGBAToCMYKA(dst, src, b, t)
+	if dst.Opaque() {
+		return dst.DropAlpha()
+	}
+	return dst
+}
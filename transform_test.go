@@ -0,0 +1,155 @@
+package tiff
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"reflect"
+	"testing"
+)
+
+// swapTransformer is a ColorTransformer that maps C, M, Y to B, G, R and
+// back, ignoring K, so that its use can be told from the naive conversion.
+type swapTransformer struct{}
+
+func (swapTransformer) CMYKToRGB(dst, src []uint8) {
+	for i, j := 0, 0; i < len(src); i, j = i+4, j+3 {
+		dst[j], dst[j+1], dst[j+2] = src[i+2], src[i+1], src[i]
+	}
+}
+
+func (swapTransformer) RGBToCMYK(dst, src []uint8) {
+	for i, j := 0, 0; i < len(src); i, j = i+3, j+4 {
+		dst[j], dst[j+1], dst[j+2], dst[j+3] = src[i+2], src[i+1], src[i], 0
+	}
+}
+
+func TestDecodeTransformer(t *testing.T) {
+	r := image.Rect(0, 0, 19, 7)
+	cmyk, cmyka := image.NewCMYK(r), NewCMYKA(r)
+	for y := 0; y < r.Dy(); y++ {
+		for x := 0; x < r.Dx(); x++ {
+			c := CMYKA{uint8(x * 13), uint8(y * 31), uint8(x * y), 0x20, uint8(x*13 + y)}
+			cmyk.SetCMYK(x, y, color.CMYK{c.C, c.M, c.Y, c.K})
+			cmyka.SetCMYKA(x, y, c)
+		}
+	}
+	for _, src := range []image.Image{cmyk, cmyka} {
+		var buf bytes.Buffer
+		if err := Encode(&buf, src, &Options{Compression: LZW, TileWidth: 16, TileLength: 16}); err != nil {
+			t.Fatal(err)
+		}
+		for _, alpha := range []AlphaMode{AlphaAsStored, AlphaPremultiplied} {
+			m, err := DecodeWithOptions(bytes.NewReader(buf.Bytes()), &DecodeOptions{Alpha: alpha, Transformer: swapTransformer{}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var want image.Image
+			switch {
+			case src == image.Image(cmyk) || alpha == AlphaPremultiplied:
+				rgba := image.NewRGBA(r)
+				for y := 0; y < r.Dy(); y++ {
+					for x := 0; x < r.Dx(); x++ {
+						c := cmyka.CMYKAt(x, y)
+						if src == image.Image(cmyk) {
+							c.A = 0xff
+						}
+						rgba.Set(x, y, color.NRGBA{c.Y, c.M, c.C, c.A})
+					}
+				}
+				want = rgba
+			default:
+				nrgba := image.NewNRGBA(r)
+				for y := 0; y < r.Dy(); y++ {
+					for x := 0; x < r.Dx(); x++ {
+						c := cmyka.CMYKAt(x, y)
+						nrgba.SetNRGBA(x, y, color.NRGBA{c.Y, c.M, c.C, c.A})
+					}
+				}
+				want = nrgba
+			}
+			if !reflect.DeepEqual(m, want) {
+				t.Errorf("%T, alpha mode %d: got %T, want %T with the transformed colors", src, alpha, m, want)
+			}
+		}
+	}
+
+	// The transformer does not apply to other images.
+	var buf bytes.Buffer
+	gray := image.NewGray(r)
+	if err := Encode(&buf, gray, nil); err != nil {
+		t.Fatal(err)
+	}
+	m, err := DecodeWithOptions(bytes.NewReader(buf.Bytes()), &DecodeOptions{Transformer: swapTransformer{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(m, gray) {
+		t.Errorf("gray image: got %T", m)
+	}
+}
+
+func TestEncodeTransformer(t *testing.T) {
+	r := image.Rect(0, 0, 9, 4)
+	src := image.NewNRGBA(r)
+	for y := 0; y < r.Dy(); y++ {
+		for x := 0; x < r.Dx(); x++ {
+			src.SetNRGBA(x, y, color.NRGBA{uint8(x * 20), uint8(y * 50), 0x80, 0xff})
+		}
+	}
+	for _, opaque := range []bool{true, false} {
+		if !opaque {
+			src.SetNRGBA(1, 2, color.NRGBA{1, 2, 3, 4})
+		}
+		var buf bytes.Buffer
+		if err := Encode(&buf, src, &Options{Transformer: swapTransformer{}}); err != nil {
+			t.Fatal(err)
+		}
+		m, err := Decode(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for y := 0; y < r.Dy(); y++ {
+			for x := 0; x < r.Dx(); x++ {
+				c := src.NRGBAAt(x, y)
+				want := CMYKA{c.B, c.G, c.R, 0, c.A}
+				var got CMYKA
+				switch m := m.(type) {
+				case *image.CMYK:
+					cc := m.CMYKAt(x, y)
+					got = CMYKA{cc.C, cc.M, cc.Y, cc.K, 0xff}
+				case *CMYKAImg:
+					got = m.CMYKAt(x, y)
+				default:
+					t.Fatalf("opaque %v: got %T", opaque, m)
+				}
+				if got != want {
+					t.Fatalf("opaque %v: got %v at (%d, %d), want %v", opaque, got, x, y, want)
+				}
+			}
+		}
+		if _, ok := m.(*image.CMYK); ok != opaque {
+			t.Errorf("opaque %v: got %T", opaque, m)
+		}
+	}
+}
+
+func TestTransformCMYKA(t *testing.T) {
+	r := image.Rect(0, 0, 5, 3)
+	src := NewCMYKA(r)
+	for i := range src.Pix {
+		src.Pix[i] = uint8(i * 7)
+	}
+	got, want := image.NewNRGBA(r), image.NewNRGBA(r)
+	TransformCMYKAToNRGBA(got, src, r, NaiveTransformer)
+	ConvertCMYKAToNRGBA(want, src, r)
+	if !reflect.DeepEqual(got, want) {
+		t.Error("TransformCMYKAToNRGBA with NaiveTransformer differs from ConvertCMYKAToNRGBA")
+	}
+	back, wantBack := NewCMYKA(r), NewCMYKA(r)
+	TransformNRGBAToCMYKA(back, got, r, NaiveTransformer)
+	ConvertNRGBAToCMYKA(wantBack, got, r)
+	if !reflect.DeepEqual(back, wantBack) {
+		t.Error("TransformNRGBAToCMYKA with NaiveTransformer differs from ConvertNRGBAToCMYKA")
+	}
+}
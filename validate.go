@@ -0,0 +1,254 @@
+package tiff
+
+import (
+	"fmt"
+	"io"
+	"math"
+)
+
+// An Issue is a problem in the structure of a TIFF file found by Validate.
+type Issue struct {
+	// IFD is the offset of the IFD with the problem.
+	IFD int64
+	// Tag is the ID of the entry with the problem, or 0 if the problem
+	// concerns the IFD as a whole.
+	Tag uint16
+	// Message describes the problem.
+	Message string
+}
+
+func (i Issue) String() string {
+	if i.Tag == 0 {
+		return fmt.Sprintf("IFD at %d: %s", i.IFD, i.Message)
+	}
+	return fmt.Sprintf("IFD at %d, tag %d: %s", i.IFD, i.Tag, i.Message)
+}
+
+// baselineRequired holds the tags that Baseline TIFF requires in every
+// image, besides the strip or tile offsets and byte counts. The other
+// required tags have default values.
+var baselineRequired = []uint16{tImageWidth, tImageLength, tPhotometricInterpretation, tXResolution, tYResolution}
+
+// Validate checks the structure of the TIFF file in r without decoding its
+// images, and returns the problems it finds, so that malformed files can be
+// rejected with specific messages: IFDs outside the file and loops in the
+// chain of IFDs, entries that are not sorted by tag, values outside the
+// file, missing required tags of Baseline TIFF, and strip or tile offsets
+// and byte counts that do not match the layout of the image or point
+// outside the file. The SubIFDs of the pages are checked too.
+//
+// Validate returns an error only if r does not hold a TIFF file or cannot be
+// read. The size of the file is taken from r if it has a Size method, like
+// *bytes.Reader and *io.SectionReader, or a Stat method, like *os.File;
+// otherwise, data outside the file is detected by reading it.
+func Validate(r io.ReaderAt) ([]Issue, error) {
+	h, offset, err := readHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	v := &validator{
+		d:    &decoder{r: r, byteOrder: h.byteOrder, bigTIFF: h.bigTIFF},
+		size: readerSize(r),
+		seen: make(map[int64]bool),
+	}
+	v.chain(offset)
+	return v.issues, nil
+}
+
+// A validator collects the issues of a file for Validate.
+type validator struct {
+	d      *decoder
+	size   int64 // Size of the file, or math.MaxInt64 if it is not known.
+	seen   map[int64]bool
+	issues []Issue
+}
+
+func (v *validator) add(ifd int64, tag uint16, format string, args ...interface{}) {
+	v.issues = append(v.issues, Issue{IFD: ifd, Tag: tag, Message: fmt.Sprintf(format, args...)})
+}
+
+// inFile reports whether the n bytes at offset lie within the file.
+func (v *validator) inFile(offset, n int64) bool {
+	if offset < 0 || n < 0 {
+		return false
+	}
+	if v.size != math.MaxInt64 {
+		return offset+n <= v.size
+	}
+	if n == 0 {
+		return true
+	}
+	_, err := v.d.r.ReadAt(make([]byte, 1), offset+n-1)
+	return err == nil
+}
+
+// chain checks the IFDs of the chain starting at offset and their SubIFDs.
+func (v *validator) chain(offset int64) {
+	for prev := int64(0); offset != 0; {
+		if v.seen[offset] {
+			v.add(prev, 0, "the next IFD at %d was already visited; the chain of IFDs contains a loop", offset)
+			return
+		}
+		v.seen[offset] = true
+		next, ok := v.ifd(offset)
+		if !ok {
+			return
+		}
+		prev, offset = offset, next
+	}
+}
+
+// ifd checks the IFD at offset and returns the offset of the next IFD.
+// It returns false if the IFD cannot be read.
+func (v *validator) ifd(offset int64) (int64, bool) {
+	if offset < 0 || !v.inFile(offset, 2) {
+		v.add(offset, 0, "IFD lies outside the file")
+		return 0, false
+	}
+	p, next, err := v.d.readEntries(offset)
+	if err != nil {
+		v.add(offset, 0, "cannot read IFD entries: %v", err)
+		return 0, false
+	}
+
+	tags := make(map[uint16]Tag)
+	n := v.d.entryLen()
+	prevID := -1
+	for i := 0; i < len(p); i += n {
+		id := v.d.byteOrder.Uint16(p[i : i+2])
+		switch {
+		case int(id) == prevID:
+			v.add(offset, id, "duplicate entry")
+		case int(id) < prevID:
+			v.add(offset, id, "entry is not sorted in ascending order of tags, after tag %d", prevID)
+		}
+		prevID = int(id)
+		t, err := v.d.ifdTag(p[i : i+n])
+		if err != nil {
+			v.add(offset, id, "invalid value: %v", err)
+			continue
+		}
+		if t.Value == nil {
+			v.add(offset, id, "unknown data type %d", t.Type)
+		}
+		tags[id] = t
+	}
+	v.image(offset, tags)
+
+	if subs, ok := tags[tSubIFDs].Value.([]uint64); ok {
+		for _, off := range subs {
+			if !v.seen[int64(off)] {
+				v.seen[int64(off)] = true
+				v.ifd(int64(off))
+			}
+		}
+	}
+	return next, true
+}
+
+// image checks the entries of the image in the IFD at offset given by tags.
+func (v *validator) image(offset int64, tags map[uint16]Tag) {
+	value := func(id uint16, def uint64) uint64 {
+		if x, ok := tags[id].Value.([]uint64); ok && len(x) > 0 {
+			return x[0]
+		}
+		return def
+	}
+	for _, id := range baselineRequired {
+		if _, ok := tags[id]; !ok {
+			v.add(offset, id, "required entry is missing")
+		}
+	}
+
+	offsetTag, countTag := uint16(tStripOffsets), uint16(tStripByteCounts)
+	_, tiled := tags[tTileOffsets]
+	if tiled {
+		offsetTag, countTag = tTileOffsets, tTileByteCounts
+	}
+	offsets, _ := tags[offsetTag].Value.([]uint64)
+	counts, _ := tags[countTag].Value.([]uint64)
+	if _, ok := tags[offsetTag]; !ok {
+		v.add(offset, 0, "neither StripOffsets nor TileOffsets is present")
+		return
+	}
+	if _, ok := tags[countTag]; !ok {
+		v.add(offset, countTag, "required entry is missing")
+		return
+	}
+	if len(offsets) != len(counts) {
+		v.add(offset, countTag, "%d byte counts for %d offsets", len(counts), len(offsets))
+		return
+	}
+
+	width, length := value(tImageWidth, 0), value(tImageLength, 0)
+	if width == 0 || length == 0 {
+		return
+	}
+	spp := value(tSamplesPerPixel, 1)
+	bits := uint64(0)
+	if bps, ok := tags[tBitsPerSample].Value.([]uint64); ok {
+		for _, b := range bps {
+			bits += b
+		}
+		if uint64(len(bps)) != spp {
+			v.add(offset, tBitsPerSample, "%d values for %d samples per pixel", len(bps), spp)
+		}
+	} else {
+		bits = spp
+	}
+	planes := uint64(1)
+	if value(tPlanarConfiguration, pcChunky) == pcPlanar && spp > 0 {
+		planes, bits = spp, bits/spp
+	}
+
+	blkW, blkH := width, minUint64(value(tRowsPerStrip, length), length)
+	if tiled {
+		blkW, blkH = value(tTileWidth, 0), value(tTileLength, 0)
+		if blkW == 0 || blkH == 0 {
+			v.add(offset, 0, "TileWidth and TileLength are required for tiled images")
+			return
+		}
+		if blkW%16 != 0 || blkH%16 != 0 {
+			v.add(offset, tTileWidth, "tile size %dx%d is not a multiple of 16", blkW, blkH)
+		}
+	}
+	if blkH == 0 {
+		v.add(offset, tRowsPerStrip, "RowsPerStrip is 0")
+		return
+	}
+	across, down := (width+blkW-1)/blkW, (length+blkH-1)/blkH
+	if want := across * down * planes; uint64(len(offsets)) != want {
+		v.add(offset, offsetTag, "%d strips or tiles, want %d for the image size", len(offsets), want)
+		return
+	}
+
+	uncompressed := value(tCompression, cNone) == cNone
+	for i := range offsets {
+		if counts[i] == 0 {
+			continue
+		}
+		if !v.inFile(int64(offsets[i]), int64(counts[i])) {
+			v.add(offset, offsetTag, "strip or tile %d at %d with %d bytes extends past the end of the file", i, offsets[i], counts[i])
+			continue
+		}
+		if !uncompressed {
+			continue
+		}
+		// The last strip may hold fewer rows.
+		rows := blkH
+		if !tiled && uint64(i)%down == down-1 {
+			rows = length - (down-1)*blkH
+		}
+		if want := (blkW*bits + 7) / 8 * rows; counts[i] < want {
+			v.add(offset, countTag, "uncompressed strip or tile %d has %d bytes, want %d", i, counts[i], want)
+		}
+	}
+}
+
+// minUint64 returns the smaller of a or b.
+func minUint64(a, b uint64) uint64 {
+	if a <= b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,127 @@
+package tiff
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	src, err := openImage("video-001.tiff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	for _, opt := range []*Options{
+		{Compression: LZW, Pyramid: &Pyramid{Levels: 2}},
+		{TileWidth: 32, TileLength: 32, ForceBigTIFF: true},
+		{RowsPerStrip: 7},
+	} {
+		if err := e.WriteImage(src, opt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+	valid := buf.Bytes()
+	if issues, err := Validate(bytes.NewReader(valid)); len(issues) != 0 || err != nil {
+		t.Fatalf("valid file: got %v, %v", issues, err)
+	}
+
+	var small bytes.Buffer
+	if err := Encode(&small, image.NewGray(image.Rect(0, 0, 4, 4)), nil); err != nil {
+		t.Fatal(err)
+	}
+	// The IFD of the image follows its 16 bytes of pixel data.
+	ifd := int64(binary.LittleEndian.Uint32(small.Bytes()[4:]))
+	entry := func(b []byte, i int) []byte { return b[ifd+2+int64(i)*ifdLen:][:ifdLen] }
+
+	for _, tc := range []struct {
+		name string
+		data func() []byte
+		want string
+	}{
+		{"truncated", func() []byte {
+			b := append([]byte(nil), valid...)
+			return b[:len(b)-1]
+		}, ""},
+		{"unsorted", func() []byte {
+			b := append([]byte(nil), small.Bytes()...)
+			e0, e1 := entry(b, 0), entry(b, 1)
+			tmp := append([]byte(nil), e0...)
+			copy(e0, e1)
+			copy(e1, tmp)
+			return b
+		}, "not sorted"},
+		{"loop", func() []byte {
+			b := append([]byte(nil), small.Bytes()...)
+			n := binary.LittleEndian.Uint16(b[ifd:])
+			binary.LittleEndian.PutUint32(b[ifd+2+int64(n)*ifdLen:], uint32(ifd))
+			return b
+		}, "loop"},
+		{"strip outside", func() []byte {
+			b := append([]byte(nil), small.Bytes()...)
+			for i := 0; ; i++ {
+				if e := entry(b, i); binary.LittleEndian.Uint16(e) == tStripOffsets {
+					binary.LittleEndian.PutUint32(e[8:], 1<<20)
+					return b
+				}
+			}
+		}, "past the end"},
+		{"missing tags", func() []byte {
+			return buildTIFF(t, testPage{make([]byte, 3), []ifdEntry{
+				{tImageWidth, dtShort, []uint32{2}},
+				{tImageLength, dtShort, []uint32{2}},
+				{tBitsPerSample, dtShort, []uint32{8}},
+				{tStripOffsets, dtLong, []uint32{0}},
+				{tStripByteCounts, dtLong, []uint32{3}},
+			}})
+		}, "required"},
+		{"short strip", func() []byte {
+			return buildTIFF(t, testPage{make([]byte, 3), []ifdEntry{
+				{tImageWidth, dtShort, []uint32{2}},
+				{tImageLength, dtShort, []uint32{2}},
+				{tBitsPerSample, dtShort, []uint32{8}},
+				{tPhotometricInterpretation, dtShort, []uint32{pBlackIsZero}},
+				{tStripOffsets, dtLong, []uint32{0}},
+				{tStripByteCounts, dtLong, []uint32{3}},
+				{tXResolution, dtRational, []uint32{72, 1}},
+				{tYResolution, dtRational, []uint32{72, 1}},
+			}})
+		}, "has 3 bytes, want 4"},
+		{"strip count", func() []byte {
+			return buildTIFF(t, testPage{make([]byte, 4), []ifdEntry{
+				{tImageWidth, dtShort, []uint32{2}},
+				{tImageLength, dtShort, []uint32{2}},
+				{tBitsPerSample, dtShort, []uint32{8}},
+				{tPhotometricInterpretation, dtShort, []uint32{pBlackIsZero}},
+				{tStripOffsets, dtLong, []uint32{0}},
+				{tRowsPerStrip, dtShort, []uint32{1}},
+				{tStripByteCounts, dtLong, []uint32{4}},
+				{tXResolution, dtRational, []uint32{72, 1}},
+				{tYResolution, dtRational, []uint32{72, 1}},
+			}})
+		}, "want 2 for the image size"},
+	} {
+		issues, err := Validate(bytes.NewReader(tc.data()))
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		found := false
+		for _, i := range issues {
+			found = found || strings.Contains(i.String(), tc.want)
+		}
+		if len(issues) == 0 || !found {
+			t.Errorf("%s: got issues %v, want one containing %q", tc.name, issues, tc.want)
+		}
+	}
+
+	if _, err := Validate(strings.NewReader("not a TIFF file")); err == nil {
+		t.Error("not a TIFF file: got nil error")
+	}
+}
@@ -7,11 +7,19 @@ package tiff
 import (
 	"bytes"
 	"compress/zlib"
+	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"image"
+	"image/color"
+	"image/draw"
 	"io"
+	"math"
 	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/hhrutter/lzw"
 )
@@ -23,31 +31,52 @@ import (
 //   2. Image data.
 //   3. Image File Directory (IFD).
 //   4. "Pointer area" for larger entries in the IFD.
+//
+// Files with several pages repeat items 2 to 4 for each page.
 
-// We only write little-endian TIFF files.
+// Samples are prepared in little-endian order. In big-endian files, the
+// samples of more than 8 bits are swapped by a swapWriter as they are
+// encoded, while the IFDs are written in the byte order of the file.
 var enc = binary.LittleEndian
 
 // An ifdEntry is a single entry in an Image File Directory.
 // A value of type dtRational is composed of two 32-bit values,
 // thus data contains two uints (numerator and denominator) for a single number.
+// Likewise, data contains the low and high 32 bits of each 8-byte value.
 type ifdEntry struct {
 	tag      int
 	datatype int
 	data     []uint32
 }
 
-func (e ifdEntry) putData(p []byte) {
-	for _, d := range e.data {
+// words returns the number of elements of data that make up a single value
+// of the given type. Rational values consist of a numerator and a
+// denominator, 8-byte values of their low and high 32 bits.
+func words(datatype int) int {
+	switch datatype {
+	case dtRational, dtSRational, dtDouble, dtLong8, dtSLong8, dtIFD8:
+		return 2
+	}
+	return 1
+}
+
+func (e ifdEntry) putData(p []byte, order binary.ByteOrder) {
+	for i := 0; i < len(e.data); i++ {
+		d := e.data[i]
 		switch e.datatype {
-		case dtByte, dtASCII:
+		case dtByte, dtASCII, dtSByte, dtUndefined:
 			p[0] = byte(d)
 			p = p[1:]
-		case dtShort:
-			enc.PutUint16(p, uint16(d))
+		case dtShort, dtSShort:
+			order.PutUint16(p, uint16(d))
 			p = p[2:]
-		case dtLong, dtRational:
-			enc.PutUint32(p, uint32(d))
+		case dtLong, dtRational, dtSLong, dtSRational, dtFloat, dtIFD:
+			order.PutUint32(p, uint32(d))
 			p = p[4:]
+		case dtDouble, dtLong8, dtSLong8, dtIFD8:
+			i++
+			order.PutUint64(p, uint64(d)|uint64(e.data[i])<<32)
+			p = p[8:]
 		}
 	}
 }
@@ -94,7 +123,8 @@ func encodeGray16(w io.Writer, pix []uint8, dx, dy, stride int, predictor bool)
 			if predictor {
 				v0, v1 = v1, v1-v0
 			}
-			// We only write little-endian TIFF files.
+			// Samples are prepared in little-endian order; swapSamples swaps
+			// them for big-endian files.
 			buf[off+0] = byte(v1)
 			buf[off+1] = byte(v1 >> 8)
 			off += 2
@@ -132,6 +162,34 @@ func encodeRGBA(w io.Writer, pix []uint8, dx, dy, stride int, predictor bool) er
 	return nil
 }
 
+func encodeRGB(w io.Writer, pix []uint8, dx, dy, stride int, predictor bool) error {
+	buf := make([]byte, dx*3)
+	for y := 0; y < dy; y++ {
+		min := y*stride + 0
+		max := y*stride + dx*4
+		off := 0
+		var r0, g0, b0 uint8
+		for i := min; i < max; i += 4 {
+			r1, g1, b1 := pix[i+0], pix[i+1], pix[i+2]
+			if predictor {
+				buf[off+0] = r1 - r0
+				buf[off+1] = g1 - g0
+				buf[off+2] = b1 - b0
+			} else {
+				buf[off+0] = r1
+				buf[off+1] = g1
+				buf[off+2] = b1
+			}
+			off += 3
+			r0, g0, b0 = r1, g1, b1
+		}
+		if _, err := w.Write(buf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func encodeRGBA64(w io.Writer, pix []uint8, dx, dy, stride int, predictor bool) error {
 	buf := make([]byte, dx*8)
 	for y := 0; y < dy; y++ {
@@ -151,7 +209,8 @@ func encodeRGBA64(w io.Writer, pix []uint8, dx, dy, stride int, predictor bool)
 				b0, b1 = b1, b1-b0
 				a0, a1 = a1, a1-a0
 			}
-			// We only write little-endian TIFF files.
+			// Samples are prepared in little-endian order; swapSamples swaps
+			// them for big-endian files.
 			buf[off+0] = byte(r1)
 			buf[off+1] = byte(r1 >> 8)
 			buf[off+2] = byte(g1)
@@ -169,6 +228,61 @@ func encodeRGBA64(w io.Writer, pix []uint8, dx, dy, stride int, predictor bool)
 	return nil
 }
 
+func encodeCMYKA64(w io.Writer, pix []uint8, dx, dy, stride int, predictor bool) error {
+	buf := make([]byte, dx*10)
+	for y := 0; y < dy; y++ {
+		row := pix[y*stride : y*stride+dx*10]
+		var v0 [5]uint16
+		for i := 0; i < len(row); i += 2 {
+			// A CMYKA64Img's Pix is in big-endian order.
+			v1 := uint16(row[i])<<8 | uint16(row[i+1])
+			if predictor {
+				s := i / 2 % 5
+				v0[s], v1 = v1, v1-v0[s]
+			}
+			// Samples are prepared in little-endian order; swapSamples swaps
+			// them for big-endian files.
+			buf[i+0] = byte(v1)
+			buf[i+1] = byte(v1 >> 8)
+		}
+		if _, err := w.Write(buf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// bigEndianEncoder returns a pixelEncoder for pixels of samples 16-bit
+// values each, stored in pix in big-endian order like the Pix of a
+// GrayA16Img or an NChannelImg.
+func bigEndianEncoder(samples int) pixelEncoder {
+	return func(w io.Writer, pix []uint8, dx, dy, stride int, predictor bool) error {
+		buf := make([]byte, dx*samples*2)
+		v0 := make([]uint16, samples)
+		for y := 0; y < dy; y++ {
+			row := pix[y*stride : y*stride+len(buf)]
+			for s := range v0 {
+				v0[s] = 0
+			}
+			for i := 0; i < len(row); i += 2 {
+				v1 := uint16(row[i])<<8 | uint16(row[i+1])
+				if predictor {
+					s := i / 2 % samples
+					v0[s], v1 = v1, v1-v0[s]
+				}
+				// Samples are prepared in little-endian order; swapSamples swaps
+				// them for big-endian files.
+				buf[i+0] = byte(v1)
+				buf[i+1] = byte(v1 >> 8)
+			}
+			if _, err := w.Write(buf); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
 func encodeCMYK(w io.Writer, pix []uint8, dx, dy, stride int, predictor bool) error {
 	if !predictor {
 		return writePix(w, pix, dy, dx*4, stride)
@@ -244,37 +358,108 @@ func writePix(w io.Writer, pix []byte, nrows, length, stride int) error {
 		if _, err := w.Write(pix[:length]); err != nil {
 			return err
 		}
-		pix = pix[stride:]
+		// The Pix of a SubImage may end with its last row.
+		if nrows > 1 {
+			pix = pix[stride:]
+		}
 	}
 	return nil
 }
 
-func writeIFD(w io.Writer, ifdOffset int, d []ifdEntry) error {
-	var buf [ifdLen]byte
+// wordAlign is the alignment of IFDs and of the values they point to, which
+// must begin on a word boundary in classic TIFF files. BigTIFF files call
+// for an alignment of 8 bytes, bigAlign, instead.
+const (
+	wordAlign = 2
+	bigAlign  = 8
+)
+
+// padLen returns the number of bytes needed to pad n bytes to a multiple of
+// align.
+func padLen(n, align int) int {
+	return (align - n%align) % align
+}
+
+// ifdLayout returns the length of an IFD entry, of the number of entries
+// that precedes them and of an offset, which is also the space for a value
+// in an entry, in classic TIFF or, if big is true, BigTIFF files.
+func ifdLayout(big bool) (entryLen, countLen, offsetLen int) {
+	if big {
+		return bigIFDLen, 8, 8
+	}
+	return ifdLen, 2, 4
+}
+
+// ifdSize returns the number of bytes that writeIFD writes for d.
+func ifdSize(d []ifdEntry, big bool) int64 {
+	entryLen, countLen, offsetLen := ifdLayout(big)
+	align := wordAlign
+	if big {
+		align = bigAlign
+	}
+	n := countLen + entryLen*len(d) + offsetLen
+	n += padLen(n, align)
+	for _, ent := range d {
+		datalen := len(ent.data) / words(ent.datatype) * int(lengths[ent.datatype])
+		if datalen > offsetLen {
+			n += datalen + padLen(datalen, align)
+		}
+	}
+	return int64(n)
+}
+
+// writeIFD writes the IFD d, which must start at the aligned ifdOffset in
+// the file, to w in the byte order order. next is the offset of the next
+// IFD, or 0 if d is the last one. If big is true, d is written in the
+// BigTIFF format.
+func writeIFD(w io.Writer, ifdOffset int64, d []ifdEntry, next int64, order binary.ByteOrder, big bool) error {
+	entryLen, countLen, offsetLen := ifdLayout(big)
+	align := wordAlign
+	if big {
+		align = bigAlign
+	}
+	putOffset := func(p []byte, v int64) {
+		if big {
+			order.PutUint64(p, uint64(v))
+		} else {
+			order.PutUint32(p, uint32(v))
+		}
+	}
+
+	buf := make([]byte, entryLen)
 	// Make space for "pointer area" containing IFD entry data
-	// longer than 4 bytes.
+	// longer than 4 bytes (8 in BigTIFF files).
 	parea := make([]byte, 1024)
-	pstart := ifdOffset + ifdLen*len(d) + 6
+	n := countLen + entryLen*len(d) + offsetLen
+	pad := padLen(n, align)
+	pstart := ifdOffset + int64(n+pad)
 	var o int // Current offset in parea.
 
 	// The IFD has to be written with the tags in ascending order.
 	sort.Sort(byTag(d))
 
 	// Write the number of entries in this IFD.
-	if err := binary.Write(w, enc, uint16(len(d))); err != nil {
+	var err error
+	if big {
+		err = binary.Write(w, order, uint64(len(d)))
+	} else {
+		err = binary.Write(w, order, uint16(len(d)))
+	}
+	if err != nil {
 		return err
 	}
 	for _, ent := range d {
-		enc.PutUint16(buf[0:2], uint16(ent.tag))
-		enc.PutUint16(buf[2:4], uint16(ent.datatype))
-		count := uint32(len(ent.data))
-		if ent.datatype == dtRational {
-			count /= 2
+		order.PutUint16(buf[0:2], uint16(ent.tag))
+		order.PutUint16(buf[2:4], uint16(ent.datatype))
+		count := uint32(len(ent.data) / words(ent.datatype))
+		value := buf[4+offsetLen:]
+		putOffset(buf[4:4+offsetLen], int64(count))
+		for i := range value {
+			value[i] = 0
 		}
-		enc.PutUint32(buf[4:8], count)
 		datalen := int(count * lengths[ent.datatype])
-		if datalen <= 4 {
-			ent.putData(buf[8:12])
+		if datalen <= offsetLen {
+			ent.putData(value, order)
 		} else {
 			if (o + datalen) > len(parea) {
 				newlen := len(parea) + 1024
@@ -285,43 +470,431 @@ func writeIFD(w io.Writer, ifdOffset int, d []ifdEntry) error {
 				copy(newarea, parea)
 				parea = newarea
 			}
-			ent.putData(parea[o : o+datalen])
-			enc.PutUint32(buf[8:12], uint32(pstart+o))
-			o += datalen
+			ent.putData(parea[o:o+datalen], order)
+			putOffset(value, pstart+int64(o))
+			// Values must begin on a word boundary (page 15), so
+			// odd-sized values are padded with zero bytes.
+			o += datalen + padLen(datalen, align)
 		}
-		if _, err := w.Write(buf[:]); err != nil {
+		if _, err := w.Write(buf); err != nil {
 			return err
 		}
 	}
 	// The IFD ends with the offset of the next IFD in the file,
 	// or zero if it is the last one (page 14).
-	if err := binary.Write(w, enc, uint32(0)); err != nil {
+	putOffset(buf, next)
+	if _, err := w.Write(buf[:offsetLen]); err != nil {
+		return err
+	}
+	if _, err := w.Write(make([]byte, pad)); err != nil {
 		return err
 	}
-	_, err := w.Write(parea[:o])
+	_, err = w.Write(parea[:o])
 	return err
 }
 
 // Options are the encoding parameters.
 type Options struct {
-	// Compression is the type of compression used.
+	// Compression is the type of compression used. With CCITTGroup4, the
+	// image is written as a bilevel image, in which pixels with at least
+	// half the maximum luminance are white and all others black; it cannot
+	// be tiled. CCITTGroup3 is not supported for encoding. Other codecs can
+	// be used with RegisterCompression and RegisteredCompression.
 	Compression CompressionType
+	// CompressionLevel is the compression level of Deflate compression,
+	// from zlib.BestSpeed to zlib.BestCompression, or zlib.HuffmanOnly.
+	// Zero means zlib.DefaultCompression. It is ignored for other types
+	// of compression.
+	CompressionLevel int
 	// Predictor determines whether a differencing predictor is used;
 	// if true, instead of each pixel's color, the color difference to the
 	// preceding one is saved.  This improves the compression for certain
 	// types of images and compressors. For example, it works well for
-	// photos with Deflate compression.
+	// photos with Deflate compression. It applies to LZW compression and
+	// registered codecs; Deflate compression always uses the predictor.
 	Predictor bool
+	// TileWidth and TileLength are the dimensions of the tiles the image
+	// is divided into. Both must be multiples of 16; tiles at the right and
+	// bottom edges that extend past the image are padded with zeros. If
+	// they are zero, the image is written in strips.
+	TileWidth, TileLength int
+	// Concurrency is the maximum number of strips or tiles that are
+	// compressed concurrently. The output does not depend on it. If it is
+	// zero or one, they are compressed one after the other, straight into
+	// the file for the strips of an Encoder returned by NewStreamEncoder;
+	// otherwise the compressed data of up to Concurrency blocks is held in
+	// memory. Registered codecs must allow concurrent use of their
+	// encoders. It is ignored for uncompressed images.
+	Concurrency int
+	// RowsPerStrip is the number of rows of each strip of an image that is
+	// not tiled, except for the last strip, which holds the remaining
+	// rows. Small strips let streaming readers start early, large ones
+	// compress better. If it is zero, the image is written as a single
+	// strip. It cannot be combined with tiles, and StripWriter, which has
+	// its own strip size, ignores it.
+	RowsPerStrip int
+	// SparseFill, if not nil, is the color of empty tiles. Tiles whose
+	// pixels all have this color are not written to the file; their
+	// TileOffsets and TileByteCounts entries are zero. Readers such as
+	// GDAL and this package decode such sparse tiles as if all their
+	// samples were zero, so SparseFill must be a color that is encoded as
+	// zero samples, e.g. color.Transparent for RGBA images; Encode returns
+	// an error if a tile of another fill color would be left empty.
+	// SparseFill is ignored unless the image is tiled.
+	SparseFill *color.Color
+	// BaselineOnly restricts the encoder to the features of Baseline TIFF
+	// (p. 11-28 of the spec), plus LZW compression, for consumers that
+	// cannot handle anything else. Only Uncompressed, LZW and PackBits
+	// compression are allowed, without predictor and tiles. The only CCITT
+	// compression of Baseline TIFF is the modified Huffman coding of
+	// Compression 2, which this encoder does not write; CCITTGroup4 is an
+	// extension and therefore rejected. Images that are not paletted or
+	// 8-bit gray are written as 8-bit RGB without an alpha channel, and
+	// tags that are not part of Baseline TIFF are omitted.
+	BaselineOnly bool
+	// WhiteIsZero determines whether gray images, including GrayAImg and
+	// GrayA16Img images, are written with a PhotometricInterpretation of
+	// WhiteIsZero instead of BlackIsZero, with their gray samples inverted
+	// accordingly. It has no effect on other images, except with Bilevel.
+	WhiteIsZero bool
+	// Bilevel determines whether the image is written as a bilevel image
+	// with 1 bit per sample, in which pixels with at least half the maximum
+	// luminance are white and all others black. It is implied by
+	// CCITTGroup4 compression, which always stores the samples as
+	// WhiteIsZero, as fax readers expect; with other compressions, they
+	// are stored as WhiteIsZero if WhiteIsZero is set and as BlackIsZero
+	// otherwise. No predictor is used for bilevel images.
+	Bilevel bool
+	// HostComputer, if not empty, is written as the HostComputer tag, which
+	// names the computer or operating system on which the image was
+	// created. Trailing NUL bytes are removed.
+	HostComputer string
+	// Software, Artist, ImageDescription and Copyright, if not empty, are
+	// written as the tags of the same name, which name the program that
+	// created the image, its creator, its subject and its copyright
+	// notice. Trailing NUL bytes are removed, as with HostComputer.
+	Software, Artist, ImageDescription, Copyright string
+	// DateTime, if not zero, is written as the DateTime tag, the date and
+	// time of image creation, in the format "YYYY:MM:DD HH:MM:SS" of its
+	// location.
+	DateTime time.Time
+	// ForceBigTIFF makes the encoder write a BigTIFF file, with 64-bit
+	// offsets, even if the file would fit into a classic TIFF file.
+	// Otherwise, BigTIFF is used only if the first page does not fit into
+	// a classic TIFF file, which is limited to 4 GB. With an Encoder, the
+	// format is chosen with the first page; if later pages exceed the
+	// limit of a classic TIFF file, WriteImage fails.
+	ForceBigTIFF bool
+	// ByteOrder is the byte order of the file, binary.LittleEndian if nil.
+	// With binary.BigEndian, a big-endian ("MM") file is written, for
+	// consumers that cannot read little-endian ones: the values of the
+	// entries of the IFDs and the samples of more than 8 bits are stored
+	// with their most significant byte first. Like ForceBigTIFF, it is
+	// only used for the first page written by an Encoder.
+	ByteOrder binary.ByteOrder
+	// XResolution and YResolution, if positive, are the number of pixels
+	// per ResolutionUnit in each direction, which determine the physical
+	// size of the image when it is printed. If only one of them is
+	// positive, it is used for both directions; if both are zero, the
+	// resolution is 72 pixels per inch. ResolutionUnit is 1 (no absolute
+	// unit), 2 (inch) or 3 (centimeter); zero means inch. It is ignored if
+	// both resolutions are zero.
+	XResolution, YResolution float64
+	ResolutionUnit           int
+	// PageNumber, if not nil, is written as the PageNumber tag of the
+	// page, which is then also marked as a page of a multi-page document
+	// by its NewSubfileType tag.
+	PageNumber *PageNumber
+	// ExtraTags are additional entries written to the IFD, such as the
+	// entries that a SourceInfo preserves from a decoded image or private
+	// tags of an application (IDs 65000 and above are reserved for
+	// those). Values that do not fit into an entry are stored after it.
+	// The entries must not duplicate the ones that the encoder writes
+	// itself, and the 64-bit types can only be written to BigTIFF files.
+	ExtraTags []Tag
+	// ICCProfile, if not empty, is embedded as the ICC profile of the
+	// page. The encoder does not check that it matches the color model
+	// of the image.
+	ICCProfile []byte
+	// XMP and IPTC, if not empty, are written as the XMP packet and the
+	// IPTC-NAA record of the page, without being checked.
+	XMP, IPTC []byte
+	// Geo, if not nil, is written as the GeoTIFF tags of the page.
+	Geo *GeoInfo
+	// Exif and GPS, if not nil, are written as the Exif IFD and the GPS
+	// IFD of the page, after its pixel data. They are not written if
+	// BaselineOnly is set.
+	Exif *Exif
+	GPS  *GPS
+	// Pyramid, if not nil, makes WriteImage write reduced-resolution
+	// overviews of the image after it, as described by Pyramid.
+	Pyramid *Pyramid
+	// Thumbnail, if not nil, makes WriteImage write a scaled-down preview
+	// of the image as the page following it and its overviews.
+	Thumbnail *Thumbnail
+	// Mask, if not nil, is written as the transparency mask of the image,
+	// a bilevel page that directly follows it, marked by bit 2 of its
+	// NewSubfileType and a PhotometricInterpretation of TransparencyMask.
+	// The pixels of Mask whose alpha is at least 0x8000 are part of the
+	// image. Mask may be larger than the image for a finer outline. It is
+	// compressed like the image, or not at all if the image is CCITTGroup4
+	// compressed, and written in a single strip.
+	Mask image.Image
+	// OnProgress, if not nil, is called after each strip or tile of the
+	// image is encoded, with the number of strips or tiles encoded so far
+	// and their total number. Overviews, thumbnails and masks are not
+	// reported. The calls are not concurrent, even with Concurrency.
+	OnProgress func(done, total int64)
+	// Transformer, if not nil, makes WriteImage write images with an 8-bit
+	// RGB or YCbCr color model, such as image.RGBA, image.NRGBA and
+	// image.YCbCr, as CMYK, converting their colors with it. Images that
+	// are not opaque are written with an alpha channel, like a CMYKAImg.
+	// It is ignored with BaselineOnly.
+	Transformer ColorTransformer
 }
 
-// Encode writes the image m to w. opt determines the options used for
-// encoding, such as the compression type. If opt is nil, an uncompressed
-// image is written.
-func Encode(w io.Writer, m image.Image, opt *Options) error {
-	d := m.Bounds().Size()
+// rgbImage is an image whose alpha channel is discarded by the encoder.
+type rgbImage struct {
+	*image.RGBA
+}
+
+// convert16 converts m to an image.Gray16, image.NRGBA64, image.RGBA64,
+// CMYKA64Img or GrayA16Img if it has the color model of one of them but is
+// of another type. Such images would otherwise be written with 8 bits per
+// sample. Other images are returned unchanged.
+func convert16(m image.Image) image.Image {
+	if _, ok := m.(*NChannelImg); ok {
+		// The color model describes only the first four samples.
+		return m
+	}
+	var dst draw.Image
+	r := image.Rect(0, 0, m.Bounds().Dx(), m.Bounds().Dy())
+	switch m.ColorModel() {
+	case color.Gray16Model:
+		if _, ok := m.(*image.Gray16); ok {
+			return m
+		}
+		dst = image.NewGray16(r)
+	case color.NRGBA64Model:
+		if _, ok := m.(*image.NRGBA64); ok {
+			return m
+		}
+		dst = image.NewNRGBA64(r)
+	case color.RGBA64Model:
+		if _, ok := m.(*image.RGBA64); ok {
+			return m
+		}
+		dst = image.NewRGBA64(r)
+	case CMYKA64Model:
+		if _, ok := m.(*CMYKA64Img); ok {
+			return m
+		}
+		dst = NewCMYKA64(r)
+	case GrayA16Model:
+		if _, ok := m.(*GrayA16Img); ok {
+			return m
+		}
+		dst = NewGrayA16(r)
+	default:
+		return m
+	}
+	draw.Draw(dst, r, m, m.Bounds().Min, draw.Src)
+	return dst
+}
+
+// baselineTags holds the tags defined by Baseline TIFF (p. 117-118 of the
+// spec).
+var baselineTags = map[int]bool{
+	tNewSubfileType:            true,
+	tSubfileType:               true,
+	tImageWidth:                true,
+	tImageLength:               true,
+	tBitsPerSample:             true,
+	tCompression:               true,
+	tPhotometricInterpretation: true,
+	tThreshholding:             true,
+	tCellWidth:                 true,
+	tCellLength:                true,
+	tFillOrder:                 true,
+	tImageDescription:          true,
+	tMake:                      true,
+	tModel:                     true,
+	tStripOffsets:              true,
+	tOrientation:               true,
+	tSamplesPerPixel:           true,
+	tRowsPerStrip:              true,
+	tStripByteCounts:           true,
+	tMinSampleValue:            true,
+	tMaxSampleValue:            true,
+	tXResolution:               true,
+	tYResolution:               true,
+	tPlanarConfiguration:       true,
+	tFreeOffsets:               true,
+	tFreeByteCounts:            true,
+	tGrayResponseUnit:          true,
+	tGrayResponseCurve:         true,
+	tResolutionUnit:            true,
+	tSoftware:                  true,
+	tDateTime:                  true,
+	tArtist:                    true,
+	tHostComputer:              true,
+	tColorMap:                  true,
+	tExtraSamples:              true,
+	tCopyright:                 true,
+}
+
+// pixelEncoder is the signature of the encodeXXX functions, which write the
+// dx by dy pixels stored in pix to w.
+type pixelEncoder func(w io.Writer, pix []uint8, dx, dy, stride int, predictor bool) error
+
+// newCompressor returns a writer that compresses the data written to it into w
+// using the given compression type. level is the compression level as in
+// Options.CompressionLevel. rows is the number of rows of the data, which
+// PackBits compression packs separately.
+func newCompressor(w io.Writer, compression uint32, level, rows int) (io.WriteCloser, error) {
+	switch compression {
+	case cPackBits:
+		return &packBitsWriter{w: w, rows: rows}, nil
+	case cLZW:
+		return lzw.NewWriter(w, true), nil
+	case cDeflate:
+		if level == 0 {
+			level = zlib.DefaultCompression
+		}
+		return zlib.NewWriterLevel(w, level)
+	case cG4:
+		// The data is compressed by encodeG4, the pixel encoder of
+		// bilevel images.
+		return nopCloser{w}, nil
+	}
+	if c, ok := registeredCodec(compression); ok && c.enc != nil {
+		return c.enc(w), nil
+	}
+	return nil, UnsupportedError(fmt.Sprintf("compression value %d", compression))
+}
+
+// nopCloser is an io.WriteCloser whose Close method does nothing.
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
+
+// encodeTiles splits the dx by dy pixels stored in pix into tiles of tw by th
+// pixels, each pixel being bpp bytes long, and passes the encoded and, if
+// requested, compressed data of each tile to emit, in the order of the
+// tiles in the file. Edge tiles are padded with zeros. If sparse is not nil,
+// it is called with the position, size and padded pixels of each tile;
+// tiles for which it returns true are left empty, and emit is called with
+// nil for them. Up to workers tiles are encoded concurrently.
+func encodeTiles(pix []uint8, dx, dy, stride, bpp, tw, th int, enc pixelEncoder, compression uint32, level int, predictor bool, sparse sparseFunc, workers int, emit func(tile []byte) error) error {
+	across := (dx + tw - 1) / tw
+	down := (dy + th - 1) / th
+	return encodeBlocks(across*down, workers, func(i int) ([]byte, error) {
+		x, y := i%across*tw, i/across*th
+		w := minInt(tw, dx-x)
+		h := minInt(th, dy-y)
+		tile := make([]uint8, tw*th*bpp)
+		for r := 0; r < h; r++ {
+			off := (y+r)*stride + x*bpp
+			copy(tile[r*tw*bpp:], pix[off:off+w*bpp])
+		}
+		if sparse != nil {
+			empty, err := sparse(x, y, w, h, tile)
+			if err != nil || empty {
+				return nil, err
+			}
+		}
+		var buf bytes.Buffer
+		if compression == cNone {
+			if err := enc(&buf, tile, tw, th, tw*bpp, predictor); err != nil {
+				return nil, err
+			}
+			return buf.Bytes(), nil
+		}
+		cw, err := newCompressor(&buf, compression, level, th)
+		if err != nil {
+			return nil, err
+		}
+		if err := enc(cw, tile, tw, th, tw*bpp, predictor); err != nil {
+			return nil, err
+		}
+		if err := cw.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}, emit)
+}
+
+// encodeBlocks calls encode for the blocks 0 to n-1 and passes the data it
+// returns to emit in the same order. Up to workers blocks are encoded
+// concurrently while emit is called for the preceding ones. After an error,
+// no more blocks are started, and encodeBlocks returns once the blocks being
+// encoded have finished.
+func encodeBlocks(n, workers int, encode func(i int) ([]byte, error), emit func(b []byte) error) error {
+	if workers <= 1 {
+		for i := 0; i < n; i++ {
+			b, err := encode(i)
+			if err != nil {
+				return err
+			}
+			if err := emit(b); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	type result struct {
+		b   []byte
+		err error
+	}
+	// Each block has a channel for its result, queued in the order of the
+	// blocks. Besides the queued blocks, one block is awaited by emit and
+	// one waits to be queued.
+	queue := make(chan chan result, workers-2)
+	done := make(chan struct{})
+	go func() {
+		defer close(queue)
+		for i := 0; i < n; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			c := make(chan result, 1)
+			go func(i int) {
+				b, err := encode(i)
+				c <- result{b, err}
+			}(i)
+			select {
+			case queue <- c:
+			case <-done:
+				<-c
+				return
+			}
+		}
+	}()
+	var err error
+	for c := range queue {
+		r := <-c
+		if err != nil {
+			continue
+		}
+		if err = r.err; err == nil {
+			err = emit(r.b)
+		}
+		if err != nil {
+			close(done)
+		}
+	}
+	return err
+}
 
-	compression := uint32(cNone)
-	predictor := false
+// encodingParams validates opt and returns the compression type, whether
+// the horizontal predictor is used and whether the image is tiled.
+func encodingParams(opt *Options) (compression uint32, predictor, tiled bool, err error) {
+	compression = cNone
 	if opt != nil {
 		compression = opt.Compression.specValue()
 		// The TIFF 6.0 spec (June,1992) says the predictor field is only to be used with LZW. (See page 64).
@@ -329,147 +902,464 @@ func Encode(w io.Writer, m image.Image, opt *Options) error {
 		// This makes sense as Deflate is supposedly the successor to LWZ.
 		// Also both PNG and PDF use Deflate with predictors.
 		predictor = opt.Predictor && compression == cLZW || compression == cDeflate
+		if opt.CompressionLevel < zlib.HuffmanOnly || opt.CompressionLevel > zlib.BestCompression {
+			return 0, false, false, fmt.Errorf("tiff: invalid compression level %d", opt.CompressionLevel)
+		}
+		if !(opt.XResolution >= 0 && opt.XResolution <= math.MaxUint32 && opt.YResolution >= 0 && opt.YResolution <= math.MaxUint32) {
+			return 0, false, false, errors.New("tiff: invalid resolution")
+		}
+		if opt.ResolutionUnit < 0 || opt.ResolutionUnit > resPerCM {
+			return 0, false, false, fmt.Errorf("tiff: invalid resolution unit %d", opt.ResolutionUnit)
+		}
+		if opt.Concurrency < 0 {
+			return 0, false, false, fmt.Errorf("tiff: invalid concurrency %d", opt.Concurrency)
+		}
+		if opt.RowsPerStrip < 0 {
+			return 0, false, false, fmt.Errorf("tiff: invalid number of rows per strip %d", opt.RowsPerStrip)
+		}
+		if opt.TileWidth != 0 || opt.TileLength != 0 {
+			if opt.RowsPerStrip != 0 {
+				return 0, false, false, errors.New("tiff: RowsPerStrip cannot be combined with tiles")
+			}
+			if opt.TileWidth <= 0 || opt.TileLength <= 0 || opt.TileWidth%16 != 0 || opt.TileLength%16 != 0 {
+				return 0, false, false, errors.New("tiff: tile width and length must be positive multiples of 16")
+			}
+			tiled = true
+		}
+		if opt.BaselineOnly {
+			switch {
+			case compression != cNone && compression != cLZW && compression != cPackBits:
+				return 0, false, false, errors.New("tiff: BaselineOnly allows Uncompressed, LZW and PackBits compression only")
+			case opt.Predictor:
+				return 0, false, false, errors.New("tiff: BaselineOnly does not allow a predictor")
+			case tiled:
+				return 0, false, false, errors.New("tiff: BaselineOnly does not allow tiles")
+			case opt.ForceBigTIFF:
+				return 0, false, false, errors.New("tiff: BaselineOnly does not allow BigTIFF")
+			}
+		}
+	}
+	switch compression {
+	case cNone, cLZW, cDeflate, cPackBits:
+	case cG4:
+		if tiled {
+			return 0, false, false, errors.New("tiff: CCITTGroup4 compression does not allow tiles")
+		}
+	default:
+		if c, ok := registeredCodec(compression); !ok || c.enc == nil {
+			return 0, false, false, UnsupportedError(fmt.Sprintf("compression value %d", compression))
+		}
+		predictor = opt.Predictor
+	}
+	return compression, predictor, tiled, nil
+}
+
+// fileOrder returns the byte order of a file whose first page is written
+// with opt: binary.BigEndian if opt.ByteOrder stores the most significant
+// byte first and binary.LittleEndian otherwise.
+func fileOrder(opt *Options) binary.ByteOrder {
+	if opt == nil || opt.ByteOrder == nil {
+		return binary.LittleEndian
 	}
+	var b [2]byte
+	opt.ByteOrder.PutUint16(b[:], 1)
+	if b[0] == 0 {
+		return binary.BigEndian
+	}
+	return binary.LittleEndian
+}
 
-	_, err := io.WriteString(w, leHeader)
-	if err != nil {
+// swapSamples returns enc, which encodes samples in little-endian order,
+// adapted to the byte order of the file written by e, given the IFD
+// entries of the page that hold its BitsPerSample.
+func (e *Encoder) swapSamples(enc pixelEncoder, ifd []ifdEntry) pixelEncoder {
+	if e.order != binary.BigEndian {
+		return enc
+	}
+	n := 0
+	for _, x := range ifd {
+		if x.tag == tBitsPerSample && len(x.data) > 0 {
+			n = int(x.data[0]) / 8
+		}
+	}
+	if n <= 1 {
+		return enc
+	}
+	return func(w io.Writer, pix []uint8, dx, dy, stride int, predictor bool) error {
+		return enc(&swapWriter{w: w, n: n}, pix, dx, dy, stride, predictor)
+	}
+}
+
+// A swapWriter reverses the order of the bytes of each group of n bytes
+// written to it before writing them to w. The groups may be split across
+// calls of Write.
+type swapWriter struct {
+	w   io.Writer
+	n   int
+	buf []byte
+}
+
+func (s *swapWriter) Write(p []byte) (int, error) {
+	s.buf = append(s.buf, p...)
+	k := len(s.buf) - len(s.buf)%s.n
+	for i := 0; i < k; i += s.n {
+		g := s.buf[i : i+s.n]
+		for a, b := 0, len(g)-1; a < b; a, b = a+1, b-1 {
+			g[a], g[b] = g[b], g[a]
+		}
+	}
+	if _, err := s.w.Write(s.buf[:k]); err != nil {
+		return 0, err
+	}
+	s.buf = append(s.buf[:0], s.buf[k:]...)
+	return len(p), nil
+}
+
+// An Encoder writes a TIFF file with one or more pages to an io.Writer.
+// Each page is written with its own options, so that the pages of a file
+// can differ in compression, photometric interpretation and layout. The
+// IFDs of the pages are chained in the order in which they are written;
+// Options.PageNumber numbers them for readers that rely on the PageNumber
+// tag.
+type Encoder struct {
+	w      io.Writer
+	big    bool             // Whether a BigTIFF file is written.
+	order  binary.ByteOrder // Byte order of the file.
+	ifd    []ifdEntry       // IFD of the last page, which is not yet written.
+	offset int64            // Offset of the IFD of the last page.
+	closed bool
+
+	// The fields below are used by Encoders returned by NewStreamEncoder.
+	stream  bool
+	start   int64        // Position of the file in w, if w is an io.Seeker.
+	pos     int64        // Number of bytes written so far.
+	pending [][]ifdEntry // IFDs of the pages, written by Close.
+	header  []byte       // Header of the file, completed by Close.
+
+	// The fields below are used by Encoders returned by OpenAppend.
+	appendPtr int64 // Offset of the pointer to the first new IFD, or 0.
+	appendEnd int64 // Offset of the pixel data of the first new page.
+
+	ctx context.Context // Context checked between blocks, if not nil.
+}
+
+// NewEncoder returns an Encoder that writes to w. The file is complete only
+// after Close has been called.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w, order: binary.LittleEndian}
+}
+
+// Close writes the IFD of the last page, completing the file. It does not
+// close the underlying writer. At least one image must have been written.
+func (e *Encoder) Close() error {
+	if e.closed {
+		return errors.New("tiff: Encoder is already closed")
+	}
+	if e.stream {
+		return e.closeStream()
+	}
+	if e.ifd == nil {
+		return errors.New("tiff: no image written")
+	}
+	e.closed = true
+	return writeIFD(e.w, e.offset, e.ifd, 0, e.order, e.big)
+}
+
+// link writes what precedes the pixel data of a new page whose IFD will be
+// at ifdOffset: the header of the file for the first page, and the IFD of
+// the preceding page, which points to the new one, for the other pages.
+// The first page appended to an existing file is linked to its last IFD.
+func (e *Encoder) link(ifdOffset int64) error {
+	switch {
+	case e.ifd != nil:
+		return writeIFD(e.w, e.offset, e.ifd, ifdOffset, e.order, e.big)
+	case e.appendPtr != 0:
+		return e.linkAppend(ifdOffset)
+	}
+	_, err := e.w.Write(e.fileHeader(ifdOffset))
+	return err
+}
+
+// fileHeader returns the header of the file written by e, with the offset
+// of the first IFD.
+func (e *Encoder) fileHeader(ifdOffset int64) []byte {
+	if e.big {
+		// The BigTIFF header holds the size of offsets, a reserved zero
+		// and the offset of the first IFD.
+		h := []byte(bigLEHeader + "\x08\x00\x00\x00" + "\x00\x00\x00\x00\x00\x00\x00\x00")
+		if e.order == binary.BigEndian {
+			copy(h, bigBEHeader+"\x00\x08")
+		}
+		e.order.PutUint64(h[8:], uint64(ifdOffset))
+		return h
+	}
+	h := []byte(leHeader + "\x00\x00\x00\x00")
+	if e.order == binary.BigEndian {
+		copy(h, beHeader)
+	}
+	e.order.PutUint32(h[4:], uint32(ifdOffset))
+	return h
+}
+
+// Encode writes the image m to w. opt determines the options used for
+// encoding, such as the compression type. If opt is nil, an uncompressed
+// image is written. Images with a 16-bit color model, such as image.Gray16,
+// image.RGBA64 and image.NRGBA64, are written with 16 bits per sample.
+func Encode(w io.Writer, m image.Image, opt *Options) error {
+	e := NewEncoder(w)
+	if err := e.WriteImage(m, opt); err != nil {
 		return err
 	}
+	return e.Close()
+}
 
-	// Compressed data is written into a buffer first, so that we
-	// know the compressed size.
-	var buf bytes.Buffer
-	// dst holds the destination for the pixel data of the image --
-	// either w or a writer to buf.
-	var dst io.Writer
-	// imageLen is the length of the pixel data in bytes.
-	// The offset of the IFD is imageLen + 8 header bytes.
-	var imageLen int
+// EncodeContext is like Encode, but stops encoding when ctx is done: ctx is
+// checked before each strip or tile, and its error is returned once it is
+// canceled or its deadline passes. The output written to w until then is
+// not a complete file.
+func EncodeContext(ctx context.Context, w io.Writer, m image.Image, opt *Options) error {
+	e := NewEncoder(w)
+	e.ctx = ctx
+	if err := e.WriteImage(m, opt); err != nil {
+		return err
+	}
+	return e.Close()
+}
 
-	switch compression {
-	case cNone:
-		dst = w
-		// Write IFD offset before outputting pixel data.
-		switch m.(type) {
-		case *image.Paletted:
-			imageLen = d.X * d.Y * 1
-		case *image.Gray:
-			imageLen = d.X * d.Y * 1
-		case *image.Gray16:
-			imageLen = d.X * d.Y * 2
-		case *image.RGBA64:
-			imageLen = d.X * d.Y * 8
-		case *image.NRGBA64:
-			imageLen = d.X * d.Y * 8
-		case *image.CMYK:
-			imageLen = d.X * d.Y * 4
-		default:
-			imageLen = d.X * d.Y * 4
+// WriteImage writes the image m as the next page of the file. opt
+// determines the options used for encoding this page, as for Encode.
+func (e *Encoder) WriteImage(m image.Image, opt *Options) error {
+	if e.closed {
+		return errors.New("tiff: Encoder is already closed")
+	}
+	if opt != nil && opt.Thumbnail != nil {
+		return e.writeThumbnail(m, opt)
+	}
+	if opt != nil && opt.Pyramid != nil {
+		return e.writePyramid(m, opt)
+	}
+	if opt != nil && opt.Mask != nil {
+		o := *opt
+		o.Mask = nil
+		if err := e.WriteImage(m, &o); err != nil {
+			return err
 		}
-		err = binary.Write(w, enc, uint32(imageLen+8))
-	case cLZW:
-		dst = lzw.NewWriter(&buf, true)
-	case cDeflate:
-		dst = zlib.NewWriter(&buf)
-	default:
-		err = UnsupportedError(fmt.Sprintf("compression value %d", compression))
+		return e.writeMask(opt.Mask, opt)
 	}
+	d := m.Bounds().Size()
 
+	compression, predictor, tiled, err := encodingParams(opt)
 	if err != nil {
 		return err
 	}
+	if compression == cG4 || opt != nil && opt.Bilevel {
+		return e.writeBilevel(m, opt, compression, tiled)
+	}
+	if opt != nil && opt.BaselineOnly {
+		// Baseline TIFF knows neither 16-bit nor CMYK nor alpha
+		// samples, so such images are converted to 8-bit gray or RGB.
+		switch m.(type) {
+		case *image.Paletted, *image.Gray:
+		case *image.Gray16:
+			gray := image.NewGray(image.Rect(0, 0, d.X, d.Y))
+			draw.Draw(gray, gray.Rect, m, m.Bounds().Min, draw.Src)
+			m = gray
+		default:
+			rgba := image.NewRGBA(image.Rect(0, 0, d.X, d.Y))
+			draw.Draw(rgba, rgba.Rect, m, m.Bounds().Min, draw.Src)
+			m = rgbImage{rgba}
+		}
+	} else if opt != nil && opt.Transformer != nil {
+		m = transformToCMYK(m, opt.Transformer)
+	}
+	m = convert16(m)
 
-	pr := uint32(prNone)
 	photometricInterpretation := uint32(pRGB)
 	samplesPerPixel := uint32(4)
 	bitsPerSample := []uint32{8, 8, 8, 8}
 	extraSamples := uint32(0)
 	colorMap := []uint32{}
+	var inks []ifdEntry // InkSet, NumberOfInks and InkNames of an NChannelImg.
 
-	if predictor {
-		pr = prHorizontal
-	}
+	// pix, stride and bpp describe the pixel data of m, which is encoded
+	// by encPix.
+	var (
+		pix    []uint8
+		stride int
+		bpp    = 4
+		encPix pixelEncoder
+	)
+
+	whiteIsZero := opt != nil && opt.WhiteIsZero
 	switch m := m.(type) {
 	case *image.Paletted:
+		// The indices are packed into fewer bits if the palette allows,
+		// except with a predictor, which needs whole bytes.
+		bits := 8
+		if !predictor {
+			bits = paletteBits(m)
+		}
 		photometricInterpretation = pPaletted
 		samplesPerPixel = 1
-		bitsPerSample = []uint32{8}
-		colorMap = make([]uint32, 256*3)
-		for i := 0; i < 256 && i < len(m.Palette); i++ {
+		bitsPerSample = []uint32{uint32(bits)}
+		n := 1 << uint(bits)
+		colorMap = make([]uint32, n*3)
+		for i := 0; i < n && i < len(m.Palette); i++ {
 			r, g, b, _ := m.Palette[i].RGBA()
-			colorMap[i+0*256] = uint32(r)
-			colorMap[i+1*256] = uint32(g)
-			colorMap[i+2*256] = uint32(b)
+			colorMap[i+0*n] = uint32(r)
+			colorMap[i+1*n] = uint32(g)
+			colorMap[i+2*n] = uint32(b)
+		}
+		pix, stride, bpp, encPix = m.Pix, m.Stride, 1, encodeGray
+		if bits < 8 {
+			encPix = packedEncoder(bits)
 		}
-		err = encodeGray(dst, m.Pix, d.X, d.Y, m.Stride, predictor)
 	case *image.Gray:
 		photometricInterpretation = pBlackIsZero
 		samplesPerPixel = 1
 		bitsPerSample = []uint32{8}
-		err = encodeGray(dst, m.Pix, d.X, d.Y, m.Stride, predictor)
+		pix, stride, bpp, encPix = m.Pix, m.Stride, 1, encodeGray
+		if whiteIsZero {
+			photometricInterpretation, pix = pWhiteIsZero, invertBytes(pix)
+		}
 	case *image.Gray16:
 		photometricInterpretation = pBlackIsZero
 		samplesPerPixel = 1
 		bitsPerSample = []uint32{16}
-		err = encodeGray16(dst, m.Pix, d.X, d.Y, m.Stride, predictor)
+		pix, stride, bpp, encPix = m.Pix, m.Stride, 2, encodeGray16
+		if whiteIsZero {
+			photometricInterpretation, pix = pWhiteIsZero, invertBytes(pix)
+		}
+	case *GrayAImg:
+		photometricInterpretation = pBlackIsZero
+		extraSamples = 2 // Unassociated alpha.
+		samplesPerPixel = 2
+		bitsPerSample = []uint32{8, 8}
+		pix, stride, bpp, encPix = m.Pix, m.Stride, 2, sampleEncoder(2, 1)
+		if whiteIsZero {
+			photometricInterpretation, pix = pWhiteIsZero, invertGray(pix, 1)
+		}
+	case *GrayA16Img:
+		photometricInterpretation = pBlackIsZero
+		extraSamples = 2 // Unassociated alpha.
+		samplesPerPixel = 2
+		bitsPerSample = []uint32{16, 16}
+		pix, stride, bpp, encPix = m.Pix, m.Stride, 4, bigEndianEncoder(2)
+		if whiteIsZero {
+			photometricInterpretation, pix = pWhiteIsZero, invertGray(pix, 2)
+		}
 	case *image.NRGBA:
 		extraSamples = 2 // Unassociated alpha.
-		err = encodeRGBA(dst, m.Pix, d.X, d.Y, m.Stride, predictor)
+		pix, stride, bpp, encPix = m.Pix, m.Stride, 4, encodeRGBA
 	case *image.NRGBA64:
 		extraSamples = 2 // Unassociated alpha.
 		bitsPerSample = []uint32{16, 16, 16, 16}
-		err = encodeRGBA64(dst, m.Pix, d.X, d.Y, m.Stride, predictor)
+		pix, stride, bpp, encPix = m.Pix, m.Stride, 8, encodeRGBA64
 	case *image.RGBA:
 		extraSamples = 1 // Associated alpha.
-		err = encodeRGBA(dst, m.Pix, d.X, d.Y, m.Stride, predictor)
+		pix, stride, bpp, encPix = m.Pix, m.Stride, 4, encodeRGBA
 	case *image.RGBA64:
 		extraSamples = 1 // Associated alpha.
 		bitsPerSample = []uint32{16, 16, 16, 16}
-		err = encodeRGBA64(dst, m.Pix, d.X, d.Y, m.Stride, predictor)
+		pix, stride, bpp, encPix = m.Pix, m.Stride, 8, encodeRGBA64
+	case rgbImage:
+		samplesPerPixel = 3
+		bitsPerSample = []uint32{8, 8, 8}
+		pix, stride, bpp, encPix = m.Pix, m.Stride, 4, encodeRGB
 	case *image.CMYK:
 		photometricInterpretation = uint32(pCMYK)
 		samplesPerPixel = uint32(4)
 		bitsPerSample = []uint32{8, 8, 8, 8}
-		err = encodeCMYK(dst, m.Pix, d.X, d.Y, m.Stride, predictor)
+		pix, stride, bpp, encPix = m.Pix, m.Stride, 4, encodeCMYK
+	case *CMYKAImg:
+		photometricInterpretation = pCMYK
+		extraSamples = 2 // Unassociated alpha.
+		samplesPerPixel = 5
+		bitsPerSample = []uint32{8, 8, 8, 8, 8}
+		pix, stride, bpp, encPix = m.Pix, m.Stride, 5, sampleEncoder(5, 1)
+	case *PremulCMYKAImg:
+		photometricInterpretation = pCMYK
+		extraSamples = 1 // Associated alpha.
+		samplesPerPixel = 5
+		bitsPerSample = []uint32{8, 8, 8, 8, 8}
+		pix, stride, bpp, encPix = m.Pix, m.Stride, 5, sampleEncoder(5, 1)
+	case *CMYKA64Img:
+		photometricInterpretation = pCMYK
+		extraSamples = 2 // Unassociated alpha.
+		samplesPerPixel = 5
+		bitsPerSample = []uint32{16, 16, 16, 16, 16}
+		pix, stride, bpp, encPix = m.Pix, m.Stride, 10, encodeCMYKA64
+	case *NChannelImg:
+		if m.Samples < 1 || m.BytesPerSample != 1 && m.BytesPerSample != 2 {
+			return fmt.Errorf("tiff: NChannelImg with %d samples of %d bytes", m.Samples, m.BytesPerSample)
+		}
+		photometricInterpretation = pCMYK
+		samplesPerPixel = uint32(m.Samples)
+		bitsPerSample = make([]uint32, m.Samples)
+		for i := range bitsPerSample {
+			bitsPerSample[i] = uint32(8 * m.BytesPerSample)
+		}
+		pix, stride, bpp = m.Pix, m.Stride, m.Samples*m.BytesPerSample
+		encPix = sampleEncoder(m.Samples, 1)
+		if m.BytesPerSample == 2 {
+			encPix = bigEndianEncoder(m.Samples)
+		}
+		inkSet := uint32(inkSetNotCMYK)
+		if m.Samples == 4 {
+			inkSet = inkSetCMYK
+		}
+		inks = []ifdEntry{
+			{tInkSet, dtShort, []uint32{inkSet}},
+			{tNumberOfInks, dtShort, []uint32{uint32(m.Samples)}},
+		}
+		if len(m.InkNames) > 0 {
+			inks = append(inks, asciiEntry(tInkNames, strings.Join(m.InkNames, "\x00")))
+		}
 	default:
 		extraSamples = 1 // Associated alpha.
-		err = encode(dst, m, predictor)
-	}
-	if err != nil {
-		return err
+		if tiled || opt != nil && opt.RowsPerStrip > 0 {
+			// Tiles and strips are cut from the pixel data, so the
+			// image is converted up front.
+			rgba := image.NewRGBA(image.Rect(0, 0, d.X, d.Y))
+			draw.Draw(rgba, rgba.Rect, m, m.Bounds().Min, draw.Src)
+			pix, stride, bpp, encPix = rgba.Pix, rgba.Stride, 4, encodeRGBA
+		} else {
+			encPix = func(w io.Writer, _ []uint8, _, _, _ int, predictor bool) error {
+				return encode(w, m, predictor)
+			}
+		}
 	}
 
-	if compression != cNone {
-		if err = dst.(io.Closer).Close(); err != nil {
-			return err
-		}
-		imageLen = buf.Len()
-		if err = binary.Write(w, enc, uint32(imageLen+8)); err != nil {
-			return err
-		}
-		if _, err = buf.WriteTo(w); err != nil {
-			return err
+	var sparse sparseFunc
+	if tiled && opt.SparseFill != nil {
+		b := m.Bounds()
+		fr, fg, fb, fa := m.ColorModel().Convert(*opt.SparseFill).RGBA()
+		sparse = func(x, y, w, h int, tile []uint8) (bool, error) {
+			for j := b.Min.Y + y; j < b.Min.Y+y+h; j++ {
+				for i := b.Min.X + x; i < b.Min.X+x+w; i++ {
+					r, g, b, a := m.At(i, j).RGBA()
+					if r != fr || g != fg || b != fb || a != fa {
+						return false, nil
+					}
+				}
+			}
+			// Readers decode empty tiles as zero samples, so a fill
+			// that is stored differently would change the image.
+			var z zeroChecker
+			if err := encPix(&z, tile, opt.TileWidth, opt.TileLength, opt.TileWidth*bpp, false); err != nil {
+				return false, err
+			}
+			if z {
+				return false, fmt.Errorf("tiff: SparseFill %v is not encoded as zero samples", *opt.SparseFill)
+			}
+			return true, nil
 		}
 	}
 
 	ifd := []ifdEntry{
-		{tImageWidth, dtShort, []uint32{uint32(d.X)}},
-		{tImageLength, dtShort, []uint32{uint32(d.Y)}},
 		{tBitsPerSample, dtShort, bitsPerSample},
-		{tCompression, dtShort, []uint32{compression}},
 		{tPhotometricInterpretation, dtShort, []uint32{photometricInterpretation}},
-		{tStripOffsets, dtLong, []uint32{8}},
 		{tSamplesPerPixel, dtShort, []uint32{samplesPerPixel}},
-		{tRowsPerStrip, dtShort, []uint32{uint32(d.Y)}},
-		{tStripByteCounts, dtLong, []uint32{uint32(imageLen)}},
-		// There is currently no support for storing the image
-		// resolution, so give a bogus value of 72x72 dpi.
-		{tXResolution, dtRational, []uint32{72, 1}},
-		{tYResolution, dtRational, []uint32{72, 1}},
-		{tResolutionUnit, dtShort, []uint32{resPerInch}},
-	}
-	if pr != prNone {
-		ifd = append(ifd, ifdEntry{tPredictor, dtShort, []uint32{pr}})
 	}
 	if len(colorMap) != 0 {
 		ifd = append(ifd, ifdEntry{tColorMap, dtShort, colorMap})
@@ -477,6 +1367,913 @@ func Encode(w io.Writer, m image.Image, opt *Options) error {
 	if extraSamples > 0 {
 		ifd = append(ifd, ifdEntry{tExtraSamples, dtShort, []uint32{extraSamples}})
 	}
+	ifd = append(ifd, inks...)
+	px := pixels{pix, d.X, d.Y, stride, bpp, encPix, sparse}
+	return e.writePage(px, opt, compression, predictor, tiled, ifd)
+}
+
+// writeBilevel writes m as a bilevel image with the given compression.
+// Pixels whose luminance is at least half the maximum are white, all others
+// black. With CCITT Group 4 compression, the samples are stored as
+// WhiteIsZero, as fax readers expect.
+func (e *Encoder) writeBilevel(m image.Image, opt *Options, compression uint32, tiled bool) error {
+	photometric, black := uint32(pBlackIsZero), uint8(0)
+	if compression == cG4 || opt.WhiteIsZero {
+		photometric, black = pWhiteIsZero, 1
+	}
+	b := m.Bounds()
+	pix := make([]uint8, b.Dx()*b.Dy())
+	i := 0
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if color.GrayModel.Convert(m.At(x, y)).(color.Gray).Y < 0x80 {
+				pix[i] = black
+			} else {
+				pix[i] = 1 - black
+			}
+			i++
+		}
+	}
+	ifd := []ifdEntry{
+		{tBitsPerSample, dtShort, []uint32{1}},
+		{tPhotometricInterpretation, dtShort, []uint32{photometric}},
+		{tSamplesPerPixel, dtShort, []uint32{1}},
+	}
+	enc := packedEncoder(1)
+	if compression == cG4 {
+		enc = encodeG4
+	}
+	px := pixels{pix, b.Dx(), b.Dy(), b.Dx(), 1, enc, nil}
+	return e.writePage(px, opt, compression, false, tiled, ifd)
+}
 
-	return writeIFD(w, imageLen+8, ifd)
+// reducedOptions returns the options of a page derived from the page
+// written with opt, marked with the NewSubfileType subfileType: 1 for a
+// reduced-resolution image such as an overview or thumbnail, 4 for a
+// transparency mask. Only the compression settings of opt are kept, without
+// the metadata of the page; CCITTGroup4, which is only defined for bilevel
+// images, is replaced by Uncompressed.
+func reducedOptions(opt *Options, subfileType uint64) *Options {
+	o := &Options{
+		Compression:      opt.Compression,
+		CompressionLevel: opt.CompressionLevel,
+		Predictor:        opt.Predictor,
+		ExtraTags:        []Tag{{ID: tNewSubfileType, Type: TypeLong, Count: 1, Value: []uint64{subfileType}}},
+	}
+	if o.Compression == CCITTGroup4 {
+		o.Compression = Uncompressed
+	}
+	return o
+}
+
+// writeMask writes the transparency mask m of the preceding page, encoded
+// with opt.
+func (e *Encoder) writeMask(m image.Image, opt *Options) error {
+	b := m.Bounds()
+	pix := make([]uint8, b.Dx()*b.Dy())
+	i := 0
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if _, _, _, a := m.At(x, y).RGBA(); a >= 0x8000 {
+				pix[i] = 1
+			}
+			i++
+		}
+	}
+	mask := reducedOptions(opt, 4)
+	compression, _, _, err := encodingParams(mask)
+	if err != nil {
+		return err
+	}
+	ifd := []ifdEntry{
+		{tBitsPerSample, dtShort, []uint32{1}},
+		{tPhotometricInterpretation, dtShort, []uint32{pTransMask}},
+		{tSamplesPerPixel, dtShort, []uint32{1}},
+	}
+	px := pixels{pix, b.Dx(), b.Dy(), b.Dx(), 1, packedEncoder(1), nil}
+	return e.writePage(px, mask, compression, false, false, ifd)
+}
+
+// paletteBits returns the smallest number of bits per sample, 1, 2, 4 or 8,
+// that holds both the indices of all palette entries of m and all indices
+// used by its pixels.
+func paletteBits(m *image.Paletted) int {
+	n := len(m.Palette)
+	b := m.Bounds()
+	for y := 0; y < b.Dy(); y++ {
+		row := m.Pix[y*m.Stride : y*m.Stride+b.Dx()]
+		for _, v := range row {
+			if int(v) >= n {
+				n = int(v) + 1
+			}
+		}
+	}
+	bits := 1
+	for 1<<uint(bits) < n {
+		bits *= 2
+	}
+	return bits
+}
+
+// packedEncoder returns a pixelEncoder for pixels of one byte each, holding
+// values of bits bits, 1, 2 or 4. The values are packed into bytes with the
+// most significant bits first, and each row starts on a byte boundary.
+func packedEncoder(bits int) pixelEncoder {
+	return func(w io.Writer, pix []uint8, dx, dy, stride int, _ bool) error {
+		perByte := 8 / bits
+		buf := make([]byte, (dx+perByte-1)/perByte)
+		for y := 0; y < dy; y++ {
+			for i := range buf {
+				buf[i] = 0
+			}
+			row := pix[y*stride : y*stride+dx]
+			for x, v := range row {
+				shift := uint(8 - bits - x%perByte*bits)
+				buf[x/perByte] |= v << shift
+			}
+			if _, err := w.Write(buf); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// invertBytes returns a copy of pix with all bits complemented, which inverts
+// the samples of an image.Gray or image.Gray16.
+func invertBytes(pix []uint8) []uint8 {
+	inverted := make([]uint8, len(pix))
+	for i, v := range pix {
+		inverted[i] = ^v
+	}
+	return inverted
+}
+
+// invertGray returns a copy of pix, the Pix of a GrayAImg (n == 1) or a
+// GrayA16Img (n == 2), with its gray samples inverted and its alpha samples
+// unchanged.
+func invertGray(pix []uint8, n int) []uint8 {
+	inverted := make([]uint8, len(pix))
+	for i, v := range pix {
+		if i/n%2 == 0 {
+			v = ^v
+		}
+		inverted[i] = v
+	}
+	return inverted
+}
+
+// A sparseFunc reports whether the tile of w by h pixels at x, y, whose
+// padded pixel data is tile, is left empty.
+type sparseFunc func(x, y, w, h int, tile []uint8) (bool, error)
+
+// zeroChecker is an io.Writer that records whether any non-zero byte was
+// written to it.
+type zeroChecker bool
+
+func (z *zeroChecker) Write(p []byte) (int, error) {
+	for _, b := range p {
+		if b != 0 {
+			*z = true
+			break
+		}
+	}
+	return len(p), nil
+}
+
+// pixels describes the pixel data of an image to be written.
+type pixels struct {
+	pix    []uint8
+	dx, dy int
+	stride int
+	bpp    int // Bytes per pixel in pix.
+	enc    pixelEncoder
+	sparse sparseFunc // Reports tiles to leave empty, if not nil.
+}
+
+// writePage writes the pixel data px as a new page. ifd holds the entries
+// describing the format of the samples; writePage adds the entries
+// describing the image's size and layout. The IFD of the page is written
+// by the next call of writePage or by Close.
+func (e *Encoder) writePage(px pixels, opt *Options, compression uint32, predictor, tiled bool, ifd []ifdEntry) error {
+	ifd, extra, subs, err := pageEntries(opt, ifd)
+	if err != nil {
+		return err
+	}
+	if e.ifd == nil && e.appendPtr == 0 && e.pos == 0 {
+		// The byte order of the file is chosen with its first page.
+		e.order = fileOrder(opt)
+	}
+	px.enc = e.swapSamples(px.enc, ifd)
+	if e.ctx != nil || opt != nil && opt.OnProgress != nil {
+		var onProgress func(done, total int64)
+		if opt != nil {
+			onProgress = opt.OnProgress
+		}
+		px = e.trackProgress(px, opt, tiled, onProgress)
+	}
+
+	bitsPerPixel := 0
+	for _, e := range ifd {
+		if e.tag == tBitsPerSample {
+			for _, b := range e.data {
+				bitsPerPixel += int(b)
+			}
+		}
+	}
+
+	rowsPerStrip := px.dy
+	if opt != nil && opt.RowsPerStrip > 0 && opt.RowsPerStrip < px.dy {
+		rowsPerStrip = opt.RowsPerStrip
+	}
+	level, workers := 0, 0
+	if opt != nil {
+		level, workers = opt.CompressionLevel, opt.Concurrency
+	}
+
+	// entries returns the IFD of the page for pixel data that starts at
+	// dataOffset, where lens holds the length of each tile or strip.
+	entries := func(dataOffset int64, lens []int) []ifdEntry {
+		d := append(append([]ifdEntry(nil), ifd...), imageEntries(px.dx, px.dy, compression, opt)...)
+		offsets := make([]int64, len(lens))
+		counts := make([]int64, len(lens))
+		off := dataOffset
+		for i, n := range lens {
+			// Empty tiles have an offset of 0.
+			if tiled && n == 0 {
+				continue
+			}
+			offsets[i], counts[i] = off, int64(n)
+			off += int64(n)
+		}
+		if tiled {
+			d = append(d,
+				ifdEntry{tTileWidth, dtShort, []uint32{uint32(opt.TileWidth)}},
+				ifdEntry{tTileLength, dtShort, []uint32{uint32(opt.TileLength)}},
+				e.offsetEntry(tTileOffsets, offsets),
+				e.offsetEntry(tTileByteCounts, counts),
+			)
+		} else {
+			d = append(d,
+				e.offsetEntry(tStripOffsets, offsets),
+				shortOrLong(tRowsPerStrip, rowsPerStrip),
+				e.offsetEntry(tStripByteCounts, counts),
+			)
+		}
+		if predictor {
+			d = append(d, ifdEntry{tPredictor, dtShort, []uint32{prHorizontal}})
+		}
+		d = append(d, extra...)
+		if opt != nil && opt.BaselineOnly {
+			baseline := d[:0]
+			for _, e := range d {
+				if baselineTags[e.tag] {
+					baseline = append(baseline, e)
+				}
+			}
+			d = baseline
+		}
+		return d
+	}
+
+	if e.stream {
+		return e.streamPage(px, opt, compression, level, workers, predictor, tiled, rowsPerStrip, extra, subs, entries)
+	}
+
+	// Compressed data is written into a buffer first, so that we
+	// know the compressed size.
+	var buf bytes.Buffer
+	// tiles holds the encoded data of each tile of a tiled image.
+	var tiles [][]byte
+	// imageLen is the length of the pixel data in bytes.
+	var imageLen int
+	// lens holds the length in bytes of each tile, or of each strip of
+	// an image that is not tiled, which has rowsPerStrip rows.
+	var lens []int
+
+	switch {
+	case tiled:
+		err = encodeTiles(px.pix, px.dx, px.dy, px.stride, px.bpp, opt.TileWidth, opt.TileLength, px.enc, compression, level, predictor, px.sparse, workers, func(t []byte) error {
+			tiles = append(tiles, t)
+			lens = append(lens, len(t))
+			imageLen += len(t)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	case compression == cNone:
+		// Rows of fewer than 8 bits per pixel are padded to whole bytes.
+		rowLen := (px.dx*bitsPerPixel + 7) / 8
+		for y := 0; ; y += rowsPerStrip {
+			lens = append(lens, rowLen*minInt(rowsPerStrip, px.dy-y))
+			if y+rowsPerStrip >= px.dy {
+				break
+			}
+		}
+		imageLen = rowLen * px.dy
+	default:
+		if lens, err = px.encodeStrips(&buf, compression, level, workers, predictor, rowsPerStrip); err != nil {
+			return err
+		}
+		imageLen = buf.Len()
+	}
+
+	var data func(w io.Writer) error
+	switch {
+	case tiled:
+		data = func(w io.Writer) error {
+			for _, t := range tiles {
+				if _, err := w.Write(t); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	case compression == cNone:
+		data = func(w io.Writer) error {
+			// The strips are written one at a time, so that a tracked
+			// encoder sees each of them.
+			for y := 0; y < px.dy; y += rowsPerStrip {
+				var pix []uint8
+				if px.pix != nil {
+					pix = px.pix[y*px.stride:]
+				}
+				if err := px.enc(w, pix, px.dx, minInt(rowsPerStrip, px.dy-y), px.stride, predictor); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	default:
+		data = func(w io.Writer) error {
+			_, err := buf.WriteTo(w)
+			return err
+		}
+	}
+	return e.placePage(opt != nil && opt.ForceBigTIFF, int64(imageLen), func(dataOffset int64) []ifdEntry {
+		return entries(dataOffset, lens)
+	}, extra, subs, data)
+}
+
+// trackProgress returns px with an encoder that checks e.ctx before each
+// strip or tile and reports each one to onProgress, if not nil. Sparse
+// tiles, which are not encoded, are reported too.
+func (e *Encoder) trackProgress(px pixels, opt *Options, tiled bool, onProgress func(done, total int64)) pixels {
+	total := int64(1)
+	switch {
+	case tiled:
+		total = int64((px.dx+opt.TileWidth-1)/opt.TileWidth) * int64((px.dy+opt.TileLength-1)/opt.TileLength)
+	case opt != nil && opt.RowsPerStrip > 0 && opt.RowsPerStrip < px.dy:
+		total = int64((px.dy + opt.RowsPerStrip - 1) / opt.RowsPerStrip)
+	}
+	var (
+		mu   sync.Mutex
+		done int64
+	)
+	step := func() {
+		if onProgress != nil {
+			mu.Lock()
+			done++
+			onProgress(done, total)
+			mu.Unlock()
+		}
+	}
+	ctx, enc := e.ctx, px.enc
+	px.enc = func(w io.Writer, pix []uint8, dx, dy, stride int, predictor bool) error {
+		if ctx != nil {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+		if err := enc(w, pix, dx, dy, stride, predictor); err != nil {
+			return err
+		}
+		step()
+		return nil
+	}
+	if sparse := px.sparse; sparse != nil {
+		px.sparse = func(x, y, w, h int, tile []uint8) (bool, error) {
+			empty, err := sparse(x, y, w, h, tile)
+			if empty {
+				step()
+			}
+			return empty, err
+		}
+	}
+	return px
+}
+
+// placePage writes a page whose pixel data, of imageLen bytes, is written
+// by data. entries returns the IFD of the page, without the pointers to the
+// sub-IFDs subs, for pixel data starting at dataOffset; extra holds its
+// entries from Options.ExtraTags. forceBig is whether BigTIFF is requested
+// for the page. The IFD of the page is written by the next call of
+// placePage or by Close.
+func (e *Encoder) placePage(forceBig bool, imageLen int64, entries func(dataOffset int64) []ifdEntry, extra []ifdEntry, subs []subIFD, data func(w io.Writer) error) error {
+	if e.stream {
+		if err := e.startStream(forceBig); err != nil {
+			return err
+		}
+		if err := e.checkExtra(extra); err != nil {
+			return err
+		}
+		cw := &countWriter{w: e.w}
+		if err := data(cw); err != nil {
+			return err
+		}
+		return e.endStreamPage(cw.n, entries, subs)
+	}
+
+	// The format of the file is chosen with its first page: BigTIFF is
+	// used if requested or if the page does not fit into a classic TIFF
+	// file.
+	if e.ifd == nil && e.appendPtr == 0 {
+		e.big = forceBig
+	}
+	var dataOffset, ifdOffset int64
+	var d []ifdEntry
+	var pad int
+	for {
+		dataOffset = 8
+		if e.big {
+			dataOffset = 16
+		}
+		switch {
+		case e.ifd != nil:
+			dataOffset = e.offset + ifdSize(e.ifd, e.big)
+		case e.appendPtr != 0:
+			dataOffset = e.appendEnd
+		}
+		// The IFD must begin on a word boundary (8 bytes in BigTIFF
+		// files), so the pixel data is padded with zero bytes.
+		pad = padLen(int(imageLen%int64(e.align())), e.align())
+		ifdOffset = dataOffset + imageLen + int64(pad)
+		d = entries(dataOffset)
+		for i := range subs {
+			subs[i].offset = ifdOffset
+			d = append(d, e.ifdPointer(subs[i].tag, ifdOffset))
+			ifdOffset += ifdSize(subs[i].entries, e.big)
+		}
+		if e.big || ifdOffset+ifdSize(d, false) <= maxClassicSize {
+			break
+		}
+		if e.appendPtr != 0 {
+			return errors.New("tiff: appended page exceeds the 4 GB of a classic TIFF file")
+		}
+		if e.ifd != nil {
+			return errors.New("tiff: file exceeds 4 GB; set Options.ForceBigTIFF for its first page")
+		}
+		e.big = true
+	}
+	if err := e.checkExtra(extra); err != nil {
+		return err
+	}
+	if err := e.link(ifdOffset); err != nil {
+		return err
+	}
+
+	w := e.w
+	if err := data(w); err != nil {
+		return err
+	}
+	if _, err := w.Write(make([]byte, pad)); err != nil {
+		return err
+	}
+	for _, sub := range subs {
+		if err := writeIFD(w, sub.offset, sub.entries, 0, e.order, e.big); err != nil {
+			return err
+		}
+	}
+
+	e.ifd, e.offset = d, ifdOffset
+	return nil
+}
+
+// encodeStrips encodes the pixels into w as strips of rowsPerStrip rows,
+// the last strip holding the remaining rows, each of them compressed on its
+// own unless compression is cNone. It returns the length of each strip. An
+// empty image has a single empty strip. If workers is greater than one, up
+// to workers strips are compressed concurrently.
+func (px pixels) encodeStrips(w io.Writer, compression uint32, level, workers int, predictor bool, rowsPerStrip int) ([]int, error) {
+	// encode encodes the strip starting at row y into w.
+	encode := func(w io.Writer, y int) error {
+		var pix []uint8
+		if px.pix != nil {
+			pix = px.pix[y*px.stride:]
+		}
+		rows := minInt(rowsPerStrip, px.dy-y)
+		if compression == cNone {
+			return px.enc(w, pix, px.dx, rows, px.stride, predictor)
+		}
+		dst, err := newCompressor(w, compression, level, rows)
+		if err != nil {
+			return err
+		}
+		if err = px.enc(dst, pix, px.dx, rows, px.stride, predictor); err != nil {
+			return err
+		}
+		return dst.Close()
+	}
+
+	n := 1
+	if px.dy > rowsPerStrip {
+		n = (px.dy + rowsPerStrip - 1) / rowsPerStrip
+	}
+	lens := make([]int, 0, n)
+	if workers > 1 && compression != cNone {
+		err := encodeBlocks(n, workers, func(i int) ([]byte, error) {
+			var buf bytes.Buffer
+			err := encode(&buf, i*rowsPerStrip)
+			return buf.Bytes(), err
+		}, func(b []byte) error {
+			lens = append(lens, len(b))
+			_, err := w.Write(b)
+			return err
+		})
+		return lens, err
+	}
+	cw := &countWriter{w: w}
+	for i := 0; i < n; i++ {
+		start := cw.n
+		if err := encode(cw, i*rowsPerStrip); err != nil {
+			return nil, err
+		}
+		lens = append(lens, int(cw.n-start))
+	}
+	return lens, nil
+}
+
+// pageEntries adds the entries for the metadata in opt to ifd, which holds
+// the entries describing the format of the samples of a page. It returns
+// them together with the entries of opt.ExtraTags and the sub-IFDs of the
+// page, which are written between its pixel data and its IFD.
+func pageEntries(opt *Options, ifd []ifdEntry) (_, extra []ifdEntry, subs []subIFD, err error) {
+	if opt != nil {
+		for _, a := range []struct {
+			tag int
+			s   string
+		}{
+			{tImageDescription, opt.ImageDescription},
+			{tSoftware, opt.Software},
+			{tArtist, opt.Artist},
+			{tHostComputer, opt.HostComputer},
+			{tCopyright, opt.Copyright},
+		} {
+			if s := strings.TrimRight(a.s, "\x00"); s != "" {
+				ifd = append(ifd, asciiEntry(a.tag, s))
+			}
+		}
+		if !opt.DateTime.IsZero() {
+			if y := opt.DateTime.Year(); y < 0 || y > 9999 {
+				return nil, nil, nil, errors.New("tiff: DateTime year out of range")
+			}
+			ifd = append(ifd, asciiEntry(tDateTime, opt.DateTime.Format("2006:01:02 15:04:05")))
+		}
+		for _, b := range []struct {
+			tag      int
+			datatype int
+			data     []byte
+		}{
+			{tICCProfile, dtUndefined, opt.ICCProfile},
+			{tXMP, dtByte, opt.XMP},
+			{tIPTC, dtUndefined, opt.IPTC},
+		} {
+			if len(b.data) > 0 {
+				data := make([]uint32, len(b.data))
+				for i, v := range b.data {
+					data[i] = uint32(v)
+				}
+				ifd = append(ifd, ifdEntry{b.tag, b.datatype, data})
+			}
+		}
+		if opt.Geo != nil {
+			tags, err := opt.Geo.tags()
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			for _, t := range tags {
+				e, err := t.entry()
+				if err != nil {
+					return nil, nil, nil, err
+				}
+				ifd = append(ifd, e)
+			}
+		}
+		if n := opt.PageNumber; n != nil {
+			if n.Page < 0 || n.Pages < 0 || n.Page > math.MaxUint16 || n.Pages > math.MaxUint16 {
+				return nil, nil, nil, errors.New("tiff: invalid page number")
+			}
+			ifd = append(ifd,
+				ifdEntry{tNewSubfileType, dtLong, []uint32{2}},
+				ifdEntry{tPageNumber, dtShort, []uint32{uint32(n.Page), uint32(n.Pages)}},
+			)
+		}
+	}
+	extra, err = extraEntries(opt, ifd)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if opt != nil && !opt.BaselineOnly {
+		if opt.Exif != nil {
+			d, err := opt.Exif.entries()
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			subs = append(subs, subIFD{tag: tExifIFD, entries: d})
+		}
+		if opt.GPS != nil {
+			d, err := opt.GPS.entries()
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			subs = append(subs, subIFD{tag: tGPSIFD, entries: d})
+		}
+	}
+	return ifd, extra, subs, nil
+}
+
+// maxClassicSize is the maximum size of a classic TIFF file, whose offsets
+// are 32 bits long. It is a variable so that tests can lower it.
+var maxClassicSize int64 = math.MaxUint32
+
+// align returns the alignment of IFDs and their values in the file.
+func (e *Encoder) align() int {
+	if e.big {
+		return bigAlign
+	}
+	return wordAlign
+}
+
+// offsetEntry returns an IFD entry holding file offsets or byte counts,
+// which are of type Long8 in BigTIFF files and of type Long otherwise.
+func (e *Encoder) offsetEntry(tag int, v []int64) ifdEntry {
+	if !e.big {
+		data := make([]uint32, len(v))
+		for i, x := range v {
+			data[i] = uint32(x)
+		}
+		return ifdEntry{tag, dtLong, data}
+	}
+	data := make([]uint32, 0, 2*len(v))
+	for _, x := range v {
+		data = append(data, uint32(x), uint32(x>>32))
+	}
+	return ifdEntry{tag, dtLong8, data}
+}
+
+// A subIFD is an IFD referenced by an entry of the IFD of a page, such as
+// the Exif IFD.
+type subIFD struct {
+	tag     int
+	entries []ifdEntry
+	offset  int64
+}
+
+// imageEntries returns the IFD entries for the size, compression and
+// resolution of an image of dx by dy pixels.
+func imageEntries(dx, dy int, compression uint32, opt *Options) []ifdEntry {
+	x, y, unit := 72.0, 72.0, resPerInch
+	if opt != nil && (opt.XResolution > 0 || opt.YResolution > 0) {
+		x, y = opt.XResolution, opt.YResolution
+		if x == 0 {
+			x = y
+		} else if y == 0 {
+			y = x
+		}
+		if opt.ResolutionUnit != 0 {
+			unit = opt.ResolutionUnit
+		}
+	}
+	rationalData := func(v float64) []uint32 {
+		r := rational(v)
+		return r[:]
+	}
+	return []ifdEntry{
+		shortOrLong(tImageWidth, dx),
+		shortOrLong(tImageLength, dy),
+		{tCompression, dtShort, []uint32{compression}},
+		{tXResolution, dtRational, rationalData(x)},
+		{tYResolution, dtRational, rationalData(y)},
+		{tResolutionUnit, dtShort, []uint32{uint32(unit)}},
+	}
+}
+
+// shortOrLong returns an IFD entry holding v, of type Short if v fits into
+// 16 bits and of type Long otherwise.
+func shortOrLong(tag, v int) ifdEntry {
+	if v > math.MaxUint16 {
+		return ifdEntry{tag, dtLong, []uint32{uint32(v)}}
+	}
+	return ifdEntry{tag, dtShort, []uint32{uint32(v)}}
+}
+
+// checkExtra returns an error if the entries of Options.ExtraTags in extra
+// cannot be written to the file.
+func (e *Encoder) checkExtra(extra []ifdEntry) error {
+	if e.big {
+		return nil
+	}
+	for _, x := range extra {
+		switch x.datatype {
+		case dtLong8, dtSLong8, dtIFD8:
+			return fmt.Errorf("tiff: extra tag %d of type %d needs a BigTIFF file; set Options.ForceBigTIFF", x.tag, x.datatype)
+		}
+	}
+	return nil
+}
+
+// ifdPointer returns an IFD entry holding the offset of a sub-IFD.
+func (e *Encoder) ifdPointer(tag int, offset int64) ifdEntry {
+	if e.big {
+		return ifdEntry{tag, dtIFD8, []uint32{uint32(offset), uint32(offset >> 32)}}
+	}
+	return ifdEntry{tag, dtLong, []uint32{uint32(offset)}}
+}
+
+// PageNumber identifies a page of a multi-page document.
+type PageNumber struct {
+	// Page is the zero-based number of the page.
+	Page int
+	// Pages is the total number of pages in the document, or 0 if it is
+	// not known.
+	Pages int
+}
+
+// asciiEntry returns an IFD entry of type ASCII holding s, terminated by a
+// NUL byte.
+func asciiEntry(tag int, s string) ifdEntry {
+	data := make([]uint32, len(s)+1)
+	for i := 0; i < len(s); i++ {
+		data[i] = uint32(s[i])
+	}
+	return ifdEntry{tag, dtASCII, data}
+}
+
+// layoutTags holds the tags that writeImage adds to the IFD itself.
+var layoutTags = []int{
+	tImageWidth, tImageLength, tCompression, tXResolution, tYResolution, tResolutionUnit,
+	tStripOffsets, tRowsPerStrip, tStripByteCounts,
+	tTileWidth, tTileLength, tTileOffsets, tTileByteCounts, tPredictor,
+}
+
+// extraEntries converts the ExtraTags of opt into IFD entries. They must not
+// duplicate each other, the entries in ifd or the layout tags.
+func extraEntries(opt *Options, ifd []ifdEntry) ([]ifdEntry, error) {
+	if opt == nil || len(opt.ExtraTags) == 0 {
+		return nil, nil
+	}
+	written := make(map[int]bool)
+	for _, e := range ifd {
+		written[e.tag] = true
+	}
+	for _, tag := range layoutTags {
+		written[tag] = true
+	}
+	if opt.Exif != nil {
+		written[tExifIFD] = true
+	}
+	if opt.GPS != nil {
+		written[tGPSIFD] = true
+	}
+	var extra []ifdEntry
+	for _, t := range opt.ExtraTags {
+		if written[int(t.ID)] {
+			return nil, fmt.Errorf("tiff: extra tag %d is already written by the encoder", t.ID)
+		}
+		written[int(t.ID)] = true
+		e, err := t.entry()
+		if err != nil {
+			return nil, err
+		}
+		extra = append(extra, e)
+	}
+	return extra, nil
+}
+
+// sampleEncoder returns a pixelEncoder for pixels of samples values of bps
+// bytes each, stored in pix as they are written to the file.
+func sampleEncoder(samples, bps int) pixelEncoder {
+	return func(w io.Writer, pix []uint8, dx, dy, stride int, predictor bool) error {
+		if !predictor {
+			return writePix(w, pix, dy, dx*samples*bps, stride)
+		}
+		n := samples * bps // bytes per pixel
+		buf := make([]byte, dx*n)
+		for y := 0; y < dy; y++ {
+			row := pix[y*stride : y*stride+len(buf)]
+			copy(buf, row[:minInt(n, len(row))])
+			for i := n; i < len(row); i += bps {
+				switch bps {
+				case 1:
+					buf[i] = row[i] - row[i-n]
+				case 2:
+					enc.PutUint16(buf[i:], enc.Uint16(row[i:])-enc.Uint16(row[i-n:]))
+				case 4:
+					enc.PutUint32(buf[i:], enc.Uint32(row[i:])-enc.Uint32(row[i-n:]))
+				}
+			}
+			if _, err := w.Write(buf); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// EncodeArray writes the samples in data to w as a TIFF image. data is a
+// []uint8, []uint16, []uint32, []int16, []int32 or []float32 holding the
+// samples in row-major order with shape [height, width, samplesPerPixel],
+// as returned by DecodeArray. Arrays with one or two samples per pixel are
+// written as gray images, those with three or more as RGB images; samples
+// beyond the first one or three are written as extra samples of unspecified
+// meaning. opt is interpreted as by Encode; BaselineOnly requires 8-bit
+// data with one or three samples.
+func EncodeArray(w io.Writer, data interface{}, shape [3]int, opt *Options) error {
+	height, width, samples := shape[0], shape[1], shape[2]
+	if height < 0 || width < 0 || samples <= 0 {
+		return errors.New("tiff: invalid array shape")
+	}
+
+	var pix []uint8
+	var bps int
+	sampleFormat := uint32(sfUint)
+	switch data := data.(type) {
+	case []uint8:
+		pix, bps = data, 1
+	case []uint16:
+		pix, bps = make([]uint8, 2*len(data)), 2
+		for i, v := range data {
+			enc.PutUint16(pix[2*i:], v)
+		}
+	case []uint32:
+		pix, bps = make([]uint8, 4*len(data)), 4
+		for i, v := range data {
+			enc.PutUint32(pix[4*i:], v)
+		}
+	case []int16:
+		pix, bps = make([]uint8, 2*len(data)), 2
+		for i, v := range data {
+			enc.PutUint16(pix[2*i:], uint16(v))
+		}
+		sampleFormat = sfInt
+	case []int32:
+		pix, bps = make([]uint8, 4*len(data)), 4
+		for i, v := range data {
+			enc.PutUint32(pix[4*i:], uint32(v))
+		}
+		sampleFormat = sfInt
+	case []float32:
+		pix, bps = make([]uint8, 4*len(data)), 4
+		for i, v := range data {
+			enc.PutUint32(pix[4*i:], math.Float32bits(v))
+		}
+		sampleFormat = sfFloat
+	default:
+		return UnsupportedError(fmt.Sprintf("array type %T", data))
+	}
+	if len(pix) != height*width*samples*bps {
+		return errors.New("tiff: array length does not match its shape")
+	}
+
+	compression, predictor, tiled, err := encodingParams(opt)
+	if err != nil {
+		return err
+	}
+	if compression == cG4 {
+		return errors.New("tiff: EncodeArray does not support CCITTGroup4 compression")
+	}
+	if opt != nil && opt.BaselineOnly && (bps != 1 || samples != 1 && samples != 3) {
+		return errors.New("tiff: BaselineOnly requires 8-bit arrays with one or three samples")
+	}
+
+	photometricInterpretation, colorSamples := uint32(pBlackIsZero), 1
+	if samples >= 3 {
+		photometricInterpretation, colorSamples = pRGB, 3
+	}
+	bitsPerSample := make([]uint32, samples)
+	sampleFormats := make([]uint32, samples)
+	for i := range bitsPerSample {
+		bitsPerSample[i] = uint32(8 * bps)
+		sampleFormats[i] = sampleFormat
+	}
+	ifd := []ifdEntry{
+		{tBitsPerSample, dtShort, bitsPerSample},
+		{tPhotometricInterpretation, dtShort, []uint32{photometricInterpretation}},
+		{tSamplesPerPixel, dtShort, []uint32{uint32(samples)}},
+	}
+	if samples > colorSamples {
+		// The meaning of the extra samples is unspecified (0).
+		ifd = append(ifd, ifdEntry{tExtraSamples, dtShort, make([]uint32, samples-colorSamples)})
+	}
+	if sampleFormat != sfUint {
+		ifd = append(ifd, ifdEntry{tSampleFormat, dtShort, sampleFormats})
+	}
+
+	px := pixels{pix, width, height, width * samples * bps, samples * bps, sampleEncoder(samples, bps), nil}
+	e := NewEncoder(w)
+	if err := e.writePage(px, opt, compression, predictor, tiled, ifd); err != nil {
+		return err
+	}
+	return e.Close()
 }
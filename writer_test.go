@@ -6,10 +6,22 @@ package tiff
 
 import (
 	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
 	"image"
+	"image/color"
+	"image/draw"
+	"io"
 	"io/ioutil"
+	"math"
 	"os"
+	"reflect"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 var roundtripTests = []struct {
@@ -32,12 +44,25 @@ var roundtripTests = []struct {
 	{"video-001-16bit.tiff", &Options{Predictor: true, Compression: LZW}},
 	{"video-001-gray.tiff", &Options{Predictor: true, Compression: LZW}},
 	{"video-001-gray-16bit.tiff", &Options{Predictor: true, Compression: LZW}},
+	{"video-001.tiff", &Options{TileWidth: 64, TileLength: 32}},
+	{"video-001.tiff", &Options{Compression: Deflate, TileWidth: 32, TileLength: 32}},
+	{"video-001.tiff", &Options{Predictor: true, Compression: LZW, TileWidth: 48, TileLength: 64}},
+	{"video-001-16bit.tiff", &Options{TileWidth: 32, TileLength: 32}},
+	{"video-001-gray.tiff", &Options{Compression: Deflate, TileWidth: 64, TileLength: 16}},
+	{"video-001-gray-16bit.tiff", &Options{Predictor: true, Compression: LZW, TileWidth: 32, TileLength: 48}},
+	{"video-001-paletted.tiff", &Options{Compression: LZW, TileWidth: 16, TileLength: 16}},
+	{"zookeeper-cmyk.tiff", &Options{Compression: Deflate, TileWidth: 64, TileLength: 64}},
 	{"go-aqua-cmyk.tiff", nil},
 	{"go-aqua-cmyk.tiff", &Options{Compression: LZW}},
 	{"go-aqua-cmyk.tiff", &Options{Predictor: true, Compression: LZW}},
 	{"zookeeper-cmyk.tiff", nil},
 	{"zookeeper-cmyk.tiff", &Options{Compression: LZW}},
 	{"zookeeper-cmyk.tiff", &Options{Predictor: true, Compression: LZW}},
+	{"video-001.tiff", &Options{Compression: PackBits}},
+	{"video-001-16bit.tiff", &Options{Compression: PackBits, TileWidth: 32, TileLength: 32}},
+	{"video-001-paletted.tiff", &Options{Compression: PackBits}},
+	{"bw-packbits.tiff", &Options{Compression: PackBits}},
+	{"zookeeper-cmyk.tiff", &Options{Compression: PackBits}},
 }
 
 func openImage(filename string) (image.Image, error) {
@@ -88,16 +113,1106 @@ func TestRoundtrip2(t *testing.T) {
 	compare(t, m0, m1)
 }
 
+// TestEncodeCompressionLevel tests that Options.CompressionLevel controls
+// the Deflate compression of strips and tiles.
+func TestEncodeCompressionLevel(t *testing.T) {
+	// Rows of pseudo-random samples that repeat every 7 rows compress
+	// much better with matches than with Huffman coding only.
+	img := image.NewGray(image.Rect(0, 0, 200, 150))
+	for y := 0; y < 150; y++ {
+		for x := 0; x < 200; x++ {
+			img.Pix[y*img.Stride+x] = uint8(uint32(x*x+y%7*31) * 2654435761 >> 13)
+		}
+	}
+	for _, tiled := range []bool{false, true} {
+		sizes := make(map[int]int)
+		for _, level := range []int{zlib.HuffmanOnly, zlib.BestSpeed, 0, zlib.BestCompression} {
+			opt := &Options{Compression: Deflate, CompressionLevel: level}
+			if tiled {
+				opt.TileWidth, opt.TileLength = 64, 64
+			}
+			var buf bytes.Buffer
+			if err := Encode(&buf, img, opt); err != nil {
+				t.Fatal(err)
+			}
+			m, err := Decode(bytes.NewReader(buf.Bytes()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			compare(t, img, m)
+			sizes[level] = buf.Len()
+		}
+		if sizes[zlib.BestCompression] >= sizes[zlib.HuffmanOnly]/2 {
+			t.Errorf("tiled %t: got %d bytes at BestCompression, %d bytes with HuffmanOnly", tiled, sizes[zlib.BestCompression], sizes[zlib.HuffmanOnly])
+		}
+	}
+
+	for _, level := range []int{-3, 10} {
+		if err := Encode(ioutil.Discard, img, &Options{Compression: Deflate, CompressionLevel: level}); err == nil {
+			t.Errorf("level %d: got nil error", level)
+		}
+	}
+}
+
+// TestEncodePredictor tests that the horizontal predictor is recorded in the
+// Predictor tag and makes LZW-compressed photos of 8 and 16 bit samples
+// smaller.
+func TestEncodePredictor(t *testing.T) {
+	for _, filename := range []string{"video-001.tiff", "video-001-16bit.tiff", "video-001-gray.tiff", "video-001-gray-16bit.tiff"} {
+		img, err := openImage(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var sizes [2]int
+		for i, predictor := range []bool{false, true} {
+			var buf bytes.Buffer
+			if err := Encode(&buf, img, &Options{Compression: LZW, Predictor: predictor}); err != nil {
+				t.Fatal(err)
+			}
+			d, err := newDecoder(bytes.NewReader(buf.Bytes()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := uint(prNone)
+			if predictor {
+				want = prHorizontal
+			}
+			if got := d.firstVal(tPredictor); got != want && !(got == 0 && want == prNone) {
+				t.Errorf("%s, predictor %t: got Predictor %d, want %d", filename, predictor, got, want)
+			}
+			sizes[i] = buf.Len()
+		}
+		if sizes[1] >= sizes[0] {
+			t.Errorf("%s: got %d bytes with predictor, %d bytes without", filename, sizes[1], sizes[0])
+		}
+	}
+}
+
+// TestEncodeSparseTiles tests that tiles consisting of the SparseFill color
+// only are not written to the file.
+func TestEncodeSparseTiles(t *testing.T) {
+	m := image.NewGray(image.Rect(0, 0, 256, 256))
+	for y := 100; y < 120; y++ {
+		for x := 10; x < 20; x++ {
+			m.SetGray(x, y, color.Gray{0xff})
+		}
+	}
+	var fill color.Color = color.Black
+	dense := new(bytes.Buffer)
+	if err := Encode(dense, m, &Options{TileWidth: 64, TileLength: 64}); err != nil {
+		t.Fatal(err)
+	}
+	sparse := new(bytes.Buffer)
+	if err := Encode(sparse, m, &Options{TileWidth: 64, TileLength: 64, SparseFill: &fill}); err != nil {
+		t.Fatal(err)
+	}
+	if sparse.Len() > dense.Len()/8 {
+		t.Errorf("sparse file is %d bytes, dense file is %d bytes", sparse.Len(), dense.Len())
+	}
+
+	d, err := newDecoder(bytes.NewReader(sparse.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	offsets, counts := d.features[tTileOffsets], d.features[tTileByteCounts]
+	if len(offsets) != 16 || len(counts) != 16 {
+		t.Fatalf("got %d tile offsets and %d tile byte counts, want 16", len(offsets), len(counts))
+	}
+	for i := range offsets {
+		// Only the tile at column 0, row 1 contains non-black pixels.
+		if empty := offsets[i] == 0 && counts[i] == 0; empty != (i != 4) {
+			t.Errorf("tile %d: offset %d, byte count %d", i, offsets[i], counts[i])
+		}
+	}
+
+	m1, err := Decode(bytes.NewReader(sparse.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	compare(t, m, m1)
+}
+
+// TestEncodeSparseFillNonZero tests that Encode rejects a SparseFill color
+// that is not encoded as zero samples instead of leaving its tiles empty.
+func TestEncodeSparseFillNonZero(t *testing.T) {
+	m := image.NewGray(image.Rect(0, 0, 64, 64))
+	for i := range m.Pix {
+		m.Pix[i] = 0xff
+	}
+	var fill color.Color = color.White
+	err := Encode(ioutil.Discard, m, &Options{TileWidth: 32, TileLength: 32, SparseFill: &fill})
+	if err == nil {
+		t.Fatal("Encode succeeded, want an error")
+	}
+
+	// A fill that no tile consists of is harmless.
+	for y := 0; y < 64; y += 32 {
+		for x := 0; x < 64; x += 32 {
+			m.SetGray(x, y, color.Gray{0x80})
+		}
+	}
+	var buf bytes.Buffer
+	if err := Encode(&buf, m, &Options{TileWidth: 32, TileLength: 32, SparseFill: &fill}); err != nil {
+		t.Fatal(err)
+	}
+	m1, err := Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compare(t, m, m1)
+}
+
+// ifdTags returns the tags of the first IFD of the TIFF file in b.
+func ifdTags(t *testing.T, b []byte) []int {
+	off := binary.LittleEndian.Uint32(b[4:8])
+	n := int(binary.LittleEndian.Uint16(b[off:]))
+	tags := make([]int, n)
+	for i := range tags {
+		tags[i] = int(binary.LittleEndian.Uint16(b[int(off)+2+ifdLen*i:]))
+	}
+	return tags
+}
+
+// checkAlignment reports an error if the first IFD of the TIFF file in b or
+// any of the values it points to begins at an odd offset.
+func checkAlignment(t *testing.T, name string, b []byte) {
+	off := binary.LittleEndian.Uint32(b[4:8])
+	if off%2 != 0 {
+		t.Errorf("%s: IFD at odd offset %d", name, off)
+	}
+	n := int(binary.LittleEndian.Uint16(b[off:]))
+	for i := 0; i < n; i++ {
+		e := b[int(off)+2+ifdLen*i:]
+		datatype := binary.LittleEndian.Uint16(e[2:4])
+		count := binary.LittleEndian.Uint32(e[4:8])
+		if count*lengths[datatype] <= 4 {
+			continue
+		}
+		if p := binary.LittleEndian.Uint32(e[8:12]); p%2 != 0 {
+			t.Errorf("%s: value of tag %d at odd offset %d", name, binary.LittleEndian.Uint16(e[0:2]), p)
+		}
+	}
+}
+
+// TestEncodeWordAlignment tests that the IFD and the values it points to
+// begin on word boundaries, as required by the spec.
+func TestEncodeWordAlignment(t *testing.T) {
+	// A 3x1 gray image has 3 bytes of pixel data.
+	gray := image.NewGray(image.Rect(0, 0, 3, 1))
+	img, err := openImage("video-001.tiff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		m    image.Image
+		opts *Options
+	}{
+		{gray, nil},
+		{img, &Options{Compression: Deflate}},
+		{img, &Options{Compression: LZW}},
+		{img, &Options{Compression: LZW, TileWidth: 32, TileLength: 16}},
+	} {
+		var buf bytes.Buffer
+		if err := Encode(&buf, tc.m, tc.opts); err != nil {
+			t.Fatal(err)
+		}
+		name := fmt.Sprintf("%T %+v", tc.m, tc.opts)
+		checkAlignment(t, name, buf.Bytes())
+		if _, err := Decode(bytes.NewReader(buf.Bytes())); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+
+	// Odd-sized values are padded so that the next value is aligned.
+	var buf bytes.Buffer
+	buf.WriteString(leHeader)
+	binary.Write(&buf, binary.LittleEndian, uint32(8))
+	err = writeIFD(&buf, 8, []ifdEntry{
+		{tImageDescription, dtASCII, []uint32{'o', 'd', 'd', 0, 0}},
+		{tMake, dtASCII, []uint32{'m', 'a', 'k', 'e', 0}},
+		{tModel, dtASCII, []uint32{'m', 'o', 'd', 'e', 'l', 0}},
+	}, 0, enc, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkAlignment(t, "writeIFD", buf.Bytes())
+}
+
+// TestEncodeBaselineOnly tests that Options.BaselineOnly restricts the
+// encoder to Baseline TIFF tags.
+func TestEncodeBaselineOnly(t *testing.T) {
+	img, err := openImage("video-001-16bit.tiff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, opts := range []*Options{
+		{BaselineOnly: true},
+		{BaselineOnly: true, Compression: LZW},
+		{BaselineOnly: true, Compression: PackBits},
+	} {
+		out := new(bytes.Buffer)
+		if err := Encode(out, img, opts); err != nil {
+			t.Fatal(err)
+		}
+		for _, tag := range ifdTags(t, out.Bytes()) {
+			if !baselineTags[tag] {
+				t.Errorf("%+v: got non-baseline tag %d", opts, tag)
+			}
+		}
+
+		m, err := Decode(bytes.NewReader(out.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		c, err := DecodeConfig(bytes.NewReader(out.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.ColorModel != color.RGBAModel {
+			t.Errorf("%+v: got color model %v, want RGBA", opts, c.ColorModel)
+		}
+		// The 16-bit samples are reduced to 8 bits.
+		b := img.Bounds()
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				r0, g0, b0, _ := img.At(x, y).RGBA()
+				r1, g1, b1, _ := m.At(x, y).RGBA()
+				if r0>>8 != r1>>8 || g0>>8 != g1>>8 || b0>>8 != b1>>8 {
+					t.Fatalf("%+v: pixel at (%d, %d): got %v, want %v", opts, x, y, m.At(x, y), img.At(x, y))
+				}
+			}
+		}
+	}
+
+	for _, opts := range []*Options{
+		{BaselineOnly: true, Compression: Deflate},
+		{BaselineOnly: true, Compression: LZW, Predictor: true},
+		{BaselineOnly: true, TileWidth: 16, TileLength: 16},
+	} {
+		if err := Encode(ioutil.Discard, img, opts); err == nil {
+			t.Errorf("%+v: got nil error, want non-nil", opts)
+		}
+	}
+}
+
+// TestEncodeArray tests that arrays written by EncodeArray are read back
+// unchanged by DecodeArray.
+func TestEncodeByteOrder(t *testing.T) {
+	src, err := openImage("video-001.tiff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := src.Bounds()
+	rgba64 := image.NewRGBA64(b)
+	draw.Draw(rgba64, b, src, b.Min, draw.Src)
+	for _, opt := range []*Options{
+		{ByteOrder: binary.BigEndian},
+		{ByteOrder: binary.BigEndian, Compression: LZW, Predictor: true, Exif: &Exif{ISO: 100}},
+		{ByteOrder: binary.BigEndian, Compression: Deflate, TileWidth: 32, TileLength: 32, ForceBigTIFF: true},
+	} {
+		for _, stream := range []bool{false, true} {
+			var buf bytes.Buffer
+			e := NewEncoder(&buf)
+			if stream {
+				e = NewStreamEncoder(&buf)
+			}
+			if err := e.WriteImage(rgba64, opt); err != nil {
+				t.Fatal(err)
+			}
+			// The byte order of the second page is that of the file.
+			if err := e.WriteImage(src, &Options{Compression: opt.Compression, Predictor: opt.Predictor}); err != nil {
+				t.Fatal(err)
+			}
+			if err := e.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if stream {
+				copy(buf.Bytes(), e.Header())
+			}
+			if h, _, err := readHeader(bytes.NewReader(buf.Bytes())); err != nil || h.byteOrder != binary.BigEndian {
+				t.Fatalf("%+v: got header %q, %v", opt, buf.Bytes()[:4], err)
+			}
+			got, err := DecodeAll(bytes.NewReader(buf.Bytes()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != 2 {
+				t.Fatalf("got %d pages, want 2", len(got))
+			}
+			compare(t, rgba64, got[0])
+			compare(t, src, got[1])
+		}
+	}
+
+	i32 := make([]int32, 5*37)
+	for i := range i32 {
+		i32[i] = int32(i*123457 - 9000000)
+	}
+	var buf bytes.Buffer
+	if err := EncodeArray(&buf, i32, [3]int{5, 37, 1}, &Options{ByteOrder: binary.BigEndian, Compression: LZW, Predictor: true}); err != nil {
+		t.Fatal(err)
+	}
+	if data, _, _, err := DecodeArray(bytes.NewReader(buf.Bytes())); err != nil || !reflect.DeepEqual(data, i32) {
+		t.Errorf("EncodeArray: decoded data differs, %v", err)
+	}
+}
+
+func TestEncodeArray(t *testing.T) {
+	const h, w = 5, 37
+	f32 := make([]float32, h*w)
+	for i := range f32 {
+		f32[i] = float32(i)*0.25 - 7
+	}
+	f32[3] = float32(math.Inf(-1))
+	u16 := make([]uint16, h*w*4)
+	for i := range u16 {
+		u16[i] = uint16(i * 977)
+	}
+	for _, tc := range []struct {
+		data  interface{}
+		shape [3]int
+		dtype string
+		opts  *Options
+	}{
+		{f32, [3]int{h, w, 1}, "float32", nil},
+		{f32, [3]int{h, w, 1}, "float32", &Options{Compression: Deflate}},
+		{f32, [3]int{h, w, 1}, "float32", &Options{Compression: LZW, Predictor: true, TileWidth: 16, TileLength: 16}},
+		{u16, [3]int{h, w, 4}, "uint16", &Options{Compression: Deflate}},
+		{u16, [3]int{h, 2 * w, 2}, "uint16", &Options{TileWidth: 32, TileLength: 16}},
+	} {
+		out := new(bytes.Buffer)
+		if err := EncodeArray(out, tc.data, tc.shape, tc.opts); err != nil {
+			t.Fatalf("%v %+v: %v", tc.shape, tc.opts, err)
+		}
+		data, shape, dtype, err := DecodeArray(bytes.NewReader(out.Bytes()))
+		if err != nil {
+			t.Fatalf("%v %+v: %v", tc.shape, tc.opts, err)
+		}
+		if shape != tc.shape || dtype != tc.dtype {
+			t.Fatalf("%v %+v: got shape %v and dtype %q", tc.shape, tc.opts, shape, dtype)
+		}
+		if !reflect.DeepEqual(data, tc.data) {
+			t.Errorf("%v %+v: decoded data differs", tc.shape, tc.opts)
+		}
+	}
+
+	// Decode returns single floating point samples as a Float32Img, but
+	// cannot decode several of them per pixel.
+	out := new(bytes.Buffer)
+	if err := EncodeArray(out, f32, [3]int{h, w, 1}, nil); err != nil {
+		t.Fatal(err)
+	}
+	img, err := Decode(bytes.NewReader(out.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m, ok := img.(*Float32Img); !ok || !reflect.DeepEqual(m.Pix, f32) {
+		t.Errorf("Decode: got %T, want a Float32Img with the encoded samples", img)
+	}
+	out.Reset()
+	if err := EncodeArray(out, f32[:2*w*2], [3]int{2, w, 2}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Decode(bytes.NewReader(out.Bytes())); err == nil {
+		t.Error("Decode: got nil error for two floating point samples per pixel")
+	}
+	if err := EncodeArray(ioutil.Discard, f32, [3]int{h, w, 2}, nil); err == nil {
+		t.Error("got nil error for mismatched shape")
+	}
+}
+
+// TestEncodeWhiteIsZero tests that gray images written with WhiteIsZero
+// have inverted samples and decode to the original image.
+func TestEncodeWhiteIsZero(t *testing.T) {
+	gray := image.NewGray(image.Rect(0, 0, 3, 1))
+	gray.Pix = []uint8{0x00, 0x80, 0xff} // Black, gray and white.
+	gray16 := image.NewGray16(image.Rect(0, 0, 2, 1))
+	gray16.Pix = []uint8{0x00, 0x00, 0x12, 0x34}
+	for _, tc := range []struct {
+		m    image.Image
+		want []uint8 // The samples stored in the file.
+		opts *Options
+	}{
+		{gray, []uint8{0xff, 0x7f, 0x00}, &Options{WhiteIsZero: true}},
+		{gray16, []uint8{0xff, 0xff, 0xcb, 0xed}, &Options{WhiteIsZero: true}},
+		{gray, nil, &Options{WhiteIsZero: true, Compression: LZW, Predictor: true}},
+	} {
+		var buf bytes.Buffer
+		if err := Encode(&buf, tc.m, tc.opts); err != nil {
+			t.Fatal(err)
+		}
+		b := buf.Bytes()
+		if tc.want != nil && !bytes.Equal(b[8:8+len(tc.want)], tc.want) {
+			t.Errorf("%T: got samples %x, want %x", tc.m, b[8:8+len(tc.want)], tc.want)
+		}
+		d, err := newDecoder(bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p := d.firstVal(tPhotometricInterpretation); p != pWhiteIsZero {
+			t.Errorf("%T: got PhotometricInterpretation %d, want %d", tc.m, p, pWhiteIsZero)
+		}
+		m, err := Decode(bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		compare(t, tc.m, m)
+	}
+}
+
+// TestEncodeRowsPerStrip tests that images are divided into strips of the
+// requested number of rows, each of which decodes on its own.
+func TestEncodeRowsPerStrip(t *testing.T) {
+	gray := image.NewGray(image.Rect(0, 0, 30, 25))
+	for i := range gray.Pix {
+		gray.Pix[i] = uint8(i * 7)
+	}
+	rgba := image.NewRGBA(gray.Rect)
+	draw.Draw(rgba, rgba.Rect, gray, image.Point{}, draw.Src)
+	paletted := image.NewPaletted(gray.Rect, color.Palette{color.Black, color.White})
+	for i := range paletted.Pix {
+		paletted.Pix[i] = uint8(i % 3 % 2)
+	}
+	for _, m := range []image.Image{gray, imageView{rgba}, paletted} {
+		for _, opts := range []*Options{
+			{RowsPerStrip: 8},
+			{RowsPerStrip: 8, Compression: LZW, Predictor: true},
+			{RowsPerStrip: 1, Compression: Deflate},
+			{RowsPerStrip: 8, Bilevel: true},
+			{RowsPerStrip: 100, Compression: LZW},
+		} {
+			var buf bytes.Buffer
+			if err := Encode(&buf, m, opts); err != nil {
+				t.Fatalf("%T, %+v: %v", m, opts, err)
+			}
+			rows := minInt(opts.RowsPerStrip, 25)
+			err := WalkIFDs(bytes.NewReader(buf.Bytes()), func(_ int64, tags map[uint16]Tag) error {
+				if got := tags[tRowsPerStrip].Value; !reflect.DeepEqual(got, []uint64{uint64(rows)}) {
+					t.Errorf("%T, %+v: got RowsPerStrip %v, want %d", m, opts, got, rows)
+				}
+				offsets := tags[tStripOffsets].Value.([]uint64)
+				counts := tags[tStripByteCounts].Value.([]uint64)
+				if want := (25 + rows - 1) / rows; len(offsets) != want || len(counts) != want {
+					t.Errorf("%T, %+v: got %d offsets and %d counts, want %d", m, opts, len(offsets), len(counts), want)
+				}
+				for i := 1; i < len(offsets) && i < len(counts); i++ {
+					if offsets[i] != offsets[i-1]+counts[i-1] {
+						t.Errorf("%T, %+v: strip %d at %d does not follow strip %d", m, opts, i, offsets[i], i-1)
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := Decode(bytes.NewReader(buf.Bytes()))
+			if err != nil {
+				t.Fatalf("%T, %+v: %v", m, opts, err)
+			}
+			if !opts.Bilevel {
+				compare(t, m, got)
+			}
+			// The strips are kept when the image is encoded again.
+			if got := sourceInfoOf(t, buf.Bytes()).Options().RowsPerStrip; got != rows {
+				t.Errorf("%T, %+v: SourceInfo.Options has RowsPerStrip %d, want %d", m, opts, got, rows)
+			}
+		}
+	}
+	for _, opts := range []*Options{{RowsPerStrip: -1}, {RowsPerStrip: 8, TileWidth: 16, TileLength: 16}} {
+		if err := Encode(ioutil.Discard, gray, opts); err == nil {
+			t.Errorf("%+v: got no error", opts)
+		}
+	}
+}
+
+// TestEncodeBilevel tests that images are written as bilevel images with
+// the requested PhotometricInterpretation and decode to black and white.
+func TestEncodeBilevel(t *testing.T) {
+	src := image.NewGray(image.Rect(0, 0, 37, 21))
+	for i := range src.Pix {
+		src.Pix[i] = uint8(i * 13)
+	}
+	want := image.NewGray(src.Rect)
+	for i, v := range src.Pix {
+		if v >= 0x80 {
+			want.Pix[i] = 0xff
+		}
+	}
+	for _, tc := range []struct {
+		opts        *Options
+		photometric uint64
+	}{
+		{&Options{Bilevel: true}, pBlackIsZero},
+		{&Options{Bilevel: true, WhiteIsZero: true}, pWhiteIsZero},
+		{&Options{Bilevel: true, WhiteIsZero: true, Compression: LZW, Predictor: true}, pWhiteIsZero},
+		{&Options{Bilevel: true, Compression: Deflate, TileWidth: 16, TileLength: 16}, pBlackIsZero},
+		{&Options{Compression: CCITTGroup4}, pWhiteIsZero},
+	} {
+		var buf bytes.Buffer
+		if err := Encode(&buf, src, tc.opts); err != nil {
+			t.Fatalf("%+v: %v", tc.opts, err)
+		}
+		err := WalkIFDs(bytes.NewReader(buf.Bytes()), func(_ int64, tags map[uint16]Tag) error {
+			if got := tags[tBitsPerSample].Value; !reflect.DeepEqual(got, []uint64{1}) {
+				t.Errorf("%+v: got BitsPerSample %v", tc.opts, got)
+			}
+			if got := tags[tPhotometricInterpretation].Value; !reflect.DeepEqual(got, []uint64{tc.photometric}) {
+				t.Errorf("%+v: got PhotometricInterpretation %v, want %d", tc.opts, got, tc.photometric)
+			}
+			if _, ok := tags[tPredictor]; ok {
+				t.Errorf("%+v: got a Predictor tag", tc.opts)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		m, err := Decode(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("%+v: %v", tc.opts, err)
+		}
+		compare(t, want, m)
+	}
+}
+
+// imageView hides the type of an image from the encoder.
+type imageView struct{ image.Image }
+
+// TestEncode16Bit tests that images of other types with 16-bit color models
+// are written with 16 bits per sample and decode without loss.
+func TestEncode16Bit(t *testing.T) {
+	gray16 := image.NewGray16(image.Rect(0, 0, 3, 2))
+	cmyka64 := NewCMYKA64(image.Rect(0, 0, 3, 2))
+	for i := range gray16.Pix {
+		gray16.Pix[i] = uint8(i*37 + 1)
+	}
+	for i := range cmyka64.Pix {
+		cmyka64.Pix[i] = uint8(i * 53)
+	}
+	for _, tc := range []struct {
+		m    image.Image
+		want image.Image // The type of the decoded image.
+	}{
+		{imageView{gray16}, &image.Gray16{}},
+		{imageView{cmyka64}, &CMYKA64Img{}},
+	} {
+		var buf bytes.Buffer
+		if err := Encode(&buf, tc.m, nil); err != nil {
+			t.Fatal(err)
+		}
+		m, err := Decode(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reflect.TypeOf(m) != reflect.TypeOf(tc.want) {
+			t.Errorf("%T: got decoded image of type %T, want %T", tc.m, m, tc.want)
+		}
+		compare(t, tc.m, m)
+	}
+}
+
+// TestEncodeExtraSamples tests that the ExtraSamples tag declares the alpha
+// of each image type as associated or unassociated, and that the image
+// decodes to a type with the same kind of alpha.
+func TestEncodeExtraSamples(t *testing.T) {
+	r := image.Rect(0, 0, 3, 2)
+	for _, tc := range []struct {
+		m     image.Image
+		extra uint64
+	}{
+		{image.NewRGBA(r), 1},
+		{image.NewRGBA64(r), 1},
+		{image.NewNRGBA(r), 2},
+		{image.NewNRGBA64(r), 2},
+		{NewGrayA(r), 2},
+		{NewGrayA16(r), 2},
+		{NewCMYKA(r), 2},
+		{NewCMYKA64(r), 2},
+		{imageView{image.NewNRGBA(r)}, 1}, // Converted to image.RGBA.
+	} {
+		var buf bytes.Buffer
+		if err := Encode(&buf, tc.m, nil); err != nil {
+			t.Fatal(err)
+		}
+		err := WalkIFDs(bytes.NewReader(buf.Bytes()), func(_ int64, tags map[uint16]Tag) error {
+			if got := tags[tExtraSamples].Value; !reflect.DeepEqual(got, []uint64{tc.extra}) {
+				t.Errorf("%T: got ExtraSamples %v, want %d", tc.m, got, tc.extra)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		m, err := Decode(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := tc.m.(imageView); !ok && m.ColorModel() != tc.m.ColorModel() {
+			t.Errorf("%T: got decoded image of type %T", tc.m, m)
+		}
+	}
+}
+
+// TestEncodePaletted tests that paletted images are written with the
+// fewest bits per sample that hold their palette, and decode to the same
+// indices and palette.
+func TestEncodePaletted(t *testing.T) {
+	for _, tc := range []struct {
+		colors int
+		opts   *Options
+		bits   uint64
+	}{
+		{2, nil, 1},
+		{3, nil, 2},
+		{16, nil, 4},
+		{17, nil, 8},
+		{256, nil, 8},
+		{5, &Options{Compression: LZW}, 4},
+		{9, &Options{TileWidth: 16, TileLength: 16}, 4},
+		{4, &Options{Compression: LZW, Predictor: true}, 8},
+	} {
+		var palette color.Palette
+		for i := 0; i < tc.colors; i++ {
+			palette = append(palette, color.RGBA{uint8(i * 7), uint8(255 - i), uint8(i * 3), 0xff})
+		}
+		m := image.NewPaletted(image.Rect(0, 0, 21, 19), palette)
+		for i := range m.Pix {
+			m.Pix[i] = uint8(i * 5 % tc.colors)
+		}
+		var buf bytes.Buffer
+		if err := Encode(&buf, m, tc.opts); err != nil {
+			t.Fatal(err)
+		}
+		err := WalkIFDs(bytes.NewReader(buf.Bytes()), func(_ int64, tags map[uint16]Tag) error {
+			if got := tags[tBitsPerSample].Value; !reflect.DeepEqual(got, []uint64{tc.bits}) {
+				t.Errorf("%d colors, %+v: got BitsPerSample %v, want %d", tc.colors, tc.opts, got, tc.bits)
+			}
+			if got := tags[tColorMap].Count; got != 3<<tc.bits {
+				t.Errorf("%d colors, %+v: got %d ColorMap values", tc.colors, tc.opts, got)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		img, err := Decode(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("%d colors, %+v: %v", tc.colors, tc.opts, err)
+		}
+		got, ok := img.(*image.Paletted)
+		if !ok {
+			t.Fatalf("%d colors, %+v: got decoded image of type %T", tc.colors, tc.opts, img)
+		}
+		if !bytes.Equal(got.Pix, m.Pix) {
+			t.Errorf("%d colors, %+v: indices differ", tc.colors, tc.opts)
+		}
+		for i, c := range palette {
+			if i >= len(got.Palette) || !reflect.DeepEqual(color.RGBA64Model.Convert(got.Palette[i]), color.RGBA64Model.Convert(c)) {
+				t.Errorf("%d colors, %+v: palette entry %d differs", tc.colors, tc.opts, i)
+				break
+			}
+		}
+	}
+}
+
+// TestEncodeClipPath tests that the clipping path of a decoded image is
+// preserved when the image is encoded again with different options.
+func TestEncodeClipPath(t *testing.T) {
+	clipPath := []byte("\x00\x06\x00\x00path data\x01\x02\x03")
+	value := make([]uint32, len(clipPath))
+	for i, b := range clipPath {
+		value[i] = uint32(b)
+	}
+	b := buildTIFF(t, testPage{make([]byte, 6), []ifdEntry{
+		{tImageWidth, dtShort, []uint32{3}},
+		{tImageLength, dtShort, []uint32{2}},
+		{tBitsPerSample, dtShort, []uint32{8}},
+		{tPhotometricInterpretation, dtShort, []uint32{pBlackIsZero}},
+		{tStripOffsets, dtLong, []uint32{0}},
+		{tRowsPerStrip, dtShort, []uint32{2}},
+		{tStripByteCounts, dtLong, []uint32{6}},
+		{tClipPath, dtByte, value},
+		{tXClipPathUnits, dtLong, []uint32{3}},
+		{tYClipPathUnits, dtLong, []uint32{2}},
+	}})
+
+	c, err := DecodeConfigFull(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := Decode(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := c.SourceInfo.Options()
+	opts.Compression = Deflate
+	var out bytes.Buffer
+	if err := Encode(&out, img, opts); err != nil {
+		t.Fatal(err)
+	}
+	err = WalkIFDs(bytes.NewReader(out.Bytes()), func(_ int64, tags map[uint16]Tag) error {
+		if got := tags[tCompression].Value; !reflect.DeepEqual(got, []uint64{cDeflate}) {
+			t.Errorf("got Compression %v, want %d", got, cDeflate)
+		}
+		if got := tags[tClipPath]; got.Type != TypeByte || !bytes.Equal(got.Value.([]byte), clipPath) {
+			t.Errorf("got ClipPath %+v, want %q", got, clipPath)
+		}
+		if got := tags[tXClipPathUnits].Value; !reflect.DeepEqual(got, []uint64{3}) {
+			t.Errorf("got XClipPathUnits %v, want 3", got)
+		}
+		if got := tags[tYClipPathUnits].Value; !reflect.DeepEqual(got, []uint64{2}) {
+			t.Errorf("got YClipPathUnits %v, want 2", got)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Extra tags must not replace the entries written by the encoder.
+	opts.ExtraTags = append(opts.ExtraTags, Tag{ID: tCompression, Type: TypeShort, Value: []uint64{cNone}})
+	if err := Encode(ioutil.Discard, img, opts); err == nil {
+		t.Error("got nil error for an extra Compression tag")
+	}
+}
+
+// TestEncodeHostComputer tests that the HostComputer option is written
+// without its trailing NUL bytes and read back into SourceInfo.
+func TestEncodeHostComputer(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 3, 2))
+	var buf bytes.Buffer
+	if err := Encode(&buf, img, &Options{HostComputer: "build-01\x00\x00"}); err != nil {
+		t.Fatal(err)
+	}
+	c, err := DecodeConfigFull(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := c.HostComputer, "build-01"; got != want {
+		t.Errorf("got HostComputer %q, want %q", got, want)
+	}
+	if got, want := c.SourceInfo.Options().HostComputer, "build-01"; got != want {
+		t.Errorf("got HostComputer option %q, want %q", got, want)
+	}
+	err = WalkIFDs(bytes.NewReader(buf.Bytes()), func(_ int64, tags map[uint16]Tag) error {
+		if got := tags[tHostComputer]; got.Type != TypeASCII || got.Count != 9 {
+			t.Errorf("got HostComputer entry %+v, want 9 ASCII bytes", got)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestEncodeDescriptiveTags tests that the descriptive tags of Options are
+// written and read back into Metadata, and that ExtraTags cannot duplicate
+// them.
+func TestEncodeDescriptiveTags(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 3, 2))
+	opt := &Options{
+		Software:         "scanner 2.1",
+		Artist:           "J. Doe\x00",
+		ImageDescription: "finish line",
+		Copyright:        "(c) 2020 race result",
+		DateTime:         time.Date(2020, 6, 7, 8, 9, 10, 0, time.UTC),
+		PageNumber:       &PageNumber{Page: 1, Pages: 3},
+	}
+	var buf bytes.Buffer
+	if err := Encode(&buf, img, opt); err != nil {
+		t.Fatal(err)
+	}
+	_, md, err := DecodeWithMetadata(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := [4]string{md.Software, md.Artist, md.ImageDescription, md.DateTime}
+	want := [4]string{"scanner 2.1", "J. Doe", "finish line", "2020:06:07 08:09:10"}
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if s, _ := md.Tags[tCopyright].Value.(string); s != opt.Copyright {
+		t.Errorf("got Copyright %q, want %q", s, opt.Copyright)
+	}
+	if v, _ := md.Tags[tPageNumber].Value.([]uint64); !reflect.DeepEqual(v, []uint64{1, 3}) {
+		t.Errorf("got PageNumber %v", v)
+	}
+
+	opt.ExtraTags = []Tag{{ID: tSoftware, Type: TypeASCII, Value: "other"}}
+	if err := Encode(ioutil.Discard, img, opt); err == nil {
+		t.Error("ExtraTags duplicating Software: got nil error")
+	}
+	opt.ExtraTags = nil
+	opt.DateTime = time.Date(10000, 1, 1, 0, 0, 0, 0, time.UTC)
+	if err := Encode(ioutil.Discard, img, opt); err == nil {
+		t.Error("DateTime in year 10000: got nil error")
+	}
+}
+
+// TestEncodeExtraTags tests that private tags of all kinds of values are
+// written to the IFD and read back unchanged.
+func TestEncodeExtraTags(t *testing.T) {
+	tags := []Tag{
+		{ID: 65000, Type: TypeASCII, Value: "2020-06-07T08:09:10.123Z"},
+		{ID: 65001, Type: TypeLong, Value: []uint64{7, 0xffffffff, 3}},
+		{ID: 65002, Type: TypeShort, Value: []uint64{42}},
+		{ID: 65003, Type: TypeDouble, Value: []float64{12.5, -1}},
+		{ID: 65004, Type: TypeUndefined, Value: []byte("camera-id")},
+		{ID: 65005, Type: TypeSRational, Value: [][2]int32{{-1, 3}}},
+	}
+	gray := image.NewGray(image.Rect(0, 0, 3, 2))
+	var buf bytes.Buffer
+	if err := Encode(&buf, gray, &Options{Compression: LZW, ExtraTags: tags}); err != nil {
+		t.Fatal(err)
+	}
+	_, m, err := DecodeWithMetadata(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range tags {
+		if got := m.Tags[want.ID]; got.Type != want.Type || !reflect.DeepEqual(got.Value, want.Value) {
+			t.Errorf("tag %d: got %+v, want %+v", want.ID, got, want)
+		}
+	}
+
+	// 64-bit values need a BigTIFF file.
+	long8 := []Tag{{ID: 65010, Type: TypeLong8, Value: []uint64{1 << 40}}}
+	if err := Encode(ioutil.Discard, gray, &Options{ExtraTags: long8}); err == nil {
+		t.Error("got nil error for a Long8 tag in a classic TIFF file")
+	}
+	buf.Reset()
+	if err := Encode(&buf, gray, &Options{ForceBigTIFF: true, ExtraTags: long8}); err != nil {
+		t.Fatal(err)
+	}
+	if _, m, err = DecodeWithMetadata(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if got := m.Tags[65010].Value; !reflect.DeepEqual(got, long8[0].Value) {
+		t.Errorf("BigTIFF: got %v for Long8 tag, want %v", got, long8[0].Value)
+	}
+}
+
+// TestEncoderPages tests that the pages written by an Encoder are chained
+// and keep their own compression and photometric interpretation.
+func TestEncoderPages(t *testing.T) {
+	scan := image.NewGray(image.Rect(0, 0, 48, 32))
+	for i := range scan.Pix {
+		if i%7 < 3 {
+			scan.Pix[i] = 0xff
+		}
+	}
+	photo, err := openImage("video-001.tiff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pages := []struct {
+		img         image.Image
+		opt         *Options
+		compression uint64
+		photometric uint64
+	}{
+		{scan, &Options{Compression: LZW, WhiteIsZero: true}, cLZW, pWhiteIsZero},
+		{photo, &Options{Compression: Deflate, Predictor: true}, cDeflate, pRGB},
+		// The samples of scan are black or white, so the bilevel page
+		// decodes to the same pixels.
+		{scan, &Options{Compression: CCITTGroup4}, cG4, pWhiteIsZero},
+		{scan, &Options{TileWidth: 16, TileLength: 16}, cNone, pBlackIsZero},
+	}
+
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	for _, p := range pages {
+		if err := e.WriteImage(p.img, p.opt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.WriteImage(scan, nil); err == nil {
+		t.Error("WriteImage after Close: got nil error")
+	}
+	checkAlignment(t, "Encoder", buf.Bytes())
+
+	i := 0
+	err = WalkIFDs(bytes.NewReader(buf.Bytes()), func(_ int64, tags map[uint16]Tag) error {
+		if i < len(pages) {
+			if got := tags[tCompression].Value; !reflect.DeepEqual(got, []uint64{pages[i].compression}) {
+				t.Errorf("page %d: got Compression %v, want %d", i, got, pages[i].compression)
+			}
+			if got := tags[tPhotometricInterpretation].Value; !reflect.DeepEqual(got, []uint64{pages[i].photometric}) {
+				t.Errorf("page %d: got PhotometricInterpretation %v, want %d", i, got, pages[i].photometric)
+			}
+		}
+		i++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i != len(pages) {
+		t.Fatalf("got %d IFDs, want %d", i, len(pages))
+	}
+
+	imgs, err := DecodeAll(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, p := range pages {
+		compare(t, p.img, imgs[i])
+	}
+}
+
+// TestEncoderPageNumber tests that Options.PageNumber numbers the pages
+// written by an Encoder.
+func TestEncoderPageNumber(t *testing.T) {
+	const n = 4
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	for i := 0; i < n; i++ {
+		m := image.NewGray(image.Rect(0, 0, 8, 8))
+		m.Pix[i] = 0xff
+		if err := e.WriteImage(m, &Options{Compression: Deflate, PageNumber: &PageNumber{i, n}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+	i := 0
+	err := WalkIFDs(bytes.NewReader(buf.Bytes()), func(_ int64, tags map[uint16]Tag) error {
+		if got := tags[tPageNumber].Value; !reflect.DeepEqual(got, []uint64{uint64(i), n}) {
+			t.Errorf("page %d: got PageNumber %v", i, got)
+		}
+		if got := tags[tNewSubfileType].Value; !reflect.DeepEqual(got, []uint64{2}) {
+			t.Errorf("page %d: got NewSubfileType %v, want 2", i, got)
+		}
+		i++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	imgs, err := DecodeAll(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(imgs) != n {
+		t.Fatalf("got %d pages, want %d", len(imgs), n)
+	}
+	for i, img := range imgs {
+		if got := img.(*image.Gray).Pix[i]; got != 0xff {
+			t.Errorf("page %d: got pixel %d, want 0xff", i, got)
+		}
+	}
+
+	if err := Encode(ioutil.Discard, image.NewGray(image.Rect(0, 0, 1, 1)), &Options{PageNumber: &PageNumber{-1, 0}}); err == nil {
+		t.Error("negative page number: got nil error")
+	}
+}
+
+// TestEncodeBigTIFF tests writing BigTIFF files, both if requested and if
+// the output exceeds the size of a classic TIFF file.
+func TestEncodeBigTIFF(t *testing.T) {
+	img, err := openImage("video-001.tiff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gray := image.NewGray(image.Rect(0, 0, 3, 1))
+	desc := Tag{ID: tImageDescription, Type: TypeASCII, Value: "odd length"}
+	for _, opts := range []*Options{
+		{ForceBigTIFF: true},
+		{ForceBigTIFF: true, Compression: LZW, Predictor: true, ExtraTags: []Tag{desc}},
+		{ForceBigTIFF: true, Compression: Deflate, TileWidth: 32, TileLength: 48},
+	} {
+		var buf bytes.Buffer
+		e := NewEncoder(&buf)
+		if err := e.WriteImage(img, opts); err != nil {
+			t.Fatal(err)
+		}
+		if err := e.WriteImage(gray, nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := e.Close(); err != nil {
+			t.Fatal(err)
+		}
+		b := buf.Bytes()
+		if string(b[:4]) != bigLEHeader {
+			t.Fatalf("%+v: got header %q, want BigTIFF", opts, b[:4])
+		}
+		err = WalkIFDs(bytes.NewReader(b), func(offset int64, tags map[uint16]Tag) error {
+			if offset%bigAlign != 0 {
+				t.Errorf("%+v: IFD at offset %d", opts, offset)
+			}
+			for _, tag := range []uint16{tStripOffsets, tTileOffsets} {
+				if got, ok := tags[tag]; ok && got.Type != TypeLong8 {
+					t.Errorf("%+v: got type %d for tag %d, want Long8", opts, got.Type, tag)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		imgs, err := DecodeAll(bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(imgs) != 2 {
+			t.Fatalf("%+v: got %d images, want 2", opts, len(imgs))
+		}
+		compare(t, img, imgs[0])
+		compare(t, gray, imgs[1])
+	}
+
+	// Lower the size limit of classic TIFF files, so that the test image
+	// exceeds it but the gray image does not.
+	defer func(max int64) { maxClassicSize = max }(maxClassicSize)
+	maxClassicSize = 1000
+	var buf bytes.Buffer
+	if err := Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf.Bytes()[:4]) != bigLEHeader {
+		t.Error("large image: got a classic TIFF file")
+	}
+	buf.Reset()
+	if err := Encode(&buf, gray, nil); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf.Bytes()[:4]) != leHeader {
+		t.Error("small image: got a BigTIFF file")
+	}
+	e := NewEncoder(ioutil.Discard)
+	if err := e.WriteImage(gray, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.WriteImage(img, nil); err == nil {
+		t.Error("classic file exceeding the limit: got nil error")
+	}
+
+	if err := Encode(ioutil.Discard, gray, &Options{ForceBigTIFF: true, BaselineOnly: true}); err == nil {
+		t.Error("BaselineOnly with ForceBigTIFF: got nil error")
+	}
+}
+
 func benchmarkEncode(b *testing.B, name string, pixelSize int) {
+	benchmarkEncodeOptions(b, name, pixelSize, nil)
+}
+
+func benchmarkEncodeOptions(b *testing.B, name string, pixelSize int, opt *Options) {
 	img, err := openImage(name)
 	if err != nil {
 		b.Fatal(err)
 	}
 	s := img.Bounds().Size()
 	b.SetBytes(int64(s.X * s.Y * pixelSize))
+	b.ReportAllocs()
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		Encode(ioutil.Discard, img, nil)
+		if err := Encode(ioutil.Discard, img, opt); err != nil {
+			b.Fatal(err)
+		}
 	}
 }
 
@@ -107,3 +1222,202 @@ func BenchmarkEncodeGray(b *testing.B)     { benchmarkEncode(b, "video-001-gray.
 func BenchmarkEncodeGray16(b *testing.B)   { benchmarkEncode(b, "video-001-gray-16bit.tiff", 2) }
 func BenchmarkEncodeRGBA(b *testing.B)     { benchmarkEncode(b, "video-001.tiff", 4) }
 func BenchmarkEncodeRGBA64(b *testing.B)   { benchmarkEncode(b, "video-001-16bit.tiff", 8) }
+
+func BenchmarkEncodeLZW(b *testing.B) {
+	benchmarkEncodeOptions(b, "video-001.tiff", 4, &Options{Compression: LZW})
+}
+
+func BenchmarkEncodeLZWPredictor(b *testing.B) {
+	benchmarkEncodeOptions(b, "video-001.tiff", 4, &Options{Compression: LZW, Predictor: true})
+}
+
+func BenchmarkEncodeLZWGray16(b *testing.B) {
+	benchmarkEncodeOptions(b, "video-001-gray-16bit.tiff", 2, &Options{Compression: LZW})
+}
+
+func BenchmarkEncodeLZWTiled(b *testing.B) {
+	benchmarkEncodeOptions(b, "video-001.tiff", 4, &Options{Compression: LZW, TileWidth: 32, TileLength: 32})
+}
+
+func BenchmarkEncodeLZWConcurrent(b *testing.B) {
+	benchmarkEncodeOptions(b, "video-001.tiff", 4, &Options{Compression: LZW, RowsPerStrip: 8, Concurrency: 4})
+}
+
+func BenchmarkEncodeDeflate(b *testing.B) {
+	benchmarkEncodeOptions(b, "video-001.tiff", 4, &Options{Compression: Deflate})
+}
+
+// limitWriter is an io.Writer that fails after n bytes.
+type limitWriter struct {
+	n int
+}
+
+func (w *limitWriter) Write(p []byte) (int, error) {
+	if len(p) > w.n {
+		n := w.n
+		w.n = 0
+		return n, errors.New("write limit reached")
+	}
+	w.n -= len(p)
+	return len(p), nil
+}
+
+// TestEncodeConcurrency tests that compressing strips and tiles
+// concurrently does not change the output.
+func TestEncodeConcurrency(t *testing.T) {
+	src, err := openImage("video-001.tiff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, opt := range []Options{
+		{Compression: Deflate, RowsPerStrip: 7},
+		{Compression: LZW, Predictor: true, TileWidth: 16, TileLength: 32},
+		{Compression: Deflate},
+		{RowsPerStrip: 10},
+	} {
+		for _, stream := range []bool{false, true} {
+			encode := func(w io.Writer, concurrency int) error {
+				e := NewEncoder(w)
+				if stream {
+					e = NewStreamEncoder(w)
+				}
+				o := opt
+				o.Concurrency = concurrency
+				if err := e.WriteImage(src, &o); err != nil {
+					return err
+				}
+				return e.Close()
+			}
+			var want, got bytes.Buffer
+			if err := encode(&want, 0); err != nil {
+				t.Fatal(err)
+			}
+			for _, concurrency := range []int{2, 3, 8} {
+				got.Reset()
+				if err := encode(&got, concurrency); err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got.Bytes(), want.Bytes()) {
+					t.Errorf("%+v, stream %t, concurrency %d: output differs", opt, stream, concurrency)
+				}
+				// Errors of the writer stop the encoding.
+				if err := encode(&limitWriter{want.Len() / 2}, concurrency); err == nil {
+					t.Errorf("%+v, stream %t, concurrency %d: got no write error", opt, stream, concurrency)
+				}
+			}
+		}
+	}
+	if err := Encode(ioutil.Discard, src, &Options{Concurrency: -1}); err == nil {
+		t.Error("negative concurrency: got no error")
+	}
+}
+
+// TestEncodeBlocksError tests that encodeBlocks starts no more blocks after
+// a block fails to encode.
+func TestEncodeBlocksError(t *testing.T) {
+	const n = 1000
+	failure := errors.New("failure")
+	for _, workers := range []int{1, 2, 4, 8} {
+		var calls int32
+		err := encodeBlocks(n, workers, func(i int) ([]byte, error) {
+			atomic.AddInt32(&calls, 1)
+			if i == 1 {
+				// Let the following blocks fill the queue.
+				time.Sleep(10 * time.Millisecond)
+				return nil, failure
+			}
+			return []byte{byte(i)}, nil
+		}, func([]byte) error { return nil })
+		if err != failure {
+			t.Errorf("workers %d: got error %v, want %v", workers, err, failure)
+		}
+		// Up to workers blocks follow block 0 before the failure of
+		// block 1 is noticed.
+		if max := int32(workers + 1); calls > max {
+			t.Errorf("workers %d: encode called %d times, want at most %d", workers, calls, max)
+		}
+	}
+}
+
+func TestEncodeContext(t *testing.T) {
+	src, err := openImage("video-001.tiff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, opt := range []Options{
+		{RowsPerStrip: 10},
+		{Compression: Deflate, RowsPerStrip: 10, Concurrency: 4},
+		{Compression: LZW, TileWidth: 32, TileLength: 32, Concurrency: 4},
+	} {
+		var last, total int64
+		opt.OnProgress = func(done, n int64) {
+			if done != last+1 {
+				t.Errorf("%+v: progress %d after %d", opt, done, last)
+			}
+			last, total = done, n
+		}
+		var buf bytes.Buffer
+		if err := EncodeContext(context.Background(), &buf, src, &opt); err != nil {
+			t.Fatal(err)
+		}
+		want := int64((src.Bounds().Dy() + 9) / 10)
+		if opt.TileWidth > 0 {
+			want = int64((src.Bounds().Dx()+31)/32) * int64((src.Bounds().Dy()+31)/32)
+		}
+		if last != want || total != want {
+			t.Errorf("%+v: progress %d of %d, want %d", opt, last, total, want)
+		}
+		m, err := Decode(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		compare(t, src, m)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		opt.OnProgress = func(done, n int64) {
+			if done == 1 {
+				cancel()
+			}
+		}
+		if err := EncodeContext(ctx, ioutil.Discard, src, &opt); err != context.Canceled {
+			t.Errorf("%+v: got %v after canceling, want %v", opt, err, context.Canceled)
+		}
+	}
+}
+
+func TestPackBits(t *testing.T) {
+	long := bytes.Repeat([]byte{7}, 300)
+	var literal []byte
+	for i := 0; i < 300; i++ {
+		literal = append(literal, byte(i))
+	}
+	for _, src := range [][]byte{
+		{},
+		{1},
+		{1, 1},
+		{1, 2, 2, 3, 3, 3, 4},
+		long,
+		literal,
+		append(append([]byte{1, 2}, long[:129]...), literal...),
+	} {
+		b := packBits(nil, src)
+		got, err := unpackBits(bytes.NewReader(b), nil, len(src))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, src) {
+			t.Errorf("%v: packed to %v, which unpacks to %v", src, b, got)
+		}
+	}
+
+	// Each row is packed separately, so no run crosses rows.
+	var buf bytes.Buffer
+	w := &packBitsWriter{w: &buf, rows: 2}
+	w.Write(bytes.Repeat([]byte{5}, 8))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.Bytes(), []byte{0xfd, 5, 0xfd, 5}; !bytes.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
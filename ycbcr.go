@@ -0,0 +1,57 @@
+package tiff
+
+// subsampling returns the horizontal and vertical chroma subsampling factors
+// of a YCbCr image, which default to 2.
+func (d *decoder) subsampling() (int, int) {
+	v := d.features[tYCbCrSubSampling]
+	if len(v) != 2 {
+		return 2, 2
+	}
+	return int(v[0]), int(v[1])
+}
+
+// A ycbcrConverter converts YCbCr samples to RGB as described on p. 90-93 of
+// the spec.
+type ycbcrConverter struct {
+	lumaRed, lumaGreen, lumaBlue float64
+	ref                          [6]float64 // ReferenceBlackWhite.
+}
+
+// ycbcrConverter returns the converter given by the YCbCrCoefficients and
+// ReferenceBlackWhite tags, which default to the coefficients of CCIR 601-1
+// and to samples using the full range of 8 bits.
+func (d *decoder) ycbcrConverter() *ycbcrConverter {
+	c := &ycbcrConverter{
+		lumaRed:   0.299,
+		lumaGreen: 0.587,
+		lumaBlue:  0.114,
+		ref:       [6]float64{0, 255, 128, 255, 128, 255},
+	}
+	if v := d.rationals[tYCbCrCoefficients]; len(v) >= 3 {
+		c.lumaRed, c.lumaGreen, c.lumaBlue = v[0], v[1], v[2]
+	}
+	if v := d.rationals[tReferenceBlackWhite]; len(v) >= 6 {
+		copy(c.ref[:], v)
+	}
+	return c
+}
+
+// rgb returns the RGB color of the samples y, cb and cr.
+func (c *ycbcrConverter) rgb(y, cb, cr uint8) (uint8, uint8, uint8) {
+	yy := (float64(y) - c.ref[0]) * 255 / (c.ref[1] - c.ref[0])
+	b := (float64(cb)-c.ref[2])*127/(c.ref[3]-c.ref[2])*(2-2*c.lumaBlue) + yy
+	r := (float64(cr)-c.ref[4])*127/(c.ref[5]-c.ref[4])*(2-2*c.lumaRed) + yy
+	g := (yy - c.lumaBlue*b - c.lumaRed*r) / c.lumaGreen
+	return clamp8(r), clamp8(g), clamp8(b)
+}
+
+// clamp8 rounds v to the nearest integer within [0, 255].
+func clamp8(v float64) uint8 {
+	switch {
+	case v <= 0:
+		return 0
+	case v >= 255:
+		return 255
+	}
+	return uint8(v + 0.5)
+}
@@ -0,0 +1,82 @@
+package tiff
+
+import (
+	"bytes"
+	"image/color"
+	"testing"
+)
+
+// TestDecodeYCbCr tests the decoding of uncompressed YCbCr images with and
+// without chroma subsampling, including partial units at the right and
+// bottom edges.
+func TestDecodeYCbCr(t *testing.T) {
+	const w, h = 5, 3
+	luma := func(x, y int) uint8 { return uint8(x*35 + y*25 + 20) }
+	chroma := func(ux, uy int) (uint8, uint8) { return uint8(100 + ux*20), uint8(160 - uy*30) }
+	for _, tc := range []struct {
+		sub [2]uint32
+		ref []uint32 // ReferenceBlackWhite as rationals, or nil.
+	}{
+		{[2]uint32{1, 1}, nil},
+		{[2]uint32{2, 1}, nil},
+		{[2]uint32{2, 2}, nil},
+		{[2]uint32{4, 2}, []uint32{16, 1, 235, 1, 128, 1, 240, 1, 128, 1, 240, 1}},
+	} {
+		sh, sv := int(tc.sub[0]), int(tc.sub[1])
+		var data []byte
+		for uy := 0; uy < (h+sv-1)/sv; uy++ {
+			for ux := 0; ux < (w+sh-1)/sh; ux++ {
+				for j := 0; j < sv; j++ {
+					for i := 0; i < sh; i++ {
+						data = append(data, luma(ux*sh+i, uy*sv+j))
+					}
+				}
+				cb, cr := chroma(ux, uy)
+				data = append(data, cb, cr)
+			}
+		}
+		ifd := []ifdEntry{
+			{tImageWidth, dtShort, []uint32{w}},
+			{tImageLength, dtShort, []uint32{h}},
+			{tBitsPerSample, dtShort, []uint32{8, 8, 8}},
+			{tPhotometricInterpretation, dtShort, []uint32{pYCbCr}},
+			{tStripOffsets, dtLong, []uint32{0}},
+			{tSamplesPerPixel, dtShort, []uint32{3}},
+			{tRowsPerStrip, dtShort, []uint32{h}},
+			{tStripByteCounts, dtLong, []uint32{uint32(len(data))}},
+			{tYCbCrSubSampling, dtShort, tc.sub[:]},
+		}
+		if tc.ref != nil {
+			ifd = append(ifd, ifdEntry{tReferenceBlackWhite, dtRational, tc.ref})
+		}
+		m, err := Decode(bytes.NewReader(buildTIFF(t, testPage{data, ifd})))
+		if err != nil {
+			t.Fatalf("subsampling %v: %v", tc.sub, err)
+		}
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				yy := luma(x, y)
+				c0, c1 := chroma(x/sh, y/sv)
+				cb, cr := int(c0), int(c1)
+				if tc.ref != nil {
+					// Expand the video range to the full range.
+					yy = uint8((int(yy) - 16) * 255 / 219)
+					cb = 128 + (cb-128)*127/112
+					cr = 128 + (cr-128)*127/112
+				}
+				r, g, b := color.YCbCrToRGB(yy, uint8(cb), uint8(cr))
+				got := m.At(x, y).(color.RGBA)
+				if absDiff(got.R, r) > 2 || absDiff(got.G, g) > 2 || absDiff(got.B, b) > 2 || got.A != 0xff {
+					t.Errorf("subsampling %v: got %v at (%d, %d), want %d %d %d", tc.sub, got, x, y, r, g, b)
+				}
+			}
+		}
+	}
+}
+
+func absDiff(a, b uint8) int {
+	if a > b {
+		return int(a - b)
+	}
+	return int(b - a)
+}
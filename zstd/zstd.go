@@ -0,0 +1,63 @@
+// Package zstd adds Zstandard compression to package tiff, using the pure Go
+// implementation of github.com/klauspost/compress/zstd. It is typically
+// imported for its side effect of registering the codec:
+//
+//	import _ "github.com/raceresult/tiff/zstd"
+//
+// Images are then encoded with Zstandard compression if
+// tiff.Options.Compression is Compression.
+package zstd
+
+import (
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/raceresult/tiff"
+)
+
+// The Compression values of Zstandard compression. ID is the value used by
+// libtiff, which is written by the encoder; IDOld is an older value that is
+// only decoded.
+const (
+	ID    = 50000
+	IDOld = 50013
+)
+
+// Compression selects Zstandard compression in tiff.Options.
+var Compression = tiff.RegisteredCompression(ID)
+
+func init() {
+	tiff.RegisterCompression(ID, newWriter, newReader)
+	tiff.RegisterCompression(IDOld, nil, newReader)
+}
+
+func newWriter(w io.Writer) io.WriteCloser {
+	// NewWriter fails only for invalid options.
+	zw, _ := zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+	return zw
+}
+
+// reader is a zstd.Decoder whose Close method satisfies io.Closer.
+type reader struct {
+	*zstd.Decoder
+}
+
+func (r reader) Close() error {
+	r.Decoder.Close()
+	return nil
+}
+
+// errReader is an io.Reader that returns err.
+type errReader struct {
+	err error
+}
+
+func (r errReader) Read([]byte) (int, error) { return 0, r.err }
+
+func newReader(r io.Reader) io.Reader {
+	zr, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return errReader{err}
+	}
+	return reader{zr}
+}
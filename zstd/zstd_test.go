@@ -0,0 +1,39 @@
+package zstd
+
+import (
+	"bytes"
+	"image"
+	"testing"
+
+	"github.com/raceresult/tiff"
+)
+
+func TestRoundtrip(t *testing.T) {
+	m := image.NewRGBA(image.Rect(0, 0, 100, 70))
+	for i := range m.Pix {
+		m.Pix[i] = uint8(i * 7 / 5)
+	}
+	for _, opt := range []*tiff.Options{
+		{Compression: Compression},
+		{Compression: Compression, Predictor: true, TileWidth: 32, TileLength: 32},
+	} {
+		var buf bytes.Buffer
+		if err := tiff.Encode(&buf, m, opt); err != nil {
+			t.Fatal(err)
+		}
+		img, err := tiff.Decode(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, ok := img.(*image.RGBA)
+		if !ok {
+			t.Fatalf("got image of type %T, want *image.RGBA", img)
+		}
+		if !bytes.Equal(got.Pix, m.Pix) {
+			t.Errorf("%+v: decoded pixels differ", opt)
+		}
+		if int64(buf.Len()) >= int64(len(m.Pix)) {
+			t.Errorf("%+v: got %d bytes for %d bytes of pixels", opt, buf.Len(), len(m.Pix))
+		}
+	}
+}